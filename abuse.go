@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// rdapURLs are the RDAP services of the registries.
+var rdapURLs = map[string]string{
+	"afrinic": "https://rdap.afrinic.net/rdap/",
+	"apnic":   "https://rdap.apnic.net/",
+	"arin":    "https://rdap.arin.net/registry/",
+	"lacnic":  "https://rdap.lacnic.net/rdap/",
+	"ripencc": "https://rdap.db.ripe.net/",
+}
+
+// abuseContact is the cached abuse contact of a holder.
+type abuseContact struct {
+	Handle  string `json:"handle,omitempty"`
+	Email   string `json:"email,omitempty"`
+	Fetched string `json:"fetched"`
+}
+
+// rdapEntity is the part of an RDAP entity needed to find abuse contacts.
+type rdapEntity struct {
+	Handle     string            `json:"handle"`
+	Roles      []string          `json:"roles"`
+	VCardArray []json.RawMessage `json:"vcardArray"`
+	Entities   []rdapEntity      `json:"entities"`
+}
+
+// fetchAbuseContact queries the registry's RDAP service for an IP address or ASN and
+// returns the first entity with the abuse role.
+func fetchAbuseContact(ctx context.Context, registry, kind, start string) (abuseContact, error) {
+	base, ok := rdapURLs[registry]
+	if !ok {
+		return abuseContact{}, fmt.Errorf("no RDAP service known for %s", registry)
+	}
+	path := "ip/" + start
+	if kind == "asn" {
+		path = "autnum/" + start
+	}
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, base+path, nil)
+	if err != nil {
+		return abuseContact{}, err
+	}
+	req.Header.Set("Accept", "application/rdap+json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return abuseContact{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return abuseContact{}, fmt.Errorf("RDAP %s: %s", base+path, resp.Status)
+	}
+	var obj struct {
+		Entities []rdapEntity `json:"entities"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&obj); err != nil {
+		return abuseContact{}, fmt.Errorf("RDAP %s: %w", base+path, err)
+	}
+	c, _ := findAbuseEntity(obj.Entities)
+	return c, nil
+}
+
+func findAbuseEntity(entities []rdapEntity) (abuseContact, bool) {
+	for _, e := range entities {
+		for _, role := range e.Roles {
+			if role == "abuse" {
+				return abuseContact{Handle: e.Handle, Email: vcardEmail(e.VCardArray)}, true
+			}
+		}
+		if c, ok := findAbuseEntity(e.Entities); ok {
+			return c, true
+		}
+	}
+	return abuseContact{}, false
+}
+
+// vcardEmail returns the first email of a jCard: ["vcard", [["email", {}, "text", "..."], ...]].
+func vcardEmail(vcard []json.RawMessage) string {
+	return vcardProperty(vcard, "email")
+}
+
+// vcardProperty returns the first text value of a jCard property.
+func vcardProperty(vcard []json.RawMessage, name string) string {
+	if len(vcard) < 2 {
+		return ""
+	}
+	var props [][]interface{}
+	if err := json.Unmarshal(vcard[1], &props); err != nil {
+		return ""
+	}
+	for _, p := range props {
+		if len(p) >= 4 && p[0] == name {
+			if value, ok := p[3].(string); ok {
+				return value
+			}
+		}
+	}
+	return ""
+}
+
+// refreshAbuseContacts fetches the abuse contacts of up to limit holders whose cached
+// contact is missing or older than maxAge, using one current resource of each holder.
+func refreshAbuseContacts(ctx context.Context, db *sql.DB, maxAge time.Duration, limit int) error {
+	rows, err := db.Query(`SELECT r.ID_Registries, r.Holder, MIN(CONCAT(r.RecordType, '|', r.Start)) FROM Resources r
+		LEFT JOIN AbuseContacts a ON a.ID_Registries = r.ID_Registries AND a.Holder = r.Holder
+		WHERE r.Holder <> '' AND r.State IN ('allocated', 'assigned')
+		AND (a.Fetched IS NULL OR a.Fetched < ?)
+		GROUP BY r.ID_Registries, r.Holder LIMIT ?;`, time.Now().UTC().Add(-maxAge).Format("2006-01-02 15:04:05"), limit)
+	if err != nil {
+		return err
+	}
+	type pending struct{ registry, holder, kind, start string }
+	var list []pending
+	for rows.Next() {
+		var p pending
+		var resource string
+		if err := rows.Scan(&p.registry, &p.holder, &resource); err != nil {
+			rows.Close()
+			return err
+		}
+		p.kind, p.start, _ = strings.Cut(resource, "|")
+		list = append(list, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	var n int
+	for _, p := range list {
+		if ctx.Err() != nil {
+			break
+		}
+		c, err := fetchAbuseContact(ctx, p.registry, p.kind, p.start)
+		if err != nil {
+			logger.Debug("Cannot look up abuse contact", "holder", p.holder, "err", err)
+			continue
+		}
+		_, err = db.Exec("REPLACE INTO AbuseContacts VALUES (?, ?, ?, ?, UTC_TIMESTAMP());",
+			p.registry, p.holder, truncate(c.Handle, 64), truncate(c.Email, 255))
+		if err != nil {
+			return fmt.Errorf("saving abuse contact: %w", err)
+		}
+		n++
+		time.Sleep(time.Second) // Stay well below the RDAP rate limits
+	}
+	logger.Info("Refreshed abuse contacts", "holders", n)
+	return nil
+}
+
+// watchAbuseContacts refreshes stale abuse contacts every hour with -abuse-refresh set.
+func watchAbuseContacts(db *sql.DB) {
+	if *f_abuseRefresh <= 0 {
+		return
+	}
+	for range time.Tick(time.Hour) {
+		if err := refreshAbuseContacts(context.Background(), db, *f_abuseRefresh, 1000); err != nil {
+			logger.Warn("Cannot refresh abuse contacts", "err", err)
+		}
+	}
+}
+
+// holderAbuseContact returns the cached abuse contact of a holder.
+func holderAbuseContact(db *sql.DB, registry, holderID string) (abuseContact, error) {
+	var c abuseContact
+	err := db.QueryRow("SELECT Handle, Email, Fetched FROM AbuseContacts WHERE ID_Registries = ? AND Holder = ?;",
+		registry, holderID).Scan(&c.Handle, &c.Email, &c.Fetched)
+	return c, err
+}
+
+// abuseCommand implements "abuse refresh [-max-age D] [-limit N]" and "abuse show REGISTRY OPAQUE_ID".
+func abuseCommand(db *sql.DB, args []string) {
+	usage := "Usage: abuse refresh [-max-age DURATION] [-limit N] | abuse show REGISTRY OPAQUE_ID"
+	if len(args) == 0 {
+		log.Fatal(usage)
+	}
+	switch args[0] {
+	case "refresh":
+		fs := flag.NewFlagSet("abuse refresh", flag.ExitOnError)
+		maxAge := fs.Duration("max-age", 7*24*time.Hour, "Refresh contacts fetched longer ago than this")
+		limit := fs.Int("limit", 1000, "Maximum number of holders to refresh")
+		fs.Parse(args[1:])
+		if err := refreshAbuseContacts(context.Background(), db, *maxAge, *limit); err != nil {
+			log.Fatal(err)
+		}
+	case "show":
+		if len(args) != 3 {
+			log.Fatal(usage)
+		}
+		c, err := holderAbuseContact(db, args[1], args[2])
+		if err == sql.ErrNoRows {
+			log.Fatal("No abuse contact cached for " + args[2])
+		} else if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(strings.TrimSpace(c.Email + " " + c.Handle))
+	default:
+		log.Fatal(usage)
+	}
+}
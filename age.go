@@ -0,0 +1,91 @@
+package main
+
+import (
+	"database/sql"
+	"sort"
+	"strconv"
+)
+
+// allocationAge is the space allocated or assigned in one year that is still delegated.
+type allocationAge struct {
+	Year     int    `json:"year"`
+	Registry string `json:"registry"`
+	CC       string `json:"cc,omitempty"`
+	Type     string `json:"type"`
+	Blocks   uint64 `json:"blocks"`
+	Size     uint64 `json:"size"` // IPv4 addresses, IPv6 /48s or ASNs
+}
+
+// allocationAges groups the current delegations of LatestAllocations by the year of
+// their record date, per registry and type and, with perCountry, per country. Records
+// without date are left out. Empty arguments select everything.
+func allocationAges(db *sql.DB, registry, cc, kind string, perCountry bool) ([]allocationAge, error) {
+	country := "''"
+	if perCountry {
+		country = "CC"
+	}
+	rows, err := db.Query(`SELECT YEAR(RecordDate), ID_Registries, `+country+`, RecordType, Value FROM LatestAllocations
+		WHERE State IN ('allocated', 'assigned') AND RecordDate > '1970-01-01'
+		AND (? = '' OR ID_Registries = ?) AND (? = '' OR CC = ?) AND (? = '' OR RecordType = ?);`,
+		registry, registry, cc, cc, kind, kind)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	type key struct {
+		year               int
+		registry, cc, kind string
+	}
+	ages := map[key]*allocationAge{}
+	var keys []key
+	for rows.Next() {
+		var k key
+		var value uint64
+		if err := rows.Scan(&k.year, &k.registry, &k.cc, &k.kind, &value); err != nil {
+			return nil, err
+		}
+		a := ages[k]
+		if a == nil {
+			a = &allocationAge{Year: k.year, Registry: k.registry, CC: k.cc, Type: k.kind}
+			ages[k] = a
+			keys = append(keys, k)
+		}
+		a.Blocks++
+		if k.kind == "ipv6" {
+			a.Size += slash48s(value)
+		} else {
+			a.Size += value
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	list := make([]allocationAge, 0, len(keys))
+	for _, k := range keys {
+		list = append(list, *ages[k])
+	}
+	sort.Slice(list, func(i, j int) bool {
+		a, b := list[i], list[j]
+		if a.Year != b.Year {
+			return a.Year < b.Year
+		}
+		return a.Registry+"|"+a.CC+"|"+a.Type < b.Registry+"|"+b.CC+"|"+b.Type
+	})
+	return list, nil
+}
+
+func ageStats(db *sql.DB, registry, cc, kind string, perCountry bool, format string) error {
+	list, err := allocationAges(db, registry, cc, kind, perCountry)
+	if err != nil {
+		return err
+	}
+	rows := make([][]string, 0, len(list))
+	for _, a := range list {
+		rows = append(rows, []string{strconv.Itoa(a.Year), a.Registry, a.CC, a.Type,
+			strconv.FormatUint(a.Blocks, 10), strconv.FormatUint(a.Size, 10)})
+	}
+	writeReport(format, []string{"year", "registry", "cc", "type", "blocks", "size"}, rows, list)
+	return nil
+}
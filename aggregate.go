@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"net/netip"
+	"os"
+	"strings"
+)
+
+// allocatedPrefixes returns the allocated and assigned prefixes of a record type in the
+// latest datasets, optionally limited to a registry and country code.
+func allocatedPrefixes(db *sql.DB, kind, registry, cc string) ([]netip.Prefix, error) {
+	rows, err := db.Query("SELECT start, value FROM ("+latestAllocationsQuery+
+		`) a (registry, cc, kind, start, value, date, status) WHERE kind = ? AND status IN ('allocated', 'assigned')
+		AND (? = '' OR registry = ?) AND (? = '' OR cc = ?);`, kind, registry, registry, cc, cc)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var prefixes []netip.Prefix
+	for rows.Next() {
+		var start string
+		var value uint64
+		if err := rows.Scan(&start, &value); err != nil {
+			return nil, err
+		}
+		p, err := recordPrefixes(kind, start, value)
+		if err != nil {
+			logger.Log(context.Background(), levelTrace, "Skipping resource", "type", kind, "start", start,
+				"value", value, "err", err)
+			continue
+		}
+		prefixes = append(prefixes, p...)
+	}
+	return prefixes, rows.Err()
+}
+
+// aggregateCommand prints the aggregated allocated space, e.g. of a country, or
+// aggregates the prefixes read from standard input when the argument is "-".
+func aggregateCommand(db *sql.DB, args []string) {
+	fs := flag.NewFlagSet("aggregate", flag.ExitOnError)
+	kind := fs.String("type", "ipv4", "Record type: ipv4 or ipv6")
+	registry := fs.String("registry", "", "Only allocations of this registry")
+	cc := fs.String("cc", "", "Only allocations of this country code")
+	fs.Parse(args)
+
+	var prefixes []netip.Prefix
+	if fs.Arg(0) == "-" {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			p, err := netip.ParsePrefix(line)
+			if err != nil {
+				log.Fatal("Invalid prefix: " + line)
+			}
+			prefixes = append(prefixes, p)
+		}
+		if err := scanner.Err(); err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		if *kind != "ipv4" && *kind != "ipv6" {
+			log.Fatal("Invalid type: " + *kind)
+		}
+		var err error
+		if prefixes, err = allocatedPrefixes(db, *kind, *registry, strings.ToUpper(*cc)); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	aggregated := aggregatePrefixes(prefixes)
+	w := bufio.NewWriter(os.Stdout)
+	for _, p := range aggregated {
+		fmt.Fprintln(w, p)
+	}
+	w.Flush()
+	logger.Debug("Aggregated prefixes", "prefixes", len(prefixes), "aggregated", len(aggregated))
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// notifier delivers an alert to one destination.
+type notifier interface {
+	notify(subject, body string) error
+}
+
+type slackNotifier struct {
+	url string
+}
+
+func (n slackNotifier) notify(subject, body string) error {
+	payload, err := json.Marshal(map[string]string{"text": "*" + subject + "*\n" + body})
+	if err != nil {
+		return err
+	}
+	resp, err := webhookClient.Post(n.url, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("slack: %s", resp.Status)
+	}
+	return nil
+}
+
+type smtpNotifier struct {
+	addr string
+	from string
+	to   []string
+}
+
+func (n smtpNotifier) notify(subject, body string) error {
+	var auth smtp.Auth
+	if user := os.Getenv("SMTP_USER"); user != "" {
+		host, _, _ := net.SplitHostPort(n.addr)
+		auth = smtp.PlainAuth("", user, os.Getenv("SMTP_PASS"), host)
+	}
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\n\r\n%s\r\n",
+		n.from, strings.Join(n.to, ", "), subject, time.Now().Format(time.RFC1123Z), body)
+	return smtp.SendMail(n.addr, auth, n.from, n.to, []byte(msg))
+}
+
+// notifiers returns the alert destinations configured on the command line.
+func notifiers() []notifier {
+	var list []notifier
+	s := currentSettings()
+	if s.slackWebhook != "" {
+		list = append(list, slackNotifier{url: s.slackWebhook})
+	}
+	if s.smtpAddr != "" && s.alertEmail != "" {
+		var to []string
+		for _, addr := range strings.Split(s.alertEmail, ",") {
+			if addr = strings.TrimSpace(addr); addr != "" {
+				to = append(to, addr)
+			}
+		}
+		list = append(list, smtpNotifier{addr: s.smtpAddr, from: s.smtpFrom, to: to})
+	}
+	return list
+}
+
+// sendAlert delivers the alert to all configured notifiers; failures are only logged.
+func sendAlert(subject, body string) {
+	logger.Warn("Alert", "subject", subject)
+	for _, n := range notifiers() {
+		if err := n.notify("[ip2asn] "+subject, body); err != nil {
+			logger.Warn("Cannot send alert", "err", err)
+		}
+	}
+}
+
+// alertOnImport raises alerts for failed imports and for record counts that do not
+// match the summary lines of the file.
+func alertOnImport(result ImportResult) {
+	source := result.Registry
+	if source == "" {
+		source = result.Source
+	}
+	if result.Status == "unchanged" {
+		return
+	}
+	if result.Status != "success" {
+		sendAlert("Import failed: "+source, fmt.Sprintf("Import from %s failed after %.1fs: %s", result.Source, result.Duration, result.Error))
+		return
+	}
+
+	var mismatches []string
+	for _, k := range []string{"asn", "ipv4", "ipv6"} {
+		if expected, ok := result.Expected[k]; ok && expected != result.Counts[k] {
+			mismatches = append(mismatches, fmt.Sprintf("%s: expected %d, imported %d", k, expected, result.Counts[k]))
+		}
+	}
+	if len(mismatches) > 0 {
+		sendAlert("Record count anomaly: "+source, fmt.Sprintf("Dataset %s serial %d from %s:\n%s",
+			result.Registry, result.Serial, result.Source, strings.Join(mismatches, "\n")))
+	}
+}
+
+// staleAlerted holds the registries already alerted on, reset once fresh again. Imports
+// and watchStaleness check concurrently; the lock also keeps them from alerting twice.
+var staleAlerted = struct {
+	sync.Mutex
+	registries map[string]bool
+}{registries: map[string]bool{}}
+
+// checkStaleness alerts once, by Slack/email and webhook, for every registry whose
+// latest dataset is older than its staleness threshold.
+func checkStaleness(db *sql.DB) {
+	list, err := freshness(db)
+	if err != nil {
+		logger.Warn("Cannot check dataset staleness", "err", err)
+		return
+	}
+
+	staleAlerted.Lock()
+	defer staleAlerted.Unlock()
+	for _, f := range list {
+		if !f.Stale {
+			delete(staleAlerted.registries, f.Registry)
+			continue
+		}
+		if !staleAlerted.registries[f.Registry] {
+			sendAlert("Stale dataset: "+f.Registry, fmt.Sprintf("Latest %s dataset is from %s, older than %s.",
+				f.Registry, f.Date.Format("2006-01-02"), f.Threshold))
+			postWebhooks(map[string]interface{}{"event": "dataset.stale", "registry": f.Registry,
+				"date": f.Date.Format("2006-01-02"), "threshold_seconds": f.Threshold.Seconds()})
+			staleAlerted.registries[f.Registry] = true
+		}
+	}
+}
+
+// watchStaleness re-checks dataset freshness hourly for long running processes.
+func watchStaleness(db *sql.DB) {
+	for range time.Tick(time.Hour) {
+		checkStaleness(db)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/csv"
+	"flag"
+	"io"
+	"log"
+	"net/netip"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// annotateCommand implements "annotate -input FILE -ip-column N", which appends asn,
+// registry, cc, holder, tags and rpki columns to every row of a delimited file. A first row whose
+// address column does not parse is taken as the header and gets the column names.
+func annotateCommand(db *sql.DB, args []string) {
+	fs := flag.NewFlagSet("annotate", flag.ExitOnError)
+	input := fs.String("input", "-", "Delimited file to annotate; - reads standard input")
+	output := fs.String("output", "-", "Annotated file to write; - writes standard output")
+	column := fs.Int("ip-column", 1, "Column holding the address, counting from 1")
+	delimiter := fs.String("delimiter", "", "Field delimiter; default tab for .tsv files, else comma")
+	fs.Parse(args)
+	if *column < 1 {
+		log.Fatal("Usage: annotate -input FILE -ip-column N [-output FILE] [-delimiter C]")
+	}
+
+	comma := ','
+	if *delimiter != "" {
+		comma, _ = utf8.DecodeRuneInString(*delimiter)
+	} else if strings.HasSuffix(*input, ".tsv") {
+		comma = '\t'
+	}
+
+	var in io.Reader = os.Stdin
+	if *input != "-" {
+		f, err := os.Open(*input)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		in = f
+	}
+	var out io.Writer = os.Stdout
+	if *output != "-" {
+		f, err := os.Create(*output)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		out = f
+	}
+
+	table, err := loadLookupTable(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := annotate(table, in, out, comma, *column-1); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// annotate copies rows from in to out with the lookup results of the address in column col.
+func annotate(table *lookupTable, in io.Reader, out io.Writer, comma rune, col int) error {
+	r := csv.NewReader(bufio.NewReaderSize(in, 1<<20))
+	r.Comma = comma
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+	r.ReuseRecord = true
+	bw := bufio.NewWriterSize(out, 1<<20)
+	w := csv.NewWriter(bw)
+	w.Comma = comma
+
+	var n, found int
+	for line := 0; ; line++ {
+		rec, err := r.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		var addr netip.Addr
+		ok := false
+		if col < len(rec) {
+			addr, ok = parseAnnotateAddr(rec[col])
+		}
+		switch {
+		case ok:
+			res := table.lookup(addr)
+			rec = append(rec, res.ASN, res.Registry, res.CC, res.Holder, strings.Join(res.Tags, ","), res.RPKI)
+			if res.ASN != "" || res.Registry != "" {
+				found++
+			}
+		case line == 0:
+			rec = append(rec, "asn", "registry", "cc", "holder", "tags", "rpki")
+		default:
+			rec = append(rec, "", "", "", "", "", "")
+		}
+		if err := w.Write(rec); err != nil {
+			return err
+		}
+		n++
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	logger.Info("Annotated rows", "rows", n, "matched", found)
+	return bw.Flush()
+}
+
+// parseAnnotateAddr accepts an address, optionally with a port or prefix length.
+func parseAnnotateAddr(s string) (netip.Addr, bool) {
+	s = strings.TrimSpace(s)
+	if a, err := netip.ParseAddr(s); err == nil {
+		return a, true
+	}
+	if ap, err := netip.ParseAddrPort(s); err == nil {
+		return ap.Addr(), true
+	}
+	if p, err := netip.ParsePrefix(s); err == nil {
+		return p.Addr(), true
+	}
+	return netip.Addr{}, false
+}
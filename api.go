@@ -0,0 +1,121 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// prefixEntry is a delegation listed by /v1/prefixes.
+type prefixEntry struct {
+	Registry string   `json:"registry"`
+	CC       string   `json:"cc"`
+	Type     string   `json:"type"`
+	Start    string   `json:"start"`
+	Value    uint64   `json:"value"`
+	Prefixes []string `json:"prefixes"`
+	Date     string   `json:"date,omitempty"`
+	Status   string   `json:"status"`
+	Holder   string   `json:"holder,omitempty"`
+}
+
+// writeLookup answers /v1/ip and /v1/asn with the lookup command's answer, addresses
+// from the lookup index once it is loaded.
+func writeLookup(db *sql.DB, ns, q string, w http.ResponseWriter) {
+	started := time.Now()
+	a, err := indexedLookup(db, lookupIndex(db, ns), q)
+	if err == sql.ErrNoRows {
+		countLookup("not_found", time.Since(started))
+		http.Error(w, "no delegation found for "+q, http.StatusNotFound)
+		return
+	} else if err != nil {
+		countLookup("error", time.Since(started))
+		countDBError("lookup", err)
+		http.Error(w, "cannot look up "+q, http.StatusInternalServerError)
+		return
+	}
+	countLookup("found", time.Since(started))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Namespace string `json:"namespace"`
+		lookupAnswer
+	}{ns, a})
+}
+
+// handleIP serves /v1/ip/{addr}: the delegation containing an address and the origin
+// of its BGP route.
+func handleIP(db *sql.DB, ns string, w http.ResponseWriter, r *http.Request) {
+	q := strings.TrimPrefix(r.URL.Path, "/v1/ip/")
+	if _, err := netip.ParseAddr(q); err != nil {
+		http.Error(w, "invalid address", http.StatusBadRequest)
+		return
+	}
+	writeLookup(db, ns, q, w)
+}
+
+// handleASN serves /v1/asn/{number}, with or without the AS prefix.
+func handleASN(db *sql.DB, ns string, w http.ResponseWriter, r *http.Request) {
+	q := strings.TrimPrefix(strings.ToUpper(strings.TrimPrefix(r.URL.Path, "/v1/asn/")), "AS")
+	if _, err := strconv.ParseUint(q, 10, 32); err != nil {
+		http.Error(w, "invalid ASN", http.StatusBadRequest)
+		return
+	}
+	writeLookup(db, ns, q, w)
+}
+
+// handlePrefixes serves /v1/prefixes: the current IPv4 and IPv6 delegations, filtered
+// by country (or cc), registry, type and status (default allocated and assigned).
+func handlePrefixes(db *sql.DB, ns string, w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	cc := strings.ToUpper(q.Get("country"))
+	if cc == "" {
+		cc = strings.ToUpper(q.Get("cc"))
+	}
+	kind := q.Get("type")
+	if kind != "" && kind != "ipv4" && kind != "ipv6" {
+		http.Error(w, "invalid type", http.StatusBadRequest)
+		return
+	}
+	query := `SELECT ID_Registries, CC, RecordType, Start, Value, IFNULL(RecordDate, ''), State, Holder FROM LatestAllocations
+		WHERE RecordType IN ('ipv4', 'ipv6') AND (? = '' OR CC = ?) AND (? = '' OR ID_Registries = ?) AND (? = '' OR RecordType = ?)`
+	params := []interface{}{cc, cc, q.Get("registry"), q.Get("registry"), kind, kind}
+	if status := q.Get("status"); status != "" {
+		query += " AND State = ?"
+		params = append(params, status)
+	} else {
+		query += " AND State IN ('allocated', 'assigned')"
+	}
+	rows, err := db.Query(query+" ORDER BY RecordType, ID_Registries, Start;", params...)
+	if err != nil {
+		http.Error(w, "cannot query prefixes", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	list := []prefixEntry{}
+	for rows.Next() {
+		var e prefixEntry
+		if err := rows.Scan(&e.Registry, &e.CC, &e.Type, &e.Start, &e.Value, &e.Date, &e.Status, &e.Holder); err != nil {
+			http.Error(w, "cannot query prefixes", http.StatusInternalServerError)
+			return
+		}
+		prefixes, err := recordPrefixes(e.Type, e.Start, e.Value)
+		if err != nil {
+			continue
+		}
+		for _, p := range prefixes {
+			e.Prefixes = append(e.Prefixes, p.String())
+		}
+		list = append(list, e)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, "cannot query prefixes", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"namespace": ns, "prefixes": list})
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// asNamesURL is the default name source: one "ASN NAME - Description, CC" line per ASN.
+const asNamesURL = "https://ftp.ripe.net/ripe/asnames/asn.txt"
+
+// asName is a row of AsNames.
+type asName struct {
+	ASN         uint64 `json:"asn"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Org         string `json:"org,omitempty"`
+	CC          string `json:"cc,omitempty"`
+	Source      string `json:"source"`
+}
+
+// asNamesCommand implements "asnames import [-format asntxt|whois|as2org] [-source NAME] [FILE|URL]"
+// and "asnames lookup ASN". Without a file the RIPE asn.txt list is downloaded; whois
+// dumps (aut-num objects, gzip compressed or not) must be local files.
+func asNamesCommand(db *sql.DB, args []string) {
+	usage := "Usage: asnames import [-format asntxt|whois|as2org] [-source NAME] [FILE|URL] | asnames lookup ASN"
+	if len(args) == 0 {
+		log.Fatal(usage)
+	}
+	switch args[0] {
+	case "import":
+		fs := flag.NewFlagSet("asnames import", flag.ExitOnError)
+		format := fs.String("format", "asntxt", "Input format: asntxt (RIPE asn.txt), whois (RPSL aut-num objects) or as2org (CAIDA)")
+		source := fs.String("source", "", "Name of the source, recorded with each name; defaults to the file name")
+		fs.Parse(args[1:])
+		file := asNamesURL
+		if fs.NArg() > 1 {
+			log.Fatal(usage)
+		} else if fs.NArg() == 1 {
+			file = fs.Arg(0)
+		}
+		if *source == "" {
+			*source = file[strings.LastIndexAny(file, "/")+1:]
+		}
+
+		var names []asName
+		var err error
+		switch *format {
+		case "asntxt":
+			names, err = readASNamesText(file)
+		case "whois":
+			err = readWhoisObjects(file, func(obj map[string]string, class string) {
+				if class != "aut-num" {
+					return
+				}
+				asn, err := strconv.ParseUint(strings.TrimPrefix(strings.ToUpper(obj["aut-num"]), "AS"), 10, 32)
+				if err == nil {
+					names = append(names, asName{ASN: asn, Name: obj["as-name"], Description: obj["descr"], Org: obj["org"]})
+				}
+			})
+		case "as2org":
+			names, err = readAS2Org(file)
+		default:
+			log.Fatal("Unknown asnames format: " + *format)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := saveASNames(db, *source, names); err != nil {
+			log.Fatal(err)
+		}
+		auditLog(db, "asnames", *source, 0)
+		logger.Info("Imported AS names", "count", len(names), "file", file)
+	case "lookup":
+		if len(args) != 2 {
+			log.Fatal(usage)
+		}
+		asn, err := strconv.ParseUint(strings.TrimPrefix(strings.ToUpper(args[1]), "AS"), 10, 32)
+		if err != nil {
+			log.Fatal("Invalid ASN: " + args[1])
+		}
+		n, err := lookupASName(db, asn)
+		if err == sql.ErrNoRows {
+			log.Fatal("No name known for AS" + strconv.FormatUint(asn, 10))
+		} else if err != nil {
+			log.Fatal(err)
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(n)
+	default:
+		log.Fatal(usage)
+	}
+}
+
+// enrichCommand implements "enrich asn-names [-names FILE|URL] [-as2org FILE|URL] [-source NAME]":
+// the names of asn.txt are completed with the organizations of a CAIDA as2org file, and
+// ASNs only known to CAIDA are added, all under one source.
+func enrichCommand(db *sql.DB, args []string) {
+	usage := "Usage: enrich asn-names [-names FILE|URL] [-as2org FILE|URL] [-source NAME]"
+	if len(args) == 0 || args[0] != "asn-names" {
+		log.Fatal(usage)
+	}
+	fs := flag.NewFlagSet("enrich asn-names", flag.ExitOnError)
+	namesFile := fs.String("names", asNamesURL, "AS names in the RIPE asn.txt format")
+	as2org := fs.String("as2org", "", "CAIDA as2org file (as-org2info.txt, gzip compressed or not); names only when empty")
+	source := fs.String("source", "enrich", "Name of the source, recorded with each name")
+	fs.Parse(args[1:])
+	if fs.NArg() > 0 {
+		log.Fatal(usage)
+	}
+
+	names, err := readASNamesText(*namesFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *as2org != "" {
+		orgs, err := readAS2Org(*as2org)
+		if err != nil {
+			log.Fatal(err)
+		}
+		names = mergeASNames(names, orgs)
+	}
+	if err := saveASNames(db, *source, names); err != nil {
+		log.Fatal(err)
+	}
+	auditLog(db, "enrich", *source, 0)
+	logger.Info("Saved AS names", "count", len(names), "source", *source)
+}
+
+// mergeASNames adds the organization and missing fields of extra to names, and the
+// ASNs only in extra.
+func mergeASNames(names, extra []asName) []asName {
+	index := map[uint64]int{}
+	for i, n := range names {
+		index[n.ASN] = i
+	}
+	for _, e := range extra {
+		i, ok := index[e.ASN]
+		if !ok {
+			index[e.ASN] = len(names)
+			names = append(names, e)
+			continue
+		}
+		n := &names[i]
+		if n.Org == "" {
+			n.Org = e.Org
+		}
+		if n.Name == "" {
+			n.Name = e.Name
+		}
+		if n.CC == "" {
+			n.CC = e.CC
+		}
+	}
+	return names
+}
+
+// readNameSource returns the content of a local file or URL, decompressed.
+func readNameSource(file string) ([]byte, error) {
+	var data []byte
+	var err error
+	if strings.HasPrefix(file, "http://") || strings.HasPrefix(file, "https://") {
+		data, err = downloadFile(context.Background(), &file)
+	} else {
+		data, err = ioutil.ReadFile(file)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading AS names %s: %w", file, err)
+	}
+	r, _, err := decompressReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("reading AS names %s: %w", file, err)
+	}
+	return ioutil.ReadAll(r)
+}
+
+// readAS2Org parses a CAIDA as2org file: organization lines "org_id|changed|org_name|country|source"
+// followed by AS lines "aut|changed|aut_name|org_id|opaque_id|source", each section
+// announced by a "# format:" comment.
+func readAS2Org(file string) ([]asName, error) {
+	data, err := readNameSource(file)
+	if err != nil {
+		return nil, err
+	}
+
+	type org struct{ name, cc string }
+	orgs := map[string]org{}
+	var names []asName
+	section := ""
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "# format:") {
+			section = strings.SplitN(strings.TrimPrefix(line, "# format:"), "|", 2)[0]
+			continue
+		} else if line == "" || line[0] == '#' {
+			continue
+		}
+		fields := strings.Split(line, "|")
+		switch {
+		case section == "org_id" && len(fields) >= 4:
+			orgs[fields[0]] = org{fields[2], strings.ToUpper(fields[3])}
+		case section == "aut" && len(fields) >= 4:
+			asn, err := strconv.ParseUint(fields[0], 10, 32)
+			if err != nil {
+				continue
+			}
+			// Keep the organization ID until both sections are read
+			names = append(names, asName{ASN: asn, Name: fields[2], Org: fields[3]})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading AS names %s: %w", file, err)
+	}
+	for i := range names {
+		o := orgs[names[i].Org]
+		names[i].Org, names[i].CC = o.name, o.cc
+	}
+	return names, nil
+}
+
+// readASNamesText parses asn.txt lines such as "13335 CLOUDFLARENET - Cloudflare, Inc., US".
+func readASNamesText(file string) ([]asName, error) {
+	data, err := readNameSource(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []asName
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.SplitN(strings.TrimSpace(scanner.Text()), " ", 2)
+		if len(fields) != 2 {
+			continue
+		}
+		asn, err := strconv.ParseUint(fields[0], 10, 32)
+		if err != nil {
+			continue
+		}
+		n := asName{ASN: asn, Name: fields[1]}
+		if i := strings.LastIndex(n.Name, ", "); i >= 0 && len(n.Name)-i == 4 {
+			n.Name, n.CC = n.Name[:i], strings.ToUpper(n.Name[i+2:])
+		}
+		if i := strings.Index(n.Name, " - "); i >= 0 {
+			n.Name, n.Description = n.Name[:i], n.Name[i+3:]
+		}
+		names = append(names, n)
+	}
+	return names, scanner.Err()
+}
+
+// saveASNames replaces the names of a source.
+func saveASNames(db *sql.DB, source string, names []asName) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM AsNames WHERE Source = ?;", source); err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare("REPLACE INTO AsNames VALUES (?, ?, ?, ?, ?, ?, NOW());")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, n := range names {
+		if _, err := stmt.Exec(n.ASN, truncate(n.Name, 128), truncate(n.Description, 255), truncate(n.Org, 255),
+			truncate(n.CC, 2), source); err != nil {
+			return fmt.Errorf("saving AS%d: %w", n.ASN, err)
+		}
+	}
+	return tx.Commit()
+}
+
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
+
+func lookupASName(db *sql.DB, asn uint64) (asName, error) {
+	n := asName{ASN: asn}
+	err := db.QueryRow("SELECT Name, Description, Org, CC, Source FROM AsNames WHERE ASN = ?;", asn).Scan(
+		&n.Name, &n.Description, &n.Org, &n.CC, &n.Source)
+	return n, err
+}
+
+// asNameOf returns the name of an ASN for lookup output, or "" when unknown or
+// when the join is disabled with -no-asnames.
+func asNameOf(db *sql.DB, asn string) string {
+	return asNameRow(db, asn).Name
+}
+
+// asNameRow returns the AsNames row of an ASN, empty when unknown or with -no-asnames.
+func asNameRow(db *sql.DB, asn string) asName {
+	if *f_noASNames {
+		return asName{}
+	}
+	v, err := strconv.ParseUint(asn, 10, 32)
+	if err != nil {
+		return asName{}
+	}
+	n, err := lookupASName(db, v)
+	if err != nil && err != sql.ErrNoRows && !isMissingTable(err) {
+		logger.Debug("Cannot look up the AS name", "asn", v, "err", err)
+	}
+	return n
+}
+
+// loadASNames returns all AS names for exports, or nil with -no-asnames.
+func loadASNames(db *sql.DB) (map[string]string, error) {
+	if *f_noASNames {
+		return nil, nil
+	}
+	rows, err := db.Query("SELECT ASN, Name FROM AsNames;")
+	if isMissingTable(err) {
+		return map[string]string{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	names := map[string]string{}
+	for rows.Next() {
+		var asn, name string
+		if err := rows.Scan(&asn, &name); err != nil {
+			return nil, err
+		}
+		names[asn] = name
+	}
+	return names, rows.Err()
+}
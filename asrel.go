@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// asNeighbor is an AS related to another AS: one of its customers, providers or peers.
+type asNeighbor struct {
+	ASN  uint64 `json:"asn"`
+	Name string `json:"name,omitempty"`
+}
+
+// asRelCommand implements "asrel import [-source NAME] FILE" for CAIDA AS relationship
+// files (provider|customer|-1 and peer|peer|0 lines, optionally bzip2 or gzip
+// compressed) and the queries "asrel customers|providers|peers ASN" and
+// "asrel upstreams ADDRESS|PREFIX", which lists the providers of the origin AS of the
+// most specific BGP prefix covering the address.
+func asRelCommand(db *sql.DB, args []string) {
+	usage := "Usage: asrel import [-source NAME] FILE | asrel customers|providers|peers ASN | asrel upstreams ADDRESS [-format F]"
+	if len(args) < 2 {
+		log.Fatal(usage)
+	}
+	if args[0] == "import" {
+		fs := flag.NewFlagSet("asrel import", flag.ExitOnError)
+		source := fs.String("source", "caida", "Name of the dataset; its earlier relationships are replaced")
+		fs.Parse(args[1:])
+		if fs.NArg() != 1 {
+			log.Fatal(usage)
+		}
+		if err := importASRelationships(db, *source, fs.Arg(0)); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	fs := flag.NewFlagSet("asrel", flag.ExitOnError)
+	format := fs.String("format", "table", "Output format: table, csv or json")
+	fs.Parse(args[2:])
+
+	var asn uint64
+	var err error
+	if args[0] == "upstreams" {
+		var origin string
+		if origin, err = originOf(db, args[1]); err == sql.ErrNoRows {
+			log.Fatal("No BGP prefix covers " + args[1])
+		} else if err != nil {
+			log.Fatal(err)
+		}
+		logger.Debug("Origin", "prefix", args[1], "asn", origin)
+		if asn, err = strconv.ParseUint(strings.SplitN(origin, "_", 2)[0], 10, 32); err != nil {
+			log.Fatal("Origin is not a single ASN: " + origin)
+		}
+		args[0] = "providers"
+	} else if asn, err = strconv.ParseUint(strings.TrimPrefix(strings.ToUpper(args[1]), "AS"), 10, 32); err != nil {
+		log.Fatal("Invalid ASN: " + args[1])
+	}
+	switch args[0] {
+	case "customers", "providers", "peers":
+	default:
+		log.Fatal(usage)
+	}
+
+	list, err := asNeighbors(db, asn, args[0])
+	if err != nil {
+		log.Fatal(err)
+	}
+	rows := make([][]string, 0, len(list))
+	for _, n := range list {
+		rows = append(rows, []string{strconv.FormatUint(n.ASN, 10), n.Name})
+	}
+	writeReport(*format, []string{"asn", "name"}, rows, list)
+}
+
+func importASRelationships(db *sql.DB, source, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r, _, err := decompressReader(f)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM AsRelationships WHERE Source = ?;", source); err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare("REPLACE INTO AsRelationships VALUES (?, ?, ?, ?);")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	var n int
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, "|")
+		if len(fields) < 3 {
+			continue
+		}
+		a, err1 := strconv.ParseUint(fields[0], 10, 32)
+		b, err2 := strconv.ParseUint(fields[1], 10, 32)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		var rel string
+		switch fields[2] {
+		case "-1":
+			rel = "p2c"
+		case "0":
+			rel = "p2p"
+			if b < a { // Peerings are stored once, lower ASN first
+				a, b = b, a
+			}
+		default:
+			continue
+		}
+		if _, err := stmt.Exec(a, b, rel, source); err != nil {
+			return fmt.Errorf("saving AS%d-AS%d: %w", a, b, err)
+		}
+		n++
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("reading %s: %w", file, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	auditLog(db, "asrel", source, 0)
+	logger.Info("Imported AS relationships", "count", n, "source", source)
+	return nil
+}
+
+// asNeighbors returns the customers, providers or peers of an AS with their names.
+func asNeighbors(db *sql.DB, asn uint64, kind string) ([]asNeighbor, error) {
+	query := map[string]string{
+		"customers": "SELECT CustomerAS FROM AsRelationships WHERE ProviderAS = ? AND Relationship = 'p2c' ORDER BY 1;",
+		"providers": "SELECT ProviderAS FROM AsRelationships WHERE CustomerAS = ? AND Relationship = 'p2c' ORDER BY 1;",
+		"peers": `SELECT IF(ProviderAS = ?, CustomerAS, ProviderAS) FROM AsRelationships
+			WHERE (ProviderAS = ? OR CustomerAS = ?) AND Relationship = 'p2p' ORDER BY 1;`,
+	}[kind]
+	params := []interface{}{asn}
+	if kind == "peers" {
+		params = []interface{}{asn, asn, asn}
+	}
+	rows, err := db.Query(query, params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := []asNeighbor{}
+	for rows.Next() {
+		var n asNeighbor
+		if err := rows.Scan(&n.ASN); err != nil {
+			return nil, err
+		}
+		list = append(list, n)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for i := range list {
+		list[i].Name = asNameOf(db, strconv.FormatUint(list[i].ASN, 10))
+	}
+	return list, nil
+}
+
+// originOf returns the origin AS of the most specific BGP prefix covering an address or prefix.
+func originOf(db *sql.DB, target string) (string, error) {
+	_, origin, err := routeOf(db, target)
+	return origin, err
+}
+
+// routeOf returns the most specific BGP prefix covering an address or prefix and its origin AS.
+func routeOf(db *sql.DB, target string) (netip.Prefix, string, error) {
+	p, err := netip.ParsePrefix(target)
+	if err != nil {
+		addr, err := netip.ParseAddr(target)
+		if err != nil {
+			return netip.Prefix{}, "", fmt.Errorf("invalid address or prefix: %s", target)
+		}
+		p = netip.PrefixFrom(addr, addr.BitLen())
+	}
+	family := 4
+	if p.Addr().Is6() {
+		family = 6
+	}
+	var prefix, origin string
+	err = db.QueryRow(`SELECT Prefix, OriginAS FROM BgpPrefixes WHERE Family = ? AND StartIP <= ? AND EndIP >= ?
+		ORDER BY StartIP DESC, EndIP LIMIT 1;`, family, p.Masked().Addr().AsSlice(), lastAddr(p).AsSlice()).Scan(&prefix, &origin)
+	if err != nil {
+		return netip.Prefix{}, "", err
+	}
+	route, err := netip.ParsePrefix(prefix)
+	return route, origin, err
+}
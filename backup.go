@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// backupTables are dumped in an order that restores cleanly.
+var backupTables = []string{"Registries", "RegistryMirrors", "Datasets", "Summaries", "Records_ipv4", "Records_ipv6", "Records_asn",
+	"ImportJobs", "AuditLog", "ApiKeys", "RawFiles", "Changes", "Resources", "Transfers", "DatasetTotals", "Orgs", "Watches",
+	"LatestAllocations", "CountryRollup", "HolderRollup", "DatasetQuality", "Overlaps", "AsNames", "GrowthSeries", "BgpPrefixes", "AsRelationships", "Vrps", "IrrRoutes", "AbuseContacts", "RdnsSuffixes", "GeofeedEntries", "SpecialPurpose", "FirewallPolicies", "DnsblZones", "Tags", "DownloadValidators", "RdapCache", "SchemaVersion"}
+
+// The backup file is gzipped JSON Lines: a header object, then per table an object
+// naming the table and its columns followed by one array per row. Values are JSON
+// strings, null, or {"b64": "..."} for binary data such as IPv6 addresses.
+type backupHeader struct {
+	Format  string    `json:"format"`
+	Version int       `json:"version"`
+	Created time.Time `json:"created"`
+	Schema  string    `json:"schema"`
+}
+
+type backupTable struct {
+	Table   string   `json:"table"`
+	Columns []string `json:"columns"`
+}
+
+type backupBinary struct {
+	B64 string `json:"b64"`
+}
+
+// backupCommand writes the full dataset state to the file given as argument.
+func backupCommand(db *sql.DB, args []string) {
+	if len(args) != 1 {
+		log.Fatal("Usage: backup FILE")
+	}
+	file, err := os.Create(args[0])
+	if err != nil {
+		log.Fatal(err)
+	}
+	zw := gzip.NewWriter(file)
+	w := bufio.NewWriter(zw)
+	enc := json.NewEncoder(w)
+
+	enc.Encode(backupHeader{Format: "ip2asn-backup", Version: 1, Created: time.Now().UTC(), Schema: namespaceSchema(*f_namespace)})
+	for _, table := range backupTables {
+		n, err := backupTableRows(db, table, enc)
+		if isMissingTable(err) {
+			logger.Debug("Skipping missing table", "table", table)
+			continue
+		}
+		if err != nil {
+			log.Fatal(fmt.Sprintf("Backing up %s: %s", table, err.Error()))
+		}
+		logger.Info("Backed up table", "table", table, "rows", n)
+	}
+
+	if err := w.Flush(); err != nil {
+		log.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		log.Fatal(err)
+	}
+	if err := file.Close(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func backupTableRows(db *sql.DB, table string, enc *json.Encoder) (int, error) {
+	rows, err := db.Query("SELECT * FROM " + table + ";")
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return 0, err
+	}
+	enc.Encode(backupTable{Table: table, Columns: columns})
+
+	n := 0
+	values := make([]sql.RawBytes, len(columns))
+	ptrs := make([]interface{}, len(columns))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			return n, err
+		}
+		row := make([]interface{}, len(columns))
+		for i, v := range values {
+			switch {
+			case v == nil:
+				row[i] = nil
+			case utf8.Valid(v):
+				row[i] = string(v)
+			default:
+				row[i] = backupBinary{B64: base64.StdEncoding.EncodeToString(v)}
+			}
+		}
+		if err := enc.Encode(row); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, rows.Err()
+}
+
+// restoreUpserted are the tables init seeds; their restored rows replace the seeded ones.
+var restoreUpserted = map[string]bool{"Registries": true, "SchemaVersion": true}
+
+// restoreStatement returns the statement restoring a row of table, and the index of a
+// column left out of it or -1. AuditLog rows are renumbered after the entries of the
+// current schema, such as that of init, as the log is append-only.
+func restoreStatement(table backupTable, force bool) (string, int) {
+	columns, omit := table.Columns, -1
+	if table.Table == "AuditLog" {
+		for i, c := range columns {
+			if c == "ID" {
+				columns, omit = append(append([]string{}, columns[:i]...), columns[i+1:]...), i
+				break
+			}
+		}
+	}
+	insert := "INSERT INTO "
+	if force {
+		insert = "INSERT IGNORE INTO "
+	}
+	var update []string
+	if restoreUpserted[table.Table] {
+		insert = "INSERT INTO "
+		for _, c := range columns {
+			update = append(update, fmt.Sprintf("%s = VALUES(%[1]s)", c))
+		}
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ")
+	query := fmt.Sprintf("%s%s (%s) VALUES (%s)", insert, table.Table, strings.Join(columns, ", "), placeholders)
+	if len(update) > 0 {
+		query += " ON DUPLICATE KEY UPDATE " + strings.Join(update, ", ")
+	}
+	return query + ";", omit
+}
+
+// restoreCommand loads a backup into the current schema, normally just created by init.
+// With -force rows that already exist are skipped.
+func restoreCommand(db *sql.DB, args []string) {
+	if len(args) != 1 {
+		log.Fatal("Usage: restore FILE")
+	}
+	file, err := os.Open(args[0])
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer file.Close()
+	zr, err := gzip.NewReader(file)
+	if err != nil {
+		log.Fatal(err)
+	}
+	dec := json.NewDecoder(bufio.NewReader(zr))
+
+	var hdr backupHeader
+	if err := dec.Decode(&hdr); err != nil || hdr.Format != "ip2asn-backup" {
+		log.Fatal("Not an ip2asn backup file: " + args[0])
+	}
+	if hdr.Version != 1 {
+		log.Fatal(fmt.Sprintf("Unsupported backup version %d", hdr.Version))
+	}
+	logger.Info("Restoring backup", "schema", hdr.Schema, "created", hdr.Created.Format(time.RFC3339))
+
+	var table backupTable
+	var stmt *sql.Stmt
+	var tx *sql.Tx
+	n, omit := 0, -1
+	finishTable := func() {
+		if tx == nil {
+			return
+		}
+		stmt.Close()
+		if err := tx.Commit(); err != nil {
+			log.Fatal(err)
+		}
+		logger.Info("Restored table", "table", table.Table, "rows", n)
+		tx = nil
+	}
+
+	for {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err == io.EOF {
+			break
+		} else if err != nil {
+			log.Fatal(err)
+		}
+
+		if raw[0] == '{' { // Start of the next table
+			finishTable()
+			if err := json.Unmarshal(raw, &table); err != nil {
+				log.Fatal(err)
+			}
+			if tx, err = db.Begin(); err != nil {
+				log.Fatal(err)
+			}
+			var query string
+			query, omit = restoreStatement(table, *f_force)
+			if stmt, err = tx.Prepare(query); err != nil {
+				log.Fatal(fmt.Sprintf("Restoring %s: %s", table.Table, err.Error()))
+			}
+			n = 0
+			continue
+		}
+
+		var row []json.RawMessage
+		if err := json.Unmarshal(raw, &row); err != nil || tx == nil {
+			log.Fatal("Corrupt backup file: row outside of a table")
+		}
+		values := make([]interface{}, 0, len(row))
+		for i, field := range row {
+			if i != omit {
+				values = append(values, restoreValue(field))
+			}
+		}
+		if _, err := stmt.Exec(values...); err != nil {
+			log.Fatal(fmt.Sprintf("Restoring %s row %d: %s", table.Table, n+1, err.Error()))
+		}
+		n++
+	}
+	finishTable()
+	auditLog(db, "restore", args[0], 0) // After the restored AuditLog rows so their IDs stay free
+}
+
+func restoreValue(field json.RawMessage) interface{} {
+	if string(field) == "null" {
+		return nil
+	}
+	var s string
+	if json.Unmarshal(field, &s) == nil {
+		return s
+	}
+	var bin backupBinary
+	if json.Unmarshal(field, &bin) == nil && bin.B64 != "" {
+		if b, err := base64.StdEncoding.DecodeString(bin.B64); err == nil {
+			return b
+		}
+	}
+	return nil
+}
+
+// isMissingTable reports whether err is MySQL's "table doesn't exist".
+func isMissingTable(err error) bool {
+	driverErr, ok := err.(*mysql.MySQLError)
+	return ok && driverErr.Number == 1146
+}
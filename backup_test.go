@@ -0,0 +1,61 @@
+package main
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestRestoreFreshSchema backs up a database and restores it into one just created by
+// init, which already holds the seeded registries, its schema version and its audit
+// entry. It needs a MySQL server, as TestMigrateBaselineSchema.
+func TestRestoreFreshSchema(t *testing.T) {
+	if os.Getenv("IP2ASN_TEST_MYSQL") == "" {
+		t.Skip("set IP2ASN_TEST_MYSQL=1 and the MYSQL_* variables to test against a MySQL server")
+	}
+	src := testSchema(t, "ip2asn_test_backup", nil)
+	initCommand(src, nil)
+	for _, s := range []string{
+		`UPDATE Registries SET LatestDataSetLocation = 'https://mirror.example/delegated-ripencc-latest'
+			WHERE ShortName = 'ripencc';`,
+		`INSERT INTO Datasets (ID, ID_Registries, serial, version, records, UTCoffset) VALUES (1, 'ripencc', 1, '2', 1, 0);`,
+		`INSERT INTO Records_ipv4 (ID_Datasets, ID_Registries, CC, FirstIP, HostCount, RecordDate, State, OpaqueID,
+			ID_LastDatasets, LastIP) VALUES
+			(1, 'ripencc', 'NL', INET_ATON('100.64.0.0'), 256, '2020-01-01', 'allocated', 'org-a', 1, INET_ATON('100.64.0.255'));`,
+	} {
+		if _, err := src.Exec(s); err != nil {
+			t.Fatalf("%s: %v", s, err)
+		}
+	}
+	path := filepath.Join(t.TempDir(), "backup.jsonl.gz")
+	backupCommand(src, []string{path})
+
+	dst := testSchema(t, "ip2asn_test_restore", nil)
+	initCommand(dst, nil)
+	restoreCommand(dst, []string{path})
+
+	count := func(db *sql.DB, query string) int {
+		t.Helper()
+		var n int
+		if err := db.QueryRow(query).Scan(&n); err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		return n
+	}
+	for _, q := range []string{
+		"SELECT COUNT(*) FROM Registries;",
+		"SELECT COUNT(*) FROM SchemaVersion;",
+		"SELECT COUNT(*) FROM Datasets;",
+		"SELECT COUNT(*) FROM Records_ipv4;",
+		"SELECT COUNT(*) FROM Registries WHERE LatestDataSetLocation LIKE 'https://mirror.example/%';",
+	} {
+		if got, want := count(dst, q), count(src, q); got != want {
+			t.Errorf("%s %d after restore; want %d", q, got, want)
+		}
+	}
+	// The backed-up init entry, then those of init and restore in the new schema
+	if got, want := count(dst, "SELECT COUNT(*) FROM AuditLog;"), count(src, "SELECT COUNT(*) FROM AuditLog;")+2; got != want {
+		t.Errorf("%d audit entries after restore; want %d", got, want)
+	}
+}
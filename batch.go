@@ -0,0 +1,105 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// maxBatchSize keeps a batch within MySQL's 65535 placeholders at up to 9 per record.
+const maxBatchSize = 7000
+
+// recordBatch collects the records of one type and writes them with multi-row INSERTs
+// in the import transaction. Records already stored by an earlier dataset get their
+// ID_LastDatasets updated, marking them as seen in this one, and their holder and
+// extensions from this one.
+type recordBatch struct {
+	tx       *sql.Tx
+	table    string
+	insert   string // statement up to VALUES
+	row      string // placeholders of one record
+	suffix   string // handling of duplicates
+	size     int
+	args     []interface{}
+	n        int
+	written  uint64 // records written
+	affected uint64 // rows affected, as reported by the database
+}
+
+func newRecordBatch(tx *sql.Tx, recordType string, dataset int64, size int) *recordBatch {
+	conversion, derived := "?", ""
+	switch recordType {
+	case "ipv4":
+		// LastIP from the columns before it, then Prefixes
+		conversion, derived = "INET_ATON(?)", ", IF(HostCount > 0, FirstIP + HostCount - 1, NULL), ?"
+	case "ipv6":
+		conversion, derived = "INET6_ATON(?)", ", INET6_ATON(?)" // LastIP
+	}
+	return &recordBatch{tx: tx, table: "Records_" + recordType, insert: "INSERT INTO Records_" + recordType + " VALUES ",
+		row:    fmt.Sprintf("(DEFAULT, %d, ?, ?, %s, ?, ?, ?, ?, ?, %d%s)", dataset, conversion, dataset, derived),
+		suffix: " ON DUPLICATE KEY UPDATE ID_LastDatasets = VALUES(ID_LastDatasets), OpaqueID = VALUES(OpaqueID), Extensions = VALUES(Extensions);",
+		size:   clampBatchSize(size)}
+}
+
+// clampBatchSize limits -batch-size to what a single statement can hold.
+func clampBatchSize(size int) int {
+	if size < 1 {
+		return 1
+	} else if size > maxBatchSize {
+		return maxBatchSize
+	}
+	return size
+}
+
+// add queues a record: registry, cc, start, value, date, status, opaque ID and extensions,
+// and for IPv4 the prefixes, for IPv6 the last address.
+func (b *recordBatch) add(args ...interface{}) error {
+	b.args = append(b.args, args...)
+	b.n++
+	if b.n >= b.size {
+		return b.flush()
+	}
+	return nil
+}
+
+// flush writes the queued records.
+func (b *recordBatch) flush() error {
+	if b.n == 0 {
+		return nil
+	}
+	query := b.insert + strings.TrimSuffix(strings.Repeat(b.row+",", b.n), ",") + b.suffix
+	res, err := b.tx.Exec(query, b.args...)
+	if err != nil {
+		return fmt.Errorf("inserting %d records into %s: %w", b.n, b.table, err)
+	}
+	if n, err := res.RowsAffected(); err == nil {
+		b.affected += uint64(n)
+	}
+	b.written += uint64(b.n)
+	b.args, b.n = b.args[:0], 0
+	return nil
+}
+
+// publishNewRecords publishes a record event for every record first stored by a dataset.
+func publishNewRecords(db *sql.DB, registry string, dataset int64, serial uint64) error {
+	for t, start := range map[string]string{"ipv4": "INET_NTOA(FirstIP)", "ipv6": "INET6_NTOA(FirstIP)", "asn": "CAST(ASN AS CHAR)"} {
+		rows, err := db.Query(fmt.Sprintf(`SELECT CC, %s, %s, IFNULL(DATE_FORMAT(RecordDate, '%%Y%%m%%d'), ''), State
+			FROM Records_%s WHERE ID_Registries = ? AND ID_Datasets = ?;`, start, keyTypes[t][1], t), registry, dataset)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			ev := RecordEvent{Registry: registry, Type: t, Dataset: dataset, Serial: serial}
+			if err := rows.Scan(&ev.CC, &ev.Start, &ev.Value, &ev.Date, &ev.Status); err != nil {
+				rows.Close()
+				return err
+			}
+			publishRecordEvent(ev)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
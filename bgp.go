@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/krassi/ip2asn/pkg/mrt"
+)
+
+// bgpCommand implements "bgp import [-source NAME] FILE", loading prefixes seen in BGP,
+// and "bgp lookup [-format table|csv|json] ADDRESS...". FILE is an MRT RIB dump of
+// RouteViews or RIPE RIS (rib.*.bz2, bview.*.gz), a CAIDA RouteViews prefix2as file
+// (prefix, length and origin separated by tabs) or a "prefix/len origin" table dump such
+// as bgp.tools' table.txt; gzip and bzip2 compressed files are accepted. Origins are
+// kept as given, e.g. "64496_64497" for MOAS; MRT origins seen by the most peers come first.
+func bgpCommand(db *sql.DB, args []string) {
+	usage := "Usage: bgp import [-source NAME] FILE | bgp lookup [-format table|csv|json] ADDRESS..."
+	if len(args) == 0 {
+		log.Fatal(usage)
+	}
+	switch args[0] {
+	case "import":
+		fs := flag.NewFlagSet("bgp import", flag.ExitOnError)
+		source := fs.String("source", "routeviews", "Name of the BGP view; its earlier prefixes are replaced")
+		fs.Parse(args[1:])
+		if fs.NArg() != 1 {
+			log.Fatal(usage)
+		}
+		if err := importBGPPrefixes(db, *source, fs.Arg(0)); err != nil {
+			log.Fatal(err)
+		}
+	case "lookup":
+		fs := flag.NewFlagSet("bgp lookup", flag.ExitOnError)
+		format := fs.String("format", "table", "Output format: table, csv or json")
+		fs.Parse(args[1:])
+		if fs.NArg() == 0 {
+			log.Fatal(usage)
+		}
+		bgpLookup(db, *format, fs.Args())
+	default:
+		log.Fatal(usage)
+	}
+}
+
+func importBGPPrefixes(db *sql.DB, source, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	dr, _, err := decompressReader(f)
+	if err != nil {
+		return err
+	}
+	r := bufio.NewReader(dr)
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM BgpPrefixes WHERE Source = ?;", source); err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare("REPLACE INTO BgpPrefixes VALUES (?, ?, ?, ?, ?, ?);")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	var n, skipped int
+	save := func(p netip.Prefix, origin string) error {
+		family := 4
+		if p.Addr().Is6() {
+			family = 6
+		}
+		if _, err := stmt.Exec(family, p.Addr().AsSlice(), lastAddr(p).AsSlice(), p.String(), truncate(origin, 64), source); err != nil {
+			return fmt.Errorf("saving %s: %w", p, err)
+		}
+		if n++; n%100000 == 0 {
+			logger.Debug("Importing BGP prefixes", "count", n)
+		}
+		return nil
+	}
+
+	if header, _ := r.Peek(12); mrt.IsMRT(header) {
+		routes := mrt.NewReader(r)
+		for {
+			route, err := routes.Next()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return fmt.Errorf("reading %s: %w", file, err)
+			}
+			if route.Prefix.Bits() == 0 { // Default routes cover everything
+				skipped++
+				continue
+			}
+			if err := save(route.Prefix, strings.Join(route.Origins, "_")); err != nil {
+				return err
+			}
+		}
+	} else {
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			p, origin, ok := parseBGPLine(scanner.Text())
+			if !ok {
+				skipped++
+				continue
+			}
+			if err := save(p, origin); err != nil {
+				return err
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return fmt.Errorf("reading %s: %w", file, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	auditLog(db, "bgp", source, 0)
+	logger.Info("Imported BGP prefixes", "count", n, "source", source, "skipped", skipped)
+	return nil
+}
+
+// parseBGPLine parses "192.0.2.0\t24\t64496" or "192.0.2.0/24 64496".
+func parseBGPLine(line string) (netip.Prefix, string, bool) {
+	fields := strings.Fields(line)
+	if len(fields) == 3 && !strings.Contains(fields[0], "/") {
+		fields = []string{fields[0] + "/" + fields[1], fields[2]}
+	}
+	if len(fields) != 2 || strings.HasPrefix(fields[0], "#") {
+		return netip.Prefix{}, "", false
+	}
+	p, err := netip.ParsePrefix(fields[0])
+	if err != nil {
+		return netip.Prefix{}, "", false
+	}
+	return p.Masked(), strings.TrimPrefix(strings.ToUpper(fields[1]), "AS"), true
+}
+
+// bgpRoute is the most specific route of a BGP view covering an address.
+type bgpRoute struct {
+	Source   string `json:"source"`
+	Route    string `json:"route"`
+	OriginAS string `json:"origin_as"`
+	ASName   string `json:"as_name,omitempty"`
+	RPKI     string `json:"rpki,omitempty"` // when VRPs are imported
+}
+
+// originAnswer puts the delegation of an address next to its routes in each BGP view.
+type originAnswer struct {
+	Query      string     `json:"query"`
+	Registry   string     `json:"registry,omitempty"`
+	CC         string     `json:"cc,omitempty"`
+	Start      string     `json:"start,omitempty"`
+	Value      uint64     `json:"value,omitempty"`
+	Status     string     `json:"status,omitempty"`
+	Holder     string     `json:"holder,omitempty"`
+	HolderName string     `json:"holder_name,omitempty"`
+	Routes     []bgpRoute `json:"routes"`
+}
+
+// bgpLookup prints who each address is allocated to and which ASNs currently originate
+// it, one row per BGP view.
+func bgpLookup(db *sql.DB, format string, queries []string) {
+	var list []originAnswer
+	var rows [][]string
+	for _, q := range queries {
+		addr, err := netip.ParseAddr(q)
+		if err != nil {
+			log.Fatal("Invalid address: " + q)
+		}
+		addr = addr.Unmap()
+		a, err := lookupQuery(db, addr.String())
+		if err == sql.ErrNoRows {
+			logger.Warn("No delegation found", "query", q)
+		} else if err != nil {
+			log.Fatal(err)
+		}
+		o := originAnswer{Query: q, Registry: a.Registry, CC: a.CC, Start: a.Start, Value: a.Value, Status: a.Status,
+			Holder: a.Holder, Routes: []bgpRoute{}}
+		if o.Holder != "" {
+			o.HolderName, _ = orgName(db, o.Registry, o.Holder)
+		}
+		if o.Routes, err = routesOf(db, addr); err != nil {
+			log.Fatal(err)
+		}
+		list = append(list, o)
+
+		value := ""
+		if o.Registry != "" {
+			value = fmt.Sprint(o.Value)
+		}
+		row := []string{o.Query, o.Registry, o.CC, o.Start, value, o.Status, o.Holder, o.HolderName}
+		if len(o.Routes) == 0 {
+			rows = append(rows, append(row, "", "", "", "", ""))
+		}
+		for _, r := range o.Routes {
+			rows = append(rows, append(row[:len(row):len(row)], r.Source, r.Route, r.OriginAS, r.ASName, r.RPKI))
+		}
+	}
+	writeReport(format, []string{"query", "registry", "cc", "start", "value", "status", "holder", "holder_name",
+		"source", "route", "origin_as", "as_name", "rpki"}, rows, list)
+}
+
+// routesOf returns the most specific route covering addr in each BGP view, validated
+// when VRPs are imported.
+func routesOf(db *sql.DB, addr netip.Addr) ([]bgpRoute, error) {
+	family := 4
+	if addr.Is6() {
+		family = 6
+	}
+	rows, err := db.Query(`SELECT Source, Prefix, OriginAS FROM BgpPrefixes WHERE Family = ? AND StartIP <= ? AND EndIP >= ?
+		ORDER BY Source, StartIP DESC, EndIP;`, family, addr.AsSlice(), addr.AsSlice())
+	list := []bgpRoute{}
+	if isMissingTable(err) {
+		return list, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var validate bool
+	if err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM Vrps);").Scan(&validate); err != nil && !isMissingTable(err) {
+		return nil, err
+	}
+	for rows.Next() {
+		var r bgpRoute
+		if err := rows.Scan(&r.Source, &r.Route, &r.OriginAS); err != nil {
+			return nil, err
+		}
+		if len(list) > 0 && list[len(list)-1].Source == r.Source {
+			continue
+		}
+		r.ASName = asNameOf(db, strings.SplitN(r.OriginAS, "_", 2)[0])
+		asn, err := strconv.ParseUint(strings.SplitN(r.OriginAS, "_", 2)[0], 10, 32)
+		if route, perr := netip.ParsePrefix(r.Route); validate && err == nil && perr == nil {
+			if r.RPKI, err = rpkiStatus(db, route, asn); err != nil {
+				return nil, err
+			}
+		}
+		list = append(list, r)
+	}
+	return list, rows.Err()
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+)
+
+// ianaSpecialRegistries are the IANA IPv4 and IPv6 special-purpose address registries.
+var ianaSpecialRegistries = []string{
+	"https://www.iana.org/assignments/iana-ipv4-special-registry/iana-ipv4-special-registry-1.csv",
+	"https://www.iana.org/assignments/iana-ipv6-special-registry/iana-ipv6-special-registry-1.csv",
+}
+
+// allAddresses are the IPv4 and IPv6 address spaces.
+var allAddresses = []netip.Prefix{netip.MustParsePrefix("0.0.0.0/0"), netip.MustParsePrefix("::/0")}
+
+// specialBlock is an entry of a special-purpose registry.
+type specialBlock struct {
+	Prefix string `json:"prefix"`
+	Name   string `json:"name"`
+	RFC    string `json:"rfc"`
+	Global bool   `json:"globally_reachable"`
+}
+
+// bogonsCommand implements "bogons import [FILE|URL...]", loading the IANA special-purpose
+// registries (by default downloaded from iana.org), "bogons list [-full] [-family 4|6]",
+// printing the bogon prefixes or, with -full, also the space no registry has delegated,
+// and "bogons check ADDRESS".
+func bogonsCommand(db *sql.DB, args []string) {
+	usage := "Usage: bogons import [FILE|URL...] | bogons list [-full] [-family 4|6] | bogons check ADDRESS"
+	if len(args) == 0 {
+		log.Fatal(usage)
+	}
+	switch args[0] {
+	case "import":
+		sources := args[1:]
+		if len(sources) == 0 {
+			sources = ianaSpecialRegistries
+		}
+		if err := importSpecialRegistries(db, sources); err != nil {
+			log.Fatal(err)
+		}
+	case "list":
+		fs := flag.NewFlagSet("bogons list", flag.ExitOnError)
+		full := fs.Bool("full", false, "Include the unallocated space (full bogons)")
+		family := fs.Int("family", 0, "Only IPv4 (4) or IPv6 (6) prefixes")
+		fs.Parse(args[1:])
+		if err := writeBogons(db, os.Stdout, *full, *family); err != nil {
+			log.Fatal(err)
+		}
+	case "check":
+		if len(args) != 2 {
+			log.Fatal(usage)
+		}
+		addr, err := netip.ParseAddr(args[1])
+		if err != nil {
+			log.Fatal("Invalid address: " + args[1])
+		}
+		status, block, err := bogonStatus(db, addr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(strings.TrimSpace(status + " " + block.Prefix + " " + block.Name))
+	default:
+		log.Fatal(usage)
+	}
+}
+
+// importSpecialRegistries replaces the special-purpose blocks with the given CSV registries.
+func importSpecialRegistries(db *sql.DB, sources []string) error {
+	var blocks []specialBlock
+	for _, source := range sources {
+		var data []byte
+		var err error
+		if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+			data, err = downloadFile(context.Background(), &source)
+		} else {
+			data, err = ioutil.ReadFile(source)
+		}
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", source, err)
+		}
+		list, err := parseSpecialRegistry(data)
+		if err != nil {
+			return fmt.Errorf("parsing %s: %w", source, err)
+		}
+		blocks = append(blocks, list...)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM SpecialPurpose;"); err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare("REPLACE INTO SpecialPurpose VALUES (?, ?, ?, ?, ?, ?, ?);")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, b := range blocks {
+		p := netip.MustParsePrefix(b.Prefix)
+		family := 4
+		if p.Addr().Is6() {
+			family = 6
+		}
+		if _, err := stmt.Exec(family, p.Addr().AsSlice(), lastAddr(p).AsSlice(), b.Prefix, truncate(b.Name, 255),
+			truncate(b.RFC, 255), b.Global); err != nil {
+			return fmt.Errorf("saving %s: %w", b.Prefix, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	auditLog(db, "bogons", strings.Join(sources, " "), 0)
+	logger.Info("Imported special-purpose blocks", "count", len(blocks))
+	return nil
+}
+
+// parseSpecialRegistry reads an IANA special-purpose registry CSV: Address Block, Name,
+// RFC, Allocation Date, Termination Date, Source, Destination, Forwardable, Globally
+// Reachable, Reserved-by-Protocol. Address blocks may list several prefixes and carry
+// footnote marks such as "[2]"; a block is only globally reachable if marked "True".
+func parseSpecialRegistry(data []byte) ([]specialBlock, error) {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.FieldsPerRecord = -1
+	var blocks []specialBlock
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if len(rec) < 9 {
+			continue
+		}
+		for _, field := range strings.FieldsFunc(rec[0], func(c rune) bool { return c == ',' || c == ' ' }) {
+			p, err := netip.ParsePrefix(field)
+			if err != nil {
+				continue // The header and footnote marks
+			}
+			blocks = append(blocks, specialBlock{Prefix: p.Masked().String(), Name: strings.TrimSpace(rec[1]),
+				RFC: strings.Join(strings.Fields(rec[2]), " "), Global: strings.HasPrefix(strings.TrimSpace(rec[8]), "True")})
+		}
+	}
+	return blocks, nil
+}
+
+// specialBogons returns the special-purpose blocks that are not globally reachable.
+func specialBogons(db *sql.DB) ([]netip.Prefix, error) {
+	rows, err := db.Query("SELECT Prefix FROM SpecialPurpose WHERE NOT GloballyReachable;")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var prefixes []netip.Prefix
+	for rows.Next() {
+		var prefix string
+		if err := rows.Scan(&prefix); err != nil {
+			return nil, err
+		}
+		if p, err := netip.ParsePrefix(prefix); err == nil {
+			prefixes = append(prefixes, p)
+		}
+	}
+	return prefixes, rows.Err()
+}
+
+// bogonPrefixes returns the aggregated bogons of a family (0 for both) and, with full,
+// the space outside any current allocation or assignment.
+func bogonPrefixes(db *sql.DB, full bool, family int) ([]netip.Prefix, error) {
+	bogons, err := specialBogons(db)
+	if err != nil {
+		return nil, err
+	}
+	if full {
+		var delegated []netip.Prefix
+		for _, kind := range []string{"ipv4", "ipv6"} {
+			list, err := allocatedPrefixes(db, kind, "", "")
+			if err != nil {
+				return nil, err
+			}
+			delegated = append(delegated, list...)
+		}
+		bogons = append(bogons, subtractPrefixes(allAddresses, delegated)...)
+	}
+	var list []netip.Prefix
+	for _, p := range aggregatePrefixes(bogons) {
+		if family == 0 || (family == 4) == p.Addr().Is4() {
+			list = append(list, p)
+		}
+	}
+	return list, nil
+}
+
+func writeBogons(db *sql.DB, w io.Writer, full bool, family int) error {
+	list, err := bogonPrefixes(db, full, family)
+	if err != nil {
+		return err
+	}
+	for _, p := range list {
+		fmt.Fprintln(w, p)
+	}
+	return nil
+}
+
+// bogonStatus returns "bogon" with the special-purpose block for addresses that are not
+// globally reachable, "unallocated" for addresses outside any delegation, else "".
+func bogonStatus(db *sql.DB, addr netip.Addr) (string, specialBlock, error) {
+	family := 4
+	if addr.Is6() {
+		family = 6
+	}
+	var b specialBlock
+	err := db.QueryRow(`SELECT Prefix, Name, Rfc, GloballyReachable FROM SpecialPurpose
+		WHERE Family = ? AND StartIP <= ? AND EndIP >= ? ORDER BY StartIP DESC, EndIP LIMIT 1;`,
+		family, addr.AsSlice(), addr.AsSlice()).Scan(&b.Prefix, &b.Name, &b.RFC, &b.Global)
+	if err == nil && !b.Global {
+		return "bogon", b, nil
+	} else if err != nil && err != sql.ErrNoRows {
+		return "", specialBlock{}, err
+	}
+	if _, _, _, err := rirRecord(db, addr); err == sql.ErrNoRows {
+		return "unallocated", specialBlock{}, nil
+	} else if err != nil {
+		return "", specialBlock{}, err
+	}
+	return "", specialBlock{}, nil
+}
+
+// handleBogons serves /v1/bogons as a plain prefix list; full=1 includes unallocated
+// space and family=4 or 6 selects one address family.
+func handleBogons(db *sql.DB, ns string, w http.ResponseWriter, r *http.Request) {
+	family := 0
+	fmt.Sscan(r.URL.Query().Get("family"), &family)
+	list, err := bogonPrefixes(db, r.URL.Query().Get("full") == "1", family)
+	if err != nil {
+		http.Error(w, "cannot compute bogons", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	for _, p := range list {
+		fmt.Fprintln(w, p)
+	}
+}
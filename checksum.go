@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// checksumFiles are the checksum files registries publish next to their delegated
+// files, strongest first, e.g. delegated-ripencc-extended-latest.md5 containing
+// "MD5 (delegated-ripencc-extended-latest) = 0123...".
+var checksumFiles = []struct {
+	ext  string
+	hash func() hash.Hash
+	hex  *regexp.Regexp
+}{
+	{".sha256", sha256.New, regexp.MustCompile(`\b[0-9a-fA-F]{64}\b`)},
+	{".md5", md5.New, regexp.MustCompile(`\b[0-9a-fA-F]{32}\b`)},
+}
+
+// spoolFile is a downloaded file in a temporary file, removed on Close.
+type spoolFile struct {
+	*os.File
+}
+
+func (f spoolFile) Close() error {
+	err := f.File.Close()
+	os.Remove(f.Name())
+	return err
+}
+
+// downloadDataset downloads a delegated file and, unless -skip-checksum is set, verifies
+// it against the registry's published checksum. For the check the file is spooled to a
+// temporary file, so it is complete and verified before the import starts. A mismatch is
+// retried once, as the file may have been replaced during the download; without a
+// checksum file the download is accepted with a warning.
+func downloadDataset(ctx context.Context, url string) (io.ReadCloser, error) {
+	for attempt := 1; ; attempt++ {
+		body, err := openDownload(ctx, url)
+		if err != nil {
+			return nil, err
+		}
+		if *f_skipChecksum {
+			return body, nil
+		}
+		f, sums, err := spoolDownload(body)
+		if err != nil {
+			return nil, err
+		}
+		if err = verifyChecksum(ctx, url, sums); err == nil {
+			return f, nil
+		}
+		f.Close()
+		if attempt == 2 {
+			return nil, err
+		}
+		logger.Warn("Checksum verification failed; downloading again", "err", err)
+	}
+}
+
+// spoolDownload copies a download into a temporary file and returns the file, rewound,
+// with the digests of the checksumFiles by extension.
+func spoolDownload(body io.ReadCloser) (io.ReadCloser, map[string]string, error) {
+	defer body.Close()
+	f, err := ioutil.TempFile("", "ip2asn-*")
+	if err != nil {
+		return nil, nil, err
+	}
+	spool := spoolFile{f}
+	hashes := make([]hash.Hash, len(checksumFiles))
+	writers := []io.Writer{f}
+	for i, c := range checksumFiles {
+		hashes[i] = c.hash()
+		writers = append(writers, hashes[i])
+	}
+	if _, err := io.Copy(io.MultiWriter(writers...), body); err != nil {
+		spool.Close()
+		return nil, nil, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		spool.Close()
+		return nil, nil, err
+	}
+	sums := map[string]string{}
+	for i, c := range checksumFiles {
+		sums[c.ext] = hex.EncodeToString(hashes[i].Sum(nil))
+	}
+	return spool, sums, nil
+}
+
+// verifyChecksum compares the digests of a download with the first checksum file found
+// next to url.
+func verifyChecksum(ctx context.Context, url string, sums map[string]string) error {
+	client := &http.Client{Timeout: 30 * time.Second}
+	for _, c := range checksumFiles {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url+c.ext, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			logger.Debug("Cannot fetch checksum", "url", url+c.ext, "err", err)
+			continue
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil || resp.StatusCode != http.StatusOK {
+			continue
+		}
+		want := c.hex.FindString(string(body))
+		if want == "" {
+			logger.Warn("No checksum found", "url", url+c.ext)
+			continue
+		}
+		if got := sums[c.ext]; got != strings.ToLower(want) {
+			return fmt.Errorf("checksum mismatch for %s: %s published %s, downloaded file has %s", url, strings.TrimPrefix(c.ext, "."), want, got)
+		}
+		logger.Debug("Verified checksum", "url", url, "checksum_url", url+c.ext)
+		return nil
+	}
+	logger.Warn("No checksum published; import not verified", "url", url)
+	return nil
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/binary"
+	"math"
+	"math/bits"
+	"net/netip"
+	"sort"
+	"strings"
+)
+
+// ipv4RangeToPrefixes splits an IPv4 range given as start address and address count,
+// as used in the delegated files, into the minimal list of CIDR prefixes.
+func ipv4RangeToPrefixes(start netip.Addr, count uint64) []netip.Prefix {
+	var prefixes []netip.Prefix
+	b := start.As4()
+	addr := uint64(b[0])<<24 | uint64(b[1])<<16 | uint64(b[2])<<8 | uint64(b[3])
+	end := addr + count // exclusive
+	if end > 1<<32 {
+		end = 1 << 32
+	}
+	for addr < end {
+		// Largest block aligned at addr that still fits into the range
+		size := uint64(1) << 32
+		if addr != 0 {
+			size = uint64(1) << bits.TrailingZeros64(addr)
+		}
+		for size > end-addr {
+			size >>= 1
+		}
+		a := netip.AddrFrom4([4]byte{byte(addr >> 24), byte(addr >> 16), byte(addr >> 8), byte(addr)})
+		prefixes = append(prefixes, netip.PrefixFrom(a, 32-bits.TrailingZeros64(size)))
+		addr += size
+	}
+	return prefixes
+}
+
+// ipv4PrefixList returns the prefixes of an IPv4 record comma-separated, as stored in
+// Records_ipv4.Prefixes, or nil for an invalid start address.
+func ipv4PrefixList(start string, count uint64) interface{} {
+	addr, err := netip.ParseAddr(start)
+	if err != nil || !addr.Is4() {
+		return nil
+	}
+	prefixes := ipv4RangeToPrefixes(addr, count)
+	list := make([]string, len(prefixes))
+	for i, p := range prefixes {
+		list[i] = p.String()
+	}
+	return strings.Join(list, ",")
+}
+
+// ipv4LastIP returns the last address of an IPv4 record, or nil for an invalid start
+// address or a range that is empty or runs past 255.255.255.255.
+func ipv4LastIP(start string, count uint64) interface{} {
+	addr, err := netip.ParseAddr(start)
+	if err != nil || !addr.Is4() || count == 0 {
+		return nil
+	}
+	b := addr.As4()
+	last := uint64(binary.BigEndian.Uint32(b[:])) + count - 1
+	if last > math.MaxUint32 {
+		return nil
+	}
+	binary.BigEndian.PutUint32(b[:], uint32(last))
+	return netip.AddrFrom4(b).String()
+}
+
+// ipv6LastIP returns the last address of an IPv6 record, as stored in
+// Records_ipv6.LastIP, or nil for an invalid start address or prefix length.
+func ipv6LastIP(start string, bits uint64) interface{} {
+	addr, err := netip.ParseAddr(start)
+	if err != nil || !addr.Is6() || bits > 128 {
+		return nil
+	}
+	return lastAddr(netip.PrefixFrom(addr, int(bits))).String()
+}
+
+// recordPrefixes returns the prefixes of an IPv4 or IPv6 record. IPv6 values are prefix lengths.
+func recordPrefixes(kind, start string, value uint64) ([]netip.Prefix, error) {
+	addr, err := netip.ParseAddr(start)
+	if err != nil {
+		return nil, err
+	}
+	if kind == "ipv6" {
+		p, err := addr.Prefix(int(value))
+		if err != nil {
+			return nil, err
+		}
+		return []netip.Prefix{p}, nil
+	}
+	return ipv4RangeToPrefixes(addr, value), nil
+}
+
+// aggregatePrefixes merges overlapping and adjacent prefixes into the minimal
+// set of prefixes covering the same addresses, sorted with IPv4 first.
+func aggregatePrefixes(prefixes []netip.Prefix) []netip.Prefix {
+	list := make([]netip.Prefix, 0, len(prefixes))
+	for _, p := range prefixes {
+		if p.IsValid() {
+			list = append(list, p.Masked())
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if c := list[i].Addr().Compare(list[j].Addr()); c != 0 {
+			return c < 0
+		}
+		return list[i].Bits() < list[j].Bits()
+	})
+
+	var result []netip.Prefix
+	for i := 0; i < len(list); {
+		first, last := list[i].Addr(), lastAddr(list[i])
+		for i++; i < len(list); i++ {
+			next := list[i].Addr()
+			if next.Is4() != first.Is4() || (next.Compare(last) > 0 && next != last.Next()) {
+				break
+			}
+			if l := lastAddr(list[i]); l.Compare(last) > 0 {
+				last = l
+			}
+		}
+		result = append(result, rangeToPrefixes(first, last)...)
+	}
+	return result
+}
+
+// rangeToPrefixes splits the inclusive address range first-last into the minimal list of prefixes.
+func rangeToPrefixes(first, last netip.Addr) []netip.Prefix {
+	var prefixes []netip.Prefix
+	for first.IsValid() && first.Compare(last) <= 0 {
+		for bits := 0; bits <= first.BitLen(); bits++ {
+			p := netip.PrefixFrom(first, bits)
+			if p.Masked().Addr() != first || lastAddr(p).Compare(last) > 0 {
+				continue
+			}
+			prefixes = append(prefixes, p)
+			first = lastAddr(p).Next() // invalid after the last address of the family
+			break
+		}
+	}
+	return prefixes
+}
+
+// subtractPrefixes returns the aggregated addresses of a that are not in b.
+func subtractPrefixes(a, b []netip.Prefix) []netip.Prefix {
+	b = aggregatePrefixes(b)
+	var result []netip.Prefix
+	j := 0
+	for _, p := range aggregatePrefixes(a) {
+		first, last := p.Addr(), lastAddr(p)
+		for first.IsValid() && first.Compare(last) <= 0 {
+			for j < len(b) && lastAddr(b[j]).Less(first) {
+				j++
+			}
+			if j == len(b) || last.Less(b[j].Addr()) {
+				result = append(result, rangeToPrefixes(first, last)...)
+				break
+			}
+			if first.Less(b[j].Addr()) {
+				result = append(result, rangeToPrefixes(first, b[j].Addr().Prev())...)
+			}
+			first = lastAddr(b[j]).Next() // invalid after the last address of the family
+		}
+	}
+	return aggregatePrefixes(result)
+}
+
+// lastAddr returns the highest address in a prefix.
+func lastAddr(p netip.Prefix) netip.Addr {
+	a := p.Masked().Addr()
+	if a.Is4() {
+		b := a.As4()
+		for i := p.Bits(); i < 32; i++ {
+			b[i/8] |= 0x80 >> (i % 8)
+		}
+		return netip.AddrFrom4(b)
+	}
+	b := a.As16()
+	for i := p.Bits(); i < 128; i++ {
+		b[i/8] |= 0x80 >> (i % 8)
+	}
+	return netip.AddrFrom16(b)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// prefixes parses a space-separated list of prefixes.
+func prefixes(t *testing.T, list string) []netip.Prefix {
+	t.Helper()
+	var result []netip.Prefix
+	for _, s := range strings.Fields(list) {
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result = append(result, p)
+	}
+	return result
+}
+
+func TestIPv4RangeToPrefixes(t *testing.T) {
+	tests := []struct {
+		start string
+		count uint64
+		want  string
+	}{
+		{"10.0.0.0", 256, "10.0.0.0/24"},
+		{"10.0.0.0", 768, "10.0.0.0/23 10.0.2.0/24"},
+		{"10.0.1.0", 1024, "10.0.1.0/24 10.0.2.0/23 10.0.4.0/24"},
+		{"0.0.0.0", 1 << 32, "0.0.0.0/0"},
+		{"255.255.255.0", 512, "255.255.255.0/24"}, // Clipped at the end of the address space
+		{"10.0.0.1", 1, "10.0.0.1/32"},
+	}
+	for _, tt := range tests {
+		got := ipv4RangeToPrefixes(netip.MustParseAddr(tt.start), tt.count)
+		if want := prefixes(t, tt.want); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s + %d: %v; want %v", tt.start, tt.count, got, want)
+		}
+	}
+}
+
+func TestAggregatePrefixes(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{"adjacent", "10.0.0.0/24 10.0.1.0/24", "10.0.0.0/23"},
+		{"unaligned adjacent", "10.0.1.0/24 10.0.2.0/24", "10.0.1.0/24 10.0.2.0/24"},
+		{"contained", "10.0.0.0/16 10.0.5.0/24", "10.0.0.0/16"},
+		{"overlapping and unsorted", "10.0.2.0/23 10.0.0.0/23 10.0.3.0/24", "10.0.0.0/22"},
+		{"unmasked", "10.0.0.7/24", "10.0.0.0/24"},
+		{"families apart", "2001:db8::/33 10.0.0.0/8 2001:db8:8000::/33", "10.0.0.0/8 2001:db8::/32"},
+		{"whole space", "0.0.0.0/1 128.0.0.0/1", "0.0.0.0/0"},
+		{"IPv4 end next to IPv6", "255.255.255.255/32 ::/128", "255.255.255.255/32 ::/128"},
+		{"empty", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := aggregatePrefixes(prefixes(t, tt.in))
+			if want := prefixes(t, tt.want); !reflect.DeepEqual(got, want) {
+				t.Errorf("aggregatePrefixes(%s) = %v; want %v", tt.in, got, want)
+			}
+		})
+	}
+}
+
+func TestSubtractPrefixes(t *testing.T) {
+	tests := []struct {
+		a, b, want string
+	}{
+		{"10.0.0.0/22", "10.0.1.0/24", "10.0.0.0/24 10.0.2.0/23"},
+		{"10.0.0.0/24", "10.0.0.0/16", ""},
+		{"10.0.0.0/24", "192.0.2.0/24", "10.0.0.0/24"},
+		{"2001:db8::/32", "2001:db8::/33", "2001:db8:8000::/33"},
+		{"255.255.255.0/24", "255.255.255.128/25", "255.255.255.0/25"},
+	}
+	for _, tt := range tests {
+		got := subtractPrefixes(prefixes(t, tt.a), prefixes(t, tt.b))
+		if want := prefixes(t, tt.want); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s minus %s: %v; want %v", tt.a, tt.b, got, want)
+		}
+	}
+}
+
+func TestLastIP(t *testing.T) {
+	tests := []struct {
+		kind, start string
+		value       uint64
+		want        interface{}
+	}{
+		{"ipv4", "10.0.0.0", 768, "10.0.2.255"},
+		{"ipv4", "255.255.255.0", 256, "255.255.255.255"},
+		{"ipv4", "255.255.255.0", 512, nil},
+		{"ipv4", "10.0.0.0", 0, nil},
+		{"ipv4", "2001:db8::", 256, nil},
+		{"ipv6", "2001:db8::", 32, "2001:db8:ffff:ffff:ffff:ffff:ffff:ffff"},
+		{"ipv6", "2001:db8::1", 128, "2001:db8::1"},
+		{"ipv6", "2001:db8::", 129, nil},
+		{"ipv6", "10.0.0.0", 8, nil},
+	}
+	for _, tt := range tests {
+		last := ipv4LastIP
+		if tt.kind == "ipv6" {
+			last = ipv6LastIP
+		}
+		if got := last(tt.start, tt.value); got != tt.want {
+			t.Errorf("last address of %s %s %d: %v; want %v", tt.kind, tt.start, tt.value, got, tt.want)
+		}
+	}
+}
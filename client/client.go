@@ -0,0 +1,367 @@
+// Package client is a Go client of the ip2asn REST API described by openapi.json,
+// which the server publishes at /v1/openapi.json.
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Version is the version of the OpenAPI document the client was written against.
+const Version = "1.1.0"
+
+// Client calls an ip2asn server. APIKey, if set, is sent as X-API-Key and selects
+// the namespace the server answers from.
+type Client struct {
+	BaseURL    string
+	APIKey     string
+	HTTPClient *http.Client
+}
+
+// New returns a client of the server at baseURL, e.g. "http://localhost:8080".
+func New(baseURL, apiKey string) *Client {
+	return &Client{BaseURL: strings.TrimRight(baseURL, "/"), APIKey: apiKey, HTTPClient: http.DefaultClient}
+}
+
+// Error is a non-2xx response.
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("ip2asn: %d %s", e.StatusCode, e.Message)
+}
+
+type Dataset struct {
+	ID       int64  `json:"id"`
+	Registry string `json:"registry"`
+	Serial   uint64 `json:"serial"`
+	Date     string `json:"date"`
+}
+
+type FreePoolPoint struct {
+	Registry        string `json:"registry"`
+	Date            string `json:"date"`
+	Serial          uint64 `json:"serial"`
+	Available       uint64 `json:"available"`
+	Reserved        uint64 `json:"reserved"`
+	AvailableBlocks uint64 `json:"available_blocks"`
+	ReservedBlocks  uint64 `json:"reserved_blocks"`
+}
+
+type AbuseContact struct {
+	Handle  string `json:"handle,omitempty"`
+	Email   string `json:"email,omitempty"`
+	Fetched string `json:"fetched"`
+}
+
+type HolderResource struct {
+	Registry    string   `json:"registry"`
+	Type        string   `json:"type"`
+	Start       string   `json:"start"`
+	Value       uint64   `json:"value"`
+	Prefixes    []string `json:"prefixes,omitempty"`
+	CC          string   `json:"cc"`
+	HolderName  string   `json:"holder_name,omitempty"`
+	ASName      string   `json:"as_name,omitempty"`
+	Status      string   `json:"status"`
+	FirstSeen   string   `json:"first_seen"`
+	LastSeen    string   `json:"last_seen"`
+	Transferred string   `json:"transferred,omitempty"`
+}
+
+type Holder struct {
+	Namespace string           `json:"namespace"`
+	Holder    string           `json:"holder"`
+	Name      string           `json:"name"`
+	Country   string           `json:"country"`
+	Abuse     *AbuseContact    `json:"abuse,omitempty"`
+	Resources []HolderResource `json:"resources"`
+}
+
+type SpacePoint struct {
+	Registry string `json:"registry"`
+	Date     string `json:"date"`
+	Serial   uint64 `json:"serial"`
+	Type     string `json:"type"`
+	Status   string `json:"status"`
+	Blocks   uint64 `json:"blocks"`
+	Size     uint64 `json:"size"` // IPv4 addresses, IPv6 /48s or ASNs
+}
+
+type IPv6Adoption struct {
+	Date        string  `json:"date"`
+	CC          string  `json:"cc"`
+	IPv6Blocks  uint64  `json:"ipv6_allocations"`
+	IPv6Slash32 float64 `json:"ipv6_32s"`
+	IPv4Blocks  uint64  `json:"ipv4_allocations"`
+	IPv4Addrs   uint64  `json:"ipv4_addresses"`
+	Ratio       float64 `json:"ipv6_ipv4_ratio"`
+}
+
+type GrowthPoint struct {
+	Date     string `json:"date"`
+	Registry string `json:"registry"`
+	Type     string `json:"type"`
+	Blocks   uint64 `json:"blocks"`
+	Size     uint64 `json:"size"` // IPv4 addresses, IPv6 /48s or ASNs
+}
+
+type Deallocation struct {
+	Date     string `json:"date"`
+	Registry string `json:"registry"`
+	Type     string `json:"type"`
+	Start    string `json:"start"`
+	Value    uint64 `json:"value"`
+	CC       string `json:"cc"`
+	Status   string `json:"status"` // before the change
+	Holder   string `json:"holder,omitempty"`
+	Reason   string `json:"reason"` // returned, transferred or removed
+}
+
+type Lookup struct {
+	Namespace string   `json:"namespace"`
+	Query     string   `json:"query"`
+	Registry  string   `json:"registry,omitempty"`
+	CC        string   `json:"cc,omitempty"`
+	Type      string   `json:"type,omitempty"`
+	Start     string   `json:"start,omitempty"`
+	Value     uint64   `json:"value,omitempty"`
+	Date      string   `json:"date,omitempty"`
+	Status    string   `json:"status,omitempty"`
+	Holder    string   `json:"holder,omitempty"`
+	ASN       string   `json:"asn,omitempty"` // origin of the covering BGP route, for addresses
+	ASName    string   `json:"as_name,omitempty"`
+	Route     string   `json:"route,omitempty"`
+	Tags      []string `json:"tags,omitempty"`
+}
+
+type Prefix struct {
+	Registry string   `json:"registry"`
+	CC       string   `json:"cc"`
+	Type     string   `json:"type"`
+	Start    string   `json:"start"`
+	Value    uint64   `json:"value"`
+	Prefixes []string `json:"prefixes"`
+	Date     string   `json:"date,omitempty"`
+	Status   string   `json:"status"`
+	Holder   string   `json:"holder,omitempty"`
+}
+
+// Filter holds the optional query parameters; empty fields are left out.
+type Filter struct {
+	Registry string
+	CC       string
+	Type     string // asn, ipv4 or ipv6
+	Status   string
+	Since    string // YYYY-MM-DD
+}
+
+func (f Filter) values() url.Values {
+	v := url.Values{}
+	for name, value := range map[string]string{"registry": f.Registry, "cc": f.CC, "type": f.Type, "status": f.Status, "since": f.Since} {
+		if value != "" {
+			v.Set(name, value)
+		}
+	}
+	return v
+}
+
+// get requests path with the query and returns the body of a successful response.
+func (c *Client) get(ctx context.Context, path string, query url.Values) (io.ReadCloser, error) {
+	u := c.BaseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.APIKey != "" {
+		req.Header.Set("X-API-Key", c.APIKey)
+	}
+	hc := c.HTTPClient
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, &Error{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(msg))}
+	}
+	return resp.Body, nil
+}
+
+func (c *Client) getJSON(ctx context.Context, path string, query url.Values, v interface{}) error {
+	body, err := c.get(ctx, path, query)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	return json.NewDecoder(body).Decode(v)
+}
+
+func (c *Client) getText(ctx context.Context, path string, query url.Values) ([]string, error) {
+	body, err := c.get(ctx, path, query)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(string(data)), nil
+}
+
+// Healthz returns nil when the server is alive and its database reachable.
+func (c *Client) Healthz(ctx context.Context) error {
+	_, err := c.getText(ctx, "/healthz", nil)
+	return err
+}
+
+// Readyz returns nil when every registry has a fresh dataset.
+func (c *Client) Readyz(ctx context.Context) error {
+	_, err := c.getText(ctx, "/readyz", nil)
+	return err
+}
+
+// Datasets returns the latest dataset of every registry.
+func (c *Client) Datasets(ctx context.Context) ([]Dataset, error) {
+	var out struct {
+		Datasets []Dataset `json:"datasets"`
+	}
+	err := c.getJSON(ctx, "/v1/datasets", nil, &out)
+	return out.Datasets, err
+}
+
+// FreePool returns the IPv4 free pool per dataset; only Registry and Since are used.
+func (c *Client) FreePool(ctx context.Context, f Filter) ([]FreePoolPoint, error) {
+	var out struct {
+		List []FreePoolPoint `json:"ipv4_free_pool"`
+	}
+	err := c.getJSON(ctx, "/v1/freepool", Filter{Registry: f.Registry, Since: f.Since}.values(), &out)
+	return out.List, err
+}
+
+// Holder returns the resources of a holder. With all, removed resources are included;
+// a non-empty asOf (YYYY-MM-DD) returns the resources held on that date instead.
+func (c *Client) Holder(ctx context.Context, holder string, all bool, asOf string) (*Holder, error) {
+	q := url.Values{}
+	if all {
+		q.Set("all", "1")
+	}
+	if asOf != "" {
+		q.Set("as_of", asOf)
+	}
+	var out Holder
+	if err := c.getJSON(ctx, "/v1/holders/"+url.PathEscape(holder), q, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// Space returns the available and reserved space; Registry, Type and Since are used.
+func (c *Client) Space(ctx context.Context, f Filter) ([]SpacePoint, error) {
+	var out struct {
+		List []SpacePoint `json:"space"`
+	}
+	err := c.getJSON(ctx, "/v1/stats/space", Filter{Registry: f.Registry, Type: f.Type, Since: f.Since}.values(), &out)
+	return out.List, err
+}
+
+// IPv6Adoption returns the IPv6 adoption per country; CC and Since are used.
+func (c *Client) IPv6Adoption(ctx context.Context, f Filter, daily bool) ([]IPv6Adoption, error) {
+	q := Filter{CC: f.CC, Since: f.Since}.values()
+	if daily {
+		q.Set("daily", "1")
+	}
+	var out struct {
+		List []IPv6Adoption `json:"ipv6_adoption"`
+	}
+	err := c.getJSON(ctx, "/v1/stats/ipv6", q, &out)
+	return out.List, err
+}
+
+// Growth returns the delegated space over time.
+func (c *Client) Growth(ctx context.Context, f Filter) ([]GrowthPoint, error) {
+	var out struct {
+		List []GrowthPoint `json:"growth"`
+	}
+	err := c.getJSON(ctx, "/v1/growth", f.values(), &out)
+	return out.List, err
+}
+
+// Deallocated returns the resources deallocated in the last days (0 for the server's
+// default of 30), at most limit (0 for 1000); Registry and CC are used.
+func (c *Client) Deallocated(ctx context.Context, f Filter, days, limit int) ([]Deallocation, error) {
+	q := Filter{Registry: f.Registry, CC: f.CC}.values()
+	if days > 0 {
+		q.Set("days", strconv.Itoa(days))
+	}
+	if limit > 0 {
+		q.Set("limit", strconv.Itoa(limit))
+	}
+	var out struct {
+		List []Deallocation `json:"deallocated"`
+	}
+	err := c.getJSON(ctx, "/v1/deallocated", q, &out)
+	return out.List, err
+}
+
+// Bogons returns the bogon prefixes of a family (0 for both); full includes the
+// undelegated space.
+func (c *Client) Bogons(ctx context.Context, full bool, family int) ([]string, error) {
+	q := url.Values{}
+	if full {
+		q.Set("full", "1")
+	}
+	if family != 0 {
+		q.Set("family", strconv.Itoa(family))
+	}
+	return c.getText(ctx, "/v1/bogons", q)
+}
+
+// IP returns the delegation containing an address.
+func (c *Client) IP(ctx context.Context, addr string) (*Lookup, error) {
+	var out Lookup
+	if err := c.getJSON(ctx, "/v1/ip/"+url.PathEscape(addr), nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ASN returns the delegation of an ASN.
+func (c *Client) ASN(ctx context.Context, asn uint32) (*Lookup, error) {
+	var out Lookup
+	if err := c.getJSON(ctx, "/v1/asn/"+strconv.FormatUint(uint64(asn), 10), nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// Prefixes returns the current IPv4 and IPv6 delegations; Registry, CC, Type and
+// Status are used.
+func (c *Client) Prefixes(ctx context.Context, f Filter) ([]Prefix, error) {
+	q := Filter{Registry: f.Registry, Type: f.Type, Status: f.Status}.values()
+	if f.CC != "" {
+		q.Set("country", f.CC)
+	}
+	var out struct {
+		List []Prefix `json:"prefixes"`
+	}
+	err := c.getJSON(ctx, "/v1/prefixes", q, &out)
+	return out.List, err
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// subcommandFlags are the global flags that the import and serve commands also take after
+// their name, e.g. "import -source all -force". Given before the name, or without a
+// command as in earlier versions ("-source all"), they work the same.
+var subcommandFlags = map[string][]string{
+	"import": {"source", "in", "url", "dry-run", "invalid-out", "max-invalid-percent", "strict", "force", "invalid-header-ok", "skip-checksum", "concurrency", "batch-size",
+		"download-retries", "download-timeout", "archive-raw", "mirror-dir", "mirror-only", "daemon", "schedule"},
+	"serve": {"listen", "whois-listen", "dnsbl-listen", "grpc-listen", "require-api-key", "reload-interval", "export-dir",
+		"abuse-refresh", "rdns-sample", "taxii-countries"},
+}
+
+// mainCommands are listed first by -h, with their summary.
+var mainCommands = [][2]string{
+	{"import", "Download and import datasets: -source NAME|all, -in FILE or -url URL"},
+	{"lookup", "Show the delegation, country and ASN of addresses and ASNs"},
+	{"export", "Write the current or past delegations as CSV, TSV, JSON lines or MMDB"},
+	{"serve", "Serve the HTTP API on -listen (default :8080) without importing"},
+	{"migrate", "Apply pending schema migrations"},
+	{"diff", "Compare the records of two dates or serials"},
+	{"status", "Show the schema version and the latest dataset of each registry"},
+}
+
+// otherCommands are the remaining commands of runCommand, for -h.
+var otherCommands = []string{"abuse", "aggregate", "annotate", "apikeys", "asnames", "asrel", "backup", "bgp",
+	"bogons", "changes", "check", "compare", "coverage", "deallocated", "dnsbl", "enrich", "firewall", "freepool", "geo",
+	"geofeed", "growth", "holder", "init", "irr", "jobs", "orgs", "overlaps", "rank", "raw", "rdns", "report", "resources",
+	"restore", "rpki", "stats", "summaries", "tags", "transfers", "views", "watch"}
+
+// usage prints the commands and the global flags.
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %s [flags] [COMMAND [command flags] [arguments]]\n\nCommands:\n", os.Args[0])
+	for _, c := range mainCommands {
+		fmt.Fprintf(out, "  %-8s %s\n", c[0], c[1])
+	}
+	fmt.Fprintf(out, "\nOther commands: %s.\n", strings.Join(otherCommands, ", "))
+	fmt.Fprintf(out, "Run \"%s COMMAND -h\" for the flags of a command. Without a command, -source, -in or -url\n", os.Args[0])
+	fmt.Fprint(out, "import and -listen serves, as with the import and serve commands.\n\nFlags:\n")
+	flag.PrintDefaults()
+}
+
+// parseSubcommand parses the flags after the name of the import and serve commands into
+// the global flags, and prints the help of "help" and "COMMAND -h" for them.
+func parseSubcommand() {
+	if flag.NArg() == 0 {
+		return
+	}
+	name := flag.Arg(0)
+	if name == "help" {
+		usage()
+		os.Exit(0)
+	}
+	names, ok := subcommandFlags[name]
+	if !ok {
+		return
+	}
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	for _, n := range names {
+		f := flag.Lookup(n)
+		fs.Var(f.Value, n, f.Usage)
+	}
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s [flags] %s [command flags]\n\n", os.Args[0], name)
+		for _, c := range mainCommands {
+			if c[0] == name {
+				fmt.Fprintf(fs.Output(), "%s.\n\nCommand flags:\n", c[1])
+			}
+		}
+		fs.PrintDefaults()
+	}
+	fs.Parse(flag.Args()[1:])
+	if fs.NArg() > 0 {
+		log.Fatal(fmt.Sprintf("Unexpected arguments to %s: %s", name, strings.Join(fs.Args(), " ")))
+	}
+	fs.Visit(func(f *flag.Flag) { cmdlineFlags[f.Name] = true })
+}
+
+// isCommand reports whether the command line names the given command.
+func isCommand(name string) bool {
+	return flag.NArg() > 0 && flag.Arg(0) == name
+}
+
+// runCommand executes a positional command given after the flags, e.g. "jobs list".
+func runCommand(db *sql.DB, args []string) {
+	switch args[0] {
+	case "deallocated":
+		deallocatedCommand(db, args[1:])
+	case "freepool":
+		freePoolCommand(db, args[1:])
+	case "diff":
+		diffCommand(db, args[1:])
+	case "dnsbl":
+		dnsblCommand(db, args[1:])
+	case "export":
+		exportCommand(db, args[1:])
+	case "firewall":
+		firewallCommand(db, args[1:])
+	case "geofeed":
+		geofeedCommand(db, args[1:])
+	case "geo":
+		geoCommand(db, args[1:])
+	case "report":
+		reportCommand(db, args[1:])
+	case "growth":
+		growthCommand(db, args[1:])
+	case "holder":
+		holderCommand(db, args[1:])
+	case "rdns":
+		rdnsCommand(db, args[1:])
+	case "irr":
+		irrCommand(db, args[1:])
+	case "jobs":
+		jobsCommand(db, args[1:])
+	case "abuse":
+		abuseCommand(db, args[1:])
+	case "annotate":
+		annotateCommand(db, args[1:])
+	case "aggregate":
+		aggregateCommand(db, args[1:])
+	case "asrel":
+		asRelCommand(db, args[1:])
+	case "asnames":
+		asNamesCommand(db, args[1:])
+	case "enrich":
+		enrichCommand(db, args[1:])
+	case "apikeys":
+		apiKeysCommand(args[1:])
+	case "backup":
+		backupCommand(db, args[1:])
+	case "restore":
+		restoreCommand(db, args[1:])
+	case "init":
+		initCommand(db, args[1:])
+	case "migrate":
+		migrateCommand(db, args[1:])
+	case "lookup":
+		lookupCommand(db, args[1:])
+	case "orgs":
+		orgsCommand(db, args[1:])
+	case "overlaps":
+		overlapsCommand(db, args[1:])
+	case "rank":
+		rankCommand(db, args[1:])
+	case "raw":
+		rawCommand(db, args[1:])
+	case "bogons":
+		bogonsCommand(db, args[1:])
+	case "bgp":
+		bgpCommand(db, args[1:])
+	case "check":
+		checkCommand(db)
+	case "coverage":
+		coverageCommand(db, args[1:])
+	case "compare":
+		compareCommand(db, args[1:])
+	case "changes":
+		changesCommand(db, args[1:])
+	case "resources":
+		resourcesCommand(db, args[1:])
+	case "rpki":
+		rpkiCommand(db, args[1:])
+	case "status":
+		statusCommand(db, args[1:])
+	case "stats":
+		statsCommand(db, args[1:])
+	case "summaries":
+		summariesCommand(db, args[1:])
+	case "tags":
+		tagsCommand(db, args[1:])
+	case "transfers":
+		transfersCommand(db, args[1:])
+	case "watch":
+		watchesCommand(db, args[1:])
+	case "views":
+		viewsCommand(db)
+	default:
+		log.Fatal("Unknown command: " + args[0])
+	}
+}
@@ -0,0 +1,540 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/netip"
+	"os"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+// snapshot is the dataset of a registry in effect on a date.
+type snapshot struct {
+	ID     int64  `json:"-"`
+	Serial uint64 `json:"serial"`
+	Date   string `json:"date"`
+}
+
+// typeGrowth compares the delegated (allocated and assigned) resources of one record type.
+type typeGrowth struct {
+	Type       string `json:"type"`
+	FromBlocks uint64 `json:"from_blocks"`
+	ToBlocks   uint64 `json:"to_blocks"`
+	FromSize   uint64 `json:"from_size"` // IPv4 addresses, IPv6 /48s or ASNs
+	ToSize     uint64 `json:"to_size"`
+}
+
+// transitionCount counts resources that moved from one status or country to another.
+type transitionCount struct {
+	Type   string `json:"type"`
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Blocks uint64 `json:"blocks"`
+	Size   uint64 `json:"size"`
+}
+
+// countryDelta is the change of the delegated resources of a country.
+type countryDelta struct {
+	CC            string `json:"cc"`
+	IPv4Addresses int64  `json:"ipv4_addresses"`
+	IPv6Slash48s  int64  `json:"ipv6_48s"`
+	ASNs          int64  `json:"asns"`
+}
+
+// comparison summarises what changed in a registry between two snapshots.
+type comparison struct {
+	Registry      string            `json:"registry"`
+	From          snapshot          `json:"from"`
+	To            snapshot          `json:"to"`
+	Growth        []typeGrowth      `json:"growth"`
+	Added         uint64            `json:"added"`
+	Removed       uint64            `json:"removed"`
+	Changed       uint64            `json:"changed"`
+	StatusChanges []transitionCount `json:"status_changes"`
+	CountryMoves  []transitionCount `json:"country_moves"`
+	Countries     []countryDelta    `json:"countries"`
+	Transfers     map[string]uint64 `json:"transfers"` // incoming, outgoing and internal
+}
+
+// compareCommand implements "compare -registry NAME -from DATE -to DATE", comparing the
+// datasets in effect on the two dates. Resource changes are folded from the Changes
+// table, so they cover only datasets imported in sequence.
+func compareCommand(db *sql.DB, args []string) {
+	fs := flag.NewFlagSet("compare", flag.ExitOnError)
+	registry := fs.String("registry", "", "Registry to compare")
+	from := fs.String("from", "", "Date of the first snapshot (YYYY-MM-DD)")
+	to := fs.String("to", "", "Date of the second snapshot (YYYY-MM-DD)")
+	format := fs.String("format", "table", "Output format: table or json")
+	limit := fs.Int("limit", 20, "Number of countries to list, by largest change")
+	fs.Parse(args)
+	if *registry == "" || *from == "" || *to == "" {
+		log.Fatal("Usage: compare -registry NAME -from YYYY-MM-DD -to YYYY-MM-DD")
+	}
+	for _, d := range []string{*from, *to} {
+		if _, err := time.Parse("2006-01-02", d); err != nil {
+			log.Fatal("Invalid date: " + d)
+		}
+	}
+
+	c, err := compareSnapshots(db, *registry, *from, *to)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(c.Countries) > *limit {
+		c.Countries = c.Countries[:*limit]
+	}
+	if *format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(c); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	printComparison(c)
+}
+
+// snapshotAt returns the newest dataset of a registry ending on or before a date.
+func snapshotAt(db *sql.DB, registry, date string) (snapshot, error) {
+	var s snapshot
+	err := db.QueryRow(`SELECT ID, serial, IFNULL(enddate, '') FROM Datasets WHERE ID_Registries = ? AND enddate <= ?
+		ORDER BY serial DESC LIMIT 1;`, registry, date).Scan(&s.ID, &s.Serial, &s.Date)
+	if err == sql.ErrNoRows {
+		return s, fmt.Errorf("no %s dataset on or before %s", registry, date)
+	}
+	return s, err
+}
+
+func compareSnapshots(db *sql.DB, registry, from, to string) (*comparison, error) {
+	c := &comparison{Registry: registry, Transfers: map[string]uint64{"incoming": 0, "outgoing": 0, "internal": 0}}
+	var err error
+	if c.From, err = snapshotAt(db, registry, from); err != nil {
+		return nil, err
+	}
+	if c.To, err = snapshotAt(db, registry, to); err != nil {
+		return nil, err
+	}
+	if c.From.Serial > c.To.Serial {
+		return nil, fmt.Errorf("the -from snapshot (%s) is newer than the -to snapshot (%s)", c.From.Date, c.To.Date)
+	}
+	if c.Growth, err = compareGrowth(db, c.From.ID, c.To.ID); err != nil {
+		return nil, err
+	}
+	if err := compareChanges(db, c); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`SELECT SourceRIR, RecipientRIR, COUNT(*) FROM Transfers
+		WHERE (SourceRIR = ? OR RecipientRIR = ?) AND TransferDate > ? AND TransferDate <= ? + INTERVAL 1 DAY
+		GROUP BY SourceRIR, RecipientRIR;`, registry, registry, c.From.Date, c.To.Date)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var src, dst string
+		var n uint64
+		if err := rows.Scan(&src, &dst, &n); err != nil {
+			return nil, err
+		}
+		switch {
+		case src == dst:
+			c.Transfers["internal"] += n
+		case dst == registry:
+			c.Transfers["incoming"] += n
+		default:
+			c.Transfers["outgoing"] += n
+		}
+	}
+	return c, rows.Err()
+}
+
+// compareGrowth compares the delegated totals of two datasets from DatasetTotals.
+func compareGrowth(db *sql.DB, from, to int64) ([]typeGrowth, error) {
+	rows, err := db.Query(`SELECT RecordType,
+		SUM(IF(ID_Datasets = ?, Blocks, 0)), SUM(IF(ID_Datasets = ?, Blocks, 0)),
+		SUM(IF(ID_Datasets = ?, Size, 0)), SUM(IF(ID_Datasets = ?, Size, 0))
+		FROM DatasetTotals WHERE ID_Datasets IN (?, ?) AND State IN ('allocated', 'assigned')
+		GROUP BY RecordType ORDER BY RecordType;`, from, to, from, to, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := []typeGrowth{}
+	for rows.Next() {
+		var g typeGrowth
+		if err := rows.Scan(&g.Type, &g.FromBlocks, &g.ToBlocks, &g.FromSize, &g.ToSize); err != nil {
+			return nil, err
+		}
+		list = append(list, g)
+	}
+	return list, rows.Err()
+}
+
+// foldChanges folds the changes of a registry recorded after the dataset with serial from
+// up to serial to into one net change per resource: its state before the first and after
+// the last change. Resources added and removed again or changed back are left out. The
+// changes are ordered by type and start.
+func foldChanges(db *sql.DB, registry string, from, to uint64) ([]change, error) {
+	rows, err := db.Query(`SELECT c.RecordType, c.Start, c.Value, IFNULL(c.OldCC, ''), IFNULL(c.NewCC, ''),
+		IFNULL(c.OldState, ''), IFNULL(c.NewState, ''), IFNULL(c.OldRecordDate, ''), IFNULL(c.NewRecordDate, ''),
+		IFNULL(c.OldOpaqueID, ''), IFNULL(c.NewOpaqueID, '')
+		FROM Changes c JOIN Datasets d ON d.ID = c.ID_Datasets
+		WHERE c.ID_Registries = ? AND d.serial > ? AND d.serial <= ? ORDER BY d.serial, c.ID;`,
+		registry, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	net := map[string]*change{}
+	for rows.Next() {
+		var kind, start string
+		var value uint64
+		var o, n allocation
+		if err := rows.Scan(&kind, &start, &value, &o.CC, &n.CC, &o.Status, &n.Status, &o.Date, &n.Date,
+			&o.OpaqueID, &n.OpaqueID); err != nil {
+			return nil, err
+		}
+		key := allocationKey(kind, start, value)
+		nc := net[key]
+		if nc == nil {
+			nc = &change{Type: kind, Start: start, Value: value}
+			if o.Status != "" {
+				nc.Old = &o
+			}
+			net[key] = nc
+		}
+		nc.New = nil
+		if n.Status != "" {
+			nc.New = &n
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	list := []change{}
+	for _, nc := range net {
+		switch {
+		case nc.Old == nil && nc.New == nil:
+			continue
+		case nc.Old == nil:
+			nc.Change = "added"
+		case nc.New == nil:
+			nc.Change = "removed"
+		case *nc.Old == *nc.New:
+			continue
+		default:
+			nc.Change = "changed"
+		}
+		list = append(list, *nc)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		a, b := list[i], list[j]
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+		if a.Type == "asn" {
+			x, _ := strconv.ParseUint(a.Start, 10, 32)
+			y, _ := strconv.ParseUint(b.Start, 10, 32)
+			return x < y
+		}
+		x, _ := netip.ParseAddr(a.Start)
+		y, _ := netip.ParseAddr(b.Start)
+		return x.Less(y)
+	})
+	return list, nil
+}
+
+// compareChanges counts the net changes between the two snapshots.
+func compareChanges(db *sql.DB, c *comparison) error {
+	list, err := foldChanges(db, c.Registry, c.From.Serial, c.To.Serial)
+	if err != nil {
+		return err
+	}
+
+	statuses := map[[3]string]*transitionCount{}
+	moves := map[[3]string]*transitionCount{}
+	countries := map[string]*countryDelta{}
+	count := func(m map[[3]string]*transitionCount, kind, from, to string, size uint64) {
+		k := [3]string{kind, from, to}
+		if m[k] == nil {
+			m[k] = &transitionCount{Type: kind, From: from, To: to}
+		}
+		m[k].Blocks++
+		m[k].Size += size
+	}
+	account := func(kind string, size uint64, a *allocation, sign int64) {
+		if a == nil || (a.Status != "allocated" && a.Status != "assigned") {
+			return
+		}
+		d := countries[a.CC]
+		if d == nil {
+			d = &countryDelta{CC: a.CC}
+			countries[a.CC] = d
+		}
+		switch kind {
+		case "ipv4":
+			d.IPv4Addresses += sign * int64(size)
+		case "ipv6":
+			d.IPv6Slash48s += sign * int64(size)
+		case "asn":
+			d.ASNs += sign * int64(size)
+		}
+	}
+
+	for _, nc := range list {
+		size := nc.Value
+		if nc.Type == "ipv6" {
+			size = slash48s(nc.Value)
+		}
+		switch nc.Change {
+		case "added":
+			c.Added++
+		case "removed":
+			c.Removed++
+		default:
+			c.Changed++
+			if nc.Old.Status != nc.New.Status {
+				count(statuses, nc.Type, nc.Old.Status, nc.New.Status, size)
+			}
+			if nc.Old.CC != nc.New.CC {
+				count(moves, nc.Type, nc.Old.CC, nc.New.CC, size)
+			}
+		}
+		account(nc.Type, size, nc.Old, -1)
+		account(nc.Type, size, nc.New, 1)
+	}
+
+	c.StatusChanges = sortedTransitions(statuses)
+	c.CountryMoves = sortedTransitions(moves)
+	c.Countries = []countryDelta{}
+	for _, d := range countries {
+		if *d != (countryDelta{CC: d.CC}) {
+			c.Countries = append(c.Countries, *d)
+		}
+	}
+	abs := func(v int64) int64 {
+		if v < 0 {
+			return -v
+		}
+		return v
+	}
+	sort.Slice(c.Countries, func(i, j int) bool {
+		a, b := c.Countries[i], c.Countries[j]
+		if abs(a.IPv4Addresses) != abs(b.IPv4Addresses) {
+			return abs(a.IPv4Addresses) > abs(b.IPv4Addresses)
+		}
+		if abs(a.IPv6Slash48s) != abs(b.IPv6Slash48s) {
+			return abs(a.IPv6Slash48s) > abs(b.IPv6Slash48s)
+		}
+		return a.CC < b.CC
+	})
+	return nil
+}
+
+// sortedTransitions orders transitions by the number of blocks, most first.
+func sortedTransitions(m map[[3]string]*transitionCount) []transitionCount {
+	list := make([]transitionCount, 0, len(m))
+	for _, t := range m {
+		list = append(list, *t)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Blocks != list[j].Blocks {
+			return list[i].Blocks > list[j].Blocks
+		}
+		return list[i].Type+list[i].From+list[i].To < list[j].Type+list[j].From+list[j].To
+	})
+	return list
+}
+
+func printComparison(c *comparison) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "%s: dataset %d (%s) -> dataset %d (%s)\n\n", c.Registry, c.From.Serial, c.From.Date, c.To.Serial, c.To.Date)
+
+	fmt.Fprintln(w, "GROWTH (allocated and assigned)\tBLOCKS\t\tSIZE\t")
+	if len(c.Growth) == 0 {
+		fmt.Fprintln(w, "  no totals recorded for these datasets; run \"summaries totals\"")
+	}
+	for _, g := range c.Growth {
+		fmt.Fprintf(w, "  %s\t%d -> %d\t%+d\t%d -> %d\t%+d\n", g.Type, g.FromBlocks, g.ToBlocks, int64(g.ToBlocks-g.FromBlocks),
+			g.FromSize, g.ToSize, int64(g.ToSize-g.FromSize))
+	}
+	fmt.Fprintf(w, "\nRESOURCES\t%d added, %d removed, %d changed\n", c.Added, c.Removed, c.Changed)
+	fmt.Fprintf(w, "TRANSFERS\t%d incoming, %d outgoing, %d internal\n", c.Transfers["incoming"], c.Transfers["outgoing"], c.Transfers["internal"])
+
+	for _, section := range []struct {
+		title string
+		list  []transitionCount
+	}{{"STATUS CHANGES", c.StatusChanges}, {"COUNTRY MOVES", c.CountryMoves}} {
+		if len(section.list) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "\n%s\tBLOCKS\tSIZE\n", section.title)
+		for _, t := range section.list {
+			fmt.Fprintf(w, "  %s %s\t%d\t%d\n", t.Type, transition(t.From, t.To), t.Blocks, t.Size)
+		}
+	}
+	if len(c.Countries) > 0 {
+		fmt.Fprintln(w, "\nCOUNTRY SHIFTS\tIPV4\tIPV6 /48S\tASNS")
+		for _, d := range c.Countries {
+			fmt.Fprintf(w, "  %s\t%+d\t%+d\t%+d\n", d.CC, d.IPv4Addresses, d.IPv6Slash48s, d.ASNs)
+		}
+	}
+	w.Flush()
+}
+
+// registryDiff is the net change of a registry between two of its datasets.
+type registryDiff struct {
+	Registry string      `json:"registry"`
+	From     snapshot    `json:"from"`
+	To       snapshot    `json:"to"`
+	Added    int         `json:"added"`
+	Removed  int         `json:"removed"`
+	Changed  int         `json:"changed"`
+	Changes  []diffEntry `json:"changes"`
+}
+
+// diffEntry is a changed resource with the names of the attributes that differ.
+type diffEntry struct {
+	change
+	Fields []string `json:"fields,omitempty"` // cc, status, date or holder
+}
+
+// diffCommand implements "diff [-registry NAME] [-type T] [-format table|json] FROM TO",
+// listing the resources added, removed and changed between two datasets. FROM and TO are
+// dates (YYYY-MM-DD), taking the dataset of each registry in effect on that day, or
+// dataset serials of the registry given with -registry.
+func diffCommand(db *sql.DB, args []string) {
+	usage := "Usage: diff [-registry NAME] [-type asn|ipv4|ipv6] [-format table|json] FROM TO"
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	registry := fs.String("registry", "", "Only this registry; required when FROM and TO are serials")
+	kind := fs.String("type", "", "Only changes of this record type: asn, ipv4 or ipv6")
+	format := fs.String("format", "table", "Output format: table or json")
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		log.Fatal(usage)
+	}
+	registries := allRegistries
+	if *registry != "" {
+		registries = []string{*registry}
+	}
+
+	list := []registryDiff{}
+	for _, reg := range registries {
+		var d registryDiff
+		var err error
+		d.Registry = reg
+		if d.From, err = snapshotArg(db, reg, fs.Arg(0)); err == nil {
+			d.To, err = snapshotArg(db, reg, fs.Arg(1))
+		}
+		if err != nil {
+			if *registry == "" {
+				logger.Warn("Skipping registry", "registry", reg, "err", err)
+				continue
+			}
+			log.Fatal(err)
+		}
+		if d.From.Serial > d.To.Serial {
+			log.Fatal(fmt.Sprintf("%s: FROM (serial %d) is newer than TO (serial %d)", reg, d.From.Serial, d.To.Serial))
+		}
+		changes, err := foldChanges(db, reg, d.From.Serial, d.To.Serial)
+		if err != nil {
+			log.Fatal(err)
+		}
+		d.Changes = []diffEntry{}
+		for _, c := range changes {
+			if *kind != "" && c.Type != *kind {
+				continue
+			}
+			e := diffEntry{change: c}
+			switch c.Change {
+			case "added":
+				d.Added++
+			case "removed":
+				d.Removed++
+			default:
+				d.Changed++
+				e.Fields = changedFields(c.Old, c.New)
+			}
+			d.Changes = append(d.Changes, e)
+		}
+		list = append(list, d)
+	}
+
+	if *format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(list); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, d := range list {
+		fmt.Fprintf(w, "%s: dataset %d (%s) -> dataset %d (%s): %d added, %d removed, %d changed\n", d.Registry,
+			d.From.Serial, d.From.Date, d.To.Serial, d.To.Date, d.Added, d.Removed, d.Changed)
+		if len(d.Changes) == 0 {
+			fmt.Fprintln(w)
+			continue
+		}
+		fmt.Fprintln(w, "  CHANGE\tTYPE\tSTART\tVALUE\tCC\tSTATUS\tHOLDER\tDATE")
+		for _, e := range d.Changes {
+			var o, n allocation
+			if e.Old != nil {
+				o = *e.Old
+			}
+			if e.New != nil {
+				n = *e.New
+			}
+			fmt.Fprintf(w, "  %s\t%s\t%s\t%d\t%s\t%s\t%s\t%s\n", e.Change, e.Type, e.Start, e.Value, transition(o.CC, n.CC),
+				transition(o.Status, n.Status), transition(o.OpaqueID, n.OpaqueID), transition(o.Date, n.Date))
+		}
+		fmt.Fprintln(w)
+	}
+	w.Flush()
+}
+
+// snapshotArg returns the dataset of a registry named by a date (YYYY-MM-DD) or serial.
+func snapshotArg(db *sql.DB, registry, arg string) (snapshot, error) {
+	if _, err := time.Parse("2006-01-02", arg); err == nil {
+		return snapshotAt(db, registry, arg)
+	}
+	serial, err := strconv.ParseUint(arg, 10, 64)
+	if err != nil {
+		return snapshot{}, fmt.Errorf("not a date or serial: %s", arg)
+	}
+	s := snapshot{Serial: serial}
+	err = db.QueryRow("SELECT ID, IFNULL(enddate, '') FROM Datasets WHERE ID_Registries = ? AND serial = ?;",
+		registry, serial).Scan(&s.ID, &s.Date)
+	if err == sql.ErrNoRows {
+		return s, fmt.Errorf("no %s dataset with serial %d", registry, serial)
+	}
+	return s, err
+}
+
+// changedFields names the attributes that differ between two states of a resource; a
+// holder change is usually a transfer.
+func changedFields(old, cur *allocation) []string {
+	var fields []string
+	if old.CC != cur.CC {
+		fields = append(fields, "cc")
+	}
+	if old.Status != cur.Status {
+		fields = append(fields, "status")
+	}
+	if old.Date != cur.Date {
+		fields = append(fields, "date")
+	}
+	if holder(old.OpaqueID) != holder(cur.OpaqueID) {
+		fields = append(fields, "holder")
+	}
+	return fields
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"bufio"
+	"compress/bzip2"
+	"compress/gzip"
+	"io"
+)
+
+// decompressReader returns a reader of the decompressed contents of r when it starts
+// with the gzip or bzip2 magic bytes, else of r itself.
+func decompressReader(r io.Reader) (io.Reader, string, error) {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(3)
+	switch {
+	case len(magic) == 3 && string(magic) == "BZh":
+		return bzip2.NewReader(br), "bzip2", nil
+	case len(magic) >= 2 && magic[0] == 0x1f && magic[1] == 0x8b:
+		zr, err := gzip.NewReader(br)
+		return zr, "gzip", err
+	}
+	return br, "", nil
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/krassi/ip2asn/pkg/rirparse"
+)
+
+// versionProbeSize is how much of a delegated file is fetched to read its version line;
+// the leading comments, version line and summary lines fit easily.
+const versionProbeSize = 64 * 1024
+
+// downloadValidators are the ETag and Last-Modified headers of the downloads in progress
+// by URL. They are stored in DownloadValidators once the import of the download is done,
+// so that a failed import is not skipped the next time.
+var downloadValidators = struct {
+	sync.Mutex
+	byURL map[string][2]string
+}{byURL: map[string][2]string{}}
+
+// rememberValidators keeps the validators of a download of url.
+func rememberValidators(url string, header http.Header) {
+	etag, modified := header.Get("ETag"), header.Get("Last-Modified")
+	if etag == "" && modified == "" {
+		return
+	}
+	downloadValidators.Lock()
+	downloadValidators.byURL[url] = [2]string{etag, modified}
+	downloadValidators.Unlock()
+}
+
+// saveValidators stores the validators of the last download of url, if the import
+// succeeded, and forgets them either way.
+func saveValidators(db *sql.DB, url string, imported bool) {
+	downloadValidators.Lock()
+	v, ok := downloadValidators.byURL[url]
+	delete(downloadValidators.byURL, url)
+	downloadValidators.Unlock()
+	if !ok || !imported || db == nil {
+		return
+	}
+	if _, err := db.Exec("REPLACE INTO DownloadValidators VALUES (?, ?, ?, NOW());", url, v[0], v[1]); err != nil {
+		logger.Warn("Cannot store the ETag and Last-Modified", "url", url, "err", err)
+	}
+}
+
+// downloadedBody is a dataset downloaded from url, which may be a mirror.
+type downloadedBody struct {
+	io.ReadCloser
+	url string
+}
+
+// downloadIfChanged downloads a dataset from the first of urls that works: the primary
+// location followed by its mirrors. With mirrors, a location serving an older serial than
+// the newest imported of the registry is passed over too. It returns errDatasetUnchanged
+// when probeDataset finds that a location has a dataset imported before. With force it
+// always downloads.
+func downloadIfChanged(ctx context.Context, st Store, urls []string, force bool) (io.ReadCloser, error) {
+	var failures []string
+	for i, url := range urls {
+		if i > 0 {
+			logger.Info("Trying mirror", "url", url)
+		}
+		if !force && !*f_mirrorOnly {
+			unchanged, hdr := probeDataset(ctx, st, url)
+			if unchanged {
+				return nil, errDatasetUnchanged
+			}
+			if len(urls) > 1 && hdr.Registry != "" {
+				latest, err := st.LatestSerial(hdr.Registry)
+				if err == nil && hdr.Serial < latest {
+					failure := fmt.Sprintf("%s has serial %d, older than the imported %d", url, hdr.Serial, latest)
+					logger.Warn("Skipping mirror", "reason", failure)
+					failures = append(failures, failure)
+					continue
+				}
+			}
+		}
+		body, err := downloadDataset(ctx, url)
+		if err == nil {
+			return downloadedBody{body, url}, nil
+		}
+		if len(urls) == 1 || ctx.Err() != nil {
+			return nil, err
+		}
+		logger.Warn("Download failed", "url", url, "err", err)
+		failures = append(failures, err.Error())
+	}
+	return nil, fmt.Errorf("no location of the dataset works: %s", strings.Join(failures, "; "))
+}
+
+// probeDataset checks the dataset at url before it is downloaded and reports whether it
+// was imported before: the server answers a HEAD request with the validators of the last
+// imported download with 304 Not Modified, or the version line at the start of the file
+// has a serial already in Datasets. Otherwise it returns the header of that version line,
+// with no Registry if it could not be read; the dataset is then downloaded.
+func probeDataset(ctx context.Context, st Store, url string) (bool, rirparse.FileHeader) {
+	client := &http.Client{Timeout: 30 * time.Second}
+	if db := st.MySQL(); db != nil {
+		var etag, modified string
+		err := db.QueryRow("SELECT ETag, LastModified FROM DownloadValidators WHERE URL = ?;", url).Scan(&etag, &modified)
+		if err == nil {
+			if notModified(ctx, client, url, etag, modified) {
+				logger.Info("Not modified since the last import; skipping", "url", url)
+				return true, rirparse.FileHeader{}
+			}
+		} else if err != sql.ErrNoRows {
+			logger.Debug("Cannot read the validators", "url", url, "err", err)
+		}
+	}
+
+	hdr, err := probeVersionLine(ctx, client, url)
+	if err != nil {
+		logger.Debug("Cannot read the version line", "url", url, "err", err)
+		return false, rirparse.FileHeader{}
+	}
+	exists, err := st.HasDataset(hdr.Registry, hdr.Serial)
+	if err != nil {
+		logger.Debug("Cannot check for an earlier import", "err", err)
+		return false, hdr
+	}
+	if exists {
+		logger.Info("Serial already imported; skipping", "registry", hdr.Registry, "serial", hdr.Serial, "url", url)
+	}
+	return exists, hdr
+}
+
+// notModified sends a conditional HEAD request for url. Servers that ignore the
+// conditions on HEAD still count as not modified when they return the same ETag.
+func notModified(ctx context.Context, client *http.Client, url, etag, modified string) bool {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return false
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	if modified != "" {
+		req.Header.Set("If-Modified-Since", modified)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		logger.Debug("HEAD request failed", "url", url, "err", err)
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusNotModified ||
+		resp.StatusCode == http.StatusOK && etag != "" && resp.Header.Get("ETag") == etag
+}
+
+// probeVersionLine reads the header of the file at url from its first bytes, requested
+// with a Range header; from servers that send the whole file the rest is not read.
+func probeVersionLine(ctx context.Context, client *http.Client, url string) (rirparse.FileHeader, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return rirparse.FileHeader{}, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=0-%d", versionProbeSize-1))
+	resp, err := client.Do(req)
+	if err != nil {
+		return rirparse.FileHeader{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		return rirparse.FileHeader{}, fmt.Errorf("%s", resp.Status)
+	}
+	r, _, err := decompressReader(io.LimitReader(resp.Body, versionProbeSize))
+	if err != nil {
+		return rirparse.FileHeader{}, err
+	}
+	hdr, err := rirparse.NewReader(r).ReadHeader()
+	if hdr.Registry != "" { // A summary line cut off by the limit does not matter
+		return hdr, nil
+	}
+	if err == nil {
+		err = rirparse.ErrInvalidHeader
+	}
+	return hdr, err
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// The config file is YAML, or TOML if its name ends in .toml. Top-level keys are flag
+// names without the dash. Flags can also be set in the environment as IP2ASN_ and the
+// flag name in capitals with underscores, e.g. IP2ASN_STALE_AFTER; the command line takes
+// precedence over the environment, and the environment over the file.
+//
+// The "database" section holds the connection settings otherwise read from MYSQL_USER,
+// MYSQL_PASS, MYSQL_PROT, MYSQL_ADDR and MYSQL_DBNAME (user, password, protocol, address
+// and name; PostgreSQL uses user, password and name), which again take precedence. The
+// "registries" section overrides the download URLs from the Registries and
+// RegistryMirrors tables; a list names mirrors after the primary location, and
+// "enabled: false" leaves a registry out of -source all:
+//
+//	verbose: 2
+//	stale-after: 36h
+//	schedule: 0 4 * * *
+//	webhook: https://hooks.example.com/ip2asn
+//	database:
+//	  user: ip2asn
+//	  address: db.example.com:3306
+//	registries:
+//	  ripencc: https://ftp.ripe.net/ripe/stats/delegated-ripencc-latest
+//	  arin:
+//	    - https://ftp.arin.net/pub/stats/arin/delegated-arin-extended-latest
+//	    - https://ftp.ripe.net/pub/stats/arin/delegated-arin-extended-latest
+//	  lacnic:
+//	    enabled: false
+//
+// or in TOML:
+//
+//	verbose = 2
+//	[database]
+//	user = "ip2asn"
+//	[registries.arin]
+//	url = "https://ftp.arin.net/pub/stats/arin/delegated-arin-extended-latest"
+//	mirrors = ["https://ftp.ripe.net/pub/stats/arin/delegated-arin-extended-latest"]
+
+// reloadableFlags can be changed by SIGHUP in a running process; everything else
+// is only read at startup.
+var reloadableFlags = map[string]bool{
+	"verbose": true, "verbose-modules": true, "debug": true, "stale-after": true, "stale-after-registry": true, "webhook": true, "slack-webhook": true,
+	"smtp-addr": true, "smtp-from": true, "alert-email": true, "schedule": true,
+}
+
+// settings are the values of the reloadable flags as the running process uses them.
+// They are built whole and swapped in atomically, so a reload never writes the flag
+// variables while other goroutines read them.
+type settings struct {
+	verbose            uint
+	moduleVerbosity    map[string]uint // -verbose-modules
+	maxVerbose         uint            // the highest of verbose and the module levels
+	staleAfter         time.Duration
+	staleAfterRegistry map[string]time.Duration
+	webhooks           string
+	slackWebhook       string
+	smtpAddr           string
+	smtpFrom           string
+	alertEmail         string
+	schedule           *cronSchedule // nil unless -daemon
+	scheduleSpec       string
+}
+
+var activeSettings atomic.Pointer[settings]
+
+// settingsReloaded is signalled after a reload swapped in new settings.
+var settingsReloaded = make(chan struct{}, 1)
+
+// startupValues are the reloadable flags as given on the command line, in the
+// environment or by default; a reload applies the config file on top of them.
+var startupValues = map[string]string{}
+
+// currentSettings returns the settings in effect.
+func currentSettings() *settings {
+	if s := activeSettings.Load(); s != nil {
+		return s
+	}
+	return &settings{verbose: 1, maxVerbose: 1}
+}
+
+// newSettings parses the reloadable flags; value returns the text of a flag.
+func newSettings(value func(name string) string) (*settings, error) {
+	s := &settings{
+		webhooks:     value("webhook"),
+		slackWebhook: value("slack-webhook"),
+		smtpAddr:     value("smtp-addr"),
+		smtpFrom:     value("smtp-from"),
+		alertEmail:   value("alert-email"),
+		scheduleSpec: value("schedule"),
+	}
+	verbose, err := strconv.ParseUint(value("verbose"), 10, 0)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -verbose: %s", value("verbose"))
+	}
+	s.verbose = uint(verbose)
+	if debug, err := strconv.ParseBool(value("debug")); err != nil {
+		return nil, fmt.Errorf("invalid -debug: %s", value("debug"))
+	} else if debug {
+		s.verbose = 5
+	}
+	if s.moduleVerbosity, err = moduleVerbosity(value("verbose-modules")); err != nil {
+		return nil, err
+	}
+	s.maxVerbose = s.verbose
+	for _, level := range s.moduleVerbosity {
+		if level > s.maxVerbose {
+			s.maxVerbose = level
+		}
+	}
+	if s.staleAfter, err = time.ParseDuration(value("stale-after")); err != nil {
+		return nil, fmt.Errorf("invalid -stale-after: %s", value("stale-after"))
+	}
+	if s.staleAfterRegistry, err = parseStaleThresholds(value("stale-after-registry")); err != nil {
+		return nil, err
+	}
+	if *f_daemon {
+		if s.schedule, err = parseSchedule(s.scheduleSpec); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// flagValue returns the text of a flag as parsed.
+func flagValue(name string) string {
+	return flag.Lookup(name).Value.String()
+}
+
+var cmdlineFlags = make(map[string]bool) // flags set on the command line; never overridden
+var envFlags = make(map[string]bool)     // flags set in the environment; not overridden by the file
+
+// legacyEnv are the environment variables read by flags before IP2ASN_ names existed.
+var legacyEnv = map[string]string{"db-driver": "DB_DRIVER", "splunk-hec-token": "SPLUNK_HEC_TOKEN"}
+
+// configDatabase is the database section of the config file, read at startup.
+var configDatabase = map[string]string{}
+
+var registryURLs struct {
+	sync.RWMutex
+	m        map[string][]string
+	disabled map[string]bool
+}
+
+// loadConfig applies the -config file. On reload only the settings and registry URLs
+// are changed.
+func loadConfig(reload bool) error {
+	if !reload {
+		for name := range reloadableFlags {
+			startupValues[name] = flagValue(name)
+		}
+	}
+	data, err := os.ReadFile(*f_config)
+	if err != nil {
+		return err
+	}
+	var cfg map[string]interface{}
+	if strings.HasSuffix(*f_config, ".toml") {
+		err = toml.Unmarshal(data, &cfg)
+	} else {
+		err = yaml.Unmarshal(data, &cfg)
+	}
+	if err != nil {
+		return fmt.Errorf("parsing %s: %w", *f_config, err)
+	}
+
+	urls, disabled := make(map[string][]string), make(map[string]bool)
+	if regs, ok := cfg["registries"].(map[string]interface{}); ok {
+		for name, entry := range regs {
+			if err := parseRegistryEntry(name, entry, urls, disabled); err != nil {
+				return fmt.Errorf("%s: registries: %w", *f_config, err)
+			}
+		}
+	}
+	delete(cfg, "registries")
+
+	database := map[string]string{}
+	if section, ok := cfg["database"].(map[string]interface{}); ok {
+		for key, value := range section {
+			switch key {
+			case "user", "password", "protocol", "address", "name":
+				database[key] = fmt.Sprint(value)
+			default:
+				return fmt.Errorf("%s: database: unknown setting %q", *f_config, key)
+			}
+		}
+	}
+	delete(cfg, "database")
+	if !reload {
+		configDatabase = database
+	} else if fmt.Sprint(database) != fmt.Sprint(configDatabase) {
+		logger.Warn("Database settings changed; restart to apply", "config", *f_config)
+	}
+
+	values := make(map[string]string, len(startupValues))
+	for name, value := range startupValues {
+		values[name] = value
+	}
+	for name, value := range cfg {
+		if flag.Lookup(name) == nil {
+			return fmt.Errorf("%s: unknown setting %q", *f_config, name)
+		}
+		if cmdlineFlags[name] || envFlags[name] || name == "config" {
+			continue
+		}
+		if reload && reloadableFlags[name] {
+			values[name] = fmt.Sprint(value)
+			continue
+		}
+		if reload {
+			if flagValue(name) != fmt.Sprint(value) {
+				logger.Warn("Setting changed; restart to apply", "setting", name, "config", *f_config)
+			}
+			continue
+		}
+		if err := flag.Set(name, fmt.Sprint(value)); err != nil {
+			return fmt.Errorf("%s: %s: %w", *f_config, name, err)
+		}
+	}
+
+	var s *settings
+	if reload {
+		if s, err = newSettings(func(name string) string { return values[name] }); err != nil {
+			return fmt.Errorf("%s: %w", *f_config, err)
+		}
+	}
+	registryURLs.Lock()
+	registryURLs.m, registryURLs.disabled = urls, disabled
+	registryURLs.Unlock()
+	if s != nil {
+		activeSettings.Store(s)
+		select {
+		case settingsReloaded <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// parseRegistryEntry reads the entry of a registry in the registries section: a URL, a
+// list of URLs, or a map with url, mirrors and enabled.
+func parseRegistryEntry(name string, entry interface{}, urls map[string][]string, disabled map[string]bool) error {
+	switch entry := entry.(type) {
+	case []interface{}:
+		if len(entry) == 0 {
+			return fmt.Errorf("no URL for %s", name)
+		}
+		for _, u := range entry {
+			urls[name] = append(urls[name], fmt.Sprint(u))
+		}
+	case map[string]interface{}:
+		for key, value := range entry {
+			switch key {
+			case "url":
+				urls[name] = append([]string{fmt.Sprint(value)}, urls[name]...)
+			case "mirrors":
+				list, ok := value.([]interface{})
+				if !ok {
+					return fmt.Errorf("%s: mirrors is not a list", name)
+				}
+				for _, u := range list {
+					urls[name] = append(urls[name], fmt.Sprint(u))
+				}
+			case "enabled":
+				enabled, ok := value.(bool)
+				if !ok {
+					return fmt.Errorf("%s: enabled is not true or false", name)
+				}
+				disabled[name] = !enabled
+			default:
+				return fmt.Errorf("%s: unknown setting %q", name, key)
+			}
+		}
+		if _, ok := entry["url"]; !ok && len(urls[name]) > 0 {
+			return fmt.Errorf("%s: mirrors without url", name)
+		}
+	default:
+		urls[name] = []string{fmt.Sprint(entry)}
+	}
+	return nil
+}
+
+// registryEnabled reports whether -source all imports a registry; the config file can
+// turn registries off.
+func registryEnabled(registry string) bool {
+	registryURLs.RLock()
+	defer registryURLs.RUnlock()
+	return !registryURLs.disabled[registry]
+}
+
+// dbSetting returns a database connection setting: the environment variable env, else
+// key in the database section of the config file, else def.
+func dbSetting(env, key, def string) string {
+	if value := os.Getenv(env); value != "" {
+		return value
+	}
+	if value, ok := configDatabase[key]; ok {
+		return value
+	}
+	return def
+}
+
+// configuredRegistryURLs returns the URL override for a registry from the config file,
+// followed by its mirrors.
+func configuredRegistryURLs(registry string) ([]string, bool) {
+	registryURLs.RLock()
+	defer registryURLs.RUnlock()
+	urls, ok := registryURLs.m[registry]
+	return urls, ok
+}
+
+// watchReload re-reads the config file on SIGHUP. Lookups and in-flight requests
+// carry on with the previous settings until the new ones are swapped in; the daemon
+// then plans its next import by the new schedule.
+func watchReload() {
+	if *f_config == "" {
+		return
+	}
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := loadConfig(true); err != nil {
+				logger.Error("Config reload failed; keeping previous settings", "err", err)
+				continue
+			}
+			logger.Info("Reloaded configuration", "config", *f_config)
+		}
+	}()
+}
+
+// initConfig remembers which flags were given explicitly, applies the environment and
+// then the config file. The settings are built from the result by parseArguments.
+func initConfig() {
+	flag.Visit(func(f *flag.Flag) { cmdlineFlags[f.Name] = true })
+	flag.VisitAll(func(f *flag.Flag) {
+		if cmdlineFlags[f.Name] {
+			return
+		}
+		env := flagEnv(f.Name)
+		value, ok := os.LookupEnv(env)
+		if !ok && legacyEnv[f.Name] != "" {
+			env = legacyEnv[f.Name]
+			value, ok = os.LookupEnv(env)
+		}
+		if !ok || value == "" {
+			return
+		}
+		if err := f.Value.Set(value); err != nil {
+			log.Fatal(fmt.Sprintf("Invalid $%s: %s", env, err.Error()))
+		}
+		envFlags[f.Name] = true
+	})
+	if *f_config == "" {
+		return
+	}
+	if err := loadConfig(false); err != nil {
+		log.Fatal("Cannot load config: " + err.Error())
+	}
+}
+
+// flagEnv returns the environment variable of a flag, e.g. IP2ASN_STALE_AFTER.
+func flagEnv(name string) string {
+	return "IP2ASN_" + strings.ToUpper(strings.Replace(name, "-", "_", -1))
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestReloadSettings reloads a config file and checks that the new settings are
+// swapped in, the daemon is told, and the flag variables are left alone.
+func TestReloadSettings(t *testing.T) {
+	config := filepath.Join(t.TempDir(), "ip2asn.yaml")
+	write := func(content string) {
+		if err := os.WriteFile(config, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer func(path string, daemon bool, staleAfter time.Duration, schedule string) {
+		*f_config, *f_daemon, *f_staleAfter, *f_schedule = path, daemon, staleAfter, schedule
+		activeSettings.Store(nil)
+	}(*f_config, *f_daemon, *f_staleAfter, *f_schedule)
+	*f_config, *f_daemon = config, true
+
+	write("stale-after: 36h\nschedule: 0 4 * * *\n")
+	if err := loadConfig(false); err != nil {
+		t.Fatal(err)
+	}
+	s, err := newSettings(flagValue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	activeSettings.Store(s)
+
+	write("stale-after: 12h\nstale-after-registry: arin=2h\nschedule: 30 * * * *\ndebug: true\n")
+	if err := loadConfig(true); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-settingsReloaded:
+	default:
+		t.Error("the daemon was not told about the reload")
+	}
+	s = currentSettings()
+	if s.staleAfter != 12*time.Hour || staleThreshold("arin") != 2*time.Hour || staleThreshold("ripencc") != 12*time.Hour {
+		t.Errorf("stale thresholds after reload: %s, arin %s", s.staleAfter, staleThreshold("arin"))
+	}
+	if s.verbose != 5 {
+		t.Errorf("verbose after reload with debug: %d, want 5", s.verbose)
+	}
+	from := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	if next := s.schedule.next(from); !next.Equal(from.Add(30 * time.Minute)) {
+		t.Errorf("next import by the reloaded schedule: %s", next)
+	}
+	if *f_staleAfter != 36*time.Hour || *f_schedule != "0 4 * * *" {
+		t.Errorf("reload changed the flags: -stale-after %s, -schedule %q", *f_staleAfter, *f_schedule)
+	}
+
+	write("stale-after: soon\n")
+	if err := loadConfig(true); err == nil {
+		t.Error("reload with an invalid -stale-after succeeded")
+	}
+	if currentSettings() != s {
+		t.Error("a failed reload replaced the settings")
+	}
+}
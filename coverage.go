@@ -0,0 +1,211 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"log"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// coverageStats compares the delegated (allocated and assigned) space of a registry
+// and country with the space announced in BGP. Sizes are IPv4 addresses or IPv6 /48s.
+type coverageStats struct {
+	Registry  string  `json:"registry"`
+	CC        string  `json:"cc"`
+	Type      string  `json:"type"`
+	Delegated uint64  `json:"delegated"`
+	Announced uint64  `json:"announced"`
+	Dark      uint64  `json:"dark"`
+	Percent   float64 `json:"announced_percent"`
+}
+
+// undelegatedAnnouncement is a BGP prefix not covered by any delegated block.
+type undelegatedAnnouncement struct {
+	Prefix string `json:"prefix"`
+	Origin string `json:"origin"`
+	Source string `json:"source"`
+}
+
+// span is an inclusive range of IPv4 addresses or IPv6 /48s.
+type span struct{ lo, hi uint64 }
+
+// spanOf converts a range to units: IPv4 addresses, or the first 48 bits of IPv6
+// addresses, so that prefixes longer than /48 count as one /48.
+func spanOf(first, last netip.Addr) span {
+	if first.Is4() {
+		a, b := first.As4(), last.As4()
+		return span{uint64(binary.BigEndian.Uint32(a[:])), uint64(binary.BigEndian.Uint32(b[:]))}
+	}
+	a, b := first.As16(), last.As16()
+	return span{binary.BigEndian.Uint64(a[:8]) >> 16, binary.BigEndian.Uint64(b[:8]) >> 16}
+}
+
+// mergeSpans sorts spans and joins overlapping and adjacent ones.
+func mergeSpans(list []span) []span {
+	sort.Slice(list, func(i, j int) bool { return list[i].lo < list[j].lo })
+	var merged []span
+	for _, s := range list {
+		if n := len(merged); n > 0 && s.lo <= merged[n-1].hi+1 {
+			if s.hi > merged[n-1].hi {
+				merged[n-1].hi = s.hi
+			}
+			continue
+		}
+		merged = append(merged, s)
+	}
+	return merged
+}
+
+// coveredSize returns how much of s lies within the merged spans.
+func coveredSize(merged []span, s span) uint64 {
+	var size uint64
+	for i := sort.Search(len(merged), func(i int) bool { return merged[i].hi >= s.lo }); i < len(merged) && merged[i].lo <= s.hi; i++ {
+		lo, hi := merged[i].lo, merged[i].hi
+		if lo < s.lo {
+			lo = s.lo
+		}
+		if hi > s.hi {
+			hi = s.hi
+		}
+		size += hi - lo + 1
+	}
+	return size
+}
+
+// coverageReport computes the coverage per registry, country and type, and lists the
+// announcements outside all delegated space.
+func coverageReport(db *sql.DB, registry, cc string) ([]coverageStats, []undelegatedAnnouncement, error) {
+	type bgpPrefix struct {
+		s                            span
+		kind, prefix, origin, source string
+	}
+	announced := map[string][]span{}
+	var prefixes []bgpPrefix
+	rows, err := db.Query("SELECT Family, StartIP, EndIP, Prefix, OriginAS, Source FROM BgpPrefixes;")
+	if err != nil {
+		return nil, nil, err
+	}
+	for rows.Next() {
+		var family int
+		var start, end []byte
+		var p bgpPrefix
+		if err := rows.Scan(&family, &start, &end, &p.prefix, &p.origin, &p.source); err != nil {
+			rows.Close()
+			return nil, nil, err
+		}
+		first, _ := netip.AddrFromSlice(start)
+		last, _ := netip.AddrFromSlice(end)
+		p.s = spanOf(first, last)
+		p.kind = "ipv" + strconv.Itoa(family)
+		announced[p.kind] = append(announced[p.kind], p.s)
+		prefixes = append(prefixes, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+	for kind := range announced {
+		announced[kind] = mergeSpans(announced[kind])
+	}
+
+	stats := map[[3]string]*coverageStats{}
+	delegated := map[string][]span{}
+	rows, err = db.Query(`SELECT ID_Registries, CC, RecordType, Start, Value FROM LatestAllocations
+		WHERE RecordType <> 'asn' AND State IN ('allocated', 'assigned');`)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var reg, country, kind, start string
+		var value uint64
+		if err := rows.Scan(&reg, &country, &kind, &start, &value); err != nil {
+			return nil, nil, err
+		}
+		blocks, err := recordPrefixes(kind, start, value)
+		if err != nil || len(blocks) == 0 {
+			continue
+		}
+		s := spanOf(blocks[0].Addr(), lastAddr(blocks[len(blocks)-1]))
+		delegated[kind] = append(delegated[kind], s)
+		if (registry != "" && reg != registry) || (cc != "" && country != cc) {
+			continue
+		}
+		key := [3]string{reg, country, kind}
+		if stats[key] == nil {
+			stats[key] = &coverageStats{Registry: reg, CC: country, Type: kind}
+		}
+		stats[key].Delegated += s.hi - s.lo + 1
+		stats[key].Announced += coveredSize(announced[kind], s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	list := make([]coverageStats, 0, len(stats))
+	for _, s := range stats {
+		s.Dark = s.Delegated - s.Announced
+		if s.Delegated > 0 {
+			s.Percent = float64(s.Announced*10000/s.Delegated) / 100
+		}
+		list = append(list, *s)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		a, b := list[i], list[j]
+		if a.Registry != b.Registry {
+			return a.Registry < b.Registry
+		}
+		if a.CC != b.CC {
+			return a.CC < b.CC
+		}
+		return a.Type < b.Type
+	})
+
+	for kind := range delegated {
+		delegated[kind] = mergeSpans(delegated[kind])
+	}
+	undelegated := []undelegatedAnnouncement{}
+	for _, p := range prefixes {
+		if coveredSize(delegated[p.kind], p.s) == 0 {
+			undelegated = append(undelegated, undelegatedAnnouncement{p.prefix, p.origin, p.source})
+		}
+	}
+	return list, undelegated, nil
+}
+
+// coverageCommand prints the delegated-versus-announced report from "bgp import" data.
+func coverageCommand(db *sql.DB, args []string) {
+	fs := flag.NewFlagSet("coverage", flag.ExitOnError)
+	registry := fs.String("registry", "", "Only this registry")
+	cc := fs.String("cc", "", "Only this country code")
+	undelegated := fs.Bool("undelegated", false, "List the announcements without delegation instead")
+	format := fs.String("format", "table", "Output format: table, csv or json")
+	fs.Parse(args)
+
+	list, orphans, err := coverageReport(db, *registry, strings.ToUpper(*cc))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *undelegated {
+		rows := make([][]string, 0, len(orphans))
+		for _, u := range orphans {
+			rows = append(rows, []string{u.Prefix, u.Origin, u.Source})
+		}
+		writeReport(*format, []string{"prefix", "origin", "source"}, rows, orphans)
+		return
+	}
+	rows := make([][]string, 0, len(list))
+	for _, s := range list {
+		rows = append(rows, []string{s.Registry, s.CC, s.Type, fmt.Sprint(s.Delegated), fmt.Sprint(s.Announced),
+			fmt.Sprint(s.Dark), fmt.Sprintf("%.2f", s.Percent)})
+	}
+	writeReport(*format, []string{"registry", "cc", "type", "delegated", "announced", "dark", "announced_percent"}, rows, list)
+	if *format == "table" {
+		logger.Info("Announcements without delegation; list them with -undelegated", "count", len(orphans))
+	}
+}
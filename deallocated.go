@@ -0,0 +1,116 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// deallocation is a delegated resource that left the delegated space: removed from
+// the dataset, or returned to the available or reserved pool. Reason is "returned",
+// "transferred" when a published transfer of the resource exists, or "removed".
+type deallocation struct {
+	Date     string `json:"date"`
+	Registry string `json:"registry"`
+	Type     string `json:"type"`
+	Start    string `json:"start"`
+	Value    uint64 `json:"value"`
+	CC       string `json:"cc"`
+	Status   string `json:"status"` // before the change
+	Holder   string `json:"holder,omitempty"`
+	Reason   string `json:"reason"`
+}
+
+// recentDeallocations lists deallocations from the Changes table on or after since,
+// newest first. Empty registry and cc select all.
+func recentDeallocations(db *sql.DB, registry, cc, since string, limit int) ([]deallocation, error) {
+	rows, err := db.Query(`SELECT c.ChangeDate, c.ID_Registries, c.RecordType, c.Start, c.Value, IFNULL(c.OldCC, ''),
+		IFNULL(c.OldState, ''), IFNULL(c.OldOpaqueID, ''), IFNULL(c.NewState, ''),
+		EXISTS(SELECT 1 FROM Transfers t WHERE t.RecordType = c.RecordType AND t.Start = c.Start)
+		FROM Changes c
+		WHERE c.ChangeDate >= ? AND c.OldState IN ('allocated', 'assigned')
+		AND (c.ChangeType = 'removed' OR c.NewState IN ('available', 'reserved'))
+		AND (? = '' OR c.ID_Registries = ?) AND (? = '' OR c.OldCC = ?)
+		ORDER BY c.ChangeDate DESC, c.ID DESC LIMIT ?;`, since, registry, registry, cc, cc, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []deallocation{}
+	for rows.Next() {
+		var d deallocation
+		var newState string
+		var transferred bool
+		if err := rows.Scan(&d.Date, &d.Registry, &d.Type, &d.Start, &d.Value, &d.CC, &d.Status, &d.Holder,
+			&newState, &transferred); err != nil {
+			return nil, err
+		}
+		d.Holder = holder(d.Holder)
+		switch {
+		case transferred:
+			d.Reason = "transferred"
+		case newState != "":
+			d.Reason = "returned"
+		default:
+			d.Reason = "removed"
+		}
+		list = append(list, d)
+	}
+	return list, rows.Err()
+}
+
+// deallocatedCommand lists recently deallocated space.
+func deallocatedCommand(db *sql.DB, args []string) {
+	fs := flag.NewFlagSet("deallocated", flag.ExitOnError)
+	registry := fs.String("registry", "", "Only this registry")
+	cc := fs.String("cc", "", "Only resources of this country code")
+	days := fs.Int("days", 30, "Only deallocations of the last number of days")
+	limit := fs.Int("limit", 1000, "Maximum number of resources to list")
+	format := fs.String("format", "table", "Output format: table, csv or json")
+	fs.Parse(args)
+
+	since := time.Now().UTC().AddDate(0, 0, -*days).Format("2006-01-02")
+	list, err := recentDeallocations(db, *registry, strings.ToUpper(*cc), since, *limit)
+	if err != nil {
+		log.Fatal(err)
+	}
+	rows := make([][]string, 0, len(list))
+	for _, d := range list {
+		rows = append(rows, []string{d.Date, d.Registry, d.Type, d.Start, fmt.Sprint(d.Value), d.CC, d.Status, d.Holder, d.Reason})
+	}
+	writeReport(*format, []string{"date", "registry", "type", "start", "value", "cc", "status", "holder", "reason"}, rows, list)
+}
+
+// handleDeallocated serves /v1/deallocated; accepts registry, cc, days and limit parameters.
+func handleDeallocated(db *sql.DB, ns string, w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	days, limit := 30, 1000
+	for _, p := range []struct {
+		name string
+		v    *int
+	}{{"days", &days}, {"limit", &limit}} {
+		if s := q.Get(p.name); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n <= 0 {
+				http.Error(w, "invalid "+p.name, http.StatusBadRequest)
+				return
+			}
+			*p.v = n
+		}
+	}
+	since := time.Now().UTC().AddDate(0, 0, -days).Format("2006-01-02")
+	list, err := recentDeallocations(db, q.Get("registry"), strings.ToUpper(q.Get("cc")), since, limit)
+	if err != nil {
+		http.Error(w, "cannot query deallocations", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"namespace": ns, "deallocated": list})
+}
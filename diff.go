@@ -0,0 +1,322 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"net/netip"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// allocation holds the attributes of a resource that are compared between datasets.
+type allocation struct {
+	CC       string `json:"cc"`
+	Date     string `json:"date,omitempty"`
+	Status   string `json:"status"`
+	OpaqueID string `json:"opaque_id,omitempty"`
+}
+
+// change is one row of the Changes table.
+type change struct {
+	Type   string      `json:"type"`   // asn, ipv4 or ipv6
+	Change string      `json:"change"` // added, removed or changed
+	Start  string      `json:"start"`
+	Value  uint64      `json:"value"`
+	Old    *allocation `json:"old,omitempty"`
+	New    *allocation `json:"new,omitempty"`
+}
+
+// datasetDiff compares the records of a dataset being imported with the
+// records still present in the previous dataset of the same registry. A
+// resource is identified by its type, start and value; a resource whose other
+// attributes differ is reported as changed.
+type datasetDiff struct {
+	registry string
+	dataset  int64
+	prevID   int64
+	prev     map[string]allocation
+	seen     map[string]bool
+	changes  []change
+}
+
+// keyTypes maps record types to the columns identifying a resource.
+var keyTypes = map[string][2]string{
+	"ipv4": {"FirstIP", "HostCount"},
+	"ipv6": {"FirstIP", "PrefixLen"},
+	"asn":  {"ASN", "ASNCount"},
+}
+
+// newDatasetDiff loads the records of the previous dataset of a registry in the
+// transaction of the import. It returns nil when there is nothing to compare with: the
+// first dataset of a registry, or a dataset that was imported before.
+func newDatasetDiff(tx *sql.Tx, registry string, serial uint64, dataset int64) (*datasetDiff, error) {
+	d := &datasetDiff{registry: registry, dataset: dataset, prev: map[string]allocation{}, seen: map[string]bool{}}
+	err := tx.QueryRow("SELECT ID FROM Datasets WHERE ID_Registries = ? AND serial < ? ORDER BY serial DESC LIMIT 1;",
+		registry, serial).Scan(&d.prevID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("finding previous dataset: %w", err)
+	}
+
+	for t := range keyTypes {
+		var exists bool
+		err := tx.QueryRow(fmt.Sprintf("SELECT EXISTS(SELECT 1 FROM Records_%s WHERE ID_Registries = ? AND ID_LastDatasets = ?);", t),
+			registry, dataset).Scan(&exists)
+		if err != nil {
+			return nil, fmt.Errorf("checking dataset %d: %w", dataset, err)
+		}
+		if exists { // Re-import; the changes were recorded the first time
+			return nil, nil
+		}
+	}
+
+	err = datasetRecords(tx, registry, d.prevID, func(t, start string, value uint64, a allocation) {
+		d.prev[allocationKey(t, start, value)] = a
+	})
+	if err != nil {
+		return nil, fmt.Errorf("loading previous dataset: %w", err)
+	}
+	logger.Debug("Comparing with dataset", "dataset", d.prevID, "records", len(d.prev))
+	return d, nil
+}
+
+// datasetRecords calls fn for every record still present in a dataset, i.e. whose
+// latest dataset it is.
+func datasetRecords(tx *sql.Tx, registry string, dataset int64, fn func(recordType, start string, value uint64, a allocation)) error {
+	for t, cols := range keyTypes {
+		start := cols[0]
+		if t == "ipv4" {
+			start = "INET_NTOA(FirstIP)"
+		} else if t == "ipv6" {
+			start = "INET6_NTOA(FirstIP)"
+		}
+		rows, err := tx.Query(fmt.Sprintf(`SELECT %s, %s, CC, IFNULL(RecordDate, ''), State, IFNULL(OpaqueID, '')
+			FROM Records_%s WHERE ID_Registries = ? AND ID_LastDatasets = ?;`, start, cols[1], t), registry, dataset)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var s string
+			var v uint64
+			var a allocation
+			if err := rows.Scan(&s, &v, &a.CC, &a.Date, &a.Status, &a.OpaqueID); err != nil {
+				rows.Close()
+				return err
+			}
+			a.OpaqueID = holder(a.OpaqueID) // Records stored before the opaque ID was split off
+			fn(t, s, v, a)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// allocationKey identifies a resource independent of the textual form of its start address.
+func allocationKey(recordType, start string, value uint64) string {
+	if addr, err := netip.ParseAddr(start); err == nil {
+		start = addr.String()
+	}
+	return fmt.Sprintf("%s|%s|%d", recordType, start, value)
+}
+
+// datasetDate returns the end date of a dataset as YYYY-MM-DD, or today when the file has none.
+func datasetDate(enddate string) string {
+	if t, err := time.Parse("20060102", enddate); err == nil {
+		return t.Format("2006-01-02")
+	}
+	return time.Now().UTC().Format("2006-01-02")
+}
+
+// normalizeDate converts yyyymmdd dates from the delegated files to the form MySQL returns.
+func normalizeDate(date string) string {
+	if len(date) == 8 && !strings.Contains(date, "-") {
+		return date[:4] + "-" + date[4:6] + "-" + date[6:]
+	}
+	return date
+}
+
+// observe records a resource of the dataset being imported.
+func (d *datasetDiff) observe(recordType, start string, value uint64, a allocation) {
+	key := allocationKey(recordType, start, value)
+	if d.seen[key] {
+		return
+	}
+	d.seen[key] = true
+	a.Date = normalizeDate(a.Date)
+
+	old, ok := d.prev[key]
+	if !ok {
+		d.changes = append(d.changes, change{Type: recordType, Change: "added", Start: start, Value: value, New: &a})
+		return
+	}
+	delete(d.prev, key)
+	if old != a {
+		d.changes = append(d.changes, change{Type: recordType, Change: "changed", Start: start, Value: value, Old: &old, New: &a})
+	}
+}
+
+// finish adds the resources missing from the new dataset as removed and writes
+// all changes to the Changes table in tx. It returns the number of changes per kind.
+func (d *datasetDiff) finish(tx *sql.Tx, date string) (map[string]uint64, error) {
+	for key, old := range d.prev {
+		parts := strings.SplitN(key, "|", 3)
+		var value uint64
+		fmt.Sscan(parts[2], &value)
+		a := old
+		d.changes = append(d.changes, change{Type: parts[0], Change: "removed", Start: parts[1], Value: value, Old: &a})
+	}
+
+	summary := map[string]uint64{"added": 0, "removed": 0, "changed": 0}
+	if _, err := tx.Exec("DELETE FROM Changes WHERE ID_Datasets = ?;", d.dataset); err != nil {
+		return nil, fmt.Errorf("clearing changes: %w", err)
+	}
+	stmt, err := tx.Prepare(`INSERT INTO Changes (ID_Datasets, ID_PrevDatasets, ID_Registries, ChangeDate, RecordType, ChangeType,
+		Start, Value, OldCC, NewCC, OldState, NewState, OldRecordDate, NewRecordDate, OldOpaqueID, NewOpaqueID)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	for _, c := range d.changes {
+		var oldVals, newVals [4]sql.NullString
+		if c.Old != nil {
+			oldVals = allocationColumns(c.Old)
+		}
+		if c.New != nil {
+			newVals = allocationColumns(c.New)
+		}
+		_, err := stmt.Exec(d.dataset, d.prevID, d.registry, date, c.Type, c.Change, c.Start, c.Value,
+			oldVals[0], newVals[0], oldVals[2], newVals[2], oldVals[1], newVals[1], oldVals[3], newVals[3])
+		if err != nil {
+			return nil, fmt.Errorf("saving change: %w", err)
+		}
+		summary[c.Change]++
+	}
+	logger.Debug("Changes since dataset", "dataset", d.prevID, "added", summary["added"],
+		"removed", summary["removed"], "changed", summary["changed"])
+	return summary, nil
+}
+
+// publish sends the changes to the event sinks, once they are committed.
+func (d *datasetDiff) publish(date string) {
+	if len(eventSinks) == 0 {
+		return
+	}
+	for _, c := range d.changes {
+		ev := ChangeEvent{Registry: d.registry, Change: c.Change, Type: c.Type, Start: c.Start, Value: c.Value, Date: date, Dataset: d.dataset}
+		if c.Old != nil {
+			ev.OldCC, ev.OldStatus, ev.OldHolder = c.Old.CC, c.Old.Status, holder(c.Old.OpaqueID)
+		}
+		if c.New != nil {
+			ev.NewCC, ev.NewStatus, ev.NewHolder = c.New.CC, c.New.Status, holder(c.New.OpaqueID)
+		}
+		publishChangeEvent(ev)
+	}
+}
+
+// allocationColumns returns CC, date, status and opaque ID as nullable columns.
+func allocationColumns(a *allocation) [4]sql.NullString {
+	return [4]sql.NullString{
+		{String: a.CC, Valid: true},
+		{String: a.Date, Valid: a.Date != ""},
+		{String: a.Status, Valid: true},
+		{String: a.OpaqueID, Valid: a.OpaqueID != ""},
+	}
+}
+
+// changesCommand lists recorded changes, newest first.
+func changesCommand(db *sql.DB, args []string) {
+	fs := flag.NewFlagSet("changes", flag.ExitOnError)
+	registry := fs.String("registry", "", "Only changes of this registry")
+	cc := fs.String("cc", "", "Only changes involving this country code")
+	asn := fs.Uint64("asn", 0, "Only changes of AS number ranges containing this ASN")
+	recordType := fs.String("type", "", "Only changes of this record type: asn, ipv4 or ipv6")
+	since := fs.String("since", "", "Only changes on or after this date (YYYY-MM-DD)")
+	until := fs.String("until", "", "Only changes on or before this date (YYYY-MM-DD)")
+	limit := fs.Int("limit", 100, "Maximum number of changes to list")
+	fs.Parse(args)
+
+	var where []string
+	var params []interface{}
+	if *registry != "" {
+		where = append(where, "ID_Registries = ?")
+		params = append(params, *registry)
+	}
+	if *cc != "" {
+		where = append(where, "(OldCC = ? OR NewCC = ?)")
+		params = append(params, strings.ToUpper(*cc), strings.ToUpper(*cc))
+	}
+	if *asn != 0 {
+		where = append(where, "RecordType = 'asn' AND ? BETWEEN CAST(Start AS UNSIGNED) AND CAST(Start AS UNSIGNED) + Value - 1")
+		params = append(params, *asn)
+	}
+	if *recordType != "" {
+		where = append(where, "RecordType = ?")
+		params = append(params, *recordType)
+	}
+	for _, d := range []struct {
+		cond  string
+		value string
+	}{{"ChangeDate >= ?", *since}, {"ChangeDate <= ?", *until}} {
+		if d.value == "" {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", d.value); err != nil {
+			log.Fatal("Invalid date: " + d.value)
+		}
+		where = append(where, d.cond)
+		params = append(params, d.value)
+	}
+
+	query := `SELECT ChangeDate, ID_Registries, RecordType, ChangeType, Start, Value, IFNULL(OldCC, ''), IFNULL(NewCC, ''),
+		IFNULL(OldState, ''), IFNULL(NewState, '') FROM Changes`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY ChangeDate DESC, ID DESC LIMIT ?;"
+	params = append(params, *limit)
+
+	rows, err := db.Query(query, params...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rows.Close()
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "DATE\tREGISTRY\tTYPE\tCHANGE\tSTART\tVALUE\tCC\tSTATE")
+	for rows.Next() {
+		var date, reg, t, kind, start, oldCC, newCC, oldState, newState string
+		var value uint64
+		if err := rows.Scan(&date, &reg, &t, &kind, &start, &value, &oldCC, &newCC, &oldState, &newState); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\t%s\t%s\n", date, reg, t, kind, start, value,
+			transition(oldCC, newCC), transition(oldState, newState))
+	}
+	if err := rows.Err(); err != nil {
+		log.Fatal(err)
+	}
+	w.Flush()
+}
+
+// transition formats an old and new value, e.g. "US -> CA".
+func transition(old, cur string) string {
+	switch {
+	case old == cur:
+		return old
+	case old == "":
+		return cur
+	case cur == "":
+		return old
+	}
+	return old + " -> " + cur
+}
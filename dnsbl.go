@@ -0,0 +1,380 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/netip"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// prefixMatcher finds the most specific of a set of prefixes containing an address.
+type prefixMatcher struct {
+	prefixes map[int]map[netip.Prefix]string
+	lengths  []int // longest first
+}
+
+func newPrefixMatcher() *prefixMatcher {
+	return &prefixMatcher{prefixes: map[int]map[netip.Prefix]string{}}
+}
+
+func (m *prefixMatcher) add(p netip.Prefix, value string) {
+	p = p.Masked()
+	bits := p.Bits()
+	if p.Addr().Is6() {
+		bits += 128 // Keep the address families apart
+	}
+	if m.prefixes[bits] == nil {
+		m.prefixes[bits] = map[netip.Prefix]string{}
+		m.lengths = append(m.lengths, bits)
+		sort.Sort(sort.Reverse(sort.IntSlice(m.lengths)))
+	}
+	if _, ok := m.prefixes[bits][p]; !ok {
+		m.prefixes[bits][p] = value
+	}
+}
+
+func (m *prefixMatcher) match(addr netip.Addr) (string, bool) {
+	for _, bits := range m.lengths {
+		plen := bits
+		if addr.Is6() {
+			if bits < 128 {
+				break
+			}
+			plen -= 128
+		} else if bits >= 128 {
+			continue
+		}
+		p, err := addr.Prefix(plen)
+		if err != nil {
+			continue
+		}
+		if v, ok := m.prefixes[bits][p]; ok {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// dnsblZone lists the addresses matched by the deny clauses of a policy (see
+// parsePolicy), except those also matched by an allow clause.
+type dnsblZone struct {
+	name   string
+	listed *prefixMatcher // prefix => reason, e.g. "deny AS12345"
+	exempt *prefixMatcher
+}
+
+// buildDNSBLZone resolves the clauses of a policy into a zone.
+func buildDNSBLZone(db *sql.DB, name, spec string) (*dnsblZone, error) {
+	rules, err := parsePolicy(spec)
+	if err != nil {
+		return nil, err
+	}
+	z := &dnsblZone{name: strings.ToLower(strings.TrimSuffix(name, ".")), listed: newPrefixMatcher(), exempt: newPrefixMatcher()}
+	for _, r := range rules {
+		list, err := policyPrefixes(db, r)
+		if err != nil {
+			return nil, fmt.Errorf("resolving %s %s %s: %w", r.Action, r.Kind, r.Value, err)
+		}
+		reason := strings.TrimSpace(r.Action + " " + r.Kind + " " + r.Value)
+		if r.Kind == "asn" {
+			reason = "deny AS" + r.Value
+		}
+		for _, p := range list {
+			if r.Action == "allow" {
+				z.exempt.add(p, "")
+			} else {
+				z.listed.add(p, reason)
+			}
+		}
+	}
+	return z, nil
+}
+
+// lookup returns the listing reason of an address.
+func (z *dnsblZone) lookup(addr netip.Addr) (string, bool) {
+	if _, ok := z.exempt.match(addr); ok {
+		return "", false
+	}
+	return z.listed.match(addr)
+}
+
+// writeRbldnsd writes a zone as rbldnsd ip4trie/ip6trie data, one prefix per line.
+func (z *dnsblZone) writeRbldnsd(w io.Writer) error {
+	fmt.Fprintf(w, "# DNSBL %s generated by ip2asn\n:127.0.0.2:Listed by %s\n", z.name, z.name)
+	for _, bits := range z.listed.lengths {
+		var list []netip.Prefix
+		for p := range z.listed.prefixes[bits] {
+			list = append(list, p)
+		}
+		sort.Slice(list, func(i, j int) bool { return list[i].Addr().Less(list[j].Addr()) })
+		for _, p := range list {
+			if _, ok := z.exempt.match(p.Addr()); ok {
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "%s :127.0.0.2:%s\n", p, z.listed.prefixes[bits][p]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// dnsblCommand implements "dnsbl add ZONE SPEC", "dnsbl list", "dnsbl remove ZONE" and
+// "dnsbl generate ZONE SPEC", which prints rbldnsd data. Stored zones are served with
+// -dnsbl-listen and exported as dnsbl-ZONE.rbldnsd to -export-dir.
+func dnsblCommand(db *sql.DB, args []string) {
+	usage := "Usage: dnsbl add ZONE SPEC | dnsbl list | dnsbl remove ZONE | dnsbl generate ZONE SPEC"
+	if len(args) == 0 {
+		log.Fatal(usage)
+	}
+	fs := flag.NewFlagSet("dnsbl "+args[0], flag.ExitOnError)
+	fs.Parse(args[1:])
+	switch args[0] {
+	case "generate":
+		if fs.NArg() != 2 {
+			log.Fatal(usage)
+		}
+		z, err := buildDNSBLZone(db, fs.Arg(0), readPolicySpec(fs.Arg(1)))
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := z.writeRbldnsd(os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+	case "add":
+		if fs.NArg() != 2 {
+			log.Fatal(usage)
+		}
+		zone := strings.ToLower(strings.TrimSuffix(fs.Arg(0), "."))
+		spec := readPolicySpec(fs.Arg(1))
+		if _, err := parsePolicy(spec); err != nil {
+			log.Fatal(err)
+		}
+		if _, err := db.Exec("REPLACE INTO DnsblZones VALUES (?, ?, NOW());", zone, spec); err != nil {
+			log.Fatal(err)
+		}
+		auditLog(db, "dnsbl.add", zone, 0)
+		regenerateExports(db)
+	case "list":
+		rows, err := db.Query("SELECT Zone, Spec, Updated FROM DnsblZones ORDER BY Zone;")
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer rows.Close()
+		var table [][]string
+		for rows.Next() {
+			var zone, spec, updated string
+			if err := rows.Scan(&zone, &spec, &updated); err != nil {
+				log.Fatal(err)
+			}
+			table = append(table, []string{zone, strings.Join(strings.Fields(spec), " "), updated})
+		}
+		writeReport("table", []string{"zone", "spec", "updated"}, table, nil)
+	case "remove":
+		if fs.NArg() != 1 {
+			log.Fatal(usage)
+		}
+		if _, err := db.Exec("DELETE FROM DnsblZones WHERE Zone = ?;", fs.Arg(0)); err != nil {
+			log.Fatal(err)
+		}
+		auditLog(db, "dnsbl.remove", fs.Arg(0), 0)
+	default:
+		log.Fatal(usage)
+	}
+}
+
+// storedDNSBLZones returns the zone names and policies of DnsblZones.
+func storedDNSBLZones(db *sql.DB) (map[string]string, error) {
+	rows, err := db.Query("SELECT Zone, Spec FROM DnsblZones;")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	zones := map[string]string{}
+	for rows.Next() {
+		var zone, spec string
+		if err := rows.Scan(&zone, &spec); err != nil {
+			return nil, err
+		}
+		zones[zone] = spec
+	}
+	return zones, rows.Err()
+}
+
+// dnsblExporters returns an exporter per stored zone for regenerateExports.
+func dnsblExporters(db *sql.DB) []exporter {
+	zones, err := storedDNSBLZones(db)
+	if err != nil {
+		if !isMissingTable(err) {
+			logger.Warn("Cannot read DNSBL zones", "err", err)
+		}
+		return nil
+	}
+	var list []exporter
+	for zone, spec := range zones {
+		zone, spec := zone, spec
+		list = append(list, exporter{"dnsbl-" + zone + ".rbldnsd", "text/plain; charset=utf-8", func(db *sql.DB, w io.Writer) error {
+			z, err := buildDNSBLZone(db, zone, spec)
+			if err != nil {
+				return err
+			}
+			return z.writeRbldnsd(w)
+		}})
+	}
+	return list
+}
+
+var dnsblZones struct {
+	sync.RWMutex
+	zones []*dnsblZone
+}
+
+// loadDNSBLZones rebuilds the served zones from DnsblZones.
+func loadDNSBLZones(db *sql.DB) {
+	stored, err := storedDNSBLZones(db)
+	if err != nil {
+		logger.Warn("Cannot read DNSBL zones", "err", err)
+		return
+	}
+	var zones []*dnsblZone
+	for name, spec := range stored {
+		z, err := buildDNSBLZone(db, name, spec)
+		if err != nil {
+			logger.Warn("Cannot load DNSBL zone", "zone", name, "err", err)
+			continue
+		}
+		zones = append(zones, z)
+	}
+	dnsblZones.Lock()
+	dnsblZones.zones = zones
+	dnsblZones.Unlock()
+	logger.Debug("Loaded DNSBL zones", "count", len(zones))
+}
+
+// startDNSBLServer answers DNSBL queries over UDP on -dnsbl-listen, e.g. A and TXT
+// queries for 4.3.2.1.asn.block.local, and reloads the zones hourly. The socket is closed
+// when ctx is cancelled, after the query being answered.
+func startDNSBLServer(ctx context.Context, db *sql.DB) {
+	conn, err := net.ListenPacket("udp", *f_dnsblListen)
+	if err != nil {
+		logger.Warn("Cannot listen for DNSBL queries", "addr", *f_dnsblListen, "err", err)
+		return
+	}
+	loadDNSBLZones(db)
+	go func() {
+		for range time.Tick(time.Hour) {
+			loadDNSBLZones(db)
+		}
+	}()
+	logger.Info("Serving DNSBL zones", "addr", *f_dnsblListen)
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+	serverRequests.Add(1)
+	go func() {
+		defer serverRequests.Done()
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				if ctx.Err() == nil {
+					logger.Warn("DNSBL server failed", "err", err)
+				}
+				return
+			}
+			if resp := dnsblResponse(buf[:n]); resp != nil {
+				conn.WriteTo(resp, addr)
+			}
+		}
+	}()
+}
+
+// dnsblResponse answers a DNS query; names outside the zones are refused.
+func dnsblResponse(query []byte) []byte {
+	var p dnsmessage.Parser
+	h, err := p.Start(query)
+	if err != nil || h.Response {
+		return nil
+	}
+	q, err := p.Question()
+	if err != nil {
+		return nil
+	}
+
+	rcode := dnsmessage.RCodeRefused
+	var reason string
+	name := strings.ToLower(strings.TrimSuffix(q.Name.String(), "."))
+	dnsblZones.RLock()
+	for _, z := range dnsblZones.zones {
+		if !strings.HasSuffix(name, "."+z.name) {
+			continue
+		}
+		rcode = dnsmessage.RCodeNameError
+		if addr, ok := dnsblAddr(strings.TrimSuffix(name, "."+z.name)); ok {
+			if r, listed := z.lookup(addr); listed {
+				rcode, reason = dnsmessage.RCodeSuccess, r
+			}
+		}
+		break
+	}
+	dnsblZones.RUnlock()
+
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: h.ID, Response: true, Authoritative: rcode != dnsmessage.RCodeRefused,
+		RecursionDesired: h.RecursionDesired, RCode: rcode})
+	b.EnableCompression()
+	b.StartQuestions()
+	b.Question(q)
+	b.StartAnswers()
+	if rcode == dnsmessage.RCodeSuccess {
+		hdr := dnsmessage.ResourceHeader{Name: q.Name, Class: dnsmessage.ClassINET, TTL: 300}
+		switch q.Type {
+		case dnsmessage.TypeA:
+			b.AResource(hdr, dnsmessage.AResource{A: [4]byte{127, 0, 0, 2}})
+		case dnsmessage.TypeTXT:
+			b.TXTResource(hdr, dnsmessage.TXTResource{TXT: []string{"Listed: " + reason}})
+		}
+	}
+	resp, err := b.Finish()
+	if err != nil {
+		return nil
+	}
+	return resp
+}
+
+// dnsblAddr parses the reversed address of a DNSBL query: 4.3.2.1 for 1.2.3.4 or 32
+// nibbles for an IPv6 address.
+func dnsblAddr(labels string) (netip.Addr, bool) {
+	parts := strings.Split(labels, ".")
+	for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
+		parts[i], parts[j] = parts[j], parts[i]
+	}
+	switch len(parts) {
+	case 4:
+		a, err := netip.ParseAddr(strings.Join(parts, "."))
+		return a, err == nil && a.Is4()
+	case 32:
+		var b [16]byte
+		for i, nibble := range parts {
+			v, err := strconv.ParseUint(nibble, 16, 4)
+			if err != nil {
+				return netip.Addr{}, false
+			}
+			b[i/2] |= byte(v) << (4 * uint(1-i%2))
+		}
+		return netip.AddrFrom16(b), true
+	}
+	return netip.Addr{}, false
+}
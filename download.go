@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// downloadFile downloads a whole file into memory, for the smaller auxiliary files.
+func downloadFile(ctx context.Context, url *string) ([]byte, error) {
+	body, err := openDownload(ctx, *url)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return ioutil.ReadAll(body)
+}
+
+var downloadClientOnce sync.Once
+var downloadHTTPClient *http.Client
+
+// downloadClient returns the client of downloads, which gives up on connecting and on
+// waiting for the response headers after -download-timeout. A body that stalls for as
+// long is cancelled by downloadBody.
+func downloadClient() *http.Client {
+	downloadClientOnce.Do(func() {
+		timeout := *f_downloadTimeout
+		downloadHTTPClient = &http.Client{Transport: &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			DialContext:           (&net.Dialer{Timeout: timeout, KeepAlive: 30 * time.Second}).DialContext,
+			TLSHandshakeTimeout:   timeout,
+			ResponseHeaderTimeout: timeout,
+			IdleConnTimeout:       90 * time.Second,
+		}}
+	})
+	return downloadHTTPClient
+}
+
+// downloadBody is the body of a download in progress. Reading it marks progress and
+// feeds the mirror; Close records the download. A body interrupted by a network error
+// or a stall is requested again from where it stopped, with a Range header.
+type downloadBody struct {
+	ctx       context.Context
+	url       string
+	body      io.ReadCloser
+	cancel    context.CancelFunc
+	stall     *time.Timer
+	validator string // ETag or Last-Modified of the first response, for If-Range
+	resumes   int
+	mirror    *mirrorWriter
+	span      trace.Span
+	started   time.Time
+	n         int
+	eof       bool
+	err       error
+	closed    bool
+}
+
+// openDownload starts downloading url; the caller reads and closes the body.
+func openDownload(ctx context.Context, url string) (*downloadBody, error) {
+	_, span := tracer.Start(ctx, "download", trace.WithAttributes(attribute.String("url", url)))
+	logger.Info("Downloading file", "url", url)
+	sdNotify("STATUS=Downloading " + url)
+	publishDatasetEvent(DatasetEvent{Event: "download.started", Source: url})
+	markProgress()
+
+	d := &downloadBody{ctx: ctx, url: url, span: span}
+	if err := d.open(); err != nil {
+		endSpan(span, err)
+		return nil, err
+	}
+	busy.Add(1)
+	d.mirror, d.started = newMirrorWriter(url), time.Now()
+	return d, nil
+}
+
+// open requests the body from byte d.n on, retrying up to -download-retries times with
+// exponential backoff on network errors, throttling and server errors.
+func (d *downloadBody) open() error {
+	var err error
+	for attempt := 0; attempt <= *f_downloadRetries; attempt++ {
+		if attempt > 0 {
+			delay := time.Duration(1<<uint(attempt-1)) * time.Second
+			logger.Warn("Download failed; retrying", "err", err, "delay", delay)
+			select {
+			case <-d.ctx.Done():
+				return d.ctx.Err()
+			case <-time.After(delay):
+			}
+		}
+		var retry bool
+		if retry, err = d.request(); err == nil || !retry {
+			return err
+		}
+	}
+	return err
+}
+
+// request sends a single request for the body and reports whether a failure is worth
+// retrying.
+func (d *downloadBody) request() (bool, error) {
+	ctx, cancel := context.WithCancel(d.ctx)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, d.url, nil)
+	if err != nil {
+		cancel()
+		return false, err
+	}
+	if d.n > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", d.n))
+		if d.validator != "" {
+			req.Header.Set("If-Range", d.validator)
+		}
+	}
+	resp, err := downloadClient().Do(req)
+	if err != nil {
+		cancel()
+		return d.ctx.Err() == nil, err
+	}
+
+	switch {
+	case d.n == 0 && resp.StatusCode == http.StatusOK:
+		rememberValidators(d.url, resp.Header)
+		if d.validator = resp.Header.Get("ETag"); d.validator == "" || strings.HasPrefix(d.validator, "W/") {
+			d.validator = resp.Header.Get("Last-Modified") // If-Range takes no weak ETags
+		}
+	case d.n > 0 && resp.StatusCode == http.StatusPartialContent:
+	case d.n > 0 && resp.StatusCode == http.StatusOK:
+		if d.validator != "" { // If-Range did not match
+			resp.Body.Close()
+			cancel()
+			return false, fmt.Errorf("downloading %s: the file changed during the download", d.url)
+		}
+		// Without Range support the file is sent again; skip what was read before
+		if _, err := io.CopyN(ioutil.Discard, resp.Body, int64(d.n)); err != nil {
+			resp.Body.Close()
+			cancel()
+			return true, err
+		}
+	default:
+		resp.Body.Close()
+		cancel()
+		retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+		return retry, fmt.Errorf("downloading %s: %s", d.url, resp.Status)
+	}
+	d.body, d.cancel = resp.Body, cancel
+	if *f_downloadTimeout > 0 {
+		d.stall = time.AfterFunc(*f_downloadTimeout, cancel)
+	}
+	return false, nil
+}
+
+// closeBody closes the body of the current request, if any.
+func (d *downloadBody) closeBody() error {
+	if d.body == nil {
+		return nil
+	}
+	if d.stall != nil {
+		d.stall.Stop()
+	}
+	err := d.body.Close()
+	d.cancel()
+	d.body, d.stall = nil, nil
+	return err
+}
+
+func (d *downloadBody) Read(p []byte) (int, error) {
+	if d.body == nil { // Resuming failed
+		return 0, d.err
+	}
+	markProgress()
+	n, err := d.body.Read(p)
+	if d.stall != nil {
+		d.stall.Reset(*f_downloadTimeout)
+	}
+	d.n += n
+	if d.mirror != nil {
+		d.mirror.Write(p[:n])
+	}
+	if err == io.EOF {
+		d.eof = true
+	} else if err != nil && d.ctx.Err() == nil && d.resumes < *f_downloadRetries {
+		d.resumes++
+		logger.Warn("Download interrupted; resuming", "url", d.url, "bytes", d.n, "err", err)
+		d.closeBody()
+		if err = d.open(); err == nil {
+			return n, nil
+		}
+		d.err = err
+	} else if err != nil {
+		d.err = err
+	}
+	return n, err
+}
+
+func (d *downloadBody) Close() error {
+	if d.closed {
+		return nil
+	}
+	d.closed = true
+	err := d.closeBody()
+	busy.Add(-1)
+	d.mirror.finish(d.eof)
+	if d.eof {
+		logger.Debug("Download complete", "bytes", d.n)
+		stats.timing("download.duration", time.Since(d.started))
+		stats.count("download.bytes", uint64(d.n))
+	}
+	if d.resumes > 0 {
+		d.span.SetAttributes(attribute.Int("resumes", d.resumes))
+	}
+	d.span.SetAttributes(attribute.Int("bytes", d.n))
+	endSpan(d.span, d.err)
+	return err
+}
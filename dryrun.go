@@ -0,0 +1,129 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/krassi/ip2asn/pkg/rirparse"
+)
+
+// dryRunStore parses datasets for -dry-run without a database: headers and records are
+// only counted, and ranges listed twice in a dataset are reported as duplicates.
+// Registry locations come from the config file or the defaults of a new database.
+type dryRunStore struct {
+	mu       sync.Mutex
+	datasets []*dryRunTx // by dataset ID - 1
+}
+
+type dryRunTx struct {
+	ranges     map[string]bool
+	duplicates []string
+}
+
+func (s *dryRunStore) HasDataset(registry string, serial uint64) (bool, error) {
+	return false, nil // Validate even datasets imported before
+}
+
+func (s *dryRunStore) LatestSerial(registry string) (uint64, error) {
+	return 0, nil
+}
+
+func (s *dryRunStore) Begin(hdr rirparse.FileHeader, force bool) (RecordTx, int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tx := &dryRunTx{ranges: map[string]bool{}}
+	s.datasets = append(s.datasets, tx)
+	return tx, int64(len(s.datasets)), nil
+}
+
+func (s *dryRunStore) RegistryURL(registry string) (string, error) {
+	for _, r := range defaultRegistries {
+		if r.ShortName == registry {
+			return r.Latest, nil
+		}
+	}
+	return "", sql.ErrNoRows
+}
+
+func (s *dryRunStore) MySQL() *sql.DB {
+	return nil
+}
+
+func (s *dryRunStore) dataset(id int64) *dryRunTx {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.datasets[id-1]
+}
+
+func (t *dryRunTx) SaveRecord(rec rirparse.Record) error {
+	key := fmt.Sprintf("%s %s/%d", rec.Type, rec.Start, rec.Value)
+	if t.ranges[key] {
+		t.duplicates = append(t.duplicates, key)
+	}
+	t.ranges[key] = true
+	return nil
+}
+
+func (t *dryRunTx) Flush() error {
+	return nil
+}
+
+func (t *dryRunTx) Commit() error {
+	return nil
+}
+
+func (t *dryRunTx) Rollback() error {
+	return nil
+}
+
+func (t *dryRunTx) MySQL() *sql.Tx {
+	return nil
+}
+
+func (t *dryRunTx) RowCounts() map[string]uint64 {
+	return nil
+}
+
+// runDryRun parses the datasets selected by -source and reports what an import would
+// insert, without connecting to a database. It exits non-zero when a dataset fails.
+func runDryRun() {
+	st := &dryRunStore{}
+	results, err := importSource(shutdownContext(), st)
+	for _, r := range results {
+		if r.Dataset == 0 { // Failed before the version line
+			fmt.Printf("%s: %s\n", r.Source, r.Error)
+			continue
+		}
+		tx := st.dataset(r.Dataset)
+		fmt.Printf("%s serial %d from %s: %s\n", r.Registry, r.Serial, r.Source, r.Status)
+		fmt.Printf("  would insert %d records: %d asn, %d ipv4, %d ipv6\n", r.Counts["asn"]+r.Counts["ipv4"]+r.Counts["ipv6"],
+			r.Counts["asn"], r.Counts["ipv4"], r.Counts["ipv6"])
+		fmt.Printf("  invalid lines: %d\n", r.Counts["invalid"])
+		fmt.Printf("  duplicate ranges: %d", len(tx.duplicates))
+		if len(tx.duplicates) > 0 {
+			fmt.Printf(" (%s)", strings.Join(firstStrings(tx.duplicates, 10), ", "))
+		}
+		fmt.Println()
+		if q := r.Quality; q != nil {
+			fmt.Printf("  overlapping blocks: %d, count mismatch: %d, date anomalies: %d, quality score: %.2f\n",
+				q.Overlaps, q.CountMismatch, q.DateAnomalies, q.Score)
+		}
+		if r.Error != "" {
+			fmt.Printf("  error: %s\n", r.Error)
+		}
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// firstStrings returns up to n strings of list, with the number left out.
+func firstStrings(list []string, n int) []string {
+	if len(list) <= n {
+		return list
+	}
+	return append(list[:n:n], fmt.Sprintf("%d more", len(list)-n))
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// esBatchSize is the number of documents sent per bulk request.
+const esBatchSize = 1000
+
+// esMapping maps the range fields of allocation and change documents, so that an
+// address in a log index can be joined with a term query on ip_range.
+const esMapping = `{"mappings": {"properties": {
+	"registry": {"type": "keyword"}, "cc": {"type": "keyword"}, "type": {"type": "keyword"},
+	"start": {"type": "keyword"}, "value": {"type": "long"}, "date": {"type": "date", "format": "yyyyMMdd||yyyy-MM-dd"},
+	"status": {"type": "keyword"}, "change": {"type": "keyword"}, "dataset": {"type": "long"}, "serial": {"type": "long"},
+	"old_cc": {"type": "keyword"}, "new_cc": {"type": "keyword"}, "old_status": {"type": "keyword"},
+	"new_status": {"type": "keyword"}, "old_holder": {"type": "keyword"}, "new_holder": {"type": "keyword"},
+	"ip_range": {"type": "ip_range"}, "asn_range": {"type": "long_range"}}}}`
+
+// elasticsearchSink bulk-indexes records into <prefix>-allocations, keyed by resource so
+// a later dataset overwrites them, and changes into <prefix>-changes. It also works
+// with OpenSearch; credentials are taken from the URL.
+type elasticsearchSink struct {
+	url     string
+	records string
+	changes string
+	mu      sync.Mutex
+	buf     bytes.Buffer
+	pending int
+}
+
+func newElasticsearchSink() *elasticsearchSink {
+	e := &elasticsearchSink{
+		url:     strings.TrimSuffix(*f_esURL, "/"),
+		records: *f_esIndexPrefix + "-allocations",
+		changes: *f_esIndexPrefix + "-changes",
+	}
+	for _, index := range []string{e.records, e.changes} {
+		if err := e.createIndex(index); err != nil {
+			log.Fatal("Cannot create Elasticsearch index: " + err.Error())
+		}
+	}
+	logger.Debug("Indexing records and changes into Elasticsearch", "url", e.url)
+	return e
+}
+
+// createIndex creates an index with the mapping unless it exists.
+func (e *elasticsearchSink) createIndex(index string) error {
+	resp, err := e.request(http.MethodHead, "/"+index, "", nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	resp, err = e.request(http.MethodPut, "/"+index, "application/json", strings.NewReader(esMapping))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s: %s: %s", index, resp.Status, body)
+	}
+	return nil
+}
+
+func (e *elasticsearchSink) request(method, path, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, e.url+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	client := http.Client{Timeout: time.Minute}
+	return client.Do(req)
+}
+
+// esRanges returns the ip_range or asn_range field of a resource.
+func esRanges(kind, start string, value uint64) map[string]interface{} {
+	if kind == "asn" {
+		first, err := strconv.ParseUint(start, 10, 32)
+		if err != nil {
+			return nil
+		}
+		return map[string]interface{}{"asn_range": map[string]uint64{"gte": first, "lte": first + value - 1}}
+	}
+	prefixes, err := recordPrefixes(kind, start, value)
+	if err != nil || len(prefixes) == 0 {
+		return nil
+	}
+	return map[string]interface{}{"ip_range": map[string]string{
+		"gte": prefixes[0].Addr().String(), "lte": lastAddr(prefixes[len(prefixes)-1]).String()}}
+}
+
+// add appends an index action and document to the pending bulk request.
+func (e *elasticsearchSink) add(index, id string, ev interface{}, ranges map[string]interface{}) {
+	doc := map[string]interface{}{}
+	data, _ := json.Marshal(ev)
+	json.Unmarshal(data, &doc)
+	for k, v := range ranges {
+		doc[k] = v
+	}
+	action, _ := json.Marshal(map[string]map[string]string{"index": {"_index": index, "_id": id}})
+	source, err := json.Marshal(doc)
+	if err != nil {
+		return
+	}
+	e.write(action, source)
+}
+
+// write appends bulk request lines and sends them once the batch is full.
+func (e *elasticsearchSink) write(lines ...[]byte) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, line := range lines {
+		e.buf.Write(line)
+		e.buf.WriteByte('\n')
+	}
+	e.pending++
+	if e.pending >= esBatchSize {
+		e.flush()
+	}
+}
+
+// flush sends the pending documents; the caller holds e.mu.
+func (e *elasticsearchSink) flush() {
+	if e.pending == 0 {
+		return
+	}
+	n := e.pending
+	body := bytes.NewReader(e.buf.Bytes())
+	defer func() {
+		e.buf.Reset()
+		e.pending = 0
+	}()
+	resp, err := e.request(http.MethodPost, "/_bulk", "application/x-ndjson", body)
+	if err != nil {
+		logger.Warn("Elasticsearch documents not indexed", "count", n, "err", err)
+		return
+	}
+	defer resp.Body.Close()
+	var result struct {
+		Errors bool `json:"errors"`
+		Items  []map[string]struct {
+			Error json.RawMessage `json:"error"`
+		} `json:"items"`
+	}
+	if resp.StatusCode != http.StatusOK || json.NewDecoder(resp.Body).Decode(&result) != nil {
+		logger.Warn("Elasticsearch documents not indexed", "count", n, "status", resp.Status)
+		return
+	}
+	if result.Errors {
+		var failed int
+		var first string
+		for _, item := range result.Items {
+			for _, r := range item {
+				if len(r.Error) > 0 {
+					if failed == 0 {
+						first = string(r.Error)
+					}
+					failed++
+				}
+			}
+		}
+		logger.Warn("Elasticsearch documents not indexed", "failed", failed, "count", n, "example", first)
+	}
+}
+
+func (e *elasticsearchSink) publishDataset(ev DatasetEvent) {
+	if ev.Event == "import.finished" {
+		e.mu.Lock()
+		e.flush()
+		e.mu.Unlock()
+	}
+}
+
+func (e *elasticsearchSink) publishRecord(ev RecordEvent) {
+	id := fmt.Sprintf("%s|%s|%s|%d", ev.Registry, ev.Type, ev.Start, ev.Value)
+	e.add(e.records, id, ev, esRanges(ev.Type, ev.Start, ev.Value))
+}
+
+func (e *elasticsearchSink) publishChange(ev ChangeEvent) {
+	id := fmt.Sprintf("%d|%s|%s|%d", ev.Dataset, ev.Type, ev.Start, ev.Value)
+	e.add(e.changes, id, ev, esRanges(ev.Type, ev.Start, ev.Value))
+	if ev.Change == "removed" { // Keep the allocations index current
+		action, _ := json.Marshal(map[string]map[string]string{"delete": {"_index": e.records,
+			"_id": fmt.Sprintf("%s|%s|%s|%d", ev.Registry, ev.Type, ev.Start, ev.Value)}})
+		e.write(action)
+	}
+}
+
+func (e *elasticsearchSink) close() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.flush()
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"time"
+)
+
+// DatasetEvent describes a step in the lifecycle of a dataset import.
+type DatasetEvent struct {
+	Event    string            `json:"event"` // download.started, import.started, diff.summary, import.finished
+	Registry string            `json:"registry,omitempty"`
+	Serial   uint64            `json:"serial,omitempty"`
+	Source   string            `json:"source"`
+	Time     time.Time         `json:"time"`
+	Changes  map[string]uint64 `json:"changes,omitempty"` // only for diff.summary
+	Result   *ImportResult     `json:"result,omitempty"`  // only for import.finished
+}
+
+// RecordEvent describes a single allocation record written to the database.
+type RecordEvent struct {
+	Registry string `json:"registry"`
+	CC       string `json:"cc"`
+	Type     string `json:"type"` // asn, ipv4 or ipv6
+	Start    string `json:"start"`
+	Value    uint64 `json:"value"`
+	Date     string `json:"date"`
+	Status   string `json:"status"`
+	Dataset  int64  `json:"dataset"`
+	Serial   uint64 `json:"serial"`
+}
+
+// ChangeEvent describes a resource added, removed or changed since the previous dataset.
+type ChangeEvent struct {
+	Registry  string `json:"registry"`
+	Change    string `json:"change"` // added, removed or changed
+	Type      string `json:"type"`
+	Start     string `json:"start"`
+	Value     uint64 `json:"value"`
+	Date      string `json:"date"`
+	OldCC     string `json:"old_cc,omitempty"`
+	NewCC     string `json:"new_cc,omitempty"`
+	OldStatus string `json:"old_status,omitempty"`
+	NewStatus string `json:"new_status,omitempty"`
+	OldHolder string `json:"old_holder,omitempty"`
+	NewHolder string `json:"new_holder,omitempty"`
+	Dataset   int64  `json:"dataset"`
+}
+
+// eventSink receives dataset lifecycle, record and change events, e.g. a message broker.
+type eventSink interface {
+	publishDataset(ev DatasetEvent)
+	publishRecord(ev RecordEvent)
+	publishChange(ev ChangeEvent)
+	close()
+}
+
+var eventSinks []eventSink
+
+// setupEventSinks connects to all configured event destinations.
+func setupEventSinks() {
+	if *f_kafkaBrokers != "" {
+		eventSinks = append(eventSinks, newKafkaSink())
+	}
+	if *f_natsURL != "" {
+		eventSinks = append(eventSinks, newNATSSink())
+	}
+	if *f_esURL != "" {
+		eventSinks = append(eventSinks, newElasticsearchSink())
+	}
+	if *f_splunkURL != "" {
+		eventSinks = append(eventSinks, newSplunkSink())
+	}
+}
+
+func publishDatasetEvent(ev DatasetEvent) {
+	ev.Time = time.Now()
+	for _, sink := range eventSinks {
+		sink.publishDataset(ev)
+	}
+}
+
+func publishRecordEvent(ev RecordEvent) {
+	for _, sink := range eventSinks {
+		sink.publishRecord(ev)
+	}
+}
+
+func publishChangeEvent(ev ChangeEvent) {
+	for _, sink := range eventSinks {
+		sink.publishChange(ev)
+	}
+}
+
+// closeEventSinks flushes pending events; call before exiting.
+func closeEventSinks() {
+	for _, sink := range eventSinks {
+		sink.close()
+	}
+}
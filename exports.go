@@ -0,0 +1,443 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// exporter produces one artifact of the latest allocations for -export-dir.
+type exporter struct {
+	name        string
+	contentType string
+	write       func(db *sql.DB, w io.Writer) error
+}
+
+var exporters = []exporter{
+	{"allocations.tsv", "text/tab-separated-values; charset=utf-8", exportAllocationsTSV},
+	{"allocations-geo.tsv", "text/tab-separated-values; charset=utf-8", exportAllocationsGeo},
+	{"ipv4.txt", "text/plain; charset=utf-8", func(db *sql.DB, w io.Writer) error { return exportCIDRList(db, w, "ipv4") }},
+	{"ipv6.txt", "text/plain; charset=utf-8", func(db *sql.DB, w io.Writer) error { return exportCIDRList(db, w, "ipv6") }},
+	{"fullbogons-ipv4.txt", "text/plain; charset=utf-8", func(db *sql.DB, w io.Writer) error { return writeBogons(db, w, true, 4) }},
+	{"fullbogons-ipv6.txt", "text/plain; charset=utf-8", func(db *sql.DB, w io.Writer) error { return writeBogons(db, w, true, 6) }},
+}
+
+// latestAllocationsQuery selects the newest record of every allocation as
+// registry, cc, type, start, value, date, status. Values are host counts for IPv4,
+// prefix lengths for IPv6 and number of ASNs for ASN records.
+const latestAllocationsQuery = `
+	SELECT r.ID_Registries, r.CC, 'asn', CAST(r.ASN AS CHAR), r.ASNCount, r.RecordDate, r.State FROM Records_asn r
+		JOIN (SELECT ID_Registries, ASN, MAX(ID_Datasets) AS ID_Datasets FROM Records_asn GROUP BY ID_Registries, ASN) l
+		USING (ID_Registries, ASN, ID_Datasets)
+	UNION ALL
+	SELECT r.ID_Registries, r.CC, 'ipv4', INET_NTOA(r.FirstIP), r.HostCount, r.RecordDate, r.State FROM Records_ipv4 r
+		JOIN (SELECT ID_Registries, FirstIP, MAX(ID_Datasets) AS ID_Datasets FROM Records_ipv4 GROUP BY ID_Registries, FirstIP) l
+		USING (ID_Registries, FirstIP, ID_Datasets)
+	UNION ALL
+	SELECT r.ID_Registries, r.CC, 'ipv6', INET6_NTOA(r.FirstIP), r.PrefixLen, r.RecordDate, r.State FROM Records_ipv6 r
+		JOIN (SELECT ID_Registries, FirstIP, MAX(ID_Datasets) AS ID_Datasets FROM Records_ipv6 GROUP BY ID_Registries, FirstIP) l
+		USING (ID_Registries, FirstIP, ID_Datasets)`
+
+func exportAllocationsTSV(db *sql.DB, w io.Writer) error {
+	rows, err := db.Query(latestAllocationsQuery + " ORDER BY 1, 3, 4;")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	// With -no-asnames names is nil and the as_name column is left out
+	names, err := loadASNames(db)
+	if err != nil {
+		return err
+	}
+	// Likewise the tags column is only written when tags have been imported
+	tags, err := loadTagOverlay(db)
+	if err != nil {
+		return err
+	}
+	header := "registry\tcc\ttype\tstart\tvalue\tdate\tstatus"
+	if names != nil {
+		header += "\tas_name"
+	}
+	if tags != nil {
+		header += "\ttags"
+	}
+	fmt.Fprintln(w, header)
+	for rows.Next() {
+		var registry, cc, kind, start, date, status string
+		var value uint64
+		if err := rows.Scan(&registry, &cc, &kind, &start, &value, &date, &status); err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\t%s", registry, cc, kind, start, value, date, status)
+		if names != nil {
+			fmt.Fprintf(w, "\t%s", names[start]) // empty for prefixes
+		}
+		if tags != nil {
+			fmt.Fprintf(w, "\t%s", strings.Join(recordTags(tags, kind, start, value), ","))
+		}
+		fmt.Fprintln(w)
+	}
+	return rows.Err()
+}
+
+// exportCIDRList writes the aggregated allocated or assigned space, one prefix per line.
+func exportCIDRList(db *sql.DB, w io.Writer, kind string) error {
+	prefixes, err := allocatedPrefixes(db, kind, "", "")
+	if err != nil {
+		return err
+	}
+	for _, prefix := range aggregatePrefixes(prefixes) {
+		fmt.Fprintln(w, prefix)
+	}
+	return nil
+}
+
+// recordTags returns the tags of an allocation: those of its first ASN, or those of the
+// tagged prefixes overlapping its address range.
+func recordTags(tags *tagOverlay, kind, start string, value uint64) []string {
+	if kind == "asn" {
+		return tags.match(netip.Addr{}, netip.Addr{}, start)
+	}
+	prefixes, err := recordPrefixes(kind, start, value)
+	if err != nil || len(prefixes) == 0 {
+		return nil
+	}
+	return tags.match(prefixes[0].Addr(), lastAddr(prefixes[len(prefixes)-1]), "")
+}
+
+var exportETags = struct {
+	sync.RWMutex
+	m map[string]string
+}{m: make(map[string]string)}
+
+// exportDir returns the directory of a namespace's exports: -export-dir itself for the
+// base schema and a subdirectory named after the namespace otherwise.
+func exportDir(ns string) string {
+	return filepath.Join(*f_exportDir, ns)
+}
+
+// regenerateExports rewrites all artifacts of the process namespace in -export-dir. Each
+// file is written to a temporary name and renamed, so HTTP clients never see partial files.
+func regenerateExports(db *sql.DB) {
+	if *f_exportDir == "" {
+		return
+	}
+	dir := exportDir(*f_namespace)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		logger.Warn("Cannot create export directory", "err", err)
+		return
+	}
+	for _, e := range append(append(exporters, firewallExporters(db)...), dnsblExporters(db)...) {
+		if err := writeExport(db, dir, e); err != nil {
+			logger.Warn("Export failed", "export", e.name, "err", err)
+			continue
+		}
+		logger.Debug("Regenerated export", "export", e.name)
+	}
+}
+
+func writeExport(db *sql.DB, dir string, e exporter) error {
+	target := filepath.Join(dir, e.name)
+	file, err := os.Create(target + ".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(target + ".tmp")
+
+	hash := sha256.New()
+	w := bufio.NewWriter(io.MultiWriter(file, hash))
+	if err = e.write(db, w); err == nil {
+		err = w.Flush()
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(target+".tmp", target); err != nil {
+		return err
+	}
+
+	exportETags.Lock()
+	exportETags.m[target] = `"` + hex.EncodeToString(hash.Sum(nil))[:32] + `"`
+	exportETags.Unlock()
+	return nil
+}
+
+// exportETag returns the ETag of the export at path, hashing the file if it was generated
+// by an earlier process.
+func exportETag(path string) string {
+	exportETags.RLock()
+	etag, ok := exportETags.m[path]
+	exportETags.RUnlock()
+	if ok {
+		return etag
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return ""
+	}
+	etag = `"` + hex.EncodeToString(hash.Sum(nil))[:32] + `"`
+	exportETags.Lock()
+	exportETags.m[path] = etag
+	exportETags.Unlock()
+	return etag
+}
+
+// handleExport serves /exports/<name> of the request's namespace with ETag and
+// Last-Modified validation.
+func handleExport(_ *sql.DB, ns string, w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/exports/")
+	var e *exporter
+	for i := range exporters {
+		if exporters[i].name == name {
+			e = &exporters[i]
+		}
+	}
+	if e == nil && (strings.HasPrefix(name, "firewall-") || strings.HasPrefix(name, "dnsbl-")) && !strings.Contains(name, "/") {
+		e = &exporter{name: name, contentType: "application/json"} // Generated from a stored policy or zone
+		if strings.HasSuffix(name, ".nft") || strings.HasSuffix(name, ".rbldnsd") {
+			e.contentType = "text/plain; charset=utf-8"
+		}
+	}
+	if e == nil {
+		http.NotFound(w, r)
+		return
+	}
+	path := filepath.Join(exportDir(ns), name)
+	file, err := os.Open(path)
+	if err != nil {
+		http.Error(w, "export not generated yet", http.StatusServiceUnavailable)
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		http.Error(w, "export unavailable", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", e.contentType)
+	if etag := exportETag(path); etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+	http.ServeContent(w, r, name, info.ModTime(), file)
+}
+
+// exportRow is a line of the flat export: an IPv4 or IPv6 prefix of a delegation with
+// the origin of its covering BGP route, or an ASN delegation.
+type exportRow struct {
+	Registry string `json:"registry"`
+	CC       string `json:"cc"`
+	Type     string `json:"type"`
+	Prefix   string `json:"prefix,omitempty"`
+	Start    string `json:"start"`
+	Value    uint64 `json:"value"`
+	ASN      string `json:"asn,omitempty"`
+	ASName   string `json:"as_name,omitempty"`
+	Date     string `json:"date,omitempty"` // of the delegation
+	Seen     string `json:"seen,omitempty"` // end date of the newest dataset listing it
+	Status   string `json:"status"`
+	Holder   string `json:"holder,omitempty"`
+	RPKI     string `json:"rpki,omitempty"` // validity of the covering route, when VRPs are imported
+}
+
+// exportCommand implements "export [-format csv|tsv|jsonl|mmdb] [-output FILE] [-registry R]
+// [-type T] [-cc CC] [-as-of DATE]", writing the current delegations, or those of a past
+// date, for use outside the database.
+func exportCommand(db *sql.DB, args []string) {
+	usage := "Usage: export [-format csv|tsv|jsonl|mmdb] [-output FILE] [-registry R] [-type asn|ipv4|ipv6] [-cc CC] [-as-of DATE]"
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	format := fs.String("format", "csv", "Output format: csv, tsv, jsonl or mmdb (MaxMind DB with country and ASN per network)")
+	output := fs.String("output", "-", "File to write; - writes standard output, except for mmdb")
+	registry := fs.String("registry", "", "Only delegations of this registry")
+	kind := fs.String("type", "", "Only delegations of this record type: asn, ipv4 or ipv6")
+	cc := fs.String("cc", "", "Only delegations of this country code")
+	asOf := fs.String("as-of", "", "Export the records listed on this date (YYYYMMDD or YYYY-MM-DD) instead of the latest; BGP origins are left out")
+	fs.Parse(args)
+	if *kind != "" && *kind != "asn" && *kind != "ipv4" && *kind != "ipv6" {
+		log.Fatal(usage)
+	}
+	if *asOf != "" {
+		date, err := parseAsOf(*asOf)
+		if err != nil {
+			log.Fatal(err)
+		}
+		*asOf = date
+	}
+
+	var write func(w io.Writer) error
+	switch *format {
+	case "csv", "tsv", "jsonl":
+		write = func(w io.Writer) error {
+			return exportFlat(db, w, *format, *registry, *kind, strings.ToUpper(*cc), *asOf)
+		}
+	case "mmdb":
+		if *output == "-" {
+			log.Fatal("export -format mmdb needs -output FILE")
+		}
+		if *registry != "" || *kind != "" || *cc != "" || *asOf != "" {
+			log.Fatal("export -format mmdb always contains all current delegations")
+		}
+		write = func(w io.Writer) error { return exportMMDB(db, w) }
+	default:
+		log.Fatal(usage)
+	}
+
+	if *output == "-" {
+		w := bufio.NewWriter(os.Stdout)
+		err := write(w)
+		if err == nil {
+			err = w.Flush()
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// Like the -export-dir artifacts, the file only appears once complete
+	file, err := os.Create(*output + ".tmp")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.Remove(*output + ".tmp")
+	w := bufio.NewWriter(file)
+	if err = write(w); err == nil {
+		err = w.Flush()
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(*output+".tmp", *output)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	logger.Info("Exported", "file", *output)
+}
+
+// exportFlat writes the delegations of any status matching the filters as CSV, TSV or
+// JSON Lines: the current ones, or those listed on the date asOf (YYYY-MM-DD). IPv4 ranges
+// that are not a single CIDR prefix give a row per prefix.
+func exportFlat(db *sql.DB, w io.Writer, format, registry, kind, cc, asOf string) error {
+	var routes prefixTrie
+	var origins []string
+	var vrps *vrpTable
+	var err error
+	if asOf == "" { // Routes are only known for the present
+		if origins, err = loadRoutes(db, &routes); err != nil {
+			return err
+		}
+		if vrps, err = loadVRPTable(db); err != nil {
+			return err
+		}
+	}
+	names, err := loadASNames(db)
+	if err != nil {
+		return err
+	}
+	query := `SELECT l.ID_Registries, l.CC, l.RecordType, l.Start, l.Value, IFNULL(l.RecordDate, ''), IFNULL(d.enddate, ''),
+		l.State, l.Holder FROM LatestAllocations l LEFT JOIN Datasets d ON d.ID = l.ID_Datasets`
+	params := []interface{}{}
+	if asOf != "" {
+		query = "SELECT * FROM (" + allocationsAsOfQuery + ") l (ID_Registries, CC, RecordType, Start, Value, RecordDate, seen, State, Holder)"
+		params = append(params, asOf, asOf, asOf)
+	}
+	rows, err := db.Query(query+`
+		WHERE (? = '' OR l.ID_Registries = ?) AND (? = '' OR l.RecordType = ?) AND (? = '' OR l.CC = ?)
+		ORDER BY l.ID_Registries, l.RecordType, l.Start;`, append(params, registry, registry, kind, kind, cc, cc)...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var enc *json.Encoder
+	var cw *csv.Writer
+	if format == "jsonl" {
+		enc = json.NewEncoder(w)
+	} else {
+		cw = csv.NewWriter(w)
+		if format == "tsv" {
+			cw.Comma = '\t'
+		}
+		cw.Write([]string{"registry", "cc", "type", "prefix", "start", "value", "asn", "as_name", "date", "seen", "status", "holder", "rpki"})
+	}
+	emit := func(r exportRow) error {
+		if enc != nil {
+			return enc.Encode(r)
+		}
+		return cw.Write([]string{r.Registry, r.CC, r.Type, r.Prefix, r.Start, strconv.FormatUint(r.Value, 10), r.ASN, r.ASName,
+			r.Date, r.Seen, r.Status, r.Holder, r.RPKI})
+	}
+
+	var n int
+	for rows.Next() {
+		var r exportRow
+		if err := rows.Scan(&r.Registry, &r.CC, &r.Type, &r.Start, &r.Value, &r.Date, &r.Seen, &r.Status, &r.Holder); err != nil {
+			return err
+		}
+		r.Holder = holder(r.Holder) // Records stored before the opaque ID was split off
+		if r.Type == "asn" {
+			r.ASN, r.ASName = r.Start, names[r.Start]
+			if err := emit(r); err != nil {
+				return err
+			}
+			n++
+			continue
+		}
+		prefixes, err := recordPrefixes(r.Type, r.Start, r.Value)
+		if err != nil {
+			logger.Log(context.Background(), levelTrace, "Skipping resource", "type", r.Type, "start", r.Start,
+				"value", r.Value, "err", err)
+			continue
+		}
+		for _, p := range prefixes {
+			row := r
+			row.Prefix = p.String()
+			if i, route, ok := routes.covering(p); ok {
+				row.ASN = origins[i]
+				row.ASName = names[strings.SplitN(row.ASN, "_", 2)[0]]
+				row.RPKI = vrps.status(route, row.ASN)
+			}
+			if err := emit(row); err != nil {
+				return err
+			}
+			n++
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if cw != nil {
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			return err
+		}
+	}
+	logger.Debug("Exported rows", "rows", n)
+	return nil
+}
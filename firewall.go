@@ -0,0 +1,347 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/netip"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// policyRule is one clause of a firewall policy, e.g. "deny AS12345" or "allow country DE".
+type policyRule struct {
+	Action string // allow or deny
+	Kind   string // asn, cc, prefix, bogons or fullbogons
+	Value  string
+}
+
+// firewallTargets maps output targets to their file extension.
+var firewallTargets = map[string]string{"nftables": "nft", "k8s": "json", "aws": "json"}
+
+var policyNameRegexp = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,62})$`)
+
+// firewallCommand implements "firewall generate -target T SPEC", printing the rules of a
+// policy, and "firewall add -target T NAME SPEC", "firewall list" and "firewall remove
+// NAME" for stored policies, which are regenerated into -export-dir after every import
+// as firewall-NAME.nft or .json. A SPEC is a list of clauses separated by commas or new
+// lines, e.g. "deny AS12345, allow country DE", or @FILE to read them from a file.
+func firewallCommand(db *sql.DB, args []string) {
+	usage := "Usage: firewall generate -target nftables|k8s|aws SPEC | firewall add -target T NAME SPEC | firewall list | firewall remove NAME"
+	if len(args) == 0 {
+		log.Fatal(usage)
+	}
+	fs := flag.NewFlagSet("firewall "+args[0], flag.ExitOnError)
+	target := fs.String("target", "nftables", "Output: nftables, k8s (NetworkPolicy) or aws (security group IpPermissions)")
+	fs.Parse(args[1:])
+	if _, ok := firewallTargets[*target]; !ok {
+		log.Fatal("Invalid -target; use nftables, k8s or aws")
+	}
+
+	switch args[0] {
+	case "generate":
+		if fs.NArg() != 1 {
+			log.Fatal(usage)
+		}
+		rules, err := parsePolicy(readPolicySpec(fs.Arg(0)))
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := writeFirewall(db, os.Stdout, "ip2asn", *target, rules); err != nil {
+			log.Fatal(err)
+		}
+	case "add":
+		if fs.NArg() != 2 || !policyNameRegexp.MatchString(fs.Arg(0)) {
+			log.Fatal(usage + " (names are lower case letters, digits and dashes)")
+		}
+		spec := readPolicySpec(fs.Arg(1))
+		if _, err := parsePolicy(spec); err != nil {
+			log.Fatal(err)
+		}
+		if _, err := db.Exec("REPLACE INTO FirewallPolicies VALUES (?, ?, ?, NOW());", fs.Arg(0), *target, spec); err != nil {
+			log.Fatal(err)
+		}
+		auditLog(db, "firewall.add", fs.Arg(0), 0)
+		regenerateExports(db)
+	case "list":
+		rows, err := db.Query("SELECT Name, Target, Spec, Updated FROM FirewallPolicies ORDER BY Name;")
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer rows.Close()
+		var table [][]string
+		for rows.Next() {
+			var name, t, spec, updated string
+			if err := rows.Scan(&name, &t, &spec, &updated); err != nil {
+				log.Fatal(err)
+			}
+			table = append(table, []string{name, t, strings.Join(strings.Fields(spec), " "), updated})
+		}
+		writeReport("table", []string{"name", "target", "spec", "updated"}, table, nil)
+	case "remove":
+		if fs.NArg() != 1 {
+			log.Fatal(usage)
+		}
+		if _, err := db.Exec("DELETE FROM FirewallPolicies WHERE Name = ?;", fs.Arg(0)); err != nil {
+			log.Fatal(err)
+		}
+		auditLog(db, "firewall.remove", fs.Arg(0), 0)
+	default:
+		log.Fatal(usage)
+	}
+}
+
+func readPolicySpec(arg string) string {
+	if !strings.HasPrefix(arg, "@") {
+		return arg
+	}
+	data, err := ioutil.ReadFile(arg[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+	return string(data)
+}
+
+// parsePolicy reads clauses of the form ACTION SELECTOR, where ACTION is allow or deny
+// and SELECTOR is ASxxx, asn N, country CC, prefix P, a bare prefix, bogons or fullbogons.
+func parsePolicy(spec string) ([]policyRule, error) {
+	var rules []policyRule
+	for _, clause := range strings.FieldsFunc(spec, func(c rune) bool { return c == ',' || c == '\n' || c == ';' }) {
+		if i := strings.IndexByte(clause, '#'); i >= 0 {
+			clause = clause[:i]
+		}
+		fields := strings.Fields(strings.ToLower(clause))
+		if len(fields) == 0 {
+			continue
+		}
+		if fields[0] != "allow" && fields[0] != "deny" || len(fields) < 2 || len(fields) > 3 {
+			return nil, fmt.Errorf("invalid policy clause: %q", strings.TrimSpace(clause))
+		}
+		r := policyRule{Action: fields[0]}
+		selector := fields[1:]
+		switch {
+		case len(selector) == 1 && (selector[0] == "bogons" || selector[0] == "fullbogons"):
+			r.Kind = selector[0]
+		case len(selector) == 1 && strings.HasPrefix(selector[0], "as"):
+			r.Kind, r.Value = "asn", strings.TrimPrefix(selector[0], "as")
+		case len(selector) == 1:
+			r.Kind, r.Value = "prefix", selector[0]
+		case selector[0] == "as" || selector[0] == "asn":
+			r.Kind, r.Value = "asn", strings.TrimPrefix(selector[1], "as")
+		case selector[0] == "country" || selector[0] == "cc":
+			r.Kind, r.Value = "cc", strings.ToUpper(selector[1])
+		case selector[0] == "prefix":
+			r.Kind, r.Value = "prefix", selector[1]
+		default:
+			return nil, fmt.Errorf("invalid policy selector: %q", strings.Join(selector, " "))
+		}
+		switch r.Kind {
+		case "asn":
+			if _, err := strconv.ParseUint(r.Value, 10, 32); err != nil {
+				return nil, fmt.Errorf("invalid ASN: %q", r.Value)
+			}
+		case "cc":
+			if len(r.Value) != 2 {
+				return nil, fmt.Errorf("invalid country code: %q", r.Value)
+			}
+		case "prefix":
+			if _, err := netip.ParsePrefix(r.Value); err != nil {
+				return nil, fmt.Errorf("invalid prefix: %q", r.Value)
+			}
+		}
+		rules = append(rules, r)
+	}
+	if len(rules) == 0 {
+		return nil, fmt.Errorf("empty policy")
+	}
+	return rules, nil
+}
+
+// policyPrefixes resolves a rule: the BGP routes originated by an AS, the current
+// delegations of a country, a literal prefix or the (full) bogons.
+func policyPrefixes(db *sql.DB, r policyRule) ([]netip.Prefix, error) {
+	switch r.Kind {
+	case "asn":
+		rows, err := db.Query(`SELECT Prefix FROM BgpPrefixes WHERE OriginAS = ? OR FIND_IN_SET(?, REPLACE(OriginAS, '_', ','));`,
+			r.Value, r.Value)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		var list []netip.Prefix
+		for rows.Next() {
+			var prefix string
+			if err := rows.Scan(&prefix); err != nil {
+				return nil, err
+			}
+			if p, err := netip.ParsePrefix(prefix); err == nil {
+				list = append(list, p)
+			}
+		}
+		return list, rows.Err()
+	case "cc":
+		v4, err := allocatedPrefixes(db, "ipv4", "", r.Value)
+		if err != nil {
+			return nil, err
+		}
+		v6, err := allocatedPrefixes(db, "ipv6", "", r.Value)
+		return append(v4, v6...), err
+	case "bogons", "fullbogons":
+		return bogonPrefixes(db, r.Kind == "fullbogons", 0)
+	default:
+		return []netip.Prefix{netip.MustParsePrefix(r.Value).Masked()}, nil
+	}
+}
+
+// resolvePolicy returns the allowed and denied prefixes; deny wins where both match.
+func resolvePolicy(db *sql.DB, rules []policyRule) (allow, deny []netip.Prefix, err error) {
+	for _, r := range rules {
+		list, err := policyPrefixes(db, r)
+		if err != nil {
+			return nil, nil, fmt.Errorf("resolving %s %s %s: %w", r.Action, r.Kind, r.Value, err)
+		}
+		if len(list) == 0 {
+			logger.Warn("Rule matches no prefixes", "action", r.Action, "kind", r.Kind, "value", r.Value)
+		}
+		if r.Action == "allow" {
+			allow = append(allow, list...)
+		} else {
+			deny = append(deny, list...)
+		}
+	}
+	deny = aggregatePrefixes(deny)
+	return subtractPrefixes(allow, deny), deny, nil
+}
+
+// writeFirewall writes the rules of a policy for a target. With allow clauses only the
+// allowed sources are accepted; without, everything but the denied sources is.
+func writeFirewall(db *sql.DB, w io.Writer, name, target string, rules []policyRule) error {
+	allow, deny, err := resolvePolicy(db, rules)
+	if err != nil {
+		return err
+	}
+	allowlist := false // Decided by the clauses, as denies may leave nothing allowed
+	for _, r := range rules {
+		allowlist = allowlist || r.Action == "allow"
+	}
+	ofFamily := func(list []netip.Prefix, is4 bool) []string {
+		out := []string{}
+		for _, p := range list {
+			if p.Addr().Is4() == is4 {
+				out = append(out, p.String())
+			}
+		}
+		return out
+	}
+
+	switch target {
+	case "nftables":
+		fmt.Fprintf(w, "# Generated by ip2asn from policy %s\ntable inet ip2asn_%s {\n", name, strings.ReplaceAll(name, "-", "_"))
+		for _, set := range []struct {
+			name, kind string
+			list       []string
+		}{{"deny_v4", "ipv4_addr", ofFamily(deny, true)}, {"deny_v6", "ipv6_addr", ofFamily(deny, false)},
+			{"allow_v4", "ipv4_addr", ofFamily(allow, true)}, {"allow_v6", "ipv6_addr", ofFamily(allow, false)}} {
+			fmt.Fprintf(w, "\tset %s {\n\t\ttype %s\n\t\tflags interval\n", set.name, set.kind)
+			if len(set.list) > 0 {
+				fmt.Fprintf(w, "\t\telements = {\n\t\t\t%s\n\t\t}\n", strings.Join(set.list, ",\n\t\t\t"))
+			}
+			fmt.Fprint(w, "\t}\n")
+		}
+		fmt.Fprint(w, "\tchain input {\n\t\ttype filter hook input priority -10; policy accept;\n")
+		fmt.Fprint(w, "\t\tip saddr @deny_v4 drop\n\t\tip6 saddr @deny_v6 drop\n")
+		if allowlist {
+			fmt.Fprint(w, "\t\tct state established,related accept\n\t\tiif lo accept\n")
+			fmt.Fprint(w, "\t\tip saddr @allow_v4 accept\n\t\tip6 saddr @allow_v6 accept\n\t\tdrop\n")
+		}
+		fmt.Fprint(w, "\t}\n}\n")
+		return nil
+
+	case "k8s":
+		type ipBlock struct {
+			CIDR   string   `json:"cidr"`
+			Except []string `json:"except,omitempty"`
+		}
+		from := []map[string]ipBlock{}
+		if allowlist {
+			for _, p := range allow {
+				from = append(from, map[string]ipBlock{"ipBlock": {CIDR: p.String()}})
+			}
+		} else {
+			from = append(from, map[string]ipBlock{"ipBlock": {CIDR: "0.0.0.0/0", Except: ofFamily(deny, true)}},
+				map[string]ipBlock{"ipBlock": {CIDR: "::/0", Except: ofFamily(deny, false)}})
+		}
+		policy := map[string]interface{}{
+			"apiVersion": "networking.k8s.io/v1",
+			"kind":       "NetworkPolicy",
+			"metadata":   map[string]interface{}{"name": "ip2asn-" + name, "labels": map[string]string{"app.kubernetes.io/managed-by": "ip2asn"}},
+			"spec": map[string]interface{}{
+				"podSelector": map[string]interface{}{},
+				"policyTypes": []string{"Ingress"},
+				"ingress":     []map[string]interface{}{{"from": from}},
+			},
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(policy)
+
+	case "aws":
+		// Security groups can only allow, so a deny-only policy allows the complement
+		if !allowlist {
+			allow = subtractPrefixes(allAddresses, deny)
+		}
+		var v4Ranges, v6Ranges []map[string]string
+		for _, p := range ofFamily(allow, true) {
+			v4Ranges = append(v4Ranges, map[string]string{"CidrIp": p, "Description": "ip2asn " + name})
+		}
+		for _, p := range ofFamily(allow, false) {
+			v6Ranges = append(v6Ranges, map[string]string{"CidrIpv6": p, "Description": "ip2asn " + name})
+		}
+		if len(allow) > 1000 {
+			logger.Warn("Policy has more prefixes than a security group allows", "policy", name, "prefixes", len(allow))
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(map[string]interface{}{"IpPermissions": []map[string]interface{}{
+			{"IpProtocol": "-1", "IpRanges": v4Ranges, "Ipv6Ranges": v6Ranges}}})
+	}
+	return fmt.Errorf("unknown target %s", target)
+}
+
+// firewallExporters returns an exporter per stored policy for regenerateExports.
+func firewallExporters(db *sql.DB) []exporter {
+	rows, err := db.Query("SELECT Name, Target, Spec FROM FirewallPolicies ORDER BY Name;")
+	if err != nil {
+		if !isMissingTable(err) {
+			logger.Warn("Cannot read firewall policies", "err", err)
+		}
+		return nil
+	}
+	defer rows.Close()
+	var list []exporter
+	for rows.Next() {
+		var name, target, spec string
+		if err := rows.Scan(&name, &target, &spec); err != nil {
+			return list
+		}
+		contentType := "application/json"
+		if target == "nftables" {
+			contentType = "text/plain; charset=utf-8"
+		}
+		list = append(list, exporter{"firewall-" + name + "." + firewallTargets[target], contentType,
+			func(db *sql.DB, w io.Writer) error {
+				rules, err := parsePolicy(spec)
+				if err != nil {
+					return err
+				}
+				return writeFirewall(db, w, name, target, rules)
+			}})
+	}
+	return list
+}
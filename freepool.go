@@ -0,0 +1,131 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// spaceTotal counts the records of one type and status in a dataset and the
+// space they cover: IPv4 addresses, IPv6 /48s or ASNs.
+type spaceTotal struct {
+	Blocks uint64
+	Size   uint64
+}
+
+// spaceTotals are keyed by "type|status".
+type spaceTotals map[string]*spaceTotal
+
+func (t spaceTotals) add(kind, status string, value uint64) {
+	s := t[kind+"|"+status]
+	if s == nil {
+		s = &spaceTotal{}
+		t[kind+"|"+status] = s
+	}
+	s.Blocks++
+	if kind == "ipv6" {
+		s.Size += slash48s(value)
+	} else {
+		s.Size += value
+	}
+}
+
+// saveDatasetTotals stores the per type and status totals of an imported dataset.
+func saveDatasetTotals(tx *sql.Tx, dataset int64, totals spaceTotals) error {
+	stmt, err := tx.Prepare("REPLACE INTO DatasetTotals VALUES (?, ?, ?, ?, ?);")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for key, t := range totals {
+		parts := strings.SplitN(key, "|", 2)
+		if _, err := stmt.Exec(dataset, parts[0], parts[1], t.Blocks, t.Size); err != nil {
+			return fmt.Errorf("saving totals: %w", err)
+		}
+	}
+	return nil
+}
+
+// freePoolPoint is the unallocated IPv4 space of a registry in one dataset.
+type freePoolPoint struct {
+	Registry        string `json:"registry"`
+	Date            string `json:"date"`
+	Serial          uint64 `json:"serial"`
+	Available       uint64 `json:"available"`
+	Reserved        uint64 `json:"reserved"`
+	AvailableBlocks uint64 `json:"available_blocks"`
+	ReservedBlocks  uint64 `json:"reserved_blocks"`
+}
+
+// freePool returns the IPv4 free pool per dataset, oldest first. Empty
+// arguments select all registries and dates.
+func freePool(db *sql.DB, registry, since string) ([]freePoolPoint, error) {
+	rows, err := db.Query(`SELECT d.ID_Registries, IFNULL(d.enddate, ''), d.serial,
+		SUM(IF(t.State = 'available', t.Size, 0)), SUM(IF(t.State = 'reserved', t.Size, 0)),
+		SUM(IF(t.State = 'available', t.Blocks, 0)), SUM(IF(t.State = 'reserved', t.Blocks, 0))
+		FROM Datasets d JOIN DatasetTotals t ON t.ID_Datasets = d.ID
+		WHERE t.RecordType = 'ipv4' AND (? = '' OR d.ID_Registries = ?) AND (? = '' OR d.enddate >= ?)
+		GROUP BY d.ID ORDER BY d.ID_Registries, d.serial;`, registry, registry, since, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []freePoolPoint{}
+	for rows.Next() {
+		var p freePoolPoint
+		if err := rows.Scan(&p.Registry, &p.Date, &p.Serial, &p.Available, &p.Reserved, &p.AvailableBlocks, &p.ReservedBlocks); err != nil {
+			return nil, err
+		}
+		list = append(list, p)
+	}
+	return list, rows.Err()
+}
+
+// freePoolCommand prints the IPv4 free pool over time.
+func freePoolCommand(db *sql.DB, args []string) {
+	fs := flag.NewFlagSet("freepool", flag.ExitOnError)
+	registry := fs.String("registry", "", "Only this registry")
+	since := fs.String("since", "", "Only datasets on or after this date (YYYY-MM-DD)")
+	format := fs.String("format", "table", "Output format: table, csv or json")
+	fs.Parse(args)
+	if *since != "" {
+		if _, err := time.Parse("2006-01-02", *since); err != nil {
+			log.Fatal("Invalid date: " + *since)
+		}
+	}
+
+	list, err := freePool(db, *registry, *since)
+	if err != nil {
+		log.Fatal(err)
+	}
+	rows := make([][]string, 0, len(list))
+	for _, p := range list {
+		rows = append(rows, []string{p.Registry, p.Date, fmt.Sprint(p.Serial), fmt.Sprint(p.Available),
+			fmt.Sprint(p.AvailableBlocks), fmt.Sprint(p.Reserved), fmt.Sprint(p.ReservedBlocks)})
+	}
+	writeReport(*format, []string{"registry", "date", "serial", "available", "available_blocks", "reserved", "reserved_blocks"}, rows, list)
+}
+
+// handleFreePool serves the IPv4 free pool over time; accepts registry and since parameters.
+func handleFreePool(db *sql.DB, ns string, w http.ResponseWriter, r *http.Request) {
+	since := r.URL.Query().Get("since")
+	if since != "" {
+		if _, err := time.Parse("2006-01-02", since); err != nil {
+			http.Error(w, "invalid since date", http.StatusBadRequest)
+			return
+		}
+	}
+	list, err := freePool(db, r.URL.Query().Get("registry"), since)
+	if err != nil {
+		http.Error(w, "cannot query free pool", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"namespace": ns, "ipv4_free_pool": list})
+}
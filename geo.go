@@ -0,0 +1,362 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/netip"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// geoField is an enriched value with its provenance: "rir" for the delegated
+// files, otherwise "geo:" and the name of the imported geo dataset.
+type geoField struct {
+	Value  string `json:"value"`
+	Source string `json:"source"`
+}
+
+// geoCity is a row of GeoCities.
+type geoCity struct {
+	Start, End netip.Addr
+	CC         string
+	Region     string
+	City       string
+	Source     string
+}
+
+// geoCommand implements "geo import [-source NAME] [-locations FILE] FILE" and "geo lookup ADDRESS".
+// Imports accept DB-IP style range files (start,end,continent,country,region,city,...) or
+// MaxMind GeoLite2 City block files together with their -locations file.
+func geoCommand(db *sql.DB, args []string) {
+	usage := "Usage: geo import [-source NAME] [-locations FILE] FILE | geo lookup ADDRESS"
+	if len(args) == 0 {
+		log.Fatal(usage)
+	}
+	switch args[0] {
+	case "import":
+		fs := flag.NewFlagSet("geo import", flag.ExitOnError)
+		source := fs.String("source", "city", "Name of the dataset, recorded as provenance")
+		locations := fs.String("locations", "", "MaxMind locations file for a MaxMind blocks file")
+		fs.Parse(args[1:])
+		if fs.NArg() != 1 {
+			log.Fatal(usage)
+		}
+		if err := importGeo(db, *source, fs.Arg(0), *locations); err != nil {
+			log.Fatal(err)
+		}
+	case "lookup":
+		if len(args) != 2 {
+			log.Fatal(usage)
+		}
+		addr, err := netip.ParseAddr(args[1])
+		if err != nil {
+			log.Fatal("Invalid address: " + args[1])
+		}
+		out := map[string]interface{}{"address": addr.String()}
+		if cc, registry, opaqueID, err := rirRecord(db, addr); err == nil {
+			out["registry"] = geoField{registry, "rir"}
+			out["country"] = geoField{cc, "rir"}
+			if c, err := holderAbuseContact(db, registry, opaqueID); err == nil && c.Email != "" {
+				out["abuse"] = c
+			}
+		}
+		if route, err := routeValidity(db, addr.String()); err == nil {
+			for k, v := range route {
+				out[k] = v // route, origin_as and, with data imported, rpki and irr
+			}
+			if asn, err := strconv.ParseUint(strings.SplitN(route["origin_as"], "_", 2)[0], 10, 32); err == nil {
+				if upstreams, err := asNeighbors(db, asn, "providers"); err == nil && len(upstreams) > 0 {
+					out["upstreams"] = upstreams
+				}
+			}
+		}
+		if status, block, err := bogonStatus(db, addr); err == nil && status != "" {
+			out["bogon"] = status // bogon or unallocated
+			if block.Prefix != "" {
+				out["special_purpose"] = block
+			}
+		}
+		origin, _ := out["origin_as"].(string)
+		if tags, err := tagsAt(db, addr, origin); err == nil && len(tags) > 0 {
+			out["tags"] = tags
+		}
+		if s, err := rdnsSuffixAt(db, addr); err == nil && s.Suffix != "" {
+			out["rdns_suffix"] = s.Suffix
+		}
+		if list, err := overlapsAt(db, addr); err == nil && len(list) > 0 {
+			out["overlaps"] = list // Listed by more than one registry
+		}
+		if g, err := geoLookup(db, addr); err == nil {
+			if _, ok := out["country"]; !ok {
+				out["country"] = geoField{g.CC, "geo:" + g.Source}
+			}
+			out["region"] = geoField{g.Region, "geo:" + g.Source}
+			out["city"] = geoField{g.City, "geo:" + g.Source}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(out)
+	default:
+		log.Fatal(usage)
+	}
+}
+
+// importGeo replaces the rows of a geo dataset.
+func importGeo(db *sql.DB, source, file, locationsFile string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r := csv.NewReader(bufio.NewReader(f))
+	r.FieldsPerRecord = -1
+	r.ReuseRecord = true
+
+	// MaxMind block files start with a header and refer to locations by geoname_id
+	var locations map[string][3]string
+	first, err := r.Read()
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", file, err)
+	}
+	maxmind := len(first) > 1 && first[0] == "network"
+	if maxmind {
+		if locationsFile == "" {
+			return fmt.Errorf("%s is a MaxMind blocks file; -locations is required", file)
+		}
+		if locations, err = readMaxMindLocations(locationsFile); err != nil {
+			return err
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM GeoCities WHERE Source = ?;", source); err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare("INSERT INTO GeoCities VALUES (?, ?, ?, ?, ?, ?, ?);")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	var n, skipped int
+	for rec := first; err == nil; rec, err = r.Read() {
+		var g geoCity
+		var ok bool
+		if maxmind {
+			g, ok = parseMaxMindBlock(rec, locations)
+		} else {
+			g, ok = parseGeoRange(rec)
+		}
+		if !ok {
+			skipped++
+			continue
+		}
+		family := 4
+		if g.Start.Is6() {
+			family = 6
+		}
+		if _, err := stmt.Exec(family, g.Start.AsSlice(), g.End.AsSlice(), g.CC, g.Region, g.City, source); err != nil {
+			return err
+		}
+		if n++; n%100000 == 0 {
+			logger.Debug("Importing geo ranges", "count", n)
+		}
+	}
+	if err != io.EOF {
+		return fmt.Errorf("reading %s: %w", file, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	auditLog(db, "geo", source, 0)
+	logger.Info("Imported geo ranges", "count", n, "source", source, "skipped", skipped)
+	return nil
+}
+
+// parseGeoRange parses a DB-IP style line: start,end,continent,country,region,city[,lat,lon].
+func parseGeoRange(rec []string) (geoCity, bool) {
+	if len(rec) < 6 {
+		return geoCity{}, false
+	}
+	start, err1 := netip.ParseAddr(rec[0])
+	end, err2 := netip.ParseAddr(rec[1])
+	if err1 != nil || err2 != nil || start.Is4() != end.Is4() {
+		return geoCity{}, false
+	}
+	return geoCity{Start: start, End: end, CC: countryCode(rec[3]), Region: rec[4], City: rec[5]}, true
+}
+
+// parseMaxMindBlock parses a GeoLite2 City blocks line: network,geoname_id,...
+func parseMaxMindBlock(rec []string, locations map[string][3]string) (geoCity, bool) {
+	if len(rec) < 2 {
+		return geoCity{}, false
+	}
+	p, err := netip.ParsePrefix(rec[0])
+	if err != nil {
+		return geoCity{}, false
+	}
+	loc := locations[rec[1]]
+	return geoCity{Start: p.Masked().Addr(), End: lastAddr(p), CC: countryCode(loc[0]), Region: loc[1], City: loc[2]}, true
+}
+
+// readMaxMindLocations maps geoname_id to country, first subdivision and city.
+func readMaxMindLocations(file string) (map[string][3]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	records, err := csv.NewReader(bufio.NewReader(f)).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", file, err)
+	}
+	locations := map[string][3]string{}
+	for _, rec := range records {
+		if len(rec) > 10 {
+			locations[rec[0]] = [3]string{rec[4], rec[7], rec[10]}
+		}
+	}
+	return locations, nil
+}
+
+func countryCode(cc string) string {
+	if len(cc) != 2 {
+		return ""
+	}
+	return strings.ToUpper(cc)
+}
+
+// geoLookup returns the geo range containing an address.
+func geoLookup(db *sql.DB, addr netip.Addr) (geoCity, error) {
+	family := 4
+	if addr.Is6() {
+		family = 6
+	}
+	var g geoCity
+	var start, end []byte
+	err := db.QueryRow(`SELECT StartIP, EndIP, CC, Region, City, Source FROM GeoCities
+		WHERE Family = ? AND StartIP <= ? ORDER BY StartIP DESC LIMIT 1;`, family, addr.AsSlice()).Scan(
+		&start, &end, &g.CC, &g.Region, &g.City, &g.Source)
+	if err != nil {
+		return g, err
+	}
+	g.Start, _ = netip.AddrFromSlice(start)
+	g.End, _ = netip.AddrFromSlice(end)
+	if g.End.Compare(addr) < 0 {
+		return g, sql.ErrNoRows
+	}
+	return g, nil
+}
+
+// rirRecord returns the country code, registry and opaque ID of the newest allocation containing an address.
+func rirRecord(db *sql.DB, addr netip.Addr) (cc, registry, opaqueID string, err error) {
+	var opaque sql.NullString
+	if addr.Is4() {
+		err = db.QueryRow(`SELECT CC, ID_Registries, OpaqueID FROM Records_ipv4
+			WHERE FirstIP <= INET_ATON(?) AND LastIP >= INET_ATON(?) AND State IN ('allocated', 'assigned')
+			ORDER BY ID_LastDatasets DESC, FirstIP DESC LIMIT 1;`, addr.String(), addr.String()).Scan(&cc, &registry, &opaque)
+		return cc, registry, holder(opaque.String), err
+	}
+	err = db.QueryRow(`SELECT CC, ID_Registries, OpaqueID FROM Records_ipv6
+		WHERE FirstIP <= INET6_ATON(?) AND LastIP >= INET6_ATON(?) AND State IN ('allocated', 'assigned')
+		ORDER BY ID_LastDatasets DESC, FirstIP DESC, PrefixLen DESC LIMIT 1;`, addr.String(), addr.String()).Scan(&cc, &registry, &opaque)
+	return cc, registry, holder(opaque.String), err
+}
+
+// exportAllocationsGeo writes allocations.tsv with the region and city of each block's
+// start address from the geo datasets. The country code column is the registry's; the
+// geo_ columns come from the dataset named in geo_source.
+func exportAllocationsGeo(db *sql.DB, w io.Writer) error {
+	type alloc struct {
+		addr                                    netip.Addr
+		registry, cc, kind, start, date, status string
+		value                                   uint64
+	}
+	rows, err := db.Query("SELECT * FROM (" + latestAllocationsQuery + ") a (registry, cc, kind, start, value, date, status) WHERE kind <> 'asn';")
+	if err != nil {
+		return err
+	}
+	var allocs []alloc
+	for rows.Next() {
+		var a alloc
+		if err := rows.Scan(&a.registry, &a.cc, &a.kind, &a.start, &a.value, &a.date, &a.status); err != nil {
+			rows.Close()
+			return err
+		}
+		if a.addr, err = netip.ParseAddr(a.start); err == nil {
+			allocs = append(allocs, a)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	sort.Slice(allocs, func(i, j int) bool { return allocs[i].addr.Less(allocs[j].addr) })
+
+	// Merge join with the geo ranges, which are ordered the same way
+	geoRows, err := db.Query("SELECT StartIP, EndIP, CC, Region, City, Source FROM GeoCities ORDER BY Family, StartIP;")
+	if isMissingTable(err) {
+		geoRows = nil // No geo data; the geo columns stay empty
+	} else if err != nil {
+		return err
+	} else {
+		defer geoRows.Close()
+	}
+	var g geoCity
+	next := func() bool {
+		if geoRows == nil || !geoRows.Next() {
+			g = geoCity{}
+			return false
+		}
+		var start, end []byte
+		if err := geoRows.Scan(&start, &end, &g.CC, &g.Region, &g.City, &g.Source); err != nil {
+			g = geoCity{}
+			return false
+		}
+		g.Start, _ = netip.AddrFromSlice(start)
+		g.End, _ = netip.AddrFromSlice(end)
+		return true
+	}
+	more := next()
+
+	tags, err := loadTagOverlay(db)
+	if err != nil {
+		return err
+	}
+	header := "registry\tcc\ttype\tstart\tvalue\tdate\tstatus\tgeo_cc\tgeo_region\tgeo_city\tgeo_source"
+	if tags != nil {
+		header += "\ttags"
+	}
+	fmt.Fprintln(w, header)
+	for _, a := range allocs {
+		for more && g.End.Less(a.addr) {
+			more = next()
+		}
+		var geoCC, region, city, source string
+		if more && g.Start.Compare(a.addr) <= 0 {
+			geoCC, region, city, source = g.CC, g.Region, g.City, g.Source
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\t%s\t%s\t%s\t%s\t%s", a.registry, a.cc, a.kind, a.start, a.value,
+			a.date, a.status, geoCC, region, city, source)
+		if tags != nil {
+			fmt.Fprintf(w, "\t%s", strings.Join(recordTags(tags, a.kind, a.start, a.value), ","))
+		}
+		fmt.Fprintln(w)
+	}
+	if geoRows == nil {
+		return nil
+	}
+	return geoRows.Err()
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/netip"
+	"sort"
+	"strings"
+	"time"
+)
+
+// geofeedRef is a geofeed URL referenced by an inetnum or inet6num object.
+type geofeedRef struct {
+	URL         string
+	First, Last netip.Addr
+}
+
+// geofeedMismatch is a geofeed entry whose country differs from the delegation's.
+type geofeedMismatch struct {
+	Prefix       string `json:"prefix"`
+	GeofeedCC    string `json:"geofeed_cc"`
+	Region       string `json:"region,omitempty"`
+	City         string `json:"city,omitempty"`
+	Registry     string `json:"registry"`
+	DelegationCC string `json:"delegation_cc"`
+	URL          string `json:"url"`
+}
+
+// geofeedCommand implements "geofeed import FILE...", which finds the RFC 8805 geofeeds
+// referenced by "geofeed:" attributes or "remarks: Geofeed URL" lines of inetnum and
+// inet6num objects in whois dumps and fetches them, and "geofeed report", which lists
+// geofeed entries whose country differs from the RIR delegation country.
+func geofeedCommand(db *sql.DB, args []string) {
+	usage := "Usage: geofeed import FILE... | geofeed report [-registry NAME] [-format F]"
+	if len(args) == 0 {
+		log.Fatal(usage)
+	}
+	switch args[0] {
+	case "import":
+		if len(args) < 2 {
+			log.Fatal(usage)
+		}
+		refs, err := findGeofeeds(args[1:])
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := importGeofeeds(context.Background(), db, refs); err != nil {
+			log.Fatal(err)
+		}
+	case "report":
+		fs := flag.NewFlagSet("geofeed report", flag.ExitOnError)
+		registry := fs.String("registry", "", "Only delegations of this registry")
+		format := fs.String("format", "table", "Output format: table, csv or json")
+		fs.Parse(args[1:])
+		list, err := geofeedMismatches(db, *registry)
+		if err != nil {
+			log.Fatal(err)
+		}
+		rows := make([][]string, 0, len(list))
+		for _, m := range list {
+			rows = append(rows, []string{m.Prefix, m.GeofeedCC, m.Region, m.City, m.Registry, m.DelegationCC, m.URL})
+		}
+		writeReport(*format, []string{"prefix", "geofeed_cc", "region", "city", "registry", "delegation_cc", "url"}, rows, list)
+	default:
+		log.Fatal(usage)
+	}
+}
+
+// findGeofeeds collects the geofeed references of the inetnum and inet6num objects of whois dumps.
+func findGeofeeds(files []string) ([]geofeedRef, error) {
+	var refs []geofeedRef
+	for _, file := range files {
+		logger.Info("Reading geofeed references", "file", file)
+		err := readWhoisObjects(file, func(obj map[string]string, class string) {
+			if class != "inetnum" && class != "inet6num" {
+				return
+			}
+			url := strings.TrimSpace(obj["geofeed"])
+			if fields := strings.Fields(obj["remarks"]); url == "" && len(fields) >= 2 && strings.EqualFold(fields[0], "geofeed") {
+				url = fields[1]
+			}
+			if !strings.HasPrefix(url, "https://") {
+				return // RFC 9632 requires HTTPS
+			}
+			first, last, ok := inetnumRange(obj[class])
+			if ok {
+				refs = append(refs, geofeedRef{URL: url, First: first, Last: last})
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return refs, nil
+}
+
+// inetnumRange parses "192.0.2.0 - 192.0.2.255" or "2001:db8::/32".
+func inetnumRange(s string) (netip.Addr, netip.Addr, bool) {
+	if p, err := netip.ParsePrefix(strings.TrimSpace(s)); err == nil {
+		return p.Masked().Addr(), lastAddr(p), true
+	}
+	lo, hi, ok := strings.Cut(s, "-")
+	if !ok {
+		return netip.Addr{}, netip.Addr{}, false
+	}
+	first, err1 := netip.ParseAddr(strings.TrimSpace(lo))
+	last, err2 := netip.ParseAddr(strings.TrimSpace(hi))
+	if err1 != nil || err2 != nil || first.BitLen() != last.BitLen() || last.Less(first) {
+		return netip.Addr{}, netip.Addr{}, false
+	}
+	return first, last, true
+}
+
+// importGeofeeds fetches every referenced geofeed once and replaces the stored entries.
+// As in RFC 9632, entries are only kept when inside an object that references the feed.
+func importGeofeeds(ctx context.Context, db *sql.DB, refs []geofeedRef) error {
+	byURL := map[string][]geofeedRef{}
+	for _, r := range refs {
+		byURL[r.URL] = append(byURL[r.URL], r)
+	}
+	urls := make([]string, 0, len(byURL))
+	for url := range byURL {
+		urls = append(urls, url)
+	}
+	sort.Strings(urls)
+
+	var feeds, entries int
+	for _, url := range urls {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		n, err := importGeofeed(ctx, db, url, byURL[url])
+		if err != nil {
+			logger.Warn("Cannot import geofeed", "url", url, "err", err)
+			continue
+		}
+		feeds++
+		entries += n
+	}
+	auditLog(db, "geofeed", fmt.Sprintf("%d feeds", feeds), 0)
+	logger.Info("Imported geofeeds", "entries", entries, "feeds", feeds, "referenced", len(urls))
+	return nil
+}
+
+func importGeofeed(ctx context.Context, db *sql.DB, url string, refs []geofeedRef) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("%s", resp.Status)
+	}
+	r := csv.NewReader(bufio.NewReader(io.LimitReader(resp.Body, 64<<20)))
+	r.FieldsPerRecord = -1
+	r.Comment = '#'
+	r.TrimLeadingSpace = true
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM GeofeedEntries WHERE URL = ?;", truncate(url, 255)); err != nil {
+		return 0, err
+	}
+	stmt, err := tx.Prepare("REPLACE INTO GeofeedEntries VALUES (?, ?, ?, ?, ?, ?, ?, ?, UTC_TIMESTAMP());")
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	var n int
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return 0, err
+		}
+		if len(rec) < 2 {
+			continue
+		}
+		p, err := netip.ParsePrefix(strings.TrimSpace(rec[0]))
+		if err != nil {
+			continue
+		}
+		p = p.Masked()
+		if !geofeedAuthorized(refs, p) {
+			logger.Log(ctx, levelTrace, "Skipping geofeed entry outside the referencing objects", "prefix", p,
+				"url", url)
+			continue
+		}
+		field := func(i int) string {
+			if i < len(rec) {
+				return strings.TrimSpace(rec[i])
+			}
+			return ""
+		}
+		family := 4
+		if p.Addr().Is6() {
+			family = 6
+		}
+		_, err = stmt.Exec(truncate(url, 255), family, p.Addr().AsSlice(), lastAddr(p).AsSlice(), p.String(),
+			strings.ToUpper(truncate(field(1), 2)), truncate(field(2), 64), truncate(field(3), 64))
+		if err != nil {
+			return 0, fmt.Errorf("saving %s: %w", p, err)
+		}
+		n++
+	}
+	return n, tx.Commit()
+}
+
+func geofeedAuthorized(refs []geofeedRef, p netip.Prefix) bool {
+	first, last := p.Addr(), lastAddr(p)
+	for _, r := range refs {
+		if r.First.BitLen() == first.BitLen() && !first.Less(r.First) && !r.Last.Less(last) {
+			return true
+		}
+	}
+	return false
+}
+
+// geofeedMismatches compares each geofeed entry with the delegation containing its first address.
+func geofeedMismatches(db *sql.DB, registry string) ([]geofeedMismatch, error) {
+	rows, err := db.Query("SELECT Prefix, CC, Region, City, URL FROM GeofeedEntries WHERE CC <> '' ORDER BY Family, StartIP;")
+	if err != nil {
+		return nil, err
+	}
+	var entries []geofeedMismatch
+	for rows.Next() {
+		var m geofeedMismatch
+		if err := rows.Scan(&m.Prefix, &m.GeofeedCC, &m.Region, &m.City, &m.URL); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		entries = append(entries, m)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	list := []geofeedMismatch{}
+	for _, m := range entries {
+		p, err := netip.ParsePrefix(m.Prefix)
+		if err != nil {
+			continue
+		}
+		cc, reg, _, err := rirRecord(db, p.Addr())
+		if err == sql.ErrNoRows {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		// ZZ and the EU code do not name a single country
+		if (registry != "" && reg != registry) || cc == m.GeofeedCC || cc == "ZZ" || cc == "EU" {
+			continue
+		}
+		m.Registry, m.DelegationCC = reg, cc
+		list = append(list, m)
+	}
+	return list, nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// growthTotals count the records of a dataset and the space they cover per country,
+// type and status. They are stored in GrowthSeries, one small set of rows per dataset,
+// so charts over years of history need not scan the Records tables.
+type growthTotals map[[3]string]*spaceTotal // cc, type, status
+
+func (g growthTotals) add(cc, kind, status string, value uint64) {
+	key := [3]string{cc, kind, status}
+	s := g[key]
+	if s == nil {
+		s = &spaceTotal{}
+		g[key] = s
+	}
+	s.Blocks++
+	if kind == "ipv6" {
+		s.Size += slash48s(value)
+	} else {
+		s.Size += value
+	}
+}
+
+// saveGrowth replaces the growth rows of a dataset.
+func saveGrowth(tx *sql.Tx, registry string, dataset int64, date string, g growthTotals) error {
+	if _, err := tx.Exec("DELETE FROM GrowthSeries WHERE ID_Datasets = ?;", dataset); err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare("INSERT INTO GrowthSeries VALUES (?, ?, ?, ?, ?, ?, ?, ?);")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for key, t := range g {
+		if _, err := stmt.Exec(dataset, registry, date, key[0], key[1], key[2], t.Blocks, t.Size); err != nil {
+			return fmt.Errorf("saving growth series: %w", err)
+		}
+	}
+	return nil
+}
+
+// growthPoint is the total of the selected series on one date.
+type growthPoint struct {
+	Date     string `json:"date"`
+	Registry string `json:"registry"`
+	Type     string `json:"type"`
+	Blocks   uint64 `json:"blocks"`
+	Size     uint64 `json:"size"` // IPv4 addresses, IPv6 /48s or ASNs
+}
+
+// growthFilter selects GrowthSeries rows; empty fields select everything. Status
+// defaults to the delegated space, allocated and assigned.
+type growthFilter struct {
+	Registry, CC, Type, Status, Since string
+}
+
+func growthFilterFromQuery(get func(string) string) (growthFilter, error) {
+	f := growthFilter{Registry: get("registry"), CC: strings.ToUpper(get("cc")), Type: get("type"), Status: get("status"), Since: get("since")}
+	if f.Since != "" {
+		if _, err := time.Parse("2006-01-02", f.Since); err != nil {
+			return f, fmt.Errorf("invalid date: %s", f.Since)
+		}
+	}
+	return f, nil
+}
+
+// growthSeries sums the selected rows per dataset and type, oldest first.
+func growthSeries(db *sql.DB, f growthFilter) ([]growthPoint, error) {
+	states := "State IN ('allocated', 'assigned')"
+	var params []interface{}
+	if f.Status != "" {
+		states = "State = ?"
+		params = append(params, f.Status)
+	}
+	params = append(params, f.Registry, f.Registry, f.CC, f.CC, f.Type, f.Type, f.Since, f.Since)
+	rows, err := db.Query(`SELECT SeriesDate, ID_Registries, RecordType, SUM(Blocks), SUM(Size) FROM GrowthSeries
+		WHERE `+states+` AND (? = '' OR ID_Registries = ?) AND (? = '' OR CC = ?) AND (? = '' OR RecordType = ?)
+		AND (? = '' OR SeriesDate >= ?) GROUP BY ID_Datasets, SeriesDate, ID_Registries, RecordType
+		ORDER BY ID_Registries, RecordType, SeriesDate;`, params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []growthPoint{}
+	for rows.Next() {
+		var p growthPoint
+		if err := rows.Scan(&p.Date, &p.Registry, &p.Type, &p.Blocks, &p.Size); err != nil {
+			return nil, err
+		}
+		list = append(list, p)
+	}
+	return list, rows.Err()
+}
+
+// growthCommand prints the growth series; "summaries totals" backfills older datasets.
+func growthCommand(db *sql.DB, args []string) {
+	fs := flag.NewFlagSet("growth", flag.ExitOnError)
+	fs.String("registry", "", "Only this registry")
+	fs.String("cc", "", "Only this country code")
+	fs.String("type", "", "Only this record type: asn, ipv4 or ipv6")
+	fs.String("status", "", "Only this status; default allocated and assigned")
+	fs.String("since", "", "Only datasets on or after this date (YYYY-MM-DD)")
+	format := fs.String("format", "table", "Output format: table, csv or json")
+	fs.Parse(args)
+
+	f, err := growthFilterFromQuery(func(name string) string { return fs.Lookup(name).Value.String() })
+	if err != nil {
+		log.Fatal(err)
+	}
+	list, err := growthSeries(db, f)
+	if err != nil {
+		log.Fatal(err)
+	}
+	rows := make([][]string, 0, len(list))
+	for _, p := range list {
+		rows = append(rows, []string{p.Date, p.Registry, p.Type, strconv.FormatUint(p.Blocks, 10), strconv.FormatUint(p.Size, 10)})
+	}
+	writeReport(*format, []string{"date", "registry", "type", "blocks", "size"}, rows, list)
+}
+
+// handleGrowth serves /v1/growth; accepts registry, cc, type, status and since parameters.
+func handleGrowth(db *sql.DB, ns string, w http.ResponseWriter, r *http.Request) {
+	f, err := growthFilterFromQuery(r.URL.Query().Get)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	list, err := growthSeries(db, f)
+	if err != nil {
+		http.Error(w, "cannot query growth series", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"namespace": ns, "growth": list})
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/krassi/ip2asn/pkg/lookuppb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// grpcLookupServer implements the Lookup service of pkg/lookuppb/lookup.proto.
+type grpcLookupServer struct {
+	lookuppb.UnimplementedLookupServer
+	shutdown context.Context // ends open change streams
+}
+
+// startGRPCServer serves the Lookup service on -grpc-listen until ctx is cancelled, then
+// drains the calls in flight for up to -shutdown-timeout.
+func startGRPCServer(ctx context.Context) {
+	ln, err := net.Listen("tcp", *f_grpcListen)
+	if err != nil {
+		logger.Warn("Cannot listen for gRPC", "addr", *f_grpcListen, "err", err)
+		return
+	}
+	srv := grpc.NewServer()
+	lookuppb.RegisterLookupServer(srv, grpcLookupServer{shutdown: ctx})
+	eventSinks = append(eventSinks, changeFeed)
+	logger.Info("Serving gRPC", "addr", *f_grpcListen)
+	go func() {
+		if err := srv.Serve(ln); err != nil {
+			logger.Warn("gRPC server failed", "err", err)
+		}
+	}()
+	serverRequests.Add(1)
+	go func() {
+		defer serverRequests.Done()
+		<-ctx.Done()
+		stopped := make(chan struct{})
+		go func() {
+			srv.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-time.After(*f_shutdownTimeout):
+			logger.Warn("gRPC calls still running at the shutdown timeout; closing them")
+			srv.Stop()
+		}
+	}()
+}
+
+// grpcNamespace authenticates the API key of a call like withNamespace and returns the
+// database of its namespace.
+func grpcNamespace(ctx context.Context) (*sql.DB, string, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	key := ""
+	if v := md.Get("x-api-key"); len(v) > 0 {
+		key = v[0]
+	} else if v := md.Get("authorization"); len(v) > 0 {
+		key = strings.TrimPrefix(v[0], "Bearer ")
+	}
+
+	ns := *f_namespace
+	if key != "" {
+		found, ok, err := apiKeyNamespace(key)
+		if err != nil {
+			return nil, "", status.Error(codes.Unavailable, "cannot verify API key")
+		}
+		if !ok {
+			return nil, "", status.Error(codes.Unauthenticated, "invalid API key")
+		}
+		ns = found
+	} else if *f_requireAPIKey {
+		return nil, "", status.Error(codes.Unauthenticated, "API key required")
+	}
+
+	db, err := namespaceDB(ns)
+	if err != nil {
+		return nil, "", status.Error(codes.Unavailable, "namespace unavailable")
+	}
+	return db, ns, nil
+}
+
+func (grpcLookupServer) LookupIP(ctx context.Context, req *lookuppb.LookupIPRequest) (*lookuppb.LookupReply, error) {
+	if _, err := netip.ParseAddr(req.Address); err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid address")
+	}
+	return grpcLookup(ctx, req.Address)
+}
+
+func (grpcLookupServer) LookupASN(ctx context.Context, req *lookuppb.LookupASNRequest) (*lookuppb.LookupReply, error) {
+	return grpcLookup(ctx, strconv.FormatUint(uint64(req.Asn), 10))
+}
+
+// grpcLookup answers a single query like writeLookup.
+func grpcLookup(ctx context.Context, q string) (*lookuppb.LookupReply, error) {
+	db, ns, err := grpcNamespace(ctx)
+	if err != nil {
+		return nil, err
+	}
+	started := time.Now()
+	a, err := indexedLookup(db, lookupIndex(db, ns), q)
+	if err == sql.ErrNoRows {
+		countLookup("not_found", time.Since(started))
+		return nil, status.Error(codes.NotFound, "no delegation found for "+q)
+	} else if err != nil {
+		countLookup("error", time.Since(started))
+		countDBError("lookup", err)
+		return nil, status.Error(codes.Internal, "cannot look up "+q)
+	}
+	countLookup("found", time.Since(started))
+	return lookupReply(a, true), nil
+}
+
+func (grpcLookupServer) BatchLookup(req *lookuppb.BatchLookupRequest, stream lookuppb.Lookup_BatchLookupServer) error {
+	db, ns, err := grpcNamespace(stream.Context())
+	if err != nil {
+		return err
+	}
+	table := lookupIndex(db, ns)
+	for _, q := range req.Queries {
+		if _, _, ok := rdapQuery(q); !ok {
+			if err := stream.Send(&lookuppb.LookupReply{Query: q, Error: "not an address or ASN"}); err != nil {
+				return err
+			}
+			continue
+		}
+		started := time.Now()
+		var reply *lookuppb.LookupReply
+		a, err := indexedLookup(db, table, q)
+		switch {
+		case err == nil:
+			countLookup("found", time.Since(started))
+			reply = lookupReply(a, true)
+		case err == sql.ErrNoRows:
+			countLookup("not_found", time.Since(started))
+			reply = lookupReply(a, false)
+		default:
+			countLookup("error", time.Since(started))
+			countDBError("lookup", err)
+			return status.Error(codes.Internal, "cannot look up "+q)
+		}
+		if err := stream.Send(reply); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lookupReply converts a lookup answer, which may be partial when not found.
+func lookupReply(a lookupAnswer, found bool) *lookuppb.LookupReply {
+	return &lookuppb.LookupReply{Query: a.Query, Found: found, Registry: a.Registry, Cc: a.CC, Type: a.Type, Start: a.Start,
+		Value: a.Value, Date: a.Date, Status: a.Status, Holder: a.Holder, Asn: a.ASN, AsName: a.ASName, AsOrg: a.ASOrg,
+		Route: a.Route, Rpki: a.RPKI, Tags: a.Tags}
+}
+
+func (s grpcLookupServer) StreamChanges(req *lookuppb.StreamChangesRequest, stream lookuppb.Lookup_StreamChangesServer) error {
+	db, ns, err := grpcNamespace(stream.Context())
+	if err != nil {
+		return err
+	}
+	// Imports of this process only fill the process namespace. Subscribe before reading
+	// the recorded changes so that none are missed in between.
+	var ch chan ChangeEvent
+	if ns == *f_namespace {
+		ch = changeFeed.subscribe()
+		defer changeFeed.unsubscribe(ch)
+	}
+
+	last := req.SinceDataset
+	if req.SinceDataset > 0 {
+		if last, err = sendRecordedChanges(db, req, stream); err != nil {
+			return err
+		}
+	}
+	if ch == nil {
+		return nil
+	}
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case <-s.shutdown.Done():
+			return status.Error(codes.Unavailable, fmt.Sprintf("server shutting down; resume from dataset %d", last))
+		case ev, ok := <-ch:
+			if !ok {
+				return status.Error(codes.ResourceExhausted, fmt.Sprintf("too slow; resume from dataset %d", last))
+			}
+			if ev.Dataset <= last || req.Registry != "" && ev.Registry != req.Registry {
+				continue // Sent from the Changes table already
+			}
+			err := stream.Send(&lookuppb.Change{Dataset: ev.Dataset, Registry: ev.Registry, Change: ev.Change, Type: ev.Type,
+				Start: ev.Start, Value: ev.Value, Date: ev.Date, OldCc: ev.OldCC, NewCc: ev.NewCC, OldStatus: ev.OldStatus,
+				NewStatus: ev.NewStatus, OldHolder: ev.OldHolder, NewHolder: ev.NewHolder})
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// sendRecordedChanges sends the changes of the datasets after req.SinceDataset from the
+// Changes table and returns the last dataset sent.
+func sendRecordedChanges(db *sql.DB, req *lookuppb.StreamChangesRequest, stream lookuppb.Lookup_StreamChangesServer) (int64, error) {
+	rows, err := db.QueryContext(stream.Context(), `SELECT ID_Datasets, ID_Registries, ChangeType, RecordType, Start, Value,
+		ChangeDate, IFNULL(OldCC, ''), IFNULL(NewCC, ''), IFNULL(OldState, ''), IFNULL(NewState, ''),
+		IFNULL(OldOpaqueID, ''), IFNULL(NewOpaqueID, '') FROM Changes
+		WHERE ID_Datasets > ? AND (? = '' OR ID_Registries = ?) ORDER BY ID;`, req.SinceDataset, req.Registry, req.Registry)
+	if err != nil {
+		countDBError("changes", err)
+		return 0, status.Error(codes.Internal, "cannot query changes")
+	}
+	defer rows.Close()
+	last := req.SinceDataset
+	for rows.Next() {
+		var c lookuppb.Change
+		if err := rows.Scan(&c.Dataset, &c.Registry, &c.Change, &c.Type, &c.Start, &c.Value, &c.Date, &c.OldCc, &c.NewCc,
+			&c.OldStatus, &c.NewStatus, &c.OldHolder, &c.NewHolder); err != nil {
+			return 0, status.Error(codes.Internal, "cannot query changes")
+		}
+		c.OldHolder, c.NewHolder = holder(c.OldHolder), holder(c.NewHolder)
+		if err := stream.Send(&c); err != nil {
+			return 0, err
+		}
+		if c.Dataset > last {
+			last = c.Dataset
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return 0, status.Error(codes.Internal, "cannot query changes")
+	}
+	return last, nil
+}
+
+// changeBroadcaster is the event sink passing change events to the StreamChanges calls.
+// A subscriber whose buffer is full is dropped, with its channel closed, rather than
+// slowing down imports.
+type changeBroadcaster struct {
+	mu          sync.Mutex
+	subscribers map[chan ChangeEvent]bool
+}
+
+var changeFeed = &changeBroadcaster{subscribers: map[chan ChangeEvent]bool{}}
+
+func (b *changeBroadcaster) subscribe() chan ChangeEvent {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ch := make(chan ChangeEvent, 4096)
+	b.subscribers[ch] = true
+	return ch
+}
+
+func (b *changeBroadcaster) unsubscribe(ch chan ChangeEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subscribers[ch] {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+}
+
+func (b *changeBroadcaster) publishDataset(ev DatasetEvent) {}
+
+func (b *changeBroadcaster) publishRecord(ev RecordEvent) {}
+
+func (b *changeBroadcaster) publishChange(ev ChangeEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subscribers {
+		select {
+		case ch <- ev:
+		default:
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+func (b *changeBroadcaster) close() {}
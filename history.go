@@ -0,0 +1,170 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// orgHolders returns the opaque IDs resolved to an organisation handle or name.
+func orgHolders(db *sql.DB, org string) ([]string, error) {
+	rows, err := db.Query("SELECT DISTINCT OpaqueID FROM Orgs WHERE OrgHandle = ? OR Name = ? ORDER BY 1;", org, org)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// holderResourcesAsOf reconstructs the resources the given opaque IDs held on a past date
+// (YYYY-MM-DD). Candidates are the Resources rows whose first and last sightings span
+// the date; the newest recorded change of each on or before the date then decides
+// whether it was still held, which covers resources that left and came back. Resources
+// that were transferred carry the date of the latest transfer up to that day.
+func holderResourcesAsOf(db *sql.DB, holderIDs []string, date string) ([]holderResource, error) {
+	if len(holderIDs) == 0 {
+		return []holderResource{}, nil
+	}
+	params := []interface{}{date, date}
+	for _, id := range holderIDs {
+		params = append(params, id)
+	}
+	rows, err := db.Query(`SELECT r.ID_Registries, r.RecordType, r.Start, r.Value, r.CC, IFNULL(o.Name, ''), r.State,
+		r.FirstSeen, r.LastSeen, r.Holder FROM Resources r
+		LEFT JOIN Orgs o ON o.ID_Registries = r.ID_Registries AND o.OpaqueID = r.Holder
+		WHERE r.FirstSeen <= ? AND (r.LastSeen >= ? OR r.State <> 'removed')
+		AND r.Holder IN (?`+strings.Repeat(", ?", len(holderIDs)-1)+`) ORDER BY r.RecordType, r.ID_Registries, r.FirstSeen;`, params...)
+	if err != nil {
+		return nil, err
+	}
+	type candidate struct {
+		holderResource
+		holder string
+	}
+	var candidates []candidate
+	for rows.Next() {
+		var c candidate
+		if err := rows.Scan(&c.Registry, &c.Type, &c.Start, &c.Value, &c.CC, &c.Name, &c.Status, &c.FirstSeen,
+			&c.LastSeen, &c.holder); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		candidates = append(candidates, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	list := []holderResource{}
+	for _, c := range candidates {
+		var kind, newHolder, newCC, newState string
+		err := db.QueryRow(`SELECT ChangeType, IFNULL(NewOpaqueID, ''), IFNULL(NewCC, ''), IFNULL(NewState, '') FROM Changes
+			WHERE ID_Registries = ? AND RecordType = ? AND Start = ? AND Value = ? AND ChangeDate <= ?
+			ORDER BY ChangeDate DESC, ID DESC LIMIT 1;`, c.Registry, c.Type, c.Start, c.Value, date).Scan(
+			&kind, &newHolder, &newCC, &newState)
+		switch {
+		case err == sql.ErrNoRows: // No change before the date; the sightings decide
+		case err != nil:
+			return nil, fmt.Errorf("checking changes of %s: %w", c.Start, err)
+		case kind == "removed" || holder(newHolder) != c.holder:
+			continue
+		default:
+			c.CC, c.Status = newCC, newState // The attributes of the time
+		}
+
+		var transferred sql.NullString
+		err = db.QueryRow(`SELECT DATE(MAX(TransferDate)) FROM Transfers WHERE RecordType = ? AND Start = ?
+			AND TransferDate < ? + INTERVAL 1 DAY;`, c.Type, c.Start, date).Scan(&transferred)
+		if err != nil && !isMissingTable(err) {
+			return nil, fmt.Errorf("checking transfers of %s: %w", c.Start, err)
+		}
+		c.Transferred = transferred.String
+		if c.Type == "asn" {
+			c.ASName = asNameOf(db, c.Start)
+		} else {
+			prefixes, _ := recordPrefixes(c.Type, c.Start, c.Value)
+			for _, p := range prefixes {
+				c.Prefixes = append(c.Prefixes, p.String())
+			}
+		}
+		list = append(list, c.holderResource)
+	}
+	return list, nil
+}
+
+// asOfJoin restricts records r to those listed in the dataset of their registry in effect
+// on a date, bound as its one parameter: first seen in that dataset or an earlier one and
+// last seen in it or a later one.
+const asOfJoin = ` JOIN Datasets f ON f.ID = r.ID_Datasets JOIN Datasets l ON l.ID = r.ID_LastDatasets
+	JOIN (SELECT ID_Registries, MAX(serial) AS serial FROM Datasets WHERE enddate <= ? GROUP BY ID_Registries) s
+	ON s.ID_Registries = r.ID_Registries AND f.serial <= s.serial AND l.serial >= s.serial`
+
+// allocationsAsOfQuery selects the records listed on a date, bound three times, as
+// registry, cc, type, start, value, date, seen, status and opaque ID; seen is the end date
+// of the last dataset listing the record.
+const allocationsAsOfQuery = `
+	SELECT r.ID_Registries, r.CC, 'asn', CAST(r.ASN AS CHAR), r.ASNCount, IFNULL(r.RecordDate, ''), IFNULL(l.enddate, ''),
+		r.State, IFNULL(r.OpaqueID, '') FROM Records_asn r` + asOfJoin + `
+	UNION ALL
+	SELECT r.ID_Registries, r.CC, 'ipv4', INET_NTOA(r.FirstIP), r.HostCount, IFNULL(r.RecordDate, ''), IFNULL(l.enddate, ''),
+		r.State, IFNULL(r.OpaqueID, '') FROM Records_ipv4 r` + asOfJoin + `
+	UNION ALL
+	SELECT r.ID_Registries, r.CC, 'ipv6', INET6_NTOA(r.FirstIP), r.PrefixLen, IFNULL(r.RecordDate, ''), IFNULL(l.enddate, ''),
+		r.State, IFNULL(r.OpaqueID, '') FROM Records_ipv6 r` + asOfJoin
+
+// parseAsOf accepts a date as YYYYMMDD or YYYY-MM-DD and returns it as YYYY-MM-DD.
+func parseAsOf(date string) (string, error) {
+	date = normalizeDate(date)
+	if _, err := time.Parse("2006-01-02", date); err != nil {
+		return "", fmt.Errorf("invalid date: %s", date)
+	}
+	return date, nil
+}
+
+// lookupAsOf answers an address or ASN like lookupQuery from the records listed on a date
+// (YYYY-MM-DD). BGP routes and tags are only known for the present and left out.
+func lookupAsOf(db *sql.DB, q, date string) (lookupAnswer, error) {
+	a := lookupAnswer{Query: q}
+	var start, opaque sql.NullString
+	var err error
+	if asn, perr := strconv.ParseUint(strings.TrimPrefix(strings.ToUpper(q), "AS"), 10, 32); perr == nil {
+		a.ASN = strconv.FormatUint(asn, 10)
+		a.ASName = asNameOf(db, a.ASN)
+		err = db.QueryRow(`SELECT r.ID_Registries, r.CC, CAST(r.ASN AS CHAR), r.ASNCount, IFNULL(r.RecordDate, ''), r.State,
+			r.OpaqueID FROM Records_asn r`+asOfJoin+` WHERE r.ASN <= ? AND r.ASN + r.ASNCount > ?
+			ORDER BY r.State IN ('allocated', 'assigned') DESC LIMIT 1;`, date, asn, asn).Scan(
+			&a.Registry, &a.CC, &start, &a.Value, &a.Date, &a.Status, &opaque)
+		a.Type = "asn"
+	} else if addr, perr := netip.ParseAddr(q); perr != nil {
+		return a, fmt.Errorf("not an address or ASN: %s", q)
+	} else if addr = addr.Unmap(); addr.Is4() {
+		err = db.QueryRow(`SELECT r.ID_Registries, r.CC, INET_NTOA(r.FirstIP), r.HostCount, IFNULL(r.RecordDate, ''), r.State,
+			r.OpaqueID FROM Records_ipv4 r`+asOfJoin+` WHERE r.FirstIP <= INET_ATON(?) AND r.LastIP >= INET_ATON(?)
+			ORDER BY r.HostCount, r.State IN ('allocated', 'assigned') DESC LIMIT 1;`, date, q, q).Scan(
+			&a.Registry, &a.CC, &start, &a.Value, &a.Date, &a.Status, &opaque)
+		a.Type = "ipv4"
+	} else {
+		err = db.QueryRow(`SELECT r.ID_Registries, r.CC, INET6_NTOA(r.FirstIP), r.PrefixLen, IFNULL(r.RecordDate, ''), r.State,
+			r.OpaqueID FROM Records_ipv6 r`+asOfJoin+` WHERE r.FirstIP <= INET6_ATON(?) AND r.LastIP >= INET6_ATON(?)
+			ORDER BY r.PrefixLen DESC, r.State IN ('allocated', 'assigned') DESC LIMIT 1;`, date, addr.String(), addr.String()).Scan(
+			&a.Registry, &a.CC, &start, &a.Value, &a.Date, &a.Status, &opaque)
+		a.Type = "ipv6"
+	}
+	if err != nil {
+		return lookupAnswer{Query: q, ASN: a.ASN, ASName: a.ASName}, err
+	}
+	a.Start, a.Holder = start.String, holder(opaque.String)
+	return a, nil
+}
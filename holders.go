@@ -0,0 +1,171 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// holderResource is a resource delegated to a holder, identified by the opaque ID
+// of the extended delegated files.
+type holderResource struct {
+	Registry    string   `json:"registry"`
+	Type        string   `json:"type"`
+	Start       string   `json:"start"`
+	Value       uint64   `json:"value"`
+	Prefixes    []string `json:"prefixes,omitempty"`
+	CC          string   `json:"cc"`
+	Name        string   `json:"holder_name,omitempty"` // from Orgs, when resolved
+	ASName      string   `json:"as_name,omitempty"`     // of the first ASN, from AsNames
+	Status      string   `json:"status"`
+	FirstSeen   string   `json:"first_seen"`
+	LastSeen    string   `json:"last_seen"`
+	Transferred string   `json:"transferred,omitempty"` // latest transfer, in as-of queries
+}
+
+// holderResources returns the ASNs and prefixes of a holder across registries.
+// Resources no longer delegated are only included with all set.
+func holderResources(db *sql.DB, holderID string, all bool) ([]holderResource, error) {
+	rows, err := db.Query(`SELECT r.ID_Registries, r.RecordType, r.Start, r.Value, r.CC, IFNULL(o.Name, ''), r.State, r.FirstSeen, r.LastSeen
+		FROM Resources r LEFT JOIN Orgs o ON o.ID_Registries = r.ID_Registries AND o.OpaqueID = r.Holder
+		WHERE r.Holder = ? AND (? OR r.State <> 'removed') ORDER BY r.RecordType, r.ID_Registries, r.FirstSeen;`, holderID, all)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []holderResource{}
+	for rows.Next() {
+		var r holderResource
+		if err := rows.Scan(&r.Registry, &r.Type, &r.Start, &r.Value, &r.CC, &r.Name, &r.Status, &r.FirstSeen, &r.LastSeen); err != nil {
+			return nil, err
+		}
+		if r.Type == "asn" {
+			r.ASName = asNameOf(db, r.Start)
+		} else {
+			prefixes, _ := recordPrefixes(r.Type, r.Start, r.Value)
+			for _, p := range prefixes {
+				r.Prefixes = append(r.Prefixes, p.String())
+			}
+		}
+		list = append(list, r)
+	}
+	return list, rows.Err()
+}
+
+// resourceHolder returns the opaque ID of the current holder of a prefix start address or ASN.
+func resourceHolder(db *sql.DB, start string) (string, error) {
+	var holderID string
+	err := db.QueryRow(`SELECT Holder FROM Resources WHERE Start = ? AND Holder <> '' AND State <> 'removed'
+		ORDER BY LastSeen DESC LIMIT 1;`, normalizeAddr(start)).Scan(&holderID)
+	return holderID, err
+}
+
+// holderCommand lists everything held by an opaque ID, by the holder of a given resource
+// with -of, or by all opaque IDs of an organisation with -org. With -as-of the resources
+// held on a past date are reconstructed from the history.
+func holderCommand(db *sql.DB, args []string) {
+	fs := flag.NewFlagSet("holder", flag.ExitOnError)
+	of := fs.Bool("of", false, "The argument is a prefix start address or ASN; list everything its holder holds")
+	org := fs.Bool("org", false, "The argument is an organisation handle or name from the orgs command")
+	all := fs.Bool("all", false, "Include resources no longer delegated")
+	asOf := fs.String("as-of", "", "List the resources held on this date (YYYY-MM-DD)")
+	format := fs.String("format", "table", "Output format: table, csv or json")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		log.Fatal("Usage: holder [-of|-org] [-all] [-as-of DATE] [-format FORMAT] OPAQUE_ID|RESOURCE|ORG")
+	}
+	if *asOf != "" {
+		if _, err := time.Parse("2006-01-02", *asOf); err != nil {
+			log.Fatal("Invalid date: " + *asOf)
+		}
+	}
+
+	holderID := fs.Arg(0)
+	holderIDs := []string{holderID}
+	if *org {
+		var err error
+		if holderIDs, err = orgHolders(db, fs.Arg(0)); err != nil {
+			log.Fatal(err)
+		} else if len(holderIDs) == 0 {
+			log.Fatal("No opaque IDs known for organisation " + fs.Arg(0))
+		}
+		logger.Debug("Opaque IDs", "query", fs.Arg(0), "holders", strings.Join(holderIDs, ", "))
+	} else if *of {
+		var err error
+		if holderID, err = resourceHolder(db, fs.Arg(0)); err == sql.ErrNoRows {
+			log.Fatal("No holder known for " + fs.Arg(0))
+		} else if err != nil {
+			log.Fatal(err)
+		}
+		logger.Debug("Holder", "query", fs.Arg(0), "holder", holderID)
+		holderIDs = []string{holderID}
+	}
+
+	var list []holderResource
+	var err error
+	if *asOf != "" {
+		list, err = holderResourcesAsOf(db, holderIDs, *asOf)
+	} else {
+		for _, id := range holderIDs {
+			var l []holderResource
+			if l, err = holderResources(db, id, *all); err != nil {
+				break
+			}
+			list = append(list, l...)
+		}
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(list) > 0 && list[0].Name != "" {
+		logger.Info("Holder", "holder", holderID, "name", list[0].Name)
+	}
+	rows := make([][]string, 0, len(list))
+	for _, r := range list {
+		rows = append(rows, []string{r.Registry, r.Type, r.Start, fmt.Sprint(r.Value), strings.Join(r.Prefixes, " "),
+			r.CC, r.Status, r.FirstSeen, r.LastSeen})
+	}
+	writeReport(*format, []string{"registry", "type", "start", "value", "prefixes", "cc", "status", "first_seen", "last_seen"}, rows, list)
+}
+
+// handleHolder serves /v1/holders/OPAQUE_ID; the parameter all=1 includes removed resources,
+// as_of=YYYY-MM-DD lists the resources held on that date.
+func handleHolder(db *sql.DB, ns string, w http.ResponseWriter, r *http.Request) {
+	holderID := strings.TrimPrefix(r.URL.Path, "/v1/holders/")
+	if holderID == "" || strings.Contains(holderID, "/") {
+		http.Error(w, "usage: /v1/holders/OPAQUE_ID", http.StatusBadRequest)
+		return
+	}
+	var list []holderResource
+	var err error
+	if asOf := r.URL.Query().Get("as_of"); asOf != "" {
+		if _, err := time.Parse("2006-01-02", asOf); err != nil {
+			http.Error(w, "invalid as_of date", http.StatusBadRequest)
+			return
+		}
+		list, err = holderResourcesAsOf(db, []string{holderID}, asOf)
+	} else {
+		list, err = holderResources(db, holderID, r.URL.Query().Get("all") == "1")
+	}
+	if err != nil {
+		http.Error(w, "cannot query holder", http.StatusInternalServerError)
+		return
+	}
+	if len(list) == 0 {
+		http.Error(w, "unknown holder", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	name, cc := orgName(db, list[0].Registry, holderID)
+	out := map[string]interface{}{"namespace": ns, "holder": holderID, "name": name, "country": cc, "resources": list}
+	if c, err := holderAbuseContact(db, list[0].Registry, holderID); err == nil && c.Email != "" {
+		out["abuse"] = c
+	}
+	json.NewEncoder(w).Encode(out)
+}
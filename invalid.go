@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/krassi/ip2asn/pkg/rirparse"
+)
+
+// invalidOut is the -invalid-out file, opened at the first rejected line and shared by
+// concurrent imports.
+var invalidOut struct {
+	sync.Mutex
+	f      *os.File
+	failed bool
+}
+
+// writeInvalidLine records a rejected line of an import in -invalid-out.
+func writeInvalidLine(result *ImportResult, number int, reason, line string) {
+	if *f_invalidOut == "" {
+		return
+	}
+	invalidOut.Lock()
+	defer invalidOut.Unlock()
+	if invalidOut.f == nil && !invalidOut.failed {
+		f, err := os.OpenFile(*f_invalidOut, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			logger.Error("Cannot open the invalid lines file", "err", err)
+			invalidOut.failed = true
+			return
+		}
+		invalidOut.f = f
+	}
+	if invalidOut.f == nil {
+		return
+	}
+	line = strings.NewReplacer("\t", " ", "\r", "").Replace(line)
+	fmt.Fprintf(invalidOut.f, "%s\t%d\t%d\t%s\t%s\n", result.Source, result.Serial, number, reason, line)
+}
+
+// recordLine formats a parsed record as its line, for records rejected after parsing.
+func recordLine(rec rirparse.Record) string {
+	return fmt.Sprintf("%s|%s|%s|%s|%d|%s|%s%s", rec.Registry, rec.CC, rec.Type, rec.Start, rec.Value, rec.Date, rec.Status, rec.Extra)
+}
+
+// checkInvalidRate fails an import whose share of invalid lines is over -max-invalid-percent,
+// a sign that the registry changed the format.
+func checkInvalidRate(counter map[string]uint64) error {
+	if *f_maxInvalidPercent <= 0 || counter["all"] == 0 {
+		return nil
+	}
+	percent := 100 * float64(counter["invalid"]) / float64(counter["all"])
+	if percent > *f_maxInvalidPercent {
+		return fmt.Errorf("%d of %d lines are invalid (%.2f%%), more than -max-invalid-percent %g",
+			counter["invalid"], counter["all"], percent, *f_maxInvalidPercent)
+	}
+	return nil
+}
+
+// reconcileCounts compares the records of a dataset with its summary lines and the records
+// field of its version line; a truncated download has fewer. A mismatch fails the import
+// with -strict and is a warning otherwise.
+func reconcileCounts(hdr rirparse.FileHeader, parsed map[string]uint64) error {
+	if hdr.Registry == "" { // No header to compare with (-invalid-header-ok)
+		return nil
+	}
+	var mismatches []string
+	for _, k := range []string{"asn", "ipv4", "ipv6"} {
+		if expected, ok := hdr.Summaries[k]; ok && expected != parsed[k] {
+			mismatches = append(mismatches, fmt.Sprintf("%d %s records, %d in the summary line", parsed[k], k, expected))
+		}
+	}
+	if hdr.Records != parsed["all"] {
+		mismatches = append(mismatches, fmt.Sprintf("%d records, %d in the version line", parsed["all"], hdr.Records))
+	}
+	if len(mismatches) == 0 {
+		return nil
+	}
+	err := fmt.Errorf("%s serial %d: %s", hdr.Registry, hdr.Serial, strings.Join(mismatches, "; "))
+	if *f_strict {
+		return err
+	}
+	logger.Warn("Record counts do not match the header", "err", err)
+	return nil
+}
@@ -1,191 +1,168 @@
 package main
 
 import (
-	"bufio"
-	"bytes"
+	"context"
 	"database/sql"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
-	"regexp"
-	"strconv"
+	"strings"
+	"time"
 
-	"github.com/go-sql-driver/mysql"
 	_ "github.com/go-sql-driver/mysql"
+	"github.com/krassi/ip2asn/pkg/rirparse"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-type FileHeader struct {
-	version   string // format version number of this file, currently 2.3;
-	registry  string // as for records and filename (see below);
-	serial    uint64 // serial number of this file (within the creating RIR series);
-	records   uint64 // number of records in file, excluding blank lines, summary lines, the version line and comments;
-	startdate string // start date of time period, in yyyymmdd format;
-	enddate   string // end date of period in yyyymmdd format;
-	UTCoffset int64  // offset from UTC (+/- hours) of local RIR producing file.
-	asnCount  uint64 // sum of the number of record lines of this type in the file.
-	ipv4Count uint64 // sum of the number of record lines of this type in the file.
-	ipv6Count uint64 // sum of the number of recoip2asnrd lines of this type in the file.
+// ImportResult summarizes a single import attempt.
+type ImportResult struct {
+	Registry string            `json:"registry"`
+	Serial   uint64            `json:"serial"`
+	Dataset  int64             `json:"dataset,omitempty"` // ID in Datasets
+	Source   string            `json:"source"`
+	Counts   map[string]uint64 `json:"counts"`
+	Expected map[string]uint64 `json:"expected,omitempty"` // per type counts from the summary lines
+	Changes  map[string]uint64 `json:"changes,omitempty"`  // added, removed and changed resources since the previous dataset
+	Rows     map[string]uint64 `json:"rows,omitempty"`     // records inserted, updated and unchanged, where the store tells
+	Quality  *datasetQuality   `json:"quality,omitempty"`
+	Started  time.Time         `json:"started"`
+	Duration float64           `json:"duration_seconds"`
+	Status   string            `json:"status"` // success, failure or unchanged (serial imported before)
+	Error    string            `json:"error,omitempty"`
 }
 
-var f_debug, f_force, f_invalid_hdr_ok *bool
+var f_debug, f_force, f_invalid_hdr_ok, f_skipChecksum, f_dryRun, f_strict *bool
+var f_invalidOut *string
+var f_maxInvalidPercent *float64
 var f_verbose *uint
-var f_inputFileName, f_URL, f_source *string
-
-func parseVersionLine(hdr *FileHeader, line string) bool {
-
-	re := regexp.MustCompile(`^([1-9.])+\|(afrinic|apnic|arin|lacnic|ripencc)\|([0-9]+)\|(\d+)\|(\d+)\|(\d+)\|(.*)`)
-	matches := re.FindStringSubmatch(line)
-	if matches == nil {
-		if *f_invalid_hdr_ok != true {
-			log.Fatal("Invalid file header and -invalid-header-ok not specified")
-		}
-		verbosePrint(2, "Warning: date file header missing or corrupt; ignoring due to -invalid-header-ok=true\n")
-		return false
+var f_inputFileName, f_URL, f_source, f_dbDriver, f_sqliteFile *string
+var f_listen, f_webhooks *string
+var f_slackWebhook, f_smtpAddr, f_smtpFrom, f_alertEmail *string
+var f_syslog, f_syslogFacility *string
+var f_kafkaBrokers, f_kafkaTopicPrefix, f_kafkaFormat *string
+var f_natsURL, f_natsSubjectPrefix *string
+var f_esURL, f_esIndexPrefix *string
+var f_whoisListen, f_dnsblListen, f_grpcListen, f_taxiiCountries *string
+var f_splunkURL, f_splunkToken, f_splunkIndex *string
+var f_splunkBatchSize, f_splunkRetries, f_batchSize, f_concurrency, f_downloadRetries *int
+var f_statsd, f_statsdPrefix, f_statsdTags *string
+var f_leaderLock, f_otlpEndpoint, f_pidfile, f_config, f_namespace, f_mirrorDir, f_exportDir *string
+var f_worker, f_workerQueue, f_workerResults *string
+var f_requireAPIKey, f_archiveRaw, f_mirrorOnly, f_noASNames, f_daemon *bool
+var f_staleAfter, f_shutdownTimeout, f_abuseRefresh, f_rdnsSample, f_reloadInterval, f_downloadTimeout, f_rdapCacheTTL *time.Duration
+var f_staleAfterRegistry, f_schedule *string
+var f_logFormat, f_logFile, f_verboseModules *string
+
+// saveHeaderData stores the version and summary lines of a dataset in tx. With force
+// the dataset of the same registry and serial is updated and reused.
+func saveHeaderData(tx *sql.Tx, hdr rirparse.FileHeader, force bool) (int64, error) {
+	var lastID int64
+	logger.Debug("Saving header data in database")
+	logger.Log(context.Background(), levelTrace, "Inserting dataset", "registry", hdr.Registry,
+		"serial", hdr.Serial, "version", hdr.Version, "records", hdr.Records, "start_date", hdr.StartDate,
+		"end_date", hdr.EndDate, "utc_offset", hdr.UTCOffset)
+	query := "INSERT INTO Datasets VALUES( DEFAULT, ?, ?, ?, ?, ?, ?, ?)"
+	if force { // LAST_INSERT_ID returns the ID of the updated row
+		query += ` ON DUPLICATE KEY UPDATE ID = LAST_INSERT_ID(ID), version = VALUES(version), records = VALUES(records),
+			startdate = VALUES(startdate), enddate = VALUES(enddate), UTCoffset = VALUES(UTCoffset)`
 	}
-
-	// Initialize header structure
-	hdr.version = matches[1]
-	hdr.registry = matches[2]
-	hdr.serial, _ = strconv.ParseUint(matches[3], 10, 32)
-	hdr.records, _ = strconv.ParseUint(matches[4], 10, 32)
-	hdr.startdate = matches[5]
-	hdr.enddate = matches[6]
-	hdr.UTCoffset, _ = strconv.ParseInt(matches[7], 10, 32)
-	hdr.UTCoffset /= 100 // TODO: Fix time handling
-
-	// Data corrections
-	if hdr.startdate == "00000000" {
-		hdr.startdate = "19700101"
+	res, err := tx.Exec(query, hdr.Registry, hdr.Serial, hdr.Version, hdr.Records, hdr.StartDate, hdr.EndDate, hdr.UTCOffset)
+	if err == nil {
+		lastID, err = res.LastInsertId()
+	}
+	if err != nil {
+		return 0, fmt.Errorf("saving dataset header: %w", err)
 	}
 
-	verbosePrint(3, fmt.Sprintf("VERSION LINE PARSED OK: HEADER FIELDS: %s::%s::%d::%d::%s::%s::%d\n", hdr.version,
-		hdr.registry, hdr.serial, hdr.records, hdr.startdate, hdr.enddate, hdr.UTCoffset))
-	return true
-}
-
-func parseSummaryLine(hdr *FileHeader, line string) {
-	verbosePrint(3, fmt.Sprintf("HEADER LINE: %s\n", line))
-	re := regexp.MustCompile(`^(afrinic|apnic|arin|lacnic|ripencc)\|\*\|(asn|ipv4|ipv6)\|\*\|([0-9]+)\|summary`)
-	matches := re.FindStringSubmatch(line)
-	if matches != nil {
-		switch matches[2] {
-		case "ipv4":
-			hdr.ipv4Count, _ = strconv.ParseUint(matches[3], 10, 64)
-		case "asn":
-			hdr.asnCount, _ = strconv.ParseUint(matches[3], 10, 64)
-		case "ipv6":
-			hdr.ipv6Count, _ = strconv.ParseUint(matches[3], 10, 64)
-		default:
-			panic("Unknown record type: " + matches[2])
+	for _, k := range []string{"ipv4", "asn", "ipv6"} {
+		_, err = tx.Exec("INSERT INTO Summaries VALUES( DEFAULT, ?, ?, ?) ON DUPLICATE KEY UPDATE Count = VALUES(Count)", lastID, k, hdr.Summaries[k])
+		if err != nil {
+			return 0, fmt.Errorf("saving the %s summary: %w", k, err)
 		}
-		verbosePrint(3, fmt.Sprintf("HEADER FIELDS: %d::%d::%d\n", hdr.ipv4Count, hdr.asnCount, hdr.ipv6Count))
-		verbosePrint(4, fmt.Sprintf("%q\n", matches))
-	} else {
-		verbosePrint(3, "NO HEADER MATCHES")
 	}
+	return lastID, nil
 }
 
-func saveHeaderData(db *sql.DB, hdr FileHeader) int64 {
-	var lastID int64
-	verbosePrint(2, "Saving header data in database.\n")
-	verbosePrint(3, fmt.Sprintf("INSERT INTO Datasets VALUES( DEFAULT, %d, %d, %s, %d, %s, %s, %d)", hdr.registry, hdr.serial, hdr.version, hdr.records, hdr.startdate, hdr.enddate, hdr.UTCoffset))
-	res, err := db.Exec("INSERT INTO Datasets VALUES( DEFAULT, ?, ?, ?, ?, ?, ?, ?)",
-		hdr.registry, hdr.serial, hdr.version, hdr.records, hdr.startdate, hdr.enddate, hdr.UTCoffset)
-
-	if err == nil { // Error may be caused by duplicated unique indexes so attempt to do a select query to see if there is a match
-		lastID, err = res.LastInsertId()
-	} else {
-		driverErr, _ := err.(*mysql.MySQLError)
-		if driverErr.Number == 1062 && *f_force { // Duplicate entry and force enable; continuing
-			verbosePrint(2, "Warning: Unable to insert Dataset; probably a duplicate... quering database for an earlier copy.")
-			err = db.QueryRow("SELECT ID FROM Datasets WHERE ID_Registries = ? AND serial = ?;", hdr.registry, hdr.serial).Scan(&lastID)
-			if err != nil {
-				log.Fatal(err)
-			}
-		} else {
-			log.Fatal(err)
+// parseData imports a dataset while it is read from r, one record at a time. With force
+// a serial imported before is imported again. The changes, resources and statistics
+// derived from it are only kept in MySQL.
+func parseData(ctx context.Context, st Store, r io.Reader, force bool, result *ImportResult) (err error) {
+	db := st.MySQL()
+
+	busy.Add(1)
+	defer busy.Add(-1)
+	markProgress()
+
+	reader := rirparse.NewReader(r)
+
+	_, span := tracer.Start(ctx, "parse.header")
+	logger.Debug("Parsing header")
+	hdr, err := reader.ReadHeader()
+	if err == rirparse.ErrInvalidHeader {
+		if !*f_invalid_hdr_ok {
+			endSpan(span, err)
+			return fmt.Errorf("%w and -invalid-header-ok not specified", err)
 		}
+		logger.Debug("Data file header missing or corrupt; ignoring due to -invalid-header-ok")
+	} else if err != nil {
+		endSpan(span, err)
+		return fmt.Errorf("reading header: %w", err)
+	} else {
+		logger.Log(ctx, levelTrace, "Parsed header", "version", hdr.Version, "registry", hdr.Registry,
+			"serial", hdr.Serial, "records", hdr.Records, "start_date", hdr.StartDate, "end_date", hdr.EndDate,
+			"utc_offset", hdr.UTCOffset)
+		logger.Log(ctx, levelTrace, "Header summaries", "ipv4", hdr.Summaries["ipv4"], "asn", hdr.Summaries["asn"],
+			"ipv6", hdr.Summaries["ipv6"])
 	}
-
-	summaries := map[string]*uint64{
-		"ipv4": &hdr.ipv4Count,
-		"asn":  &hdr.asnCount,
-		"ipv6": &hdr.ipv6Count,
+	result.Registry = hdr.Registry
+	result.Serial = hdr.Serial
+	if hdr.Registry != "" { // Summary lines are only read after a valid version line
+		result.Expected = hdr.Summaries
 	}
-
-	for k := range summaries {
-		res, err = db.Exec("INSERT INTO Summaries VALUES( DEFAULT, ?, ?, ?)", lastID, k, summaries[k])
+	span.SetAttributes(attribute.String("registry", hdr.Registry), attribute.Int64("serial", int64(hdr.Serial)))
+	if hdr.Registry != "" && !force { // A serial is only imported once
+		exists, err := st.HasDataset(hdr.Registry, hdr.Serial)
 		if err != nil {
-			verbosePrint(2, fmt.Sprintf("Warning: cannot record summary value for %s: %s\n", k, err.Error()))
+			endSpan(span, err)
+			return fmt.Errorf("checking for an earlier import: %w", err)
 		}
-	}
-	return lastID
-}
-
-func parseHeader(scanner *bufio.Scanner, hdr *FileHeader) {
-	verbosePrint(2, "Parsing header.\n")
-
-	//Read first header line
-	scanner.Scan()
-	line := scanner.Text()
-
-	// Skip all comments
-	for line[0] == '#' || line[0] == '\r' { // APNIC has a bunch of comments in the file before the header starts so skip them
-		verbosePrint(4, line)
-		scanner.Scan()
-		line = scanner.Text()
-	}
-
-	if parseVersionLine(hdr, line) { // Read next 3 lines
-		for i := 0; i < 3 && scanner.Scan(); i++ {
-			line := scanner.Text()
-			parseSummaryLine(hdr, line)
+		if exists {
+			endSpan(span, nil)
+			return errDatasetUnchanged
 		}
 	}
-}
-
-func parseData(db *sql.DB, data []byte) { // r io.Reader
-	var hdr FileHeader
-	var lastID int64
-
-	r := bytes.NewReader(data)
-	scanner := bufio.NewScanner(r)
-
-	parseHeader(scanner, &hdr)
-	lastID = saveHeaderData(db, hdr)
-
-	queryTempl := "INSERT INTO %s VALUES ( DEFAULT, %d, ?, ?, %s, ?, ?, ?, ?, ?)"
-	var ipv4Query, asnQuery, ipv6Query sql.Stmt
-
-	recordTypes := map[string]*sql.Stmt{
-		"ipv4": &ipv4Query,
-		"asn":  &asnQuery,
-		"ipv6": &ipv6Query,
+	// The header, records and derived tables are written within one transaction, the records
+	// in batches, committed after the last record; a failed import leaves no trace of the dataset
+	tx, lastID, err := st.Begin(hdr, force)
+	endSpan(span, err)
+	if err != nil {
+		return err
 	}
-
-	verbosePrint(3, "DEBUG: Preparing DB queries.\n")
-	for k := range recordTypes {
-		var conversion = "?"
-		if k == "ipv4" {
-			conversion = "INET_ATON(?)"
+	defer tx.Rollback()
+	result.Dataset = lastID
+	publishDatasetEvent(DatasetEvent{Event: "import.started", Registry: hdr.Registry, Serial: hdr.Serial, Source: result.Source})
+
+	var diff *datasetDiff
+	var resources *resourceTracker
+	if db != nil {
+		if diff, err = newDatasetDiff(tx.MySQL(), hdr.Registry, hdr.Serial, lastID); err != nil {
+			return err
 		}
-		if k == "ipv6" {
-			conversion = "INET6_ATON(?)"
+		if resources, err = newResourceTracker(tx.MySQL(), hdr.Registry, lastID, datasetDate(hdr.EndDate)); err != nil {
+			return err
 		}
-		stmt, err := db.Prepare(fmt.Sprintf(queryTempl, "Records_"+string(k), lastID, conversion))
-		recordTypes[k] = stmt
-		verbosePrint(3, fmt.Sprintf("DEBUG: Query: "+string(queryTempl)+"\n", "Records_"+string(k), lastID, conversion))
-
-		if err != nil {
-			fmt.Printf("Warning: prepare query for %s: %s\n", k, err.Error())
-		}
-		defer recordTypes[k].Close()
+		defer resources.upsert.Close()
 	}
 
-	verbosePrint(2, "Processing records.\n")
+	logger.Debug("Processing records")
+	_, span = tracer.Start(ctx, "insert", trace.WithAttributes(attribute.String("registry", hdr.Registry)))
+	defer func() { endSpan(span, err) }()
 
 	var counter = map[string]uint64{
 		"ipv4":    0,
@@ -194,80 +171,390 @@ func parseData(db *sql.DB, data []byte) { // r io.Reader
 		"all":     0,
 		"invalid": 0,
 	}
-	for counter["all"] = 0; scanner.Scan(); counter["all"]++ {
-		line := scanner.Text()
-		verbosePrint(4, fmt.Sprintf("RECORD: line: %s\n", line)) // Println will add back the final '\n'
-
-		re := regexp.MustCompile(`^(afrinic|apnic|arin|lacnic|ripencc)\|([A-Z].|)\|(asn|ipv4|ipv6)\|([0-9a-f:.]+)\|([0-9]+)\|([0-9]+|)\|(allocated|assigned|available|reserved)(.*)$`)
-
-		matches := re.FindStringSubmatch(line)
-		if matches != nil {
-			if matches[6] == "00000000" || matches[6] == "" { // ARIN dataset artifact: replace with NULL
-				matches[6] = "1970-01-01"
+	result.Counts = counter
+	parsed := map[string]uint64{} // Records per type and lines counting as records, including rejected ones
+	totals := spaceTotals{}
+	growth := growthTotals{}
+	quality := newQualityChecker(hdr.EndDate)
+	for counter["all"] = 0; ; counter["all"]++ {
+		if ctx.Err() != nil { // Shutdown requested; stop between records
+			return fmt.Errorf("import interrupted after %d records: %w", counter["all"], ctx.Err())
+		}
+		rec, err := reader.Next()
+		var invalid *rirparse.InvalidLineError
+		if err == io.EOF {
+			break
+		} else if errors.As(err, &invalid) {
+			if *f_dryRun {
+				logger.Info("Invalid line", "line", invalid.Number, "reason", invalid.Reason, "text", invalid.Line)
+			} else {
+				logger.Log(ctx, levelTrace, "Invalid record", "text", invalid.Line)
 			}
-			verbosePrint(4, fmt.Sprintf("RECORD FIELDS: %s:%s:%s:%s:%s:%s:%s:%s\n", matches[1], matches[2], matches[4], matches[5], matches[6], matches[7], matches[8], ""))
-			_, err := recordTypes[matches[3]].Exec(matches[1], matches[2], matches[4], matches[5], matches[6], matches[7], matches[8], "")
-			if err != nil {
-				driverErr, _ := err.(*mysql.MySQLError)
-				if !(driverErr.Number == 1062 && *f_force) {
-					verbosePrint(2, fmt.Sprintf("Warning: EXEC: %s: %s => %q\n", matches[3], err.Error(), matches[1], matches[2], matches[4], matches[5], matches[6], matches[7], matches[8], ""))
-				}
+			writeInvalidLine(result, invalid.Number, invalid.Reason, invalid.Line)
+			if rirparse.CountsAsRecord(invalid.Reason) {
+				parsed["all"]++
 			}
-			counter[matches[3]]++
-		} else {
-			verbosePrint(3, fmt.Sprintf("DEBUG: INVALID RECORD: %s\n", line))
 			counter["invalid"]++
+		} else if err != nil {
+			return fmt.Errorf("reading data: %w", err)
+		} else if !rirparse.KnownStatus(rec.Status) { // Not storable in the State columns
+			logger.Debug("Unsupported status", "status", rec.Status, "type", rec.Type, "start", rec.Start,
+				"value", rec.Value)
+			writeInvalidLine(result, reader.Line(), "unsupported_status", recordLine(rec))
+			parsed[rec.Type]++
+			parsed["all"]++
+			counter["invalid"]++
+		} else {
+			if rec.Date == "00000000" || rec.Date == "" { // ARIN dataset artifact: replace with NULL
+				rec.Date = "1970-01-01"
+			}
+			logger.Log(ctx, levelTrace-1, "Record", "registry", rec.Registry, "cc", rec.CC, "start", rec.Start,
+				"value", rec.Value, "date", rec.Date, "status", rec.Status, "opaque_id", rec.OpaqueID)
+			if diff != nil {
+				diff.observe(rec.Type, rec.Start, rec.Value, allocation{CC: rec.CC, Date: rec.Date, Status: rec.Status, OpaqueID: rec.OpaqueID})
+			}
+			if resources != nil {
+				if err := resources.track(rec.Type, rec.Start, rec.Value, rec.CC, rec.Date, rec.Status, rec.OpaqueID); err != nil {
+					logger.Debug("Cannot track resource", "type", rec.Type, "err", err,
+						"record", fmt.Sprintf("%+v", rec))
+				}
+			}
+			if err := tx.SaveRecord(rec); err != nil {
+				return err
+			}
+			totals.add(rec.Type, rec.Status, rec.Value)
+			growth.add(rec.CC, rec.Type, rec.Status, rec.Value)
+			quality.observe(rec.Type, rec.Start, rec.Value, rec.Date, rec.Status)
+			counter[rec.Type]++
+			parsed[rec.Type]++
+			parsed["all"]++
 		}
+		markProgress()
 		if counter["all"]%5000 == 0 {
-			verbosePrint(2, fmt.Sprintf("%d records complete...\n", counter["all"]))
+			logger.Debug("Processing records", "registry", hdr.Registry, "records", counter["all"])
+			sdNotify(fmt.Sprintf("STATUS=Importing %s: %d records complete", hdr.Registry, counter["all"]))
 		}
 	}
-	verbosePrint(2, fmt.Sprintf("Processed %d records.\nASN: %d\nIPv4: %d\nIPv6: %d\nInvalid: %d\n", counter["all"], counter["asn"], counter["ipv4"], counter["ipv6"], counter["invalid"]))
-
-	if err := scanner.Err(); err != nil {
-		fmt.Fprintln(os.Stderr, "reading standard input:", err)
+	logger.Debug("Processed records", "records", counter["all"], "asn", counter["asn"], "ipv4", counter["ipv4"],
+		"ipv6", counter["ipv6"], "invalid", counter["invalid"])
+	span.SetAttributes(attribute.Int64("records", int64(counter["all"])), attribute.Int64("invalid", int64(counter["invalid"])))
+	if err := checkInvalidRate(counter); err != nil {
+		return err
+	}
+	if err := reconcileCounts(hdr, parsed); err != nil {
+		return err
 	}
+	q := quality.finish(counter, result.Expected)
+	result.Quality = &q
+	if db != nil {
+		if err := tx.Flush(); err != nil {
+			return err
+		}
+		if result.Changes, err = saveDerivedTables(tx.MySQL(), hdr, lastID, resources, totals, growth, q, diff); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("committing records: %w", err)
+	}
+	if result.Rows = tx.RowCounts(); result.Rows != nil {
+		logger.Info("Records written", "inserted", result.Rows["inserted"], "updated", result.Rows["updated"],
+			"unchanged", result.Rows["unchanged"])
+	}
+	if db == nil {
+		return nil
+	}
+	if len(eventSinks) > 0 {
+		if err := publishNewRecords(db, hdr.Registry, lastID, hdr.Serial); err != nil {
+			logger.Warn("Cannot publish record events", "err", err)
+		}
+	}
+	if diff != nil {
+		diff.publish(datasetDate(hdr.EndDate))
+		publishDatasetEvent(DatasetEvent{Event: "diff.summary", Registry: hdr.Registry, Serial: hdr.Serial, Source: result.Source, Changes: result.Changes})
+	}
+	return nil
+}
 
+// saveDerivedTables writes the resources, totals, growth series, quality, changes and
+// summary tables of a dataset in the transaction of its records, and returns the number
+// of changes per kind. A failure rolls back the dataset, so that a retry imports it again
+// rather than finding its serial.
+func saveDerivedTables(tx *sql.Tx, hdr rirparse.FileHeader, dataset int64, resources *resourceTracker, totals spaceTotals,
+	growth growthTotals, q datasetQuality, diff *datasetDiff) (map[string]uint64, error) {
+	date := datasetDate(hdr.EndDate)
+	if err := resources.finish(tx); err != nil {
+		return nil, err
+	}
+	if err := saveDatasetTotals(tx, dataset, totals); err != nil {
+		return nil, err
+	}
+	if err := saveGrowth(tx, hdr.Registry, dataset, date, growth); err != nil {
+		return nil, err
+	}
+	if err := saveQuality(tx, dataset, q); err != nil {
+		return nil, err
+	}
+	logger.Debug("Data quality score", "score", q.Score)
+	var changes map[string]uint64
+	if diff != nil {
+		var err error
+		if changes, err = diff.finish(tx, date); err != nil {
+			return nil, fmt.Errorf("saving changes: %w", err)
+		}
+	}
+	if err := updateSummaries(tx, hdr.Registry, dataset, diff); err != nil {
+		return nil, fmt.Errorf("updating summary tables: %w", err)
+	}
+	return changes, nil
 }
 
-func downloadFile(url *string) []byte {
+// errDatasetUnchanged stops an import whose registry and serial are already in Datasets.
+var errDatasetUnchanged = errors.New("dataset already imported")
+
+// importData fetches and parses one dataset and reports the outcome of the attempt. With
+// force, as -force, a serial imported before is imported again.
+func importData(ctx context.Context, st Store, source string, force bool,
+	fetch func(ctx context.Context) (io.ReadCloser, error)) (ImportResult, error) {
+	if *f_mirrorOnly { // Only fetch, which stores the file in the mirror
+		body, err := fetch(ctx)
+		if err == nil {
+			_, err = io.Copy(ioutil.Discard, body)
+			body.Close()
+		}
+		return ImportResult{Source: source}, err
+	}
 
-	verbosePrint(1, fmt.Sprintf("Downloading file from: %s\n", *url))
+	db := st.MySQL()
+	result := ImportResult{Source: source, Started: time.Now()}
+	var jobID int64
+	if db != nil {
+		jobID = startJob(db, source, result.Started)
+		auditLog(db, "import", source, jobID)
+	}
 
-	http_session, err := http.Get(*url)
-	if err != nil {
-		log.Fatal(err)
+	ctx, span := tracer.Start(ctx, "import", trace.WithAttributes(attribute.String("source", source)))
+	body, err := fetch(ctx)
+	downloaded := source
+	if err == nil {
+		if d, ok := body.(downloadedBody); ok && d.url != source {
+			logger.Info("Importing from mirror", "source", source, "url", d.url)
+			downloaded = d.url
+		}
+		var r io.Reader
+		r, _, err = decompressReader(body) // Mirrors often serve .gz or .bz2 files
+		if err == nil {
+			var raw *rawArchive
+			if db != nil {
+				raw = newRawArchive()
+			}
+			if raw != nil {
+				r = io.TeeReader(r, raw)
+			}
+			if err = parseData(ctx, st, r, force, &result); err == nil && raw != nil {
+				archiveRawFile(db, result, raw)
+			}
+		}
+		body.Close()
 	}
-	buffer, err := ioutil.ReadAll(http_session.Body)
-	if err != nil {
-		log.Fatal(err)
+	span.SetAttributes(attribute.String("registry", result.Registry), attribute.Int64("serial", int64(result.Serial)))
+	endSpan(span, err)
+
+	result.Duration = time.Since(result.Started).Seconds()
+	result.Status = "success"
+	if err == errDatasetUnchanged {
+		if result.Registry != "" { // Otherwise the download was skipped, which was logged
+			logger.Info("Serial already imported; skipping", "registry", result.Registry, "serial", result.Serial)
+		}
+		result.Status, err = "unchanged", nil
+	} else if err != nil {
+		result.Status = "failure"
+		result.Error = err.Error()
+		countDBError("import", err)
 	}
-	http_session.Body.Close()
-
-	verbosePrint(2, fmt.Sprintf("Download complete. Downloaded %d bytes.\n", len(buffer)))
-
-	return buffer
+	saveValidators(db, downloaded, err == nil)
+	if *f_dryRun { // Nothing was imported to report
+		return result, err
+	}
+	publishDatasetEvent(DatasetEvent{Event: "import.finished", Registry: result.Registry, Serial: result.Serial, Source: source, Result: &result})
+	finishJob(db, jobID, result)
+	stats.importMetrics(result)
+	countImport(result)
+	notifyWebhooks(result)
+	alertOnImport(result)
+	if db != nil {
+		alertOnWatches(db, result)
+	}
+	return result, err
 }
 
 func main() {
 	// Parse command line arguments
 	parseArguments()
+	setupLogging()
+	setupSyslog()
+	defer createPIDFile()()
+	setupStatsd()
+	defer setupTracing()()
+	if *f_dryRun {
+		runDryRun()
+		return
+	}
+	switch *f_dbDriver {
+	case "postgres":
+		runPostgres()
+		return
+	case "sqlite":
+		runSQLite()
+		return
+	case "mysql":
+	default:
+		log.Fatal("Invalid -db-driver: " + *f_dbDriver)
+	}
 
 	// Setup and test database connection
 	db := setupDB()
 	defer db.Close()
 
-	// Determine data source
-	switch *f_source {
-	case "file": // Single file with RIR data
-		verbosePrint(1, fmt.Sprintf("Reading from: %s\n", *f_inputFileName))
-		data, err := ioutil.ReadFile(*f_inputFileName)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "ERROR: reading data file %s.", *f_inputFileName)
+	// Commands such as "jobs list" run on their own; "serve" only runs the servers;
+	// "import" is the same as no command
+	if isCommand("serve") {
+		if *f_listen == "" {
+			*f_listen = ":8080"
+		}
+		*f_source = ""
+	} else if flag.NArg() > 0 && !isCommand("import") {
+		runCommand(db, flag.Args())
+		return
+	}
+
+	ctx := shutdownContext()
+	watchReload()
+
+	// Status endpoints for probes and load balancers
+	var srv *http.Server
+	if *f_listen != "" {
+		srv = startHTTPServer(db)
+	}
+	if *f_whoisListen != "" {
+		startWhoisServer(ctx, db)
+	}
+	if *f_dnsblListen != "" {
+		startDNSBLServer(ctx, db)
+	}
+	if *f_grpcListen != "" {
+		startGRPCServer(ctx)
+	}
+
+	// Connect to message brokers
+	setupEventSinks()
+	defer closeEventSinks()
+
+	// Let systemd know we are up and start pinging its watchdog
+	startWatchdog()
+	sdNotify("READY=1")
+
+	// With several instances only the leader imports; a standby daemon checks again on
+	// every scheduled run
+	scheduledSource := *f_source
+	if *f_source != "" && !tryLeadership(db) {
+		logger.Info("Another instance holds the leader lock; skipping import", "lock", *f_leaderLock)
+		if *f_listen == "" && *f_whoisListen == "" && *f_dnsblListen == "" && *f_grpcListen == "" && !*f_daemon {
+			return
+		}
+		*f_source = ""
+	}
+
+	if *f_source != "" || *f_worker != "" || *f_daemon {
+		requireSchemaVersion(db)
+	}
+
+	// Imports come from a task queue instead of the command line
+	if *f_worker != "" {
+		runWorker(ctx, db)
+		drainServers(srv)
+		return
+	}
+
+	// A daemon logs failed imports and tries again on its schedule
+	_, err := importSource(ctx, mysqlStore{db})
+	if err != nil && ctx.Err() == nil && *f_source != "all" && !*f_daemon {
+		log.Fatal(err)
+	}
+
+	if *f_source != "" && ctx.Err() == nil {
+		afterImport(db)
+	}
+	if err != nil && ctx.Err() == nil { // Failed registries of -source all, after the others were processed
+		if !*f_daemon {
 			log.Fatal(err)
 		}
-		verbosePrint(2, "File read complete.\n")
-		parseData(db, data)
+		logger.Warn("Import failed", "err", err)
+	}
+
+	// Keep serving, and importing on the -schedule, until the process is stopped
+	if *f_daemon || *f_listen != "" || *f_whoisListen != "" || *f_dnsblListen != "" || *f_grpcListen != "" {
+		if ctx.Err() == nil {
+			if *f_source == "" { // Nothing imported; make sure exports exist
+				regenerateExports(db)
+			}
+			go watchStaleness(db)
+			go watchAbuseContacts(db)
+			go watchRdnsSamples(db)
+			go watchLeadership(db)
+			if *f_listen != "" || *f_grpcListen != "" {
+				go reloadLookupIndex(db, *f_namespace) // Reflects the import just done
+				go watchLookupIndexes()
+			}
+			sdNotify("STATUS=Import complete; serving HTTP on " + *f_listen)
+			if *f_daemon {
+				*f_source = scheduledSource
+				runDaemon(ctx, db)
+			} else {
+				<-ctx.Done()
+			}
+		}
+		drainServers(srv)
+	}
+	sdNotify("STOPPING=1")
+}
+
+// afterImport runs the steps that follow imports: staleness alerts, the overlap scan and
+// the export files.
+func afterImport(db *sql.DB) {
+	checkStaleness(db)
+	if err := scanOverlaps(db); err != nil {
+		logger.Warn("Cannot scan for overlapping registrations", "err", err)
+	}
+	regenerateExports(db)
+}
+
+// afterImports runs afterImport once scheduled or queued imports stored a dataset, and
+// reloads the lookup index of a running HTTP or gRPC server so that it answers from the
+// new data.
+func afterImports(db *sql.DB) {
+	afterImport(db)
+	if *f_listen != "" || *f_grpcListen != "" {
+		reloadLookupIndex(db, *f_namespace)
+	}
+}
+
+// importSource imports the datasets selected by -source and returns the result of each attempt.
+func importSource(ctx context.Context, st Store) ([]ImportResult, error) {
+	var result ImportResult
+	var err error
+	switch *f_source {
+	case "": // Server mode only; nothing to import
+		return nil, nil
+	case "file": // Single file with RIR data
+		result, err = importData(ctx, st, *f_inputFileName, *f_force, func(ctx context.Context) (io.ReadCloser, error) {
+			logger.Info("Reading from file", "file", *f_inputFileName)
+			f, err := os.Open(*f_inputFileName)
+			if err != nil {
+				return nil, fmt.Errorf("reading data file %s: %w", *f_inputFileName, err)
+			}
+			return f, nil
+		})
 
 	case "afrinic":
 		fallthrough
@@ -278,48 +565,77 @@ func main() {
 	case "lacnic":
 		fallthrough
 	case "ripencc":
-		*f_URL = getRegistryURL(db, *f_source)
-		fallthrough
+		urls := getRegistryURLs(st, *f_source)
+		result, err = importData(ctx, st, urls[0], *f_force, func(ctx context.Context) (io.ReadCloser, error) {
+			return downloadIfChanged(ctx, st, urls, *f_force)
+		})
 	case "download": // Download the data from a specific URL
-		data := downloadFile(f_URL)
-		parseData(db, data)
-	case "all": // Iterate through all RIRs based on URLs from the Registires table
-		registries := []string{"afrinic", "apnic", "arin", "lacnic", "ripencc"}
-		for _, reg := range registries {
-			fmt.Println("Processing: " + reg)
-			url := getRegistryURL(db, reg)
-			data := downloadFile(&url)
-			parseData(db, data)
-		}
+		result, err = importData(ctx, st, *f_URL, *f_force, func(ctx context.Context) (io.ReadCloser, error) {
+			return downloadIfChanged(ctx, st, []string{*f_URL}, *f_force)
+		})
+	case "all": // All RIRs based on URLs from the Registries table
+		return importAllRegistries(ctx, st)
 
 	default:
 		log.Fatal("Invalid source type: " + *f_source)
 	}
+	return []ImportResult{result}, err
 }
 
-func getRegistryURL(db *sql.DB, registry string) string {
-	var URL string
-	err := db.QueryRow("SELECT LatestDataSetLocation FROM Registries WHERE ShortName = ?;", registry).Scan(&URL)
+func getRegistryURLs(st Store, registry string) []string {
+	urls, err := lookupRegistryURLs(st, registry)
 	if err != nil {
 		log.Fatal(err)
 	}
+	return urls
+}
 
-	verbosePrint(3, fmt.Sprintf("DEBUG: Looked up registry URL for %s: %s\n", registry, URL))
+// lookupRegistryURLs returns the download locations of a registry's latest dataset,
+// primary first: those of the config file if it lists the registry, else
+// LatestDataSetLocation followed by the RegistryMirrors of a MySQL database.
+func lookupRegistryURLs(st Store, registry string) ([]string, error) {
+	if URLs, ok := configuredRegistryURLs(registry); ok {
+		logger.Log(context.Background(), levelTrace, "Using configured registry URLs", "registry", registry,
+			"urls", strings.Join(URLs, " "))
+		return URLs, nil
+	}
 
-	return URL
-}
+	URL, err := st.RegistryURL(registry)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("unknown registry %q", registry)
+	} else if err != nil {
+		return nil, err
+	}
+	URLs := []string{URL}
+	if db := st.MySQL(); db != nil {
+		rows, err := db.Query("SELECT URL FROM RegistryMirrors WHERE ShortName = ? ORDER BY Priority;", registry)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			if err := rows.Scan(&URL); err != nil {
+				return nil, err
+			}
+			URLs = append(URLs, URL)
+		}
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
 
-func parseArguments() {
-	f_inputFileName = flag.String("in", "", "Use input file instead of downloading. Overrides flag -registry.")
-	f_URL = flag.String("url", "", "URL to download the data. Overrides flag -registry.")
-	f_source = flag.String("source", "", "Registry to download using default location. Can be one of: all, afrinic, apnic, arin, lacnic, ripencc, as well as file and download.")
+	logger.Log(context.Background(), levelTrace, "Looked up registry URLs", "registry", registry,
+		"urls", strings.Join(URLs, " "))
 
-	f_verbose = flag.Uint("verbose", 1, "Verboseness level; 0 - errors only; 1 - normal output; 3 - debug")
-	f_debug = flag.Bool("debug", false, "Debug (true/false); sets verboseness to 5.")
-	f_force = flag.Bool("force", false, "Forces data import even if Dataset and Summary records exist for the import (true/false)")
-	f_invalid_hdr_ok = flag.Bool("invalid-header-ok", false, "Ignore invalid header (true/false)")
+	return URLs, nil
+}
 
+func parseArguments() {
+	defineFlags()
+	flag.Usage = usage
 	flag.Parse()
+	parseSubcommand()
+	initConfig()
 
 	if *f_URL != "" && *f_inputFileName != "" && *f_source == "" {
 		log.Fatal("Only URL or input file can be set.")
@@ -330,49 +646,137 @@ func parseArguments() {
 	if *f_source == "" && *f_URL != "" {
 		*f_source = "download"
 	}
+	if *f_daemon && *f_source == "" {
+		*f_source = "all"
+	}
+	if *f_source == "" && *f_listen == "" && *f_worker == "" && (flag.NArg() == 0 || isCommand("import")) {
+		log.Fatal("Please, specify a data source using \"-source\", \"-in\" or \"-url\".")
+	}
 	if *f_source == "file" && *f_inputFileName == "" {
 		log.Fatal("Please, specify a filename using \"-in\".")
 	}
 	if *f_source == "download" && *f_URL == "" {
 		log.Fatal("Please, specify a webresource using \"-url\".")
 	}
-	if *f_debug {
-		*f_verbose = 5
+	if *f_dryRun && (*f_listen != "" || *f_grpcListen != "" || *f_worker != "" || *f_daemon || flag.NArg() > 0 && !isCommand("import")) {
+		log.Fatal("-dry-run only applies to imports with -source, -in or -url.")
 	}
-	if *f_verbose >= 3 && len(flag.Args()) > 0 {
-		fmt.Println(os.Stderr, "Unprocessed args:", flag.Args())
+	if *f_mirrorOnly && *f_mirrorDir == "" {
+		log.Fatal("Please, specify the mirror directory using \"-mirror-dir\".")
+	}
+	s, err := newSettings(flagValue)
+	if err != nil {
+		log.Fatal(err)
+	}
+	activeSettings.Store(s)
+	if !validNamespace(*f_namespace) {
+		log.Fatal("Invalid namespace; use up to 32 lowercase letters, digits and underscores.")
 	}
 }
 
-func verbosePrint(level uint, message string) {
-	if level <= *f_verbose {
-		/*		if a == nil {
-					fmt.Printf(format)
-				} else {
-					fmt.Printf(format, a)
-				}*/
-		fmt.Print(message)
-	}
+// defineFlags defines the command line flags with their defaults.
+func defineFlags() {
+	f_config = flag.String("config", "", "YAML or TOML (.toml) config file with flag values, database settings and registry URLs; reloaded on SIGHUP. Command line flags and IP2ASN_* environment variables take precedence.")
+	f_batchSize = flag.Int("batch-size", 1000, "Number of records per multi-row INSERT during imports.")
+	f_dbDriver = flag.String("db-driver", GetEnvDef("DB_DRIVER", "mysql"), "Database to import into: mysql, postgres or sqlite. PostgreSQL (PG* environment variables) only supports imports with -source, SQLite also the lookup command.")
+	f_sqliteFile = flag.String("sqlite-file", "ip2asn.db", "SQLite database file for -db-driver sqlite; created with its tables if missing.")
+	f_daemon = flag.Bool("daemon", false, "Keep running and import -source (default all) again on the -schedule; serials imported before are skipped.")
+	f_schedule = flag.String("schedule", "0 4 * * *", "Cron specification (minute hour day-of-month month day-of-week, local time) of the -daemon imports.")
+	f_concurrency = flag.Int("concurrency", 5, "Number of registries downloaded and imported at the same time with -source all.")
+	f_downloadRetries = flag.Int("download-retries", 3, "Retries of a failed download request, with exponential backoff; an interrupted download is resumed as often.")
+	f_downloadTimeout = flag.Duration("download-timeout", time.Minute, "Give up on connecting, on the response headers or on a download making no progress after this long (0 for no stall limit).")
+	f_skipChecksum = flag.Bool("skip-checksum", false, "Do not verify downloaded datasets against the registry's published .md5 or .sha256 file.")
+	f_inputFileName = flag.String("in", "", "Use input file instead of downloading, optionally gzip or bzip2 compressed. Overrides flag -registry.")
+	f_URL = flag.String("url", "", "URL to download the data. Overrides flag -registry.")
+	f_source = flag.String("source", "", "Registry to download using default location. Can be one of: all, afrinic, apnic, arin, lacnic, ripencc, as well as file and download.")
+
+	f_verbose = flag.Uint("verbose", 1, "Verboseness level; 0 - errors only; 1 - normal output; 2 - debug; 3 - trace")
+	f_verboseModules = flag.String("verbose-modules", "", "Per module verboseness overriding -verbose, by source file name, e.g. download=3,api=0.")
+	f_logFormat = flag.String("log-format", "plain", "Log format: plain (the message and its attributes), text (key=value) or json.")
+	f_logFile = flag.String("log-file", "", "Append log messages to this file instead of writing them to standard output.")
+	f_debug = flag.Bool("debug", false, "Debug (true/false); sets verboseness to 5.")
+	f_force = flag.Bool("force", false, "Forces data import even if Dataset and Summary records exist for the import (true/false)")
+	f_dryRun = flag.Bool("dry-run", false, "Parse the -source datasets fully and report the records, invalid lines and duplicate ranges an import would find, without using the database.")
+	f_invalidOut = flag.String("invalid-out", "", "Append the rejected lines of imports to this file: source, serial, line number, reason code and line, tab-separated.")
+	f_maxInvalidPercent = flag.Float64("max-invalid-percent", 0, "Fail an import, before its records are committed, when more than this percentage of its lines are invalid. 0 disables.")
+	f_strict = flag.Bool("strict", false, "Fail an import, before its records are committed, when the records per type or in total differ from the summary and version lines; otherwise only warn.")
+	f_invalid_hdr_ok = flag.Bool("invalid-header-ok", false, "Ignore invalid header (true/false)")
+
+	f_namespace = flag.String("namespace", GetEnvDef("IP2ASN_NAMESPACE", ""), "Tenant namespace; data lives in the schema <MYSQL_DBNAME>_<namespace>. Empty uses MYSQL_DBNAME itself.")
+	f_requireAPIKey = flag.Bool("require-api-key", false, "Reject API requests without a valid API key; otherwise they use the -namespace data.")
+	f_archiveRaw = flag.Bool("archive-raw", false, "Store the original downloaded file, compressed, with each dataset (see the raw command).")
+	f_mirrorDir = flag.String("mirror-dir", "", "Save every downloaded file under this directory as <host>/YYYY/MM/DD/<file>, whether imported or not.")
+	f_mirrorOnly = flag.Bool("mirror-only", false, "Only download into -mirror-dir; do not import.")
+	f_noASNames = flag.Bool("no-asnames", false, "Do not join AS names (see the asnames command) into lookup, export and API output.")
+	f_whoisListen = flag.String("whois-listen", "", "Serve whois queries on this address, e.g. :43, from local data and the registries' whois servers.")
+	f_dnsblListen = flag.String("dnsbl-listen", "", "Serve the DNSBL zones (see the dnsbl command) over UDP on this address, e.g. :5353.")
+	f_grpcListen = flag.String("grpc-listen", "", "Serve the gRPC lookup service (see pkg/lookuppb/lookup.proto) on this address, e.g. :9090.")
+	f_taxiiCountries = flag.String("taxii-countries", "", "Comma-separated country codes to offer as TAXII collections at /taxii2/ besides bogons and watched prefixes.")
+	f_abuseRefresh = flag.Duration("abuse-refresh", 0, "While serving, refetch abuse contacts from RDAP once they are older than this, e.g. 168h. 0 disables.")
+	f_rdapCacheTTL = flag.Duration("rdap-cache-ttl", 24*time.Hour, "Keep the RDAP answers of lookup in the RdapCache table this long. 0 disables the cache.")
+	f_reloadInterval = flag.Duration("reload-interval", time.Hour, "While serving, reload the in-memory lookup index of /v1/ip from the database this often. 0 keeps the index loaded at startup.")
+	f_rdnsSample = flag.Duration("rdns-sample", 0, "While serving, sample reverse DNS of allocations not sampled for this long, e.g. 720h. 0 disables.")
+	f_exportDir = flag.String("export-dir", "", "Regenerate export files (TSV, CIDR lists) here after each import and serve them at /exports/; a namespace uses a subdirectory of its name.")
+	f_worker = flag.String("worker", "", "Run as a worker taking import tasks from a queue: nats://host:port or redis://[:password@]host:port[/db].")
+	f_workerQueue = flag.String("worker-queue", "ip2asn.tasks", "NATS subject or Redis list to take import tasks from.")
+	f_workerResults = flag.String("worker-results", "ip2asn.results", "NATS subject or Redis list to report task results to.")
+	f_listen = flag.String("listen", "", "Address for the HTTP server with /healthz, /readyz and the /v1 API, e.g. :8080. Keeps the process running after the import; the serve command defaults it to :8080.")
+	f_webhooks = flag.String("webhook", "", "Comma-separated list of URLs to POST a JSON summary to after each import attempt.")
+	f_slackWebhook = flag.String("slack-webhook", "", "Slack incoming webhook URL for alerts on import failures, count anomalies and stale datasets.")
+	f_smtpAddr = flag.String("smtp-addr", "", "SMTP server (host:port) used to email alerts. Credentials are taken from SMTP_USER and SMTP_PASS.")
+	f_smtpFrom = flag.String("smtp-from", "ip2asn@localhost", "Sender address for alert emails.")
+	f_alertEmail = flag.String("alert-email", "", "Comma-separated list of alert email recipients.")
+	f_syslog = flag.String("syslog", "", "Also send logs to syslog (RFC 5424): local, udp://host:port or tcp://host:port.")
+	f_syslogFacility = flag.String("syslog-facility", "daemon", "Syslog facility, e.g. daemon, user, local0..local7.")
+	f_kafkaBrokers = flag.String("kafka-brokers", "", "Comma-separated Kafka brokers to publish inserted records, changes and dataset events to.")
+	f_kafkaTopicPrefix = flag.String("kafka-topic-prefix", "ip2asn", "Kafka topics are <prefix>.records, <prefix>.changes and <prefix>.datasets.")
+	f_kafkaFormat = flag.String("kafka-format", "json", "Kafka message encoding: json or avro (single object encoding).")
+	f_natsURL = flag.String("nats-url", "", "NATS server URL to publish dataset lifecycle events to, e.g. nats://localhost:4222.")
+	f_natsSubjectPrefix = flag.String("nats-subject-prefix", "ip2asn", "Events are published to <prefix>.<event>, e.g. ip2asn.import.finished.")
+	f_esURL = flag.String("es-url", "", "Elasticsearch/OpenSearch URL to bulk-index records and changes into, e.g. https://user:pass@es:9200.")
+	f_esIndexPrefix = flag.String("es-index-prefix", "ip2asn", "Elasticsearch indices are <prefix>-allocations and <prefix>-changes.")
+	f_splunkURL = flag.String("splunk-hec-url", "", "Splunk HTTP Event Collector URL to send records, changes and dataset events to, e.g. https://splunk:8088.")
+	f_splunkToken = flag.String("splunk-hec-token", GetEnvDef("SPLUNK_HEC_TOKEN", ""), "Splunk HEC token (default $SPLUNK_HEC_TOKEN).")
+	f_splunkIndex = flag.String("splunk-index", "", "Splunk index for the events; empty uses the token's default index.")
+	f_splunkBatchSize = flag.Int("splunk-batch-size", 500, "Events per Splunk HEC request.")
+	f_splunkRetries = flag.Int("splunk-retries", 3, "Retries of a failed Splunk HEC request, with exponential backoff.")
+	f_statsd = flag.String("statsd", "", "StatsD/DogStatsD address (host:port) to send import and lookup metrics to.")
+	f_statsdPrefix = flag.String("statsd-prefix", "ip2asn.", "Prefix for all StatsD metric names.")
+	f_statsdTags = flag.String("statsd-tags", "", "Comma-separated tags added to every metric, e.g. env:prod,dc:fra1.")
+	f_leaderLock = flag.String("leader-lock", "", "Name of a database lock; only the instance holding it performs imports, the others only serve.")
+	f_otlpEndpoint = flag.String("otlp-endpoint", "", "OTLP/HTTP endpoint to export traces to, e.g. http://localhost:4318/v1/traces.")
+	f_pidfile = flag.String("pidfile", "", "Write the PID to this file and refuse to start if another instance holds it.")
+	f_shutdownTimeout = flag.Duration("shutdown-timeout", 30*time.Second, "Time to drain in-flight requests and flush buffers on SIGTERM.")
+	f_staleAfter = flag.Duration("stale-after", 48*time.Hour, "Maximum age of the latest dataset per registry before it is reported stale (/readyz, alerts, check).")
+	f_staleAfterRegistry = flag.String("stale-after-registry", "", "Per registry staleness thresholds overriding -stale-after, e.g. arin=24h,afrinic=72h.")
 }
 
 func setupDB() *sql.DB {
-	// Get username password from ENV variables
-	user := GetEnvDef("MYSQL_USER", "root")
-	pass := GetEnvDef("MYSQL_PASS", "")
-	prot := GetEnvDef("MYSQL_PROT", "tcp")
-	addr := GetEnvDef("MYSQL_ADDR", "localhost:3306")
-	dbname := GetEnvDef("MYSQL_DBNAME", "ip2asn")
+	db, err := openDB(namespaceSchema(*f_namespace))
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	return db
+}
+
+// openDB connects to the given schema using the MYSQL_* environment variables or the
+// database section of the config file.
+func openDB(dbname string) (*sql.DB, error) {
+	user := dbSetting("MYSQL_USER", "user", "root")
+	pass := dbSetting("MYSQL_PASS", "password", "")
+	prot := dbSetting("MYSQL_PROT", "protocol", "tcp")
+	addr := dbSetting("MYSQL_ADDR", "address", "localhost:3306")
 	dsn := fmt.Sprintf("%s:%s@%s(%s)/%s?timeout=15s", user, pass, prot, addr, dbname)
 
 	db, err := sql.Open("mysql", dsn)
 	if err != nil {
-		log.Fatal(err.Error())
+		return nil, err
 	}
-	err = db.Ping()
-	if err != nil {
-		log.Fatal(err.Error())
+	if err = db.Ping(); err != nil {
+		db.Close()
+		return nil, err
 	}
-	return db
+	return db, nil
 }
 
 func GetEnvDef(envvar string, default_val string) string {
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ipv6Adoption is the delegated IPv6 space of a country on one date next to its IPv4 holdings.
+type ipv6Adoption struct {
+	Date        string  `json:"date"`
+	CC          string  `json:"cc"`
+	IPv6Blocks  uint64  `json:"ipv6_allocations"`
+	IPv6Slash32 float64 `json:"ipv6_32s"` // /32 equivalents
+	IPv4Blocks  uint64  `json:"ipv4_allocations"`
+	IPv4Addrs   uint64  `json:"ipv4_addresses"`
+	Ratio       float64 `json:"ipv6_ipv4_ratio"` // IPv6 allocations per IPv4 allocation
+}
+
+// ipv6Adoptions computes the adoption metrics per country from GrowthSeries, oldest
+// first. Unless daily is set only the last date of every month is kept.
+func ipv6Adoptions(db *sql.DB, cc, since string, daily bool) ([]ipv6Adoption, error) {
+	rows, err := db.Query(`SELECT SeriesDate, CC, RecordType, SUM(Blocks), SUM(Size) FROM GrowthSeries
+		WHERE State IN ('allocated', 'assigned') AND RecordType IN ('ipv4', 'ipv6') AND CC <> ''
+		AND (? = '' OR CC = ?) AND (? = '' OR SeriesDate >= ?)
+		GROUP BY SeriesDate, CC, RecordType ORDER BY CC, SeriesDate;`, cc, cc, since, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []ipv6Adoption{}
+	for rows.Next() {
+		var date, country, kind string
+		var blocks, size uint64
+		if err := rows.Scan(&date, &country, &kind, &blocks, &size); err != nil {
+			return nil, err
+		}
+		n := len(list)
+		if n == 0 || list[n-1].CC != country || list[n-1].Date != date {
+			// A new month replaces the previous date of the same month
+			if n > 0 && !daily && list[n-1].CC == country && list[n-1].Date[:7] == date[:7] {
+				list = list[:n-1]
+			}
+			list = append(list, ipv6Adoption{Date: date, CC: country})
+		}
+		a := &list[len(list)-1]
+		if kind == "ipv6" {
+			a.IPv6Blocks, a.IPv6Slash32 = blocks, math.Round(float64(size)/65536*100)/100
+		} else {
+			a.IPv4Blocks, a.IPv4Addrs = blocks, size
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for i := range list {
+		if list[i].IPv4Blocks > 0 {
+			list[i].Ratio = math.Round(float64(list[i].IPv6Blocks)/float64(list[i].IPv4Blocks)*1000) / 1000
+		}
+	}
+	return list, nil
+}
+
+func ipv6Stats(db *sql.DB, cc, since string, daily bool, format string) error {
+	list, err := ipv6Adoptions(db, cc, since, daily)
+	if err != nil {
+		return err
+	}
+	rows := make([][]string, 0, len(list))
+	for _, a := range list {
+		rows = append(rows, []string{a.Date, a.CC, fmt.Sprint(a.IPv6Blocks), fmt.Sprintf("%.2f", a.IPv6Slash32),
+			fmt.Sprint(a.IPv4Blocks), fmt.Sprint(a.IPv4Addrs), fmt.Sprintf("%.3f", a.Ratio)})
+	}
+	writeReport(format, []string{"date", "cc", "ipv6_allocations", "ipv6_32s", "ipv4_allocations", "ipv4_addresses", "ipv6_ipv4_ratio"}, rows, list)
+	return nil
+}
+
+// handleIPv6 serves /v1/stats/ipv6; accepts cc, since and daily=1 parameters.
+func handleIPv6(db *sql.DB, ns string, w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if since := q.Get("since"); since != "" {
+		if _, err := time.Parse("2006-01-02", since); err != nil {
+			http.Error(w, "invalid since date", http.StatusBadRequest)
+			return
+		}
+	}
+	list, err := ipv6Adoptions(db, strings.ToUpper(q.Get("cc")), q.Get("since"), q.Get("daily") == "1")
+	if err != nil {
+		http.Error(w, "cannot query IPv6 adoption", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"namespace": ns, "ipv6_adoption": list})
+}
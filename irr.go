@@ -0,0 +1,189 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// irrCommand implements "irr import -source NAME FILE...", loading route and route6
+// objects from RPSL dumps (e.g. radb.db.gz, ripe.db.route.gz), "irr status PREFIX ASN"
+// and "irr check [-stale] [-format F]", which lists BGP routes without a matching route
+// object or, with -stale, route objects whose route is not seen in BGP.
+func irrCommand(db *sql.DB, args []string) {
+	usage := "Usage: irr import -source NAME FILE... | irr status PREFIX ASN | irr check [-stale] [-format F]"
+	if len(args) == 0 {
+		log.Fatal(usage)
+	}
+	switch args[0] {
+	case "import":
+		fs := flag.NewFlagSet("irr import", flag.ExitOnError)
+		source := fs.String("source", "", "Name of the IRR, e.g. RADB or RIPE; its earlier objects are replaced")
+		fs.Parse(args[1:])
+		if *source == "" || fs.NArg() == 0 {
+			log.Fatal(usage)
+		}
+		if err := importIRRRoutes(db, strings.ToUpper(*source), fs.Args()); err != nil {
+			log.Fatal(err)
+		}
+	case "status":
+		if len(args) != 3 {
+			log.Fatal(usage)
+		}
+		p, err := netip.ParsePrefix(args[1])
+		if err != nil {
+			log.Fatal("Invalid prefix: " + args[1])
+		}
+		asn, err := strconv.ParseUint(strings.TrimPrefix(strings.ToUpper(args[2]), "AS"), 10, 32)
+		if err != nil {
+			log.Fatal("Invalid ASN: " + args[2])
+		}
+		status, err := irrStatus(db, p, asn)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(status)
+	case "check":
+		fs := flag.NewFlagSet("irr check", flag.ExitOnError)
+		stale := fs.Bool("stale", false, "List route objects not seen in BGP instead")
+		format := fs.String("format", "table", "Output format: table, csv or json")
+		fs.Parse(args[1:])
+		irrCheck(db, *stale, *format)
+	default:
+		log.Fatal(usage)
+	}
+}
+
+func importIRRRoutes(db *sql.DB, source string, files []string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM IrrRoutes WHERE Source = ?;", source); err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare("REPLACE INTO IrrRoutes VALUES (?, ?, ?, ?, ?, ?, ?);")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	var n int
+	for _, file := range files {
+		logger.Info("Reading route objects", "file", file)
+		var saveErr error
+		err := readWhoisObjects(file, func(obj map[string]string, class string) {
+			if saveErr != nil || (class != "route" && class != "route6") {
+				return
+			}
+			p, err := netip.ParsePrefix(strings.Fields(obj[class] + " x")[0])
+			if err != nil {
+				return
+			}
+			origin, err := strconv.ParseUint(strings.TrimPrefix(strings.ToUpper(strings.Fields(obj["origin"] + " x")[0]), "AS"), 10, 32)
+			if err != nil {
+				return
+			}
+			p = p.Masked()
+			family := 4
+			if p.Addr().Is6() {
+				family = 6
+			}
+			if _, err := stmt.Exec(family, p.Addr().AsSlice(), lastAddr(p).AsSlice(), p.String(), p.Bits(), origin, source); err != nil {
+				saveErr = fmt.Errorf("saving route %s AS%d: %w", p, origin, err)
+			}
+			n++
+		})
+		if err == nil {
+			err = saveErr
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	auditLog(db, "irr", source, 0)
+	logger.Info("Imported route objects", "count", n, "source", source)
+	return nil
+}
+
+// irrStatus checks a route against the route objects: "exact" when an object for the
+// prefix and origin exists, "covered" when a less specific object has the origin,
+// "origin-mismatch" when objects cover the prefix for other origins only, else "missing".
+func irrStatus(db *sql.DB, p netip.Prefix, origin uint64) (string, error) {
+	p = p.Masked()
+	family := 4
+	if p.Addr().Is6() {
+		family = 6
+	}
+	rows, err := db.Query(`SELECT PrefixLen, Origin FROM IrrRoutes WHERE Family = ? AND StartIP <= ? AND EndIP >= ? AND PrefixLen <= ?;`,
+		family, p.Addr().AsSlice(), lastAddr(p).AsSlice(), p.Bits())
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	status := "missing"
+	for rows.Next() {
+		var bits int
+		var asn uint64
+		if err := rows.Scan(&bits, &asn); err != nil {
+			return "", err
+		}
+		switch {
+		case asn == origin && bits == p.Bits():
+			return "exact", nil
+		case asn == origin:
+			status = "covered"
+		case status == "missing":
+			status = "origin-mismatch"
+		}
+	}
+	return status, rows.Err()
+}
+
+// irrCheck lists the BGP routes without an exact or covering route object, or the
+// route objects without a BGP route for the same prefix and origin.
+func irrCheck(db *sql.DB, stale bool, format string) {
+	query := `SELECT b.Prefix, b.OriginAS, b.Source FROM BgpPrefixes b WHERE NOT EXISTS (SELECT 1 FROM IrrRoutes i
+		WHERE i.Family = b.Family AND i.StartIP <= b.StartIP AND i.EndIP >= b.EndIP AND i.Origin = b.OriginAS)
+		ORDER BY b.Family, b.StartIP;`
+	header := []string{"prefix", "origin", "bgp_source"}
+	if stale {
+		query = `SELECT i.Prefix, i.Origin, i.Source FROM IrrRoutes i WHERE NOT EXISTS (SELECT 1 FROM BgpPrefixes b
+			WHERE b.Family = i.Family AND b.StartIP = i.StartIP AND b.EndIP = i.EndIP AND b.OriginAS = i.Origin)
+			ORDER BY i.Family, i.StartIP;`
+		header = []string{"prefix", "origin", "irr_source"}
+	}
+	rows, err := db.Query(query)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rows.Close()
+
+	type route struct {
+		Prefix string `json:"prefix"`
+		Origin string `json:"origin"`
+		Source string `json:"source"`
+	}
+	list := []route{}
+	var table [][]string
+	for rows.Next() {
+		var r route
+		if err := rows.Scan(&r.Prefix, &r.Origin, &r.Source); err != nil {
+			log.Fatal(err)
+		}
+		list = append(list, r)
+		table = append(table, []string{r.Prefix, r.Origin, r.Source})
+	}
+	if err := rows.Err(); err != nil {
+		log.Fatal(err)
+	}
+	writeReport(format, header, table, list)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+// startJob records the beginning of an import attempt and returns the job ID,
+// or 0 if it could not be recorded.
+func startJob(db *sql.DB, source string, started time.Time) int64 {
+	res, err := db.Exec("INSERT INTO ImportJobs (Source, StartTime, Status) VALUES (?, ?, 'running');",
+		source, started.UTC().Format("2006-01-02 15:04:05"))
+	if err != nil {
+		logger.Warn("Cannot record import job", "err", err)
+		return 0
+	}
+	id, _ := res.LastInsertId()
+	return id
+}
+
+// finishJob stores the outcome of an import attempt.
+func finishJob(db *sql.DB, id int64, result ImportResult) {
+	if id == 0 {
+		return
+	}
+	var registry, errMsg, dataset, serial interface{}
+	if result.Registry != "" {
+		registry, serial = result.Registry, result.Serial
+	}
+	if result.Dataset != 0 {
+		dataset = result.Dataset
+	}
+	if result.Error != "" {
+		errMsg = result.Error
+	}
+	_, err := db.Exec(`UPDATE ImportJobs SET ID_Registries = ?, serial = ?, ID_Datasets = ?, EndTime = ?, Status = ?, Error = ?,
+		CountASN = ?, CountIPv4 = ?, CountIPv6 = ?, CountInvalid = ? WHERE ID = ?;`,
+		registry, serial, dataset, time.Now().UTC().Format("2006-01-02 15:04:05"), result.Status, errMsg,
+		result.Counts["asn"], result.Counts["ipv4"], result.Counts["ipv6"], result.Counts["invalid"], id)
+	if err != nil {
+		logger.Warn("Cannot update import job", "job", id, "err", err)
+	}
+}
+
+// jobsCommand implements "jobs list [N]" and "jobs show ID".
+func jobsCommand(db *sql.DB, args []string) {
+	if len(args) == 0 {
+		log.Fatal("Usage: jobs list [N] | jobs show ID")
+	}
+	switch args[0] {
+	case "list":
+		limit := 20
+		if len(args) > 1 {
+			var err error
+			if limit, err = strconv.Atoi(args[1]); err != nil {
+				log.Fatal("Invalid number of jobs: " + args[1])
+			}
+		}
+		listJobs(db, limit)
+	case "show":
+		if len(args) < 2 {
+			log.Fatal("Usage: jobs show ID")
+		}
+		showJob(db, args[1])
+	default:
+		log.Fatal("Unknown jobs command: " + args[0])
+	}
+}
+
+func listJobs(db *sql.DB, limit int) {
+	rows, err := db.Query(`SELECT ID, StartTime, IFNULL(TIMESTAMPDIFF(SECOND, StartTime, EndTime), -1), IFNULL(ID_Registries, '-'),
+		IFNULL(serial, 0), Status, IFNULL(CountASN + CountIPv4 + CountIPv6, 0), IFNULL(CountInvalid, 0), IFNULL(Error, '')
+		FROM ImportJobs ORDER BY ID DESC LIMIT ?;`, limit)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rows.Close()
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSTARTED (UTC)\tDURATION\tREGISTRY\tSERIAL\tSTATUS\tRECORDS\tINVALID\tERROR")
+	for rows.Next() {
+		var id, records, invalid uint64
+		var duration int64
+		var started, registry, status, errMsg string
+		var serial uint64
+		if err := rows.Scan(&id, &started, &duration, &registry, &serial, &status, &records, &invalid, &errMsg); err != nil {
+			log.Fatal(err)
+		}
+		dur := "-"
+		if duration >= 0 {
+			dur = (time.Duration(duration) * time.Second).String()
+		}
+		if len(errMsg) > 60 {
+			errMsg = errMsg[:57] + "..."
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%d\t%s\t%d\t%d\t%s\n", id, started, dur, registry, serial, status, records, invalid, errMsg)
+	}
+	if err := rows.Err(); err != nil {
+		log.Fatal(err)
+	}
+	w.Flush()
+}
+
+func showJob(db *sql.DB, id string) {
+	var source, started, status string
+	var registry, ended, errMsg sql.NullString
+	var serial, dataset, asn, ipv4, ipv6, invalid sql.NullInt64
+	err := db.QueryRow(`SELECT Source, ID_Registries, serial, ID_Datasets, StartTime, EndTime, Status, Error,
+		CountASN, CountIPv4, CountIPv6, CountInvalid FROM ImportJobs WHERE ID = ?;`, id).Scan(
+		&source, &registry, &serial, &dataset, &started, &ended, &status, &errMsg, &asn, &ipv4, &ipv6, &invalid)
+	if err == sql.ErrNoRows {
+		log.Fatal("No such import job: " + id)
+	} else if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("Job:      %s\n", id)
+	fmt.Printf("Source:   %s\n", source)
+	fmt.Printf("Registry: %s\n", registry.String)
+	fmt.Printf("Serial:   %d\n", serial.Int64)
+	fmt.Printf("Dataset:  %d\n", dataset.Int64)
+	fmt.Printf("Started:  %s UTC\n", started)
+	fmt.Printf("Ended:    %s\n", ended.String)
+	fmt.Printf("Status:   %s\n", status)
+	fmt.Printf("Records:  ASN %d, IPv4 %d, IPv6 %d, invalid %d\n", asn.Int64, ipv4.Int64, ipv6.Int64, invalid.Int64)
+	if errMsg.Valid {
+		fmt.Printf("Error:    %s\n", errMsg.String)
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Avro schemas in Parsing Canonical Form; their fingerprints identify the writer schema
+// in the single object encoding header.
+const (
+	avroRecordSchema  = `{"name":"ip2asn.Record","type":"record","fields":[{"name":"registry","type":"string"},{"name":"cc","type":"string"},{"name":"type","type":"string"},{"name":"start","type":"string"},{"name":"value","type":"long"},{"name":"date","type":"string"},{"name":"status","type":"string"},{"name":"dataset","type":"long"},{"name":"serial","type":"long"}]}`
+	avroChangeSchema  = `{"name":"ip2asn.Change","type":"record","fields":[{"name":"registry","type":"string"},{"name":"change","type":"string"},{"name":"type","type":"string"},{"name":"start","type":"string"},{"name":"value","type":"long"},{"name":"date","type":"string"},{"name":"old_cc","type":"string"},{"name":"new_cc","type":"string"},{"name":"old_status","type":"string"},{"name":"new_status","type":"string"},{"name":"old_holder","type":"string"},{"name":"new_holder","type":"string"},{"name":"dataset","type":"long"}]}`
+	avroDatasetSchema = `{"name":"ip2asn.DatasetEvent","type":"record","fields":[{"name":"event","type":"string"},{"name":"registry","type":"string"},{"name":"serial","type":"long"},{"name":"source","type":"string"},{"name":"time","type":"string"},{"name":"status","type":"string"},{"name":"error","type":"string"}]}`
+)
+
+// kafkaSink publishes records to <prefix>.records, changes to <prefix>.changes and
+// lifecycle events to <prefix>.datasets.
+type kafkaSink struct {
+	records  *kafka.Writer
+	changes  *kafka.Writer
+	datasets *kafka.Writer
+	avro     bool
+}
+
+func newKafkaSink() *kafkaSink {
+	if *f_kafkaFormat != "json" && *f_kafkaFormat != "avro" {
+		log.Fatal("Invalid -kafka-format; use json or avro")
+	}
+	brokers := strings.Split(*f_kafkaBrokers, ",")
+	writer := func(topic string) *kafka.Writer {
+		return &kafka.Writer{
+			Addr:         kafka.TCP(brokers...),
+			Topic:        topic,
+			Balancer:     &kafka.Hash{},
+			BatchTimeout: 100 * time.Millisecond,
+			Async:        true,
+			Completion: func(messages []kafka.Message, err error) {
+				if err != nil {
+					logger.Warn("Kafka messages not delivered", "count", len(messages), "err", err)
+				}
+			},
+		}
+	}
+	logger.Debug("Publishing events to Kafka", "brokers", *f_kafkaBrokers)
+	return &kafkaSink{
+		records:  writer(*f_kafkaTopicPrefix + ".records"),
+		changes:  writer(*f_kafkaTopicPrefix + ".changes"),
+		datasets: writer(*f_kafkaTopicPrefix + ".datasets"),
+		avro:     *f_kafkaFormat == "avro",
+	}
+}
+
+func (k *kafkaSink) publishDataset(ev DatasetEvent) {
+	var value []byte
+	var err error
+	if k.avro {
+		status, errMsg := "", ""
+		if ev.Result != nil {
+			status, errMsg = ev.Result.Status, ev.Result.Error
+		}
+		value, err = avroEncode(avroDatasetSchema, ev.Event, ev.Registry, int64(ev.Serial), ev.Source,
+			ev.Time.Format(time.RFC3339), status, errMsg)
+	} else {
+		value, err = json.Marshal(ev)
+	}
+	if err != nil {
+		logger.Warn("Cannot encode dataset event", "registry", ev.Registry, "err", err)
+		return
+	}
+	k.write(k.datasets, ev.Registry, value)
+}
+
+func (k *kafkaSink) publishRecord(ev RecordEvent) {
+	var value []byte
+	var err error
+	if k.avro {
+		value, err = avroEncode(avroRecordSchema, ev.Registry, ev.CC, ev.Type, ev.Start, int64(ev.Value),
+			ev.Date, ev.Status, ev.Dataset, int64(ev.Serial))
+	} else {
+		value, err = json.Marshal(ev)
+	}
+	if err != nil {
+		logger.Warn("Cannot encode record event", "registry", ev.Registry, "start", ev.Start, "err", err)
+		return
+	}
+	k.write(k.records, ev.Registry+"|"+ev.Type+"|"+ev.Start, value)
+}
+
+func (k *kafkaSink) publishChange(ev ChangeEvent) {
+	var value []byte
+	var err error
+	if k.avro {
+		value, err = avroEncode(avroChangeSchema, ev.Registry, ev.Change, ev.Type, ev.Start, int64(ev.Value), ev.Date,
+			ev.OldCC, ev.NewCC, ev.OldStatus, ev.NewStatus, ev.OldHolder, ev.NewHolder, ev.Dataset)
+	} else {
+		value, err = json.Marshal(ev)
+	}
+	if err != nil {
+		logger.Warn("Cannot encode change event", "registry", ev.Registry, "start", ev.Start, "err", err)
+		return
+	}
+	k.write(k.changes, ev.Registry+"|"+ev.Type+"|"+ev.Start, value)
+}
+
+func (k *kafkaSink) write(w *kafka.Writer, key string, value []byte) {
+	err := w.WriteMessages(context.Background(), kafka.Message{Key: []byte(key), Value: value})
+	if err != nil {
+		logger.Warn("Cannot publish to Kafka", "topic", w.Topic, "err", err)
+	}
+}
+
+func (k *kafkaSink) close() {
+	k.records.Close()
+	k.changes.Close()
+	k.datasets.Close()
+}
+
+// avroEncode writes fields (string or int64 only) using Avro single object encoding.
+func avroEncode(schema string, fields ...interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Write([]byte{0xC3, 0x01})
+	binary.Write(&buf, binary.LittleEndian, avroFingerprint(schema))
+
+	var tmp [binary.MaxVarintLen64]byte
+	for _, field := range fields {
+		switch v := field.(type) {
+		case string:
+			buf.Write(tmp[:binary.PutVarint(tmp[:], int64(len(v)))])
+			buf.WriteString(v)
+		case int64:
+			buf.Write(tmp[:binary.PutVarint(tmp[:], v)])
+		default:
+			return nil, fmt.Errorf("avro: unsupported field type %T", field)
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// avroTable is the CRC-64-AVRO lookup table.
+var avroTable = func() (table [256]uint64) {
+	for i := range table {
+		fp := uint64(i)
+		for j := 0; j < 8; j++ {
+			fp = (fp >> 1) ^ (avroEmpty & -(fp & 1))
+		}
+		table[i] = fp
+	}
+	return table
+}()
+
+const avroEmpty = 0xc15d213aa4d7a795
+
+// avroFingerprints of the schemas above, computed once; imports publish concurrently
+// and only read them.
+var avroFingerprints = map[string]uint64{
+	avroRecordSchema:  avroRabin(avroRecordSchema),
+	avroChangeSchema:  avroRabin(avroChangeSchema),
+	avroDatasetSchema: avroRabin(avroDatasetSchema),
+}
+
+// avroFingerprint returns the fingerprint of a canonical schema.
+func avroFingerprint(schema string) uint64 {
+	if fp, ok := avroFingerprints[schema]; ok {
+		return fp
+	}
+	return avroRabin(schema)
+}
+
+// avroRabin computes the CRC-64-AVRO (Rabin) fingerprint of a canonical schema.
+func avroRabin(schema string) uint64 {
+	fp := uint64(avroEmpty)
+	for i := 0; i < len(schema); i++ {
+		fp = (fp >> 8) ^ avroTable[byte(fp)^schema[i]]
+	}
+	return fp
+}
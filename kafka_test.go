@@ -0,0 +1,27 @@
+package main
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestAvroEncode(t *testing.T) {
+	// The fingerprint of "null" from the Avro specification
+	if fp := avroFingerprint(`"null"`); fp != 0x63dd24e7cc258f8a {
+		t.Errorf("fingerprint of \"null\": %#x", fp)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() { // As parallel imports publish
+			defer wg.Done()
+			if _, err := avroEncode(avroDatasetSchema, "import.finished", "arin", int64(1), "", "", "", ""); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if _, err := avroEncode(avroDatasetSchema, 1.5); err == nil {
+		t.Error("encoding a float succeeded")
+	}
+}
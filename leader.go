@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"sync/atomic"
+	"time"
+)
+
+// Leader election uses a named MySQL advisory lock (GET_LOCK). The lock belongs to
+// the session that took it, so it is held on a dedicated connection and released
+// automatically if the process dies or loses its database connection.
+var isLeader atomic.Bool
+var leaderConn *sql.Conn
+
+// tryLeadership reports whether this instance may perform imports, acquiring the
+// -leader-lock if needed. Without -leader-lock every instance is a leader.
+func tryLeadership(db *sql.DB) bool {
+	if *f_leaderLock == "" {
+		return true
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if isLeader.Load() { // Verify we still hold the lock
+		var held sql.NullInt64
+		err := leaderConn.QueryRowContext(ctx, "SELECT IS_USED_LOCK(?) = CONNECTION_ID();", *f_leaderLock).Scan(&held)
+		if err == nil && held.Int64 == 1 {
+			return true
+		}
+		logger.Warn("Leader lock lost")
+		leaderConn.Close()
+		leaderConn = nil
+		isLeader.Store(false)
+	}
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		logger.Warn("Leader election failed", "err", err)
+		return false
+	}
+	var got sql.NullInt64
+	if err = conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, 0);", *f_leaderLock).Scan(&got); err != nil || got.Int64 != 1 {
+		if err != nil {
+			logger.Warn("Leader election failed", "err", err)
+		}
+		conn.Close()
+		return false
+	}
+
+	logger.Info("Acquired leader lock; this instance performs imports", "lock", *f_leaderLock)
+	leaderConn = conn
+	isLeader.Store(true)
+	return true
+}
+
+// watchLeadership keeps re-checking the lock so a standby takes over when the leader goes away.
+func watchLeadership(db *sql.DB) {
+	if *f_leaderLock == "" {
+		return
+	}
+	for range time.Tick(30 * time.Second) {
+		tryLeadership(db)
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Messages are logged through logger, a log/slog logger, with the level and the
+// attributes of the event. -verbose and -verbose-modules choose the levels shown: 0
+// errors, 1 warnings and information, 2 debug, 3 and up the trace levels below debug.
+// -log-format plain writes a line with the message and the attributes, text and json
+// the records of log/slog with the module (the source file name) added.
+
+// levelTrace is the level of messages shown from -verbose 3; one level lower needs 4.
+const levelTrace = slog.LevelDebug - 1
+
+var logger = slog.New(verboseHandler{})
+
+var logOutput io.Writer = os.Stdout
+var logHandler slog.Handler // nil for -log-format plain
+
+// setupLogging opens -log-file and creates the handler of -log-format. The standard
+// logger (log.Fatal and friends) is sent the same way.
+func setupLogging() {
+	if *f_logFile != "" {
+		f, err := os.OpenFile(*f_logFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			log.Fatal("Cannot open the log file: " + err.Error())
+		}
+		logOutput = f
+	}
+	opts := &slog.HandlerOptions{Level: slog.Level(-100)} // Filtered by verboseness instead
+	switch *f_logFormat {
+	case "plain":
+	case "text":
+		logHandler = slog.NewTextHandler(logOutput, opts)
+	case "json":
+		logHandler = slog.NewJSONHandler(logOutput, opts)
+	default:
+		log.Fatal("Invalid -log-format: " + *f_logFormat)
+	}
+	if logHandler != nil || *f_logFile != "" {
+		if logHandler != nil {
+			log.SetFlags(0)
+		}
+		log.SetOutput(logWriter{})
+	}
+}
+
+// writeStdLog writes a message of the standard logger: as an error record, or as it is
+// to the log file or standard error.
+func writeStdLog(p []byte) (int, error) {
+	if logHandler != nil {
+		r := slog.NewRecord(time.Now(), slog.LevelError, strings.TrimSpace(string(p)), 0)
+		return len(p), logHandler.Handle(context.Background(), r)
+	}
+	if *f_logFile != "" {
+		return logOutput.Write(p)
+	}
+	return os.Stderr.Write(p)
+}
+
+// moduleVerbosity parses -verbose-modules, e.g. "download=3,api=0".
+func moduleVerbosity(spec string) (map[string]uint, error) {
+	if spec == "" {
+		return nil, nil
+	}
+	levels := map[string]uint{}
+	for _, entry := range strings.Split(spec, ",") {
+		parts := strings.SplitN(strings.TrimSpace(entry), "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid -verbose-modules entry: %s", entry)
+		}
+		level, err := strconv.ParseUint(parts[1], 10, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -verbose-modules entry: %s", entry)
+		}
+		levels[parts[0]] = uint(level)
+	}
+	return levels, nil
+}
+
+// verbosity returns the verboseness from which messages of a level are shown.
+func verbosity(level slog.Level) uint {
+	switch {
+	case level >= slog.LevelError:
+		return 0
+	case level >= slog.LevelInfo:
+		return 1
+	case level >= slog.LevelDebug:
+		return 2
+	}
+	return 2 + uint(slog.LevelDebug-level)
+}
+
+// recordModule returns the source file name, without .go, of the code that logged.
+func recordModule(pc uintptr) string {
+	frame, _ := runtime.CallersFrames([]uintptr{pc}).Next()
+	return strings.TrimSuffix(filepath.Base(frame.File), ".go")
+}
+
+// verboseHandler filters records by the verboseness of their module and writes them in
+// -log-format and to syslog. Groups are not used by ip2asn and are flattened.
+type verboseHandler struct {
+	attrs []slog.Attr
+}
+
+func (h verboseHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return verbosity(level) <= currentSettings().maxVerbose
+}
+
+func (h verboseHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return verboseHandler{append(h.attrs[:len(h.attrs):len(h.attrs)], attrs...)}
+}
+
+func (h verboseHandler) WithGroup(string) slog.Handler {
+	return h
+}
+
+func (h verboseHandler) Handle(ctx context.Context, r slog.Record) error {
+	s := currentSettings()
+	level, module := verbosity(r.Level), ""
+	if s.moduleVerbosity != nil || logHandler != nil {
+		module = recordModule(r.PC)
+	}
+	limit, ok := s.moduleVerbosity[module]
+	if !ok {
+		limit = s.verbose
+	}
+	if level > limit {
+		return nil
+	}
+
+	var err error
+	if logHandler != nil {
+		r = r.Clone()
+		r.AddAttrs(h.attrs...)
+		r.AddAttrs(slog.String("module", module))
+		err = logHandler.Handle(ctx, r)
+	}
+	if logHandler == nil || sysLogger != nil {
+		line := h.plainLine(r)
+		if logHandler == nil {
+			_, err = io.WriteString(logOutput, line)
+		}
+		if sysLogger != nil {
+			sysLogger.send(syslogSeverity(r.Level), level, line)
+		}
+	}
+	return err
+}
+
+// plainLine formats a record for -log-format plain: the message after "Error: ",
+// "Warning: " or "DEBUG: " for trace levels, then the attributes as key=value.
+func (h verboseHandler) plainLine(r slog.Record) string {
+	var b strings.Builder
+	switch {
+	case r.Level >= slog.LevelError:
+		b.WriteString("Error: ")
+	case r.Level >= slog.LevelWarn:
+		b.WriteString("Warning: ")
+	case r.Level < slog.LevelDebug:
+		b.WriteString("DEBUG: ")
+	}
+	b.WriteString(r.Message)
+	attr := func(a slog.Attr) bool {
+		value := a.Value.Resolve().String()
+		if value == "" || strings.ContainsAny(value, " \t\"=") {
+			value = strconv.Quote(value)
+		}
+		fmt.Fprintf(&b, " %s=%s", a.Key, value)
+		return true
+	}
+	for _, a := range h.attrs {
+		attr(a)
+	}
+	r.Attrs(attr)
+	b.WriteByte('\n')
+	return b.String()
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+)
+
+// TestVerboseHandler logs at every level with -verbose 1 and a module override, and
+// checks what the plain format writes.
+func TestVerboseHandler(t *testing.T) {
+	var buf bytes.Buffer
+	defer func(w io.Writer) {
+		logOutput = w
+		activeSettings.Store(nil)
+	}(logOutput)
+	logOutput = &buf
+
+	tests := []struct {
+		name    string
+		modules map[string]uint
+		want    string
+	}{
+		{"verbose 1", nil, "Error: failed err=boom\nWarning: retrying delay=1s\nImported count=3 file=\"a b.txt\"\n"},
+		{"module at 3", map[string]uint{"logging_test": 3}, "Error: failed err=boom\nWarning: retrying delay=1s\n" +
+			"Imported count=3 file=\"a b.txt\"\nParsed header\nDEBUG: Record start=1.0.0.0\n"},
+		{"module at 0", map[string]uint{"logging_test": 0}, "Error: failed err=boom\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &settings{verbose: 1, maxVerbose: 1, moduleVerbosity: tt.modules}
+			for _, level := range tt.modules {
+				if level > s.maxVerbose {
+					s.maxVerbose = level
+				}
+			}
+			activeSettings.Store(s)
+			buf.Reset()
+			logger.Error("failed", "err", "boom")
+			logger.Warn("retrying", "delay", "1s")
+			logger.Info("Imported", "count", 3, "file", "a b.txt")
+			logger.Debug("Parsed header")
+			logger.Log(context.Background(), levelTrace, "Record", "start", "1.0.0.0")
+			logger.Log(context.Background(), levelTrace-1, "Record fields")
+			if buf.String() != tt.want {
+				t.Errorf("logged\n%s\nwant\n%s", buf.String(), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// lookupAnswer is what the database knows about an address or ASN.
+type lookupAnswer struct {
+	Query    string      `json:"query"`
+	Registry string      `json:"registry,omitempty"`
+	CC       string      `json:"cc,omitempty"`
+	Type     string      `json:"type,omitempty"`
+	Start    string      `json:"start,omitempty"`
+	Value    uint64      `json:"value,omitempty"`
+	Date     string      `json:"date,omitempty"`
+	Status   string      `json:"status,omitempty"`
+	Holder   string      `json:"holder,omitempty"`
+	ASN      string      `json:"asn,omitempty"` // origin of the covering BGP route, for addresses
+	ASName   string      `json:"as_name,omitempty"`
+	ASOrg    string      `json:"as_org,omitempty"`
+	Route    string      `json:"route,omitempty"`
+	RPKI     string      `json:"rpki,omitempty"` // validity of the route, when VRPs are imported
+	Tags     []string    `json:"tags,omitempty"`
+	RDAP     *rdapAnswer `json:"rdap,omitempty"` // live answer of the registry, with -live or without a delegation
+}
+
+// lookupIndexThreshold is the number of arguments from which lookup loads the lookup
+// table first instead of querying per address.
+const lookupIndexThreshold = 100
+
+// lookupCommand implements "lookup [-format table|csv|json] [-as-of DATE] ADDRESS|ASN...",
+// printing the registry, country, ASN, allocation date and status of each argument.
+func lookupCommand(db *sql.DB, args []string) {
+	runLookups(args, db, func(n int, asOf string) func(q string) (lookupAnswer, error) {
+		if asOf != "" {
+			return func(q string) (lookupAnswer, error) { return lookupAsOf(db, q, asOf) }
+		}
+		var table *lookupTable
+		if n >= lookupIndexThreshold {
+			var err error
+			if table, err = loadLookupTable(db); err != nil {
+				logger.Warn("Cannot load the lookup table; querying per address", "err", err)
+			}
+		}
+		return func(q string) (lookupAnswer, error) { return indexedLookup(db, table, q) }
+	})
+}
+
+// runLookups implements the lookup command; newQuery returns the function answering each
+// of n arguments, on the -as-of date (YYYY-MM-DD) when it is set. Queries without a
+// delegation, or all with -live, are also asked from RDAP; rdapCache is the database
+// with the RdapCache table, or nil.
+func runLookups(args []string, rdapCache *sql.DB, newQuery func(n int, asOf string) func(q string) (lookupAnswer, error)) {
+	fs := flag.NewFlagSet("lookup", flag.ExitOnError)
+	format := fs.String("format", "table", "Output format: table, csv or json")
+	asOf := fs.String("as-of", "", "Answer from the datasets in effect on this date (YYYYMMDD or YYYY-MM-DD); BGP routes are left out")
+	live := fs.Bool("live", false, "Also ask the registry's RDAP service about queries with a delegation")
+	noRDAP := fs.Bool("no-rdap", false, "Do not ask RDAP about queries without a delegation")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		log.Fatal("Usage: lookup [-format table|csv|json] [-as-of DATE] [-live|-no-rdap] ADDRESS|ASN...")
+	}
+	if *asOf != "" && *live {
+		log.Fatal("-live answers for the present; it cannot be combined with -as-of")
+	}
+	if *asOf != "" {
+		date, err := parseAsOf(*asOf)
+		if err != nil {
+			log.Fatal(err)
+		}
+		*asOf = date
+	}
+
+	query := newQuery(fs.NArg(), *asOf)
+	ctx := shutdownContext()
+	var list []lookupAnswer
+	for _, q := range fs.Args() {
+		a, err := query(q)
+		if *asOf == "" && (*live && err == nil || err == sql.ErrNoRows && !*noRDAP) {
+			if r, rerr := lookupRDAP(ctx, rdapCache, a.Registry, q); rerr != nil {
+				logger.Warn("RDAP lookup failed", "query", q, "err", rerr)
+			} else {
+				mergeRDAP(&a, r)
+				if err == sql.ErrNoRows && a.Start != "" {
+					err = nil
+				}
+			}
+		}
+		if err == sql.ErrNoRows {
+			logger.Warn("No delegation found", "query", q)
+		} else if err != nil {
+			log.Fatal(err)
+		}
+		list = append(list, a)
+	}
+	rows := make([][]string, 0, len(list))
+	for _, a := range list {
+		value := ""
+		if a.Registry != "" {
+			value = strconv.FormatUint(a.Value, 10)
+		}
+		rows = append(rows, []string{a.Query, a.Registry, a.CC, a.ASN, a.ASName, a.Type, a.Start, value, a.Date, a.Status,
+			a.Holder, strings.Join(a.Tags, ","), a.RPKI, rdapHandle(a.RDAP)})
+	}
+	writeReport(*format, []string{"query", "registry", "cc", "asn", "as_name", "type", "start", "value", "date", "status",
+		"holder", "tags", "rpki", "rdap"}, rows, list)
+}
+
+// rdapHandle is the rdap column of lookup: the handle and name of the live answer.
+func rdapHandle(r *rdapAnswer) string {
+	if r == nil {
+		return ""
+	}
+	return strings.TrimSpace(r.Handle + " " + r.Name)
+}
+
+// lookupQuery answers an address (the current allocation or assignment containing it, as
+// the lookup table does, and the origin of its BGP route) or an ASN ("AS64500" or
+// "64500", the delegation of the ASN block). It returns sql.ErrNoRows with the partial
+// answer when no delegation contains the query.
+func lookupQuery(db *sql.DB, q string) (lookupAnswer, error) {
+	a := lookupAnswer{Query: q}
+	if asn, err := strconv.ParseUint(strings.TrimPrefix(strings.ToUpper(q), "AS"), 10, 32); err == nil {
+		a.ASN = strconv.FormatUint(asn, 10)
+		n := asNameRow(db, a.ASN)
+		a.ASName, a.ASOrg = n.Name, n.Org
+		a.Tags, _ = tagsAt(db, netip.Addr{}, a.ASN)
+		var date sql.NullString
+		err := db.QueryRow(`SELECT ID_Registries, CC, RecordType, Start, Value, RecordDate, State, Holder FROM LatestAllocations
+			WHERE RecordType = 'asn' AND CAST(Start AS UNSIGNED) <= ? AND CAST(Start AS UNSIGNED) + Value > ? LIMIT 1;`,
+			asn, asn).Scan(&a.Registry, &a.CC, &a.Type, &a.Start, &a.Value, &date, &a.Status, &a.Holder)
+		a.Date = date.String
+		return a, err
+	}
+
+	addr, err := netip.ParseAddr(q)
+	if err != nil {
+		return a, fmt.Errorf("not an address or ASN: %s", q)
+	}
+	addr = addr.Unmap()
+	if route, err := routeValidity(db, addr.String()); err == nil {
+		a.Route, a.ASN, a.RPKI = route["route"], route["origin_as"], route["rpki"]
+		n := asNameRow(db, strings.SplitN(a.ASN, "_", 2)[0])
+		a.ASName, a.ASOrg = n.Name, n.Org
+	}
+	a.Tags, _ = tagsAt(db, addr, a.ASN)
+	err = recordAt(db, addr, &a)
+	return a, err
+}
+
+// currentDelegations restricts Records_* r to the allocations and assignments of the
+// latest dataset of each registry: the rows of LatestAllocations that the lookup table
+// indexes, so that lookups answer the same with and without it.
+const currentDelegations = ` JOIN (SELECT d.ID FROM Datasets d JOIN (SELECT ID_Registries, MAX(serial) AS serial FROM Datasets
+	GROUP BY ID_Registries) m USING (ID_Registries, serial)) l ON l.ID = r.ID_LastDatasets
+	WHERE r.State IN ` + delegatedStates
+
+// delegatedStates are the statuses of delegations that lookups answer with.
+const delegatedStates = "('allocated', 'assigned')"
+
+// recordAt fills in the current delegation containing addr.
+func recordAt(db *sql.DB, addr netip.Addr, a *lookupAnswer) error {
+	var date, opaque sql.NullString
+	if addr.Is4() {
+		err := db.QueryRow(`SELECT r.ID_Registries, r.CC, INET_NTOA(r.FirstIP), r.HostCount, r.RecordDate, r.State, r.OpaqueID
+			FROM Records_ipv4 r`+currentDelegations+` AND r.FirstIP <= INET_ATON(?) AND r.LastIP >= INET_ATON(?)
+			ORDER BY r.FirstIP DESC LIMIT 1;`, addr.String(), addr.String()).Scan(
+			&a.Registry, &a.CC, &a.Start, &a.Value, &date, &a.Status, &opaque)
+		if err == nil {
+			a.Type, a.Date, a.Holder = "ipv4", date.String, holder(opaque.String)
+		}
+		return err
+	}
+	err := db.QueryRow(`SELECT r.ID_Registries, r.CC, INET6_NTOA(r.FirstIP), r.PrefixLen, r.RecordDate, r.State, r.OpaqueID
+		FROM Records_ipv6 r`+currentDelegations+` AND r.FirstIP <= INET6_ATON(?) AND r.LastIP >= INET6_ATON(?)
+		ORDER BY r.FirstIP DESC, r.PrefixLen DESC LIMIT 1;`, addr.String(), addr.String()).Scan(
+		&a.Registry, &a.CC, &a.Start, &a.Value, &date, &a.Status, &opaque)
+	if err == nil {
+		a.Type, a.Date, a.Holder = "ipv6", date.String, holder(opaque.String)
+	}
+	return err
+}
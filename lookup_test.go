@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+)
+
+// TestLookupPaths answers the same addresses with the lookup table and with queries per
+// address, around a reserved block and a delegation removed by the latest dataset. It
+// needs a MySQL server, as TestMigrateBaselineSchema.
+func TestLookupPaths(t *testing.T) {
+	if os.Getenv("IP2ASN_TEST_MYSQL") == "" {
+		t.Skip("set IP2ASN_TEST_MYSQL=1 and the MYSQL_* variables to test against a MySQL server")
+	}
+	db := testSchema(t, "ip2asn_test_lookup", schemaStatements())
+	for _, s := range []string{
+		`INSERT INTO Datasets (ID, ID_Registries, serial, version, records, UTCoffset) VALUES
+			(1, 'ripencc', 1, '2', 4, 0), (2, 'ripencc', 2, '2', 3, 0);`,
+		`INSERT INTO Records_ipv4 (ID_Datasets, ID_Registries, CC, FirstIP, HostCount, RecordDate, State, OpaqueID,
+			ID_LastDatasets, LastIP) VALUES
+			(1, 'ripencc', 'NL', INET_ATON('100.64.0.0'), 65536, '2020-01-01', 'allocated', 'org-a', 2, INET_ATON('100.64.255.255')),
+			(1, 'ripencc', 'NL', INET_ATON('100.64.2.0'), 128, '2020-01-01', 'reserved', '', 2, INET_ATON('100.64.2.127')),
+			(1, 'ripencc', 'DE', INET_ATON('100.65.100.0'), 256, '2020-01-01', 'assigned', 'org-b', 1, INET_ATON('100.65.100.255'));`,
+		`INSERT INTO Records_ipv6 (ID_Datasets, ID_Registries, CC, FirstIP, PrefixLen, RecordDate, State, OpaqueID,
+			ID_LastDatasets, LastIP) VALUES
+			(1, 'ripencc', 'NL', INET6_ATON('2001:db8::'), 32, '2020-01-01', 'allocated', 'org-a', 2,
+				INET6_ATON('2001:db8:ffff:ffff:ffff:ffff:ffff:ffff')),
+			(1, 'ripencc', 'NL', INET6_ATON('2001:db8::'), 48, '2020-01-01', 'available', '', 2,
+				INET6_ATON('2001:db8:0:ffff:ffff:ffff:ffff:ffff'));`,
+		`INSERT INTO LatestAllocations (ID_Registries, RecordType, Start, Value, CC, RecordDate, State, Holder, ID_Datasets) VALUES
+			('ripencc', 'ipv4', '100.64.0.0', 65536, 'NL', '2020-01-01', 'allocated', 'org-a', 1),
+			('ripencc', 'ipv4', '100.64.2.0', 128, 'NL', '2020-01-01', 'reserved', '', 1),
+			('ripencc', 'ipv6', '2001:db8::', 32, 'NL', '2020-01-01', 'allocated', 'org-a', 1),
+			('ripencc', 'ipv6', '2001:db8::', 48, 'NL', '2020-01-01', 'available', '', 1);`,
+	} {
+		if _, err := db.Exec(s); err != nil {
+			t.Fatalf("%s: %v", s, err)
+		}
+	}
+	// More-specific reserved prefixes in front of the allocation than a fixed number of
+	// candidate rows would skip.
+	for i := 1; i <= 100; i++ {
+		if _, err := db.Exec(`INSERT INTO Records_ipv6 (ID_Datasets, ID_Registries, CC, FirstIP, PrefixLen, RecordDate, State,
+			OpaqueID, ID_LastDatasets, LastIP) VALUES (1, 'ripencc', 'NL', INET6_ATON(?), 48, '2020-01-01', 'reserved', '', 2,
+			INET6_ATON(?));`, fmt.Sprintf("2001:db8:%x::", i), fmt.Sprintf("2001:db8:%x:ffff:ffff:ffff:ffff:ffff", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	table, err := loadLookupTable(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		query string
+		start string // of the delegation found; "" for none
+	}{
+		{"100.64.2.10", "100.64.0.0"},      // In a reserved block of an allocation
+		{"100.64.3.1", "100.64.0.0"},       // In the allocation only
+		{"100.65.100.1", ""},               // Assignment removed by the latest dataset
+		{"2001:db8::1", "2001:db8::"},      // In an available /48 of an allocation
+		{"2001:db8:ff00::1", "2001:db8::"}, // Behind the reserved /48s
+		{"100.66.0.1", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			direct, derr := indexedLookup(db, nil, tt.query)
+			indexed, ierr := indexedLookup(db, table, tt.query)
+			if derr != ierr {
+				t.Fatalf("errors: %v per address, %v with the lookup table", derr, ierr)
+			}
+			if direct.Start != tt.start {
+				t.Errorf("delegation %q; want %q", direct.Start, tt.start)
+			}
+			if len(direct.Tags) == 0 && len(indexed.Tags) == 0 {
+				direct.Tags, indexed.Tags = nil, nil
+			}
+			if !reflect.DeepEqual(direct, indexed) {
+				t.Errorf("per address:\n  %+v\nwith the lookup table:\n  %+v", direct, indexed)
+			}
+		})
+	}
+}
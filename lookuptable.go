@@ -0,0 +1,222 @@
+package main
+
+import (
+	"database/sql"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+)
+
+// delegationRange is a current allocation or assignment.
+type delegationRange struct {
+	registry string
+	cc       string
+	kind     string
+	start    string
+	value    uint64
+	date     string
+	status   string
+	holder   string
+}
+
+// lookupResult is what the lookup table knows about an address.
+type lookupResult struct {
+	ASN      string   `json:"asn,omitempty"`
+	Route    string   `json:"route,omitempty"`
+	RPKI     string   `json:"rpki,omitempty"`
+	Registry string   `json:"registry,omitempty"`
+	CC       string   `json:"cc,omitempty"`
+	Holder   string   `json:"holder,omitempty"`
+	Tags     []string `json:"tags,omitempty"`
+}
+
+// lookupTable answers address lookups from memory, for bulk annotation and the index of
+// lookup and serve: prefix tries of the delegations and of the BGP routes.
+type lookupTable struct {
+	delegations []delegationRange
+	byPrefix    prefixTrie // index into delegations
+	origins     []string
+	routes      prefixTrie // index into origins
+	vrps        *vrpTable  // nil without VRPs
+	names       map[string]string
+	tags        *tagOverlay
+	loaded      time.Time
+}
+
+// loadLookupTable reads the current delegations, the BGP prefixes and the VRPs.
+func loadLookupTable(db *sql.DB) (*lookupTable, error) {
+	t := &lookupTable{loaded: time.Now()}
+	rows, err := db.Query(`SELECT ID_Registries, CC, RecordType, Start, Value, IFNULL(RecordDate, ''), State, Holder
+		FROM LatestAllocations WHERE RecordType <> 'asn' AND State IN ` + delegatedStates + `;`)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var d delegationRange
+		if err := rows.Scan(&d.registry, &d.cc, &d.kind, &d.start, &d.value, &d.date, &d.status, &d.holder); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		prefixes, err := recordPrefixes(d.kind, d.start, d.value)
+		if err != nil || len(prefixes) == 0 {
+			continue
+		}
+		t.delegations = append(t.delegations, d)
+		for _, p := range prefixes {
+			t.byPrefix.insert(p, len(t.delegations)-1)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if t.origins, err = loadRoutes(db, &t.routes); err != nil {
+		return nil, err
+	}
+	if t.vrps, err = loadVRPTable(db); err != nil {
+		return nil, err
+	}
+	if t.names, err = loadASNames(db); err != nil {
+		return nil, err
+	}
+	if t.tags, err = loadTagOverlay(db); err != nil {
+		return nil, err
+	}
+	logger.Debug("Loaded lookup table", "delegations", len(t.delegations), "routes", t.routes.size,
+		"duration", time.Since(t.loaded).Round(time.Millisecond))
+	return t, nil
+}
+
+// loadRoutes adds the BGP prefixes to routes and returns their origins, indexed by the
+// trie values. Without BgpPrefixes there are none.
+func loadRoutes(db *sql.DB, routes *prefixTrie) ([]string, error) {
+	rows, err := db.Query("SELECT Prefix, OriginAS FROM BgpPrefixes;")
+	if isMissingTable(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var origins []string
+	for rows.Next() {
+		var prefix, origin string
+		if err := rows.Scan(&prefix, &origin); err != nil {
+			return nil, err
+		}
+		p, err := netip.ParsePrefix(prefix)
+		if err != nil {
+			continue
+		}
+		origins = append(origins, origin)
+		routes.insert(p, len(origins)-1)
+	}
+	return origins, rows.Err()
+}
+
+// lookup returns the most specific BGP route, the delegation containing an address and
+// the tags of the address and its origin.
+func (t *lookupTable) lookup(addr netip.Addr) lookupResult {
+	var r lookupResult
+	addr = addr.Unmap()
+	if i, p, ok := t.routes.match(addr); ok {
+		r.ASN, r.Route, r.RPKI = t.origins[i], p.String(), t.vrps.status(p, t.origins[i])
+	}
+	if i, _, ok := t.byPrefix.match(addr); ok {
+		d := t.delegations[i]
+		r.Registry, r.CC, r.Holder = d.registry, d.cc, d.holder
+	}
+	r.Tags = t.tags.match(addr, addr, r.ASN)
+	return r
+}
+
+// answer is lookupQuery for an address answered from memory. Only allocations and
+// assignments are indexed, so other addresses return sql.ErrNoRows.
+func (t *lookupTable) answer(q string, addr netip.Addr) (lookupAnswer, error) {
+	a := lookupAnswer{Query: q}
+	addr = addr.Unmap()
+	if i, p, ok := t.routes.match(addr); ok {
+		a.ASN, a.Route, a.RPKI = t.origins[i], p.String(), t.vrps.status(p, t.origins[i])
+		a.ASName = t.names[strings.SplitN(a.ASN, "_", 2)[0]]
+	}
+	a.Tags = t.tags.match(addr, addr, a.ASN)
+	i, _, ok := t.byPrefix.match(addr)
+	if !ok {
+		return a, sql.ErrNoRows
+	}
+	d := t.delegations[i]
+	a.Registry, a.CC, a.Type, a.Start, a.Value = d.registry, d.cc, d.kind, d.start, d.value
+	a.Date, a.Status, a.Holder = d.date, d.status, d.holder
+	return a, nil
+}
+
+// indexedLookup answers addresses from t and everything else, or everything when t is
+// nil, with lookupQuery.
+func indexedLookup(db *sql.DB, t *lookupTable, q string) (lookupAnswer, error) {
+	if t != nil {
+		if addr, err := netip.ParseAddr(q); err == nil {
+			return t.answer(q, addr)
+		}
+	}
+	return lookupQuery(db, q)
+}
+
+// lookupIndexes are the lookup tables of the namespaces served, loaded on first use and
+// refreshed every -reload-interval.
+var lookupIndexes = struct {
+	sync.Mutex
+	tables  map[string]*lookupTable
+	loading map[string]bool
+}{tables: map[string]*lookupTable{}, loading: map[string]bool{}}
+
+// lookupIndex returns the lookup table of a namespace, or nil while it is being loaded
+// in the background.
+func lookupIndex(db *sql.DB, ns string) *lookupTable {
+	lookupIndexes.Lock()
+	defer lookupIndexes.Unlock()
+	t, ok := lookupIndexes.tables[ns]
+	if !ok && !lookupIndexes.loading[ns] {
+		lookupIndexes.loading[ns] = true
+		go reloadLookupIndex(db, ns)
+	}
+	return t
+}
+
+// reloadLookupIndex replaces the lookup table of a namespace; on failure the previous
+// one stays in use.
+func reloadLookupIndex(db *sql.DB, ns string) {
+	t, err := loadLookupTable(db)
+	lookupIndexes.Lock()
+	defer lookupIndexes.Unlock()
+	delete(lookupIndexes.loading, ns)
+	if err != nil {
+		logger.Warn("Cannot load the lookup index", "namespace", ns, "err", err)
+		return
+	}
+	lookupIndexes.tables[ns] = t
+}
+
+// watchLookupIndexes reloads the lookup table of every namespace in use every
+// -reload-interval.
+func watchLookupIndexes() {
+	if *f_reloadInterval <= 0 {
+		return
+	}
+	for range time.Tick(*f_reloadInterval) {
+		lookupIndexes.Lock()
+		var list []string
+		for ns := range lookupIndexes.tables {
+			list = append(list, ns)
+		}
+		lookupIndexes.Unlock()
+		for _, ns := range list {
+			db, err := namespaceDB(ns)
+			if err != nil {
+				logger.Warn("Cannot reload the lookup index", "namespace", ns, "err", err)
+				continue
+			}
+			reloadLookupIndex(db, ns)
+		}
+	}
+}
@@ -0,0 +1,12 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+// TestMain defines the flags, so that the tests see their defaults.
+func TestMain(m *testing.M) {
+	defineFlags()
+	os.Exit(m.Run())
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// histogram counts observations into buckets by upper bound.
+type histogram struct {
+	bounds []float64
+	counts []uint64 // per bucket, the last one above all bounds
+	sum    float64
+	count  uint64
+}
+
+func newHistogram(bounds ...float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]uint64, len(bounds)+1)}
+}
+
+func (h *histogram) observe(v float64) {
+	i := sort.SearchFloat64s(h.bounds, v)
+	h.counts[i]++
+	h.sum += v
+	h.count++
+}
+
+var importDurationBounds = []float64{1, 5, 15, 30, 60, 120, 300, 600, 1800}
+var lookupDurationBounds = []float64{0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1}
+
+// processMetrics are the counters of this process since it started, exposed on /metrics
+// next to the gauges queried from the database. Keys are the label values.
+var processMetrics = struct {
+	sync.Mutex
+	imports        map[[2]string]uint64 // registry, status
+	records        map[[2]string]uint64 // registry, type
+	invalid        map[string]uint64
+	importDuration map[string]*histogram
+	lastSuccess    map[string]uint64 // Unix time
+	dbErrors       map[string]uint64 // operation
+	lookups        map[string]uint64 // result
+	lookupDuration *histogram
+}{
+	imports: map[[2]string]uint64{}, records: map[[2]string]uint64{}, invalid: map[string]uint64{},
+	importDuration: map[string]*histogram{}, lastSuccess: map[string]uint64{}, dbErrors: map[string]uint64{},
+	lookups: map[string]uint64{}, lookupDuration: newHistogram(lookupDurationBounds...),
+}
+
+// countImport records a finished import attempt.
+func countImport(result ImportResult) {
+	m := &processMetrics
+	m.Lock()
+	defer m.Unlock()
+	m.imports[[2]string{result.Registry, result.Status}]++
+	for _, k := range []string{"asn", "ipv4", "ipv6"} {
+		m.records[[2]string{result.Registry, k}] += result.Counts[k]
+	}
+	m.invalid[result.Registry] += result.Counts["invalid"]
+	if m.importDuration[result.Registry] == nil {
+		m.importDuration[result.Registry] = newHistogram(importDurationBounds...)
+	}
+	m.importDuration[result.Registry].observe(result.Duration)
+	if result.Status == "success" {
+		m.lastSuccess[result.Registry] = uint64(time.Now().Unix())
+	}
+}
+
+// countLookup records a lookup served over HTTP or gRPC, also as a StatsD timing: found,
+// not_found or error.
+func countLookup(result string, d time.Duration) {
+	m := &processMetrics
+	m.Lock()
+	m.lookups[result]++
+	m.lookupDuration.observe(d.Seconds())
+	m.Unlock()
+	stats.timing("lookup.duration", d, "result:"+result)
+}
+
+// countDBError records err if it came from the database, for an operation such as
+// import or lookup.
+func countDBError(op string, err error) {
+	var mysqlErr *mysql.MySQLError
+	if !errors.As(err, &mysqlErr) && !errors.Is(err, driver.ErrBadConn) && !errors.Is(err, sql.ErrConnDone) &&
+		!errors.Is(err, mysql.ErrInvalidConn) {
+		return
+	}
+	processMetrics.Lock()
+	processMetrics.dbErrors[op]++
+	processMetrics.Unlock()
+}
+
+// writeHistogram writes the series of a histogram; labels are without braces.
+func writeHistogram(w io.Writer, name, labels string, h *histogram) {
+	if labels != "" {
+		labels += ","
+	}
+	var cumulative uint64
+	for i, bound := range h.bounds {
+		cumulative += h.counts[i]
+		fmt.Fprintf(w, "%s_bucket{%sle=\"%g\"} %d\n", name, labels, bound, cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket{%sle=\"+Inf\"} %d\n", name, labels, h.count)
+	labels = strings.TrimSuffix(labels, ",")
+	if labels != "" {
+		labels = "{" + labels + "}"
+	}
+	fmt.Fprintf(w, "%s_sum%s %g\n", name, labels, h.sum)
+	fmt.Fprintf(w, "%s_count%s %d\n", name, labels, h.count)
+}
+
+// writeProcessMetrics writes the processMetrics, sorted by labels.
+func writeProcessMetrics(w io.Writer) {
+	m := &processMetrics
+	m.Lock()
+	defer m.Unlock()
+	sorted2 := func(keys map[[2]string]uint64) [][2]string {
+		list := make([][2]string, 0, len(keys))
+		for k := range keys {
+			list = append(list, k)
+		}
+		sort.Slice(list, func(i, j int) bool {
+			return list[i][0] < list[j][0] || list[i][0] == list[j][0] && list[i][1] < list[j][1]
+		})
+		return list
+	}
+	sorted := func(keys map[string]uint64) []string {
+		list := make([]string, 0, len(keys))
+		for k := range keys {
+			list = append(list, k)
+		}
+		sort.Strings(list)
+		return list
+	}
+
+	fmt.Fprintln(w, "# HELP ip2asn_imports_total Import attempts by registry and status.")
+	fmt.Fprintln(w, "# TYPE ip2asn_imports_total counter")
+	for _, k := range sorted2(m.imports) {
+		fmt.Fprintf(w, "ip2asn_imports_total{registry=%q,status=%q} %d\n", k[0], k[1], m.imports[k])
+	}
+	fmt.Fprintln(w, "# HELP ip2asn_records_imported_total Records read from imported datasets by registry and type.")
+	fmt.Fprintln(w, "# TYPE ip2asn_records_imported_total counter")
+	for _, k := range sorted2(m.records) {
+		fmt.Fprintf(w, "ip2asn_records_imported_total{registry=%q,type=%q} %d\n", k[0], k[1], m.records[k])
+	}
+	fmt.Fprintln(w, "# HELP ip2asn_invalid_records_total Invalid lines in imported datasets by registry.")
+	fmt.Fprintln(w, "# TYPE ip2asn_invalid_records_total counter")
+	for _, k := range sorted(m.invalid) {
+		fmt.Fprintf(w, "ip2asn_invalid_records_total{registry=%q} %d\n", k, m.invalid[k])
+	}
+	fmt.Fprintln(w, "# HELP ip2asn_import_duration_seconds Duration of import attempts by registry.")
+	fmt.Fprintln(w, "# TYPE ip2asn_import_duration_seconds histogram")
+	registries := make([]string, 0, len(m.importDuration))
+	for k := range m.importDuration {
+		registries = append(registries, k)
+	}
+	sort.Strings(registries)
+	for _, k := range registries {
+		writeHistogram(w, "ip2asn_import_duration_seconds", fmt.Sprintf("registry=%q", k), m.importDuration[k])
+	}
+	fmt.Fprintln(w, "# HELP ip2asn_last_successful_import_timestamp_seconds Time of the last successful import by registry.")
+	fmt.Fprintln(w, "# TYPE ip2asn_last_successful_import_timestamp_seconds gauge")
+	for _, k := range sorted(m.lastSuccess) {
+		fmt.Fprintf(w, "ip2asn_last_successful_import_timestamp_seconds{registry=%q} %d\n", k, m.lastSuccess[k])
+	}
+	fmt.Fprintln(w, "# HELP ip2asn_db_errors_total Database errors by operation.")
+	fmt.Fprintln(w, "# TYPE ip2asn_db_errors_total counter")
+	for _, k := range sorted(m.dbErrors) {
+		fmt.Fprintf(w, "ip2asn_db_errors_total{op=%q} %d\n", k, m.dbErrors[k])
+	}
+	fmt.Fprintln(w, "# HELP ip2asn_lookups_total Lookups served over HTTP by result.")
+	fmt.Fprintln(w, "# TYPE ip2asn_lookups_total counter")
+	for _, k := range []string{"found", "not_found", "error"} {
+		fmt.Fprintf(w, "ip2asn_lookups_total{result=%q} %d\n", k, m.lookups[k])
+	}
+	fmt.Fprintln(w, "# HELP ip2asn_lookup_duration_seconds Duration of lookups served over HTTP.")
+	fmt.Fprintln(w, "# TYPE ip2asn_lookup_duration_seconds histogram")
+	writeHistogram(w, "ip2asn_lookup_duration_seconds", "", m.lookupDuration)
+}
+
+// handleMetrics exposes the process counters and the dataset gauges in the Prometheus
+// text format.
+func handleMetrics(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	list, err := freshness(db)
+	if err != nil {
+		countDBError("metrics", err)
+		http.Error(w, "cannot query datasets: "+err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	writeProcessMetrics(w)
+	fmt.Fprintln(w, "# HELP ip2asn_dataset_age_seconds Age of the latest dataset per registry.")
+	fmt.Fprintln(w, "# TYPE ip2asn_dataset_age_seconds gauge")
+	for _, f := range list {
+		fmt.Fprintf(w, "ip2asn_dataset_age_seconds{registry=%q} %.0f\n", f.Registry, f.Age.Seconds())
+	}
+	fmt.Fprintln(w, "# HELP ip2asn_dataset_stale_threshold_seconds Configured staleness threshold per registry.")
+	fmt.Fprintln(w, "# TYPE ip2asn_dataset_stale_threshold_seconds gauge")
+	for _, f := range list {
+		fmt.Fprintf(w, "ip2asn_dataset_stale_threshold_seconds{registry=%q} %.0f\n", f.Registry, f.Threshold.Seconds())
+	}
+	fmt.Fprintln(w, "# HELP ip2asn_dataset_stale Whether the latest dataset is older than the threshold (1) or not (0).")
+	fmt.Fprintln(w, "# TYPE ip2asn_dataset_stale gauge")
+	for _, f := range list {
+		stale := 0
+		if f.Stale {
+			stale = 1
+		}
+		fmt.Fprintf(w, "ip2asn_dataset_stale{registry=%q} %d\n", f.Registry, stale)
+	}
+}
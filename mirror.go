@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"hash"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+// mirrorWriter saves a fetched file under -mirror-dir as <host>/YYYY/MM/DD/<name>, using
+// the UTC fetch date, while it is being read. A second fetch with different content on
+// the same day gets a time suffix; identical content is not stored twice.
+type mirrorWriter struct {
+	source string
+	target string
+	tmp    *os.File
+	sum    hash.Hash
+}
+
+// newMirrorWriter returns nil when -mirror-dir is not set or the file cannot be mirrored.
+func newMirrorWriter(source string) *mirrorWriter {
+	if *f_mirrorDir == "" {
+		return nil
+	}
+	u, err := url.Parse(source)
+	if err != nil || u.Host == "" {
+		logger.Warn("Cannot mirror: not a URL", "source", source)
+		return nil
+	}
+	now := time.Now().UTC()
+	name := path.Base(u.Path)
+	if name == "/" || name == "." {
+		name = "index"
+	}
+	dir := filepath.Join(*f_mirrorDir, u.Host, now.Format("2006"), now.Format("01"), now.Format("02"))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		logger.Warn("Cannot mirror", "source", source, "err", err)
+		return nil
+	}
+	m := &mirrorWriter{source: source, target: filepath.Join(dir, name), sum: sha256.New()}
+
+	// Write to a temporary name first so readers of the mirror never see partial files
+	if m.tmp, err = os.CreateTemp(dir, name+".*.tmp"); err != nil {
+		logger.Warn("Cannot mirror", "source", source, "err", err)
+		return nil
+	}
+	return m
+}
+
+// Write never fails, so a full mirror disk does not abort the import; the copy is
+// dropped instead.
+func (m *mirrorWriter) Write(p []byte) (int, error) {
+	if m.tmp != nil {
+		m.sum.Write(p)
+		if _, err := m.tmp.Write(p); err != nil {
+			logger.Warn("Cannot mirror", "source", m.source, "err", err)
+			m.discard()
+		}
+	}
+	return len(p), nil
+}
+
+func (m *mirrorWriter) discard() {
+	m.tmp.Close()
+	os.Remove(m.tmp.Name())
+	m.tmp = nil
+}
+
+// finish renames a completely read file into place, or drops an incomplete one.
+func (m *mirrorWriter) finish(complete bool) {
+	if m == nil || m.tmp == nil {
+		return
+	}
+	if !complete {
+		m.discard()
+		return
+	}
+	target := m.target
+	if existing, err := os.Open(target); err == nil {
+		h := sha256.New()
+		io.Copy(h, existing)
+		existing.Close()
+		if bytes.Equal(h.Sum(nil), m.sum.Sum(nil)) {
+			logger.Debug("Mirror copy is up to date", "file", target)
+			m.discard()
+			return
+		}
+		target += "." + time.Now().UTC().Format("150405")
+	}
+	name := m.tmp.Name()
+	if err := m.tmp.Close(); err != nil {
+		os.Remove(name)
+		logger.Warn("Cannot mirror", "source", m.source, "err", err)
+		return
+	}
+	os.Chmod(name, 0644)
+	if err := os.Rename(name, target); err != nil {
+		os.Remove(name)
+		logger.Warn("Cannot mirror", "source", m.source, "err", err)
+		return
+	}
+	logger.Info("Mirrored", "source", m.source, "file", target)
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// mmdbRecord is what an MMDB file stores for a network.
+type mmdbRecord struct {
+	registry, cc   string
+	status, holder string
+	asn            uint32
+	hasASN         bool
+	asName         string
+}
+
+// data returns the record in the layout of the GeoLite2 Country and ASN databases
+// combined, with the registry, status and holder added.
+func (r *mmdbRecord) data() map[string]interface{} {
+	data := map[string]interface{}{}
+	if r.cc != "" {
+		country := map[string]interface{}{"iso_code": r.cc}
+		data["country"], data["registered_country"] = country, country
+	}
+	if r.registry != "" {
+		data["registry"], data["status"] = r.registry, r.status
+	}
+	if r.holder != "" {
+		data["holder"] = r.holder
+	}
+	if r.hasASN {
+		data["autonomous_system_number"] = r.asn
+	}
+	if r.asName != "" {
+		data["autonomous_system_organization"] = r.asName
+	}
+	return data
+}
+
+// mmdbNode is a node of the binary tree of an MMDB file while it is built. A node
+// without children is a network; rec is nil for space without a record.
+type mmdbNode struct {
+	children [2]*mmdbNode
+	rec      *mmdbRecord
+}
+
+// mmdbTree is an IPv6 MaxMind DB search tree with IPv4 mapped into ::/96, as readers
+// expect of a database with ip_version 6.
+type mmdbTree struct {
+	root mmdbNode
+}
+
+// set calls update on the record of every network within p, splitting the network that
+// contains p. Inserting prefixes shortest first lets more specific ones win.
+func (t *mmdbTree) set(p netip.Prefix, update func(r *mmdbRecord)) {
+	addr, bits := p.Masked().Addr(), p.Bits()
+	if addr.Is4() {
+		var a [16]byte
+		a4 := addr.As4()
+		copy(a[12:], a4[:])
+		addr, bits = netip.AddrFrom16(a), bits+96
+	}
+	n := &t.root
+	for i := 0; i < bits; i++ {
+		if n.children[0] == nil {
+			n.children[0], n.children[1] = &mmdbNode{rec: copyMMDBRecord(n.rec)}, &mmdbNode{rec: copyMMDBRecord(n.rec)}
+			n.rec = nil
+		}
+		n = n.children[bitAt(addr, i)]
+	}
+	n.walk(update)
+}
+
+// walk calls update on the record of every network below n.
+func (n *mmdbNode) walk(update func(r *mmdbRecord)) {
+	if n.children[0] != nil {
+		n.children[0].walk(update)
+		n.children[1].walk(update)
+		return
+	}
+	if n.rec == nil {
+		n.rec = &mmdbRecord{}
+	}
+	update(n.rec)
+}
+
+func copyMMDBRecord(r *mmdbRecord) *mmdbRecord {
+	if r == nil {
+		return nil
+	}
+	c := *r
+	return &c
+}
+
+// write serializes the tree with 32 bit records, the data section with identical records
+// stored once, and the metadata.
+func (t *mmdbTree) write(w io.Writer, databaseType, description string) error {
+	// Number the inner nodes breadth first; the root is node 0
+	var nodes []*mmdbNode
+	index := map[*mmdbNode]uint32{}
+	if t.root.children[0] == nil { // An empty tree still needs a root node
+		t.root.children[0], t.root.children[1] = &mmdbNode{}, &mmdbNode{}
+	}
+	queue := []*mmdbNode{&t.root}
+	for len(queue) > 0 {
+		n := queue[0]
+		queue = queue[1:]
+		index[n] = uint32(len(nodes))
+		nodes = append(nodes, n)
+		for _, c := range n.children {
+			if c.children[0] != nil {
+				queue = append(queue, c)
+			}
+		}
+	}
+
+	var data bytes.Buffer
+	offsets := map[string]uint32{}
+	nodeCount := uint64(len(nodes))
+	record := func(c *mmdbNode) (uint32, error) {
+		if c.children[0] != nil {
+			return index[c], nil
+		}
+		if c.rec == nil {
+			return uint32(nodeCount), nil // No data
+		}
+		var enc bytes.Buffer
+		encodeMMDB(&enc, c.rec.data())
+		offset, ok := offsets[enc.String()]
+		if !ok {
+			offset = uint32(data.Len())
+			offsets[enc.String()] = offset
+			data.Write(enc.Bytes())
+		}
+		if v := nodeCount + 16 + uint64(offset); v <= math.MaxUint32 {
+			return uint32(v), nil
+		}
+		return 0, fmt.Errorf("mmdb: too large for 32 bit records")
+	}
+
+	bw := bufio.NewWriter(w)
+	var buf [8]byte
+	for _, n := range nodes {
+		left, err := record(n.children[0])
+		if err != nil {
+			return err
+		}
+		right, err := record(n.children[1])
+		if err != nil {
+			return err
+		}
+		binary.BigEndian.PutUint32(buf[:4], left)
+		binary.BigEndian.PutUint32(buf[4:], right)
+		bw.Write(buf[:])
+	}
+	bw.Write(make([]byte, 16)) // Data section separator
+	bw.Write(data.Bytes())
+	bw.WriteString("\xab\xcd\xefMaxMind.com")
+	encodeMMDB(bw, map[string]interface{}{
+		"binary_format_major_version": uint16(2),
+		"binary_format_minor_version": uint16(0),
+		"build_epoch":                 uint64(time.Now().Unix()),
+		"database_type":               databaseType,
+		"description":                 map[string]interface{}{"en": description},
+		"ip_version":                  uint16(6),
+		"languages":                   []string{"en"},
+		"node_count":                  uint32(nodeCount),
+		"record_size":                 uint16(32),
+	})
+	return bw.Flush()
+}
+
+// mmdbControl writes the control byte and size of a field of an MMDB data section.
+func mmdbControl(w io.ByteWriter, kind byte, size int) {
+	first := kind << 5
+	if kind > 7 { // Extended type
+		first = 0
+	}
+	switch {
+	case size < 29:
+		w.WriteByte(first | byte(size))
+		if kind > 7 {
+			w.WriteByte(kind - 7)
+		}
+	case size < 285:
+		w.WriteByte(first | 29)
+		if kind > 7 {
+			w.WriteByte(kind - 7)
+		}
+		w.WriteByte(byte(size - 29))
+	case size < 65821:
+		w.WriteByte(first | 30)
+		if kind > 7 {
+			w.WriteByte(kind - 7)
+		}
+		size -= 285
+		w.WriteByte(byte(size >> 8))
+		w.WriteByte(byte(size))
+	default:
+		w.WriteByte(first | 31)
+		if kind > 7 {
+			w.WriteByte(kind - 7)
+		}
+		size -= 65821
+		w.WriteByte(byte(size >> 16))
+		w.WriteByte(byte(size >> 8))
+		w.WriteByte(byte(size))
+	}
+}
+
+// encodeMMDB writes a value of the types used in the data section and the metadata;
+// map keys are written sorted so that equal records encode equally.
+func encodeMMDB(w interface {
+	io.Writer
+	io.ByteWriter
+}, v interface{}) {
+	writeUint := func(kind byte, u uint64) {
+		var b [8]byte
+		binary.BigEndian.PutUint64(b[:], u)
+		n := 0
+		for n < 8 && b[n] == 0 {
+			n++
+		}
+		mmdbControl(w, kind, 8-n)
+		w.Write(b[n:])
+	}
+	switch v := v.(type) {
+	case string:
+		mmdbControl(w, 2, len(v))
+		io.WriteString(w, v)
+	case uint16:
+		writeUint(5, uint64(v))
+	case uint32:
+		writeUint(6, uint64(v))
+	case uint64:
+		writeUint(9, v)
+	case []string:
+		mmdbControl(w, 11, len(v))
+		for _, s := range v {
+			encodeMMDB(w, s)
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		mmdbControl(w, 7, len(keys))
+		for _, k := range keys {
+			encodeMMDB(w, k)
+			encodeMMDB(w, v[k])
+		}
+	default:
+		panic(fmt.Sprintf("mmdb: cannot encode %T", v))
+	}
+}
+
+// exportMMDB writes the current allocations and assignments with their country and
+// registry, and the origin AS of the BGP routes with its name, in the layout of the
+// GeoLite2 Country and ASN databases combined.
+func exportMMDB(db *sql.DB, w io.Writer) error {
+	type network struct {
+		prefix          netip.Prefix
+		registry, cc    string
+		status, holder  string
+		origin          uint64
+		name            string
+		isRoute, hasASN bool
+	}
+	var list []network
+	rows, err := db.Query(`SELECT ID_Registries, CC, RecordType, Start, Value, State, Holder FROM LatestAllocations
+		WHERE RecordType <> 'asn' AND State IN ('allocated', 'assigned');`)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var registry, cc, kind, start, status, holderID string
+		var value uint64
+		if err := rows.Scan(&registry, &cc, &kind, &start, &value, &status, &holderID); err != nil {
+			rows.Close()
+			return err
+		}
+		prefixes, err := recordPrefixes(kind, start, value)
+		if err != nil {
+			continue
+		}
+		for _, p := range prefixes {
+			list = append(list, network{prefix: p, registry: registry, cc: cc, status: status, holder: holderID})
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	names, err := loadASNames(db)
+	if err != nil {
+		return err
+	}
+	rows, err = db.Query("SELECT Prefix, OriginAS FROM BgpPrefixes;")
+	if err != nil && !isMissingTable(err) {
+		return err
+	}
+	if err == nil {
+		defer rows.Close()
+		for rows.Next() {
+			var prefix, origin string
+			if err := rows.Scan(&prefix, &origin); err != nil {
+				return err
+			}
+			p, err := netip.ParsePrefix(prefix)
+			if err != nil {
+				continue
+			}
+			// Multiple origins are joined by _ or , in prefix2as files; the first is kept
+			first := strings.FieldsFunc(origin, func(r rune) bool { return r == '_' || r == ',' })
+			if len(first) == 0 {
+				continue
+			}
+			asn, err := strconv.ParseUint(first[0], 10, 32)
+			list = append(list, network{prefix: p, isRoute: true, origin: asn, name: names[first[0]], hasASN: err == nil})
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+	}
+
+	// Delegations and routes set different keys, each shortest prefix first
+	sort.SliceStable(list, func(i, j int) bool {
+		if list[i].isRoute != list[j].isRoute {
+			return !list[i].isRoute
+		}
+		return list[i].prefix.Bits() < list[j].prefix.Bits()
+	})
+	var tree mmdbTree
+	for _, n := range list {
+		n := n
+		if n.isRoute {
+			tree.set(n.prefix, func(r *mmdbRecord) {
+				r.asn, r.hasASN, r.asName = uint32(n.origin), n.hasASN, n.name
+			})
+			continue
+		}
+		tree.set(n.prefix, func(r *mmdbRecord) {
+			r.registry, r.cc, r.status, r.holder = n.registry, n.cc, n.status, n.holder
+		})
+	}
+	logger.Debug("MMDB networks from delegations and routes", "networks", len(list))
+	return tree.write(w, "ip2asn-RIR-ASN-Country", "Delegations of the regional Internet registries with BGP origin AS")
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"text/tabwriter"
+)
+
+// Each tenant namespace is a separate schema with the full set of tables, so teams
+// keep their own datasets and filters. API keys live in the base schema and map a
+// key to the namespace its requests are served from.
+
+var namespaceRe = regexp.MustCompile(`^[a-z0-9_]{0,32}$`)
+
+func validNamespace(ns string) bool {
+	return namespaceRe.MatchString(ns)
+}
+
+// namespaceSchema returns the database schema holding a namespace's data.
+func namespaceSchema(ns string) string {
+	base := dbSetting("MYSQL_DBNAME", "name", "ip2asn")
+	if ns == "" {
+		return base
+	}
+	return base + "_" + ns
+}
+
+var namespaceDBs = struct {
+	sync.Mutex
+	m map[string]*sql.DB
+}{m: make(map[string]*sql.DB)}
+
+// namespaceDB returns a shared connection pool for the namespace, opening it on first use.
+func namespaceDB(ns string) (*sql.DB, error) {
+	namespaceDBs.Lock()
+	defer namespaceDBs.Unlock()
+	if db, ok := namespaceDBs.m[ns]; ok {
+		return db, nil
+	}
+	db, err := openDB(namespaceSchema(ns))
+	if err != nil {
+		return nil, err
+	}
+	namespaceDBs.m[ns] = db
+	return db, nil
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// apiKeyNamespace resolves an API key to its namespace.
+func apiKeyNamespace(key string) (string, bool, error) {
+	control, err := namespaceDB("")
+	if err != nil {
+		return "", false, err
+	}
+	var ns string
+	err = control.QueryRow("SELECT Namespace FROM ApiKeys WHERE KeyHash = ?;", hashAPIKey(key)).Scan(&ns)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	return ns, err == nil, err
+}
+
+// withNamespace authenticates the request's API key (X-API-Key or Authorization: Bearer)
+// and calls h with the database of the key's namespace. Requests without a key use the
+// process namespace unless -require-api-key is set.
+func withNamespace(h func(db *sql.DB, ns string, w http.ResponseWriter, r *http.Request)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("X-API-Key")
+		if key == "" {
+			key = strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		}
+
+		ns := *f_namespace
+		if key != "" {
+			found, ok, err := apiKeyNamespace(key)
+			if err != nil {
+				http.Error(w, "cannot verify API key", http.StatusServiceUnavailable)
+				return
+			}
+			if !ok {
+				http.Error(w, "invalid API key", http.StatusUnauthorized)
+				return
+			}
+			ns = found
+		} else if *f_requireAPIKey {
+			http.Error(w, "API key required", http.StatusUnauthorized)
+			return
+		}
+
+		db, err := namespaceDB(ns)
+		if err != nil {
+			http.Error(w, "namespace unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		h(db, ns, w, r)
+	}
+}
+
+// apiKeysCommand implements "apikeys create NAMESPACE [COMMENT]", "apikeys list" and
+// "apikeys revoke ID". Keys are always stored in the base schema.
+func apiKeysCommand(args []string) {
+	if len(args) == 0 {
+		log.Fatal("Usage: apikeys create NAMESPACE [COMMENT] | apikeys list | apikeys revoke ID")
+	}
+	control, err := namespaceDB("")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	switch args[0] {
+	case "create":
+		if len(args) < 2 || !validNamespace(args[1]) {
+			log.Fatal("Usage: apikeys create NAMESPACE [COMMENT]")
+		}
+		buf := make([]byte, 24)
+		if _, err := rand.Read(buf); err != nil {
+			log.Fatal(err)
+		}
+		key := hex.EncodeToString(buf)
+		_, err := control.Exec("INSERT INTO ApiKeys VALUES (DEFAULT, ?, ?, ?, NOW());",
+			hashAPIKey(key), args[1], strings.Join(args[2:], " "))
+		if err != nil {
+			log.Fatal(err)
+		}
+		auditLog(control, "apikey.create", args[1], 0)
+		fmt.Println(key) // Only shown once; the database keeps a hash
+	case "list":
+		rows, err := control.Query("SELECT ID, Namespace, Comment, Created FROM ApiKeys ORDER BY Namespace, ID;")
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer rows.Close()
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tNAMESPACE\tCREATED\tCOMMENT")
+		for rows.Next() {
+			var id int64
+			var ns, comment, created string
+			if err := rows.Scan(&id, &ns, &comment, &created); err != nil {
+				log.Fatal(err)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", id, ns, created, comment)
+		}
+		w.Flush()
+	case "revoke":
+		if len(args) < 2 {
+			log.Fatal("Usage: apikeys revoke ID")
+		}
+		res, err := control.Exec("DELETE FROM ApiKeys WHERE ID = ?;", args[1])
+		if err != nil {
+			log.Fatal(err)
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			log.Fatal("No such API key: " + args[1])
+		}
+		auditLog(control, "apikey.revoke", args[1], 0)
+	default:
+		log.Fatal("Unknown apikeys command: " + args[0])
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+
+	"github.com/nats-io/nats.go"
+)
+
+// natsSink publishes dataset lifecycle events as JSON to <prefix>.<event> subjects,
+// e.g. ip2asn.import.finished. Individual records and changes are not published.
+type natsSink struct {
+	conn *nats.Conn
+}
+
+func newNATSSink() *natsSink {
+	conn, err := nats.Connect(*f_natsURL, nats.Name("ip2asn"), nats.MaxReconnects(-1))
+	if err != nil {
+		log.Fatal("Cannot connect to NATS: " + err.Error())
+	}
+	logger.Debug("Publishing events to NATS", "url", conn.ConnectedUrl())
+	return &natsSink{conn: conn}
+}
+
+func (n *natsSink) publishDataset(ev DatasetEvent) {
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return
+	}
+	if err := n.conn.Publish(*f_natsSubjectPrefix+"."+ev.Event, data); err != nil {
+		logger.Warn("NATS publish failed", "err", err)
+	}
+}
+
+func (n *natsSink) publishRecord(ev RecordEvent) {}
+
+func (n *natsSink) publishChange(ev ChangeEvent) {}
+
+func (n *natsSink) close() {
+	if err := n.conn.Drain(); err != nil {
+		n.conn.Close()
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"database/sql"
+	"flag"
+	"io"
+	"log"
+	"os"
+	"strings"
+)
+
+// orgObject is an organisation object of a whois database dump.
+type orgObject struct {
+	Name string
+	CC   string
+}
+
+// orgsCommand implements "orgs import -registry NAME FILE..." which maps opaque IDs to
+// organisations. The files are whois database dumps in RPSL form (RIPE style split
+// files such as ripe.db.organisation.gz and ripe.db.inetnum.gz) or ARIN bulk whois
+// text; gzip compressed files are accepted. An opaque ID is resolved when one of its
+// resources starts exactly where a whois object that references an organisation starts.
+func orgsCommand(db *sql.DB, args []string) {
+	if len(args) == 0 || args[0] != "import" {
+		log.Fatal("Usage: orgs import -registry NAME FILE...")
+	}
+	fs := flag.NewFlagSet("orgs import", flag.ExitOnError)
+	registry := fs.String("registry", "", "Registry whose opaque IDs are resolved")
+	fs.Parse(args[1:])
+	if *registry == "" || fs.NArg() == 0 {
+		log.Fatal("Usage: orgs import -registry NAME FILE...")
+	}
+
+	// Resources with an opaque ID, keyed like the whois objects: "type|start"
+	holders := map[string]string{}
+	rows, err := db.Query("SELECT RecordType, Start, Holder FROM Resources WHERE ID_Registries = ? AND Holder <> '';", *registry)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for rows.Next() {
+		var kind, start, holderID string
+		if err := rows.Scan(&kind, &start, &holderID); err != nil {
+			log.Fatal(err)
+		}
+		holders[kind+"|"+start] = holderID
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		log.Fatal(err)
+	}
+
+	orgs := map[string]orgObject{}
+	links := map[string]string{} // opaque ID to organisation handle
+	for _, file := range fs.Args() {
+		logger.Info("Reading whois objects", "file", file)
+		err := readWhoisObjects(file, func(obj map[string]string, class string) {
+			switch class {
+			case "organisation", "orgid":
+				orgs[obj[class]] = orgObject{Name: firstOf(obj, "org-name", "orgname"), CC: strings.ToUpper(obj["country"])}
+				return
+			}
+			handle := firstOf(obj, "org", "orgid")
+			if handle == "" {
+				return
+			}
+			if key := whoisResourceKey(obj, class); key != "" {
+				if holderID, ok := holders[key]; ok {
+					links[holderID] = handle
+				}
+			}
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	stmt, err := db.Prepare("REPLACE INTO Orgs VALUES (?, ?, ?, ?, ?, NOW());")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer stmt.Close()
+	var resolved int
+	for holderID, handle := range links {
+		org, ok := orgs[handle]
+		if !ok {
+			continue
+		}
+		if len(org.CC) != 2 {
+			org.CC = ""
+		}
+		if _, err := stmt.Exec(*registry, holderID, handle, org.Name, org.CC); err != nil {
+			log.Fatal(err)
+		}
+		resolved++
+	}
+	auditLog(db, "orgs", *registry, 0)
+	logger.Info("Resolved opaque IDs", "resolved", resolved, "holders", len(uniqueValues(holders)),
+		"organisations", len(orgs))
+}
+
+// readWhoisObjects calls fn for every object of a whois dump with its attributes keyed
+// by lower case name (first occurrence wins) and its class, the first attribute name.
+func readWhoisObjects(file string, fn func(obj map[string]string, class string)) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var r io.Reader = bufio.NewReader(f)
+	if magic, _ := r.(*bufio.Reader).Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		r = zr
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	obj := map[string]string{}
+	class := ""
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			if class != "" {
+				fn(obj, class)
+			}
+			obj, class = map[string]string{}, ""
+			continue
+		}
+		if line[0] == '#' || line[0] == '%' || line[0] == ' ' || line[0] == '\t' || line[0] == '+' {
+			continue // Comments and continuation lines
+		}
+		i := strings.IndexByte(line, ':')
+		if i <= 0 {
+			continue
+		}
+		key := strings.ToLower(line[:i])
+		if class == "" {
+			class = key
+		}
+		if _, ok := obj[key]; !ok {
+			obj[key] = strings.TrimSpace(line[i+1:])
+		}
+	}
+	if class != "" {
+		fn(obj, class)
+	}
+	return scanner.Err()
+}
+
+// whoisResourceKey returns "type|start" for aut-num, inetnum and inet6num objects and
+// their ARIN equivalents, matching the RecordType and Start columns of Resources.
+func whoisResourceKey(obj map[string]string, class string) string {
+	switch class {
+	case "aut-num", "ashandle":
+		asn := strings.TrimPrefix(strings.ToUpper(firstOf(obj, "asnumber", "aut-num", "ashandle")), "AS")
+		if i := strings.IndexAny(asn, " -"); i > 0 { // ARIN ranges: "64496 - 64511"
+			asn = asn[:i]
+		}
+		return "asn|" + asn
+	case "inetnum", "inet6num", "nethandle":
+		r := firstOf(obj, "netrange", "inetnum", "inet6num")
+		start := strings.TrimSpace(strings.SplitN(strings.SplitN(r, "-", 2)[0], "/", 2)[0])
+		if strings.Contains(start, ":") {
+			return "ipv6|" + normalizeAddr(start)
+		}
+		return "ipv4|" + normalizeAddr(start)
+	}
+	return ""
+}
+
+// firstOf returns the first non-empty attribute of the given names.
+func firstOf(obj map[string]string, keys ...string) string {
+	for _, k := range keys {
+		if v := obj[k]; v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func uniqueValues(m map[string]string) map[string]bool {
+	u := map[string]bool{}
+	for _, v := range m {
+		u[v] = true
+	}
+	return u
+}
+
+// orgName returns the organisation name and country of an opaque ID, if resolved.
+func orgName(db *sql.DB, registry, holderID string) (name, cc string) {
+	err := db.QueryRow("SELECT Name, CC FROM Orgs WHERE ID_Registries = ? AND OpaqueID = ?;", registry, holderID).Scan(&name, &cc)
+	if err != nil && err != sql.ErrNoRows {
+		logger.Debug("Cannot resolve holder", "holder", holderID, "err", err)
+	}
+	return name, cc
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"net/netip"
+	"sort"
+	"time"
+)
+
+// attribution is the registry, country and status a block is delegated with.
+type attribution struct {
+	Registry string `json:"registry"`
+	CC       string `json:"cc"`
+	Status   string `json:"status"`
+}
+
+// overlap is address space that the latest datasets of two registries both list, mostly
+// early registration transfer (ERX) and legacy space.
+type overlap struct {
+	Start netip.Addr  `json:"start"`
+	End   netip.Addr  `json:"end"`
+	A     attribution `json:"a"`
+	B     attribution `json:"b"`
+}
+
+// findOverlaps sweeps the blocks of LatestAllocations in address order and returns the
+// intersections of blocks from different registries.
+func findOverlaps(db *sql.DB) ([]overlap, error) {
+	type block struct {
+		start, end netip.Addr
+		attribution
+	}
+	rows, err := db.Query("SELECT ID_Registries, RecordType, Start, Value, CC, State FROM LatestAllocations WHERE RecordType <> 'asn';")
+	if err != nil {
+		return nil, err
+	}
+	var blocks []block
+	for rows.Next() {
+		var b block
+		var kind, start string
+		var value uint64
+		if err := rows.Scan(&b.Registry, &kind, &start, &value, &b.CC, &b.Status); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		prefixes, err := recordPrefixes(kind, start, value)
+		if err != nil || len(prefixes) == 0 {
+			continue
+		}
+		b.start, b.end = prefixes[0].Addr(), lastAddr(prefixes[len(prefixes)-1])
+		blocks = append(blocks, b)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	sort.Slice(blocks, func(i, j int) bool { return blocks[i].start.Less(blocks[j].start) })
+
+	var list []overlap
+	var open []block // Blocks that may still overlap the next one
+	for _, b := range blocks {
+		kept := open[:0]
+		for _, o := range open {
+			if o.end.BitLen() != b.start.BitLen() || o.end.Less(b.start) {
+				continue
+			}
+			kept = append(kept, o)
+			if o.Registry == b.Registry {
+				continue
+			}
+			end := o.end
+			if b.end.Less(end) {
+				end = b.end
+			}
+			list = append(list, overlap{Start: b.start, End: end, A: o.attribution, B: b.attribution})
+		}
+		open = append(kept, b)
+	}
+	return list, nil
+}
+
+// scanOverlaps records the current overlaps in the Overlaps table. Rows keep the time
+// the overlap was first seen; overlaps no longer found are removed.
+func scanOverlaps(db *sql.DB) error {
+	list, err := findOverlaps(db)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC().Format("2006-01-02 15:04:05")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.Prepare(`INSERT INTO Overlaps VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE CC = VALUES(CC), State = VALUES(State), OtherCC = VALUES(OtherCC),
+		OtherState = VALUES(OtherState), LastSeen = VALUES(LastSeen);`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, o := range list {
+		family := 4
+		if o.Start.Is6() {
+			family = 6
+		}
+		// Store each pair once, ordered by registry
+		a, b := o.A, o.B
+		if b.Registry < a.Registry {
+			a, b = b, a
+		}
+		if _, err := stmt.Exec(family, o.Start.AsSlice(), o.End.AsSlice(), a.Registry, a.CC, a.Status,
+			b.Registry, b.CC, b.Status, now, now); err != nil {
+			return fmt.Errorf("saving overlap: %w", err)
+		}
+	}
+	if _, err := tx.Exec("DELETE FROM Overlaps WHERE LastSeen < ?;", now); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	logger.Debug("Found address ranges listed by more than one registry", "ranges", len(list))
+	return nil
+}
+
+// overlapsAt returns the attributions of the recorded overlaps containing an address.
+func overlapsAt(db *sql.DB, addr netip.Addr) ([]attribution, error) {
+	family := 4
+	if addr.Is6() {
+		family = 6
+	}
+	rows, err := db.Query(`SELECT ID_Registries, CC, State, OtherRegistry, OtherCC, OtherState FROM Overlaps
+		WHERE Family = ? AND StartIP <= ? AND EndIP >= ?;`, family, addr.AsSlice(), addr.AsSlice())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []attribution
+	seen := map[attribution]bool{}
+	for rows.Next() {
+		var a, b attribution
+		if err := rows.Scan(&a.Registry, &a.CC, &a.Status, &b.Registry, &b.CC, &b.Status); err != nil {
+			return nil, err
+		}
+		for _, x := range []attribution{a, b} {
+			if !seen[x] {
+				seen[x] = true
+				list = append(list, x)
+			}
+		}
+	}
+	return list, rows.Err()
+}
+
+// overlapsCommand implements "overlaps scan" and "overlaps list [-registry NAME] [-format F]".
+func overlapsCommand(db *sql.DB, args []string) {
+	usage := "Usage: overlaps scan | overlaps list [-registry NAME] [-format table|csv|json]"
+	if len(args) == 0 {
+		log.Fatal(usage)
+	}
+	switch args[0] {
+	case "scan":
+		if err := scanOverlaps(db); err != nil {
+			log.Fatal(err)
+		}
+	case "list":
+		fs := flag.NewFlagSet("overlaps list", flag.ExitOnError)
+		registry := fs.String("registry", "", "Only overlaps involving this registry")
+		format := fs.String("format", "table", "Output format: table, csv or json")
+		fs.Parse(args[1:])
+		rows, err := db.Query(`SELECT StartIP, EndIP, ID_Registries, CC, State, OtherRegistry, OtherCC, OtherState, FirstSeen
+			FROM Overlaps WHERE ? = '' OR ID_Registries = ? OR OtherRegistry = ? ORDER BY Family, StartIP;`,
+			*registry, *registry, *registry)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer rows.Close()
+
+		type listed struct {
+			overlap
+			FirstSeen string `json:"first_seen"`
+		}
+		list := []listed{}
+		var table [][]string
+		for rows.Next() {
+			var o listed
+			var start, end []byte
+			if err := rows.Scan(&start, &end, &o.A.Registry, &o.A.CC, &o.A.Status, &o.B.Registry, &o.B.CC, &o.B.Status, &o.FirstSeen); err != nil {
+				log.Fatal(err)
+			}
+			o.Start, _ = netip.AddrFromSlice(start)
+			o.End, _ = netip.AddrFromSlice(end)
+			list = append(list, o)
+			table = append(table, []string{o.Start.String(), o.End.String(), o.A.Registry, o.A.CC, o.A.Status,
+				o.B.Registry, o.B.CC, o.B.Status, o.FirstSeen})
+		}
+		if err := rows.Err(); err != nil {
+			log.Fatal(err)
+		}
+		writeReport(*format, []string{"start", "end", "registry", "cc", "status", "other_registry", "other_cc", "other_status", "first_seen"}, table, list)
+	default:
+		log.Fatal(usage)
+	}
+}
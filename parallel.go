@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// allRegistries are imported by -source all.
+var allRegistries = []string{"afrinic", "apnic", "arin", "lacnic", "ripencc"}
+
+// importAllRegistries downloads and imports the latest dataset of every registry not
+// disabled in the config file, up to -concurrency at a time, and returns the result of
+// each attempt. A failing registry does not stop the others; the returned error names
+// every registry that failed.
+func importAllRegistries(ctx context.Context, st Store) ([]ImportResult, error) {
+	var registries []string
+	for _, reg := range allRegistries {
+		if registryEnabled(reg) {
+			registries = append(registries, reg)
+		} else {
+			logger.Debug("Skipping registry disabled in the config file", "registry", reg)
+		}
+	}
+	workers := *f_concurrency
+	if workers > len(registries) {
+		workers = len(registries)
+	}
+	if workers < 1 {
+		workers = 1
+	}
+
+	queue := make(chan string)
+	var mu sync.Mutex
+	var failed []string
+	var results []ImportResult
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for reg := range queue {
+				result, err := importRegistry(ctx, st, reg)
+				mu.Lock()
+				results = append(results, result)
+				if err != nil && ctx.Err() == nil {
+					logger.Warn("Import failed", "registry", reg, "err", err)
+					failed = append(failed, reg+": "+err.Error())
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, reg := range registries {
+		select {
+		case queue <- reg:
+		case <-ctx.Done():
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		return results, fmt.Errorf("%d of %d registries failed: %s", len(failed), len(registries), strings.Join(failed, "; "))
+	}
+	return results, nil
+}
+
+// importRegistry imports the latest dataset of one registry and reports the outcome.
+func importRegistry(ctx context.Context, st Store, registry string) (ImportResult, error) {
+	failed := ImportResult{Registry: registry, Status: "failure"}
+	if ctx.Err() != nil {
+		return failed, ctx.Err()
+	}
+	urls, err := lookupRegistryURLs(st, registry)
+	if err != nil {
+		failed.Error = err.Error()
+		return failed, err
+	}
+	logger.Info("Processing", "registry", registry)
+	result, err := importData(ctx, st, urls[0], *f_force, func(ctx context.Context) (io.ReadCloser, error) {
+		return downloadIfChanged(ctx, st, urls, *f_force)
+	})
+	if err != nil {
+		return result, err
+	}
+	if !*f_mirrorOnly && result.Status == "success" {
+		logger.Info("Imported serial", "registry", registry, "serial", result.Serial,
+			"records", result.Counts["all"], "duration_seconds", result.Duration)
+	}
+	return result, nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// createPIDFile writes our PID to -pidfile and holds an exclusive lock on it for the
+// lifetime of the process, so a second instance on the same host refuses to start.
+// The returned function removes the file.
+func createPIDFile() func() {
+	if *f_pidfile == "" {
+		return func() {}
+	}
+	file, err := os.OpenFile(*f_pidfile, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		log.Fatal("Cannot open PID file: " + err.Error())
+	}
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		buf := make([]byte, 32)
+		n, _ := file.Read(buf)
+		pid := strings.TrimSpace(string(buf[:n]))
+		log.Fatalf("Another instance is already running (PID %s, lock %s).", pid, *f_pidfile)
+	}
+
+	// Locked; replace any stale content with our PID
+	if err := file.Truncate(0); err == nil {
+		_, err = file.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+	if err != nil {
+		log.Fatal("Cannot write PID file: " + err.Error())
+	}
+	logger.Log(context.Background(), levelTrace, "Wrote PID file", "file", *f_pidfile)
+
+	return func() {
+		os.Remove(*f_pidfile)
+		file.Close() // Releases the lock
+	}
+}
@@ -0,0 +1,646 @@
+// The gRPC lookup service of ip2asn, served on -grpc-listen next to the REST API. Like
+// the REST API it answers from the namespace of the API key in the "x-api-key" or
+// "authorization: Bearer" metadata, or from the process namespace.
+//
+// Regenerate the Go code after changes with
+//
+//	protoc --go_out=. --go_opt=paths=source_relative \
+//	    --go-grpc_out=. --go-grpc_opt=paths=source_relative pkg/lookuppb/lookup.proto
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.12
+// 	protoc        (unknown)
+// source: pkg/lookuppb/lookup.proto
+
+package lookuppb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type LookupIPRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Address       string                 `protobuf:"bytes,1,opt,name=address,proto3" json:"address,omitempty"` // IPv4 or IPv6
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *LookupIPRequest) Reset() {
+	*x = LookupIPRequest{}
+	mi := &file_pkg_lookuppb_lookup_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LookupIPRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LookupIPRequest) ProtoMessage() {}
+
+func (x *LookupIPRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_pkg_lookuppb_lookup_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LookupIPRequest.ProtoReflect.Descriptor instead.
+func (*LookupIPRequest) Descriptor() ([]byte, []int) {
+	return file_pkg_lookuppb_lookup_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *LookupIPRequest) GetAddress() string {
+	if x != nil {
+		return x.Address
+	}
+	return ""
+}
+
+type LookupASNRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Asn           uint32                 `protobuf:"varint,1,opt,name=asn,proto3" json:"asn,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *LookupASNRequest) Reset() {
+	*x = LookupASNRequest{}
+	mi := &file_pkg_lookuppb_lookup_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LookupASNRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LookupASNRequest) ProtoMessage() {}
+
+func (x *LookupASNRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_pkg_lookuppb_lookup_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LookupASNRequest.ProtoReflect.Descriptor instead.
+func (*LookupASNRequest) Descriptor() ([]byte, []int) {
+	return file_pkg_lookuppb_lookup_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *LookupASNRequest) GetAsn() uint32 {
+	if x != nil {
+		return x.Asn
+	}
+	return 0
+}
+
+type BatchLookupRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Queries       []string               `protobuf:"bytes,1,rep,name=queries,proto3" json:"queries,omitempty"` // addresses and ASNs ("64500" or "AS64500")
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BatchLookupRequest) Reset() {
+	*x = BatchLookupRequest{}
+	mi := &file_pkg_lookuppb_lookup_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BatchLookupRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BatchLookupRequest) ProtoMessage() {}
+
+func (x *BatchLookupRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_pkg_lookuppb_lookup_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BatchLookupRequest.ProtoReflect.Descriptor instead.
+func (*BatchLookupRequest) Descriptor() ([]byte, []int) {
+	return file_pkg_lookuppb_lookup_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *BatchLookupRequest) GetQueries() []string {
+	if x != nil {
+		return x.Queries
+	}
+	return nil
+}
+
+type LookupReply struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Query         string                 `protobuf:"bytes,1,opt,name=query,proto3" json:"query,omitempty"`
+	Found         bool                   `protobuf:"varint,2,opt,name=found,proto3" json:"found,omitempty"` // whether a delegation contains the query
+	Registry      string                 `protobuf:"bytes,3,opt,name=registry,proto3" json:"registry,omitempty"`
+	Cc            string                 `protobuf:"bytes,4,opt,name=cc,proto3" json:"cc,omitempty"`
+	Type          string                 `protobuf:"bytes,5,opt,name=type,proto3" json:"type,omitempty"` // asn, ipv4 or ipv6
+	Start         string                 `protobuf:"bytes,6,opt,name=start,proto3" json:"start,omitempty"`
+	Value         uint64                 `protobuf:"varint,7,opt,name=value,proto3" json:"value,omitempty"` // addresses for ipv4, prefix length for ipv6, ASNs for asn
+	Date          string                 `protobuf:"bytes,8,opt,name=date,proto3" json:"date,omitempty"`    // of the delegation, YYYY-MM-DD
+	Status        string                 `protobuf:"bytes,9,opt,name=status,proto3" json:"status,omitempty"`
+	Holder        string                 `protobuf:"bytes,10,opt,name=holder,proto3" json:"holder,omitempty"` // opaque ID of the holder in the registry's extended files
+	Asn           string                 `protobuf:"bytes,11,opt,name=asn,proto3" json:"asn,omitempty"`       // for addresses the origin of the covering BGP route
+	AsName        string                 `protobuf:"bytes,12,opt,name=as_name,json=asName,proto3" json:"as_name,omitempty"`
+	AsOrg         string                 `protobuf:"bytes,13,opt,name=as_org,json=asOrg,proto3" json:"as_org,omitempty"`
+	Route         string                 `protobuf:"bytes,14,opt,name=route,proto3" json:"route,omitempty"`
+	Rpki          string                 `protobuf:"bytes,15,opt,name=rpki,proto3" json:"rpki,omitempty"` // valid, invalid or not-found, when VRPs are imported
+	Tags          []string               `protobuf:"bytes,16,rep,name=tags,proto3" json:"tags,omitempty"`
+	Error         string                 `protobuf:"bytes,17,opt,name=error,proto3" json:"error,omitempty"` // set instead of the answer for an invalid query of BatchLookup
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *LookupReply) Reset() {
+	*x = LookupReply{}
+	mi := &file_pkg_lookuppb_lookup_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LookupReply) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LookupReply) ProtoMessage() {}
+
+func (x *LookupReply) ProtoReflect() protoreflect.Message {
+	mi := &file_pkg_lookuppb_lookup_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LookupReply.ProtoReflect.Descriptor instead.
+func (*LookupReply) Descriptor() ([]byte, []int) {
+	return file_pkg_lookuppb_lookup_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *LookupReply) GetQuery() string {
+	if x != nil {
+		return x.Query
+	}
+	return ""
+}
+
+func (x *LookupReply) GetFound() bool {
+	if x != nil {
+		return x.Found
+	}
+	return false
+}
+
+func (x *LookupReply) GetRegistry() string {
+	if x != nil {
+		return x.Registry
+	}
+	return ""
+}
+
+func (x *LookupReply) GetCc() string {
+	if x != nil {
+		return x.Cc
+	}
+	return ""
+}
+
+func (x *LookupReply) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *LookupReply) GetStart() string {
+	if x != nil {
+		return x.Start
+	}
+	return ""
+}
+
+func (x *LookupReply) GetValue() uint64 {
+	if x != nil {
+		return x.Value
+	}
+	return 0
+}
+
+func (x *LookupReply) GetDate() string {
+	if x != nil {
+		return x.Date
+	}
+	return ""
+}
+
+func (x *LookupReply) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *LookupReply) GetHolder() string {
+	if x != nil {
+		return x.Holder
+	}
+	return ""
+}
+
+func (x *LookupReply) GetAsn() string {
+	if x != nil {
+		return x.Asn
+	}
+	return ""
+}
+
+func (x *LookupReply) GetAsName() string {
+	if x != nil {
+		return x.AsName
+	}
+	return ""
+}
+
+func (x *LookupReply) GetAsOrg() string {
+	if x != nil {
+		return x.AsOrg
+	}
+	return ""
+}
+
+func (x *LookupReply) GetRoute() string {
+	if x != nil {
+		return x.Route
+	}
+	return ""
+}
+
+func (x *LookupReply) GetRpki() string {
+	if x != nil {
+		return x.Rpki
+	}
+	return ""
+}
+
+func (x *LookupReply) GetTags() []string {
+	if x != nil {
+		return x.Tags
+	}
+	return nil
+}
+
+func (x *LookupReply) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+type StreamChangesRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	SinceDataset  int64                  `protobuf:"varint,1,opt,name=since_dataset,json=sinceDataset,proto3" json:"since_dataset,omitempty"` // send recorded changes of later datasets first; 0 sends only new ones
+	Registry      string                 `protobuf:"bytes,2,opt,name=registry,proto3" json:"registry,omitempty"`                              // only changes of this registry
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StreamChangesRequest) Reset() {
+	*x = StreamChangesRequest{}
+	mi := &file_pkg_lookuppb_lookup_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StreamChangesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StreamChangesRequest) ProtoMessage() {}
+
+func (x *StreamChangesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_pkg_lookuppb_lookup_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StreamChangesRequest.ProtoReflect.Descriptor instead.
+func (*StreamChangesRequest) Descriptor() ([]byte, []int) {
+	return file_pkg_lookuppb_lookup_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *StreamChangesRequest) GetSinceDataset() int64 {
+	if x != nil {
+		return x.SinceDataset
+	}
+	return 0
+}
+
+func (x *StreamChangesRequest) GetRegistry() string {
+	if x != nil {
+		return x.Registry
+	}
+	return ""
+}
+
+type Change struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Dataset       int64                  `protobuf:"varint,1,opt,name=dataset,proto3" json:"dataset,omitempty"`
+	Registry      string                 `protobuf:"bytes,2,opt,name=registry,proto3" json:"registry,omitempty"`
+	Change        string                 `protobuf:"bytes,3,opt,name=change,proto3" json:"change,omitempty"` // added, removed or changed
+	Type          string                 `protobuf:"bytes,4,opt,name=type,proto3" json:"type,omitempty"`
+	Start         string                 `protobuf:"bytes,5,opt,name=start,proto3" json:"start,omitempty"`
+	Value         uint64                 `protobuf:"varint,6,opt,name=value,proto3" json:"value,omitempty"`
+	Date          string                 `protobuf:"bytes,7,opt,name=date,proto3" json:"date,omitempty"` // of the change, YYYY-MM-DD
+	OldCc         string                 `protobuf:"bytes,8,opt,name=old_cc,json=oldCc,proto3" json:"old_cc,omitempty"`
+	NewCc         string                 `protobuf:"bytes,9,opt,name=new_cc,json=newCc,proto3" json:"new_cc,omitempty"`
+	OldStatus     string                 `protobuf:"bytes,10,opt,name=old_status,json=oldStatus,proto3" json:"old_status,omitempty"`
+	NewStatus     string                 `protobuf:"bytes,11,opt,name=new_status,json=newStatus,proto3" json:"new_status,omitempty"`
+	OldHolder     string                 `protobuf:"bytes,12,opt,name=old_holder,json=oldHolder,proto3" json:"old_holder,omitempty"`
+	NewHolder     string                 `protobuf:"bytes,13,opt,name=new_holder,json=newHolder,proto3" json:"new_holder,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Change) Reset() {
+	*x = Change{}
+	mi := &file_pkg_lookuppb_lookup_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Change) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Change) ProtoMessage() {}
+
+func (x *Change) ProtoReflect() protoreflect.Message {
+	mi := &file_pkg_lookuppb_lookup_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Change.ProtoReflect.Descriptor instead.
+func (*Change) Descriptor() ([]byte, []int) {
+	return file_pkg_lookuppb_lookup_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *Change) GetDataset() int64 {
+	if x != nil {
+		return x.Dataset
+	}
+	return 0
+}
+
+func (x *Change) GetRegistry() string {
+	if x != nil {
+		return x.Registry
+	}
+	return ""
+}
+
+func (x *Change) GetChange() string {
+	if x != nil {
+		return x.Change
+	}
+	return ""
+}
+
+func (x *Change) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *Change) GetStart() string {
+	if x != nil {
+		return x.Start
+	}
+	return ""
+}
+
+func (x *Change) GetValue() uint64 {
+	if x != nil {
+		return x.Value
+	}
+	return 0
+}
+
+func (x *Change) GetDate() string {
+	if x != nil {
+		return x.Date
+	}
+	return ""
+}
+
+func (x *Change) GetOldCc() string {
+	if x != nil {
+		return x.OldCc
+	}
+	return ""
+}
+
+func (x *Change) GetNewCc() string {
+	if x != nil {
+		return x.NewCc
+	}
+	return ""
+}
+
+func (x *Change) GetOldStatus() string {
+	if x != nil {
+		return x.OldStatus
+	}
+	return ""
+}
+
+func (x *Change) GetNewStatus() string {
+	if x != nil {
+		return x.NewStatus
+	}
+	return ""
+}
+
+func (x *Change) GetOldHolder() string {
+	if x != nil {
+		return x.OldHolder
+	}
+	return ""
+}
+
+func (x *Change) GetNewHolder() string {
+	if x != nil {
+		return x.NewHolder
+	}
+	return ""
+}
+
+var File_pkg_lookuppb_lookup_proto protoreflect.FileDescriptor
+
+const file_pkg_lookuppb_lookup_proto_rawDesc = "" +
+	"\n" +
+	"\x19pkg/lookuppb/lookup.proto\x12\tip2asn.v1\"+\n" +
+	"\x0fLookupIPRequest\x12\x18\n" +
+	"\aaddress\x18\x01 \x01(\tR\aaddress\"$\n" +
+	"\x10LookupASNRequest\x12\x10\n" +
+	"\x03asn\x18\x01 \x01(\rR\x03asn\".\n" +
+	"\x12BatchLookupRequest\x12\x18\n" +
+	"\aqueries\x18\x01 \x03(\tR\aqueries\"\xff\x02\n" +
+	"\vLookupReply\x12\x14\n" +
+	"\x05query\x18\x01 \x01(\tR\x05query\x12\x14\n" +
+	"\x05found\x18\x02 \x01(\bR\x05found\x12\x1a\n" +
+	"\bregistry\x18\x03 \x01(\tR\bregistry\x12\x0e\n" +
+	"\x02cc\x18\x04 \x01(\tR\x02cc\x12\x12\n" +
+	"\x04type\x18\x05 \x01(\tR\x04type\x12\x14\n" +
+	"\x05start\x18\x06 \x01(\tR\x05start\x12\x14\n" +
+	"\x05value\x18\a \x01(\x04R\x05value\x12\x12\n" +
+	"\x04date\x18\b \x01(\tR\x04date\x12\x16\n" +
+	"\x06status\x18\t \x01(\tR\x06status\x12\x16\n" +
+	"\x06holder\x18\n" +
+	" \x01(\tR\x06holder\x12\x10\n" +
+	"\x03asn\x18\v \x01(\tR\x03asn\x12\x17\n" +
+	"\aas_name\x18\f \x01(\tR\x06asName\x12\x15\n" +
+	"\x06as_org\x18\r \x01(\tR\x05asOrg\x12\x14\n" +
+	"\x05route\x18\x0e \x01(\tR\x05route\x12\x12\n" +
+	"\x04rpki\x18\x0f \x01(\tR\x04rpki\x12\x12\n" +
+	"\x04tags\x18\x10 \x03(\tR\x04tags\x12\x14\n" +
+	"\x05error\x18\x11 \x01(\tR\x05error\"W\n" +
+	"\x14StreamChangesRequest\x12#\n" +
+	"\rsince_dataset\x18\x01 \x01(\x03R\fsinceDataset\x12\x1a\n" +
+	"\bregistry\x18\x02 \x01(\tR\bregistry\"\xd4\x02\n" +
+	"\x06Change\x12\x18\n" +
+	"\adataset\x18\x01 \x01(\x03R\adataset\x12\x1a\n" +
+	"\bregistry\x18\x02 \x01(\tR\bregistry\x12\x16\n" +
+	"\x06change\x18\x03 \x01(\tR\x06change\x12\x12\n" +
+	"\x04type\x18\x04 \x01(\tR\x04type\x12\x14\n" +
+	"\x05start\x18\x05 \x01(\tR\x05start\x12\x14\n" +
+	"\x05value\x18\x06 \x01(\x04R\x05value\x12\x12\n" +
+	"\x04date\x18\a \x01(\tR\x04date\x12\x15\n" +
+	"\x06old_cc\x18\b \x01(\tR\x05oldCc\x12\x15\n" +
+	"\x06new_cc\x18\t \x01(\tR\x05newCc\x12\x1d\n" +
+	"\n" +
+	"old_status\x18\n" +
+	" \x01(\tR\toldStatus\x12\x1d\n" +
+	"\n" +
+	"new_status\x18\v \x01(\tR\tnewStatus\x12\x1d\n" +
+	"\n" +
+	"old_holder\x18\f \x01(\tR\toldHolder\x12\x1d\n" +
+	"\n" +
+	"new_holder\x18\r \x01(\tR\tnewHolder2\x99\x02\n" +
+	"\x06Lookup\x12>\n" +
+	"\bLookupIP\x12\x1a.ip2asn.v1.LookupIPRequest\x1a\x16.ip2asn.v1.LookupReply\x12@\n" +
+	"\tLookupASN\x12\x1b.ip2asn.v1.LookupASNRequest\x1a\x16.ip2asn.v1.LookupReply\x12F\n" +
+	"\vBatchLookup\x12\x1d.ip2asn.v1.BatchLookupRequest\x1a\x16.ip2asn.v1.LookupReply0\x01\x12E\n" +
+	"\rStreamChanges\x12\x1f.ip2asn.v1.StreamChangesRequest\x1a\x11.ip2asn.v1.Change0\x01B'Z%github.com/krassi/ip2asn/pkg/lookuppbb\x06proto3"
+
+var (
+	file_pkg_lookuppb_lookup_proto_rawDescOnce sync.Once
+	file_pkg_lookuppb_lookup_proto_rawDescData []byte
+)
+
+func file_pkg_lookuppb_lookup_proto_rawDescGZIP() []byte {
+	file_pkg_lookuppb_lookup_proto_rawDescOnce.Do(func() {
+		file_pkg_lookuppb_lookup_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_pkg_lookuppb_lookup_proto_rawDesc), len(file_pkg_lookuppb_lookup_proto_rawDesc)))
+	})
+	return file_pkg_lookuppb_lookup_proto_rawDescData
+}
+
+var file_pkg_lookuppb_lookup_proto_msgTypes = make([]protoimpl.MessageInfo, 6)
+var file_pkg_lookuppb_lookup_proto_goTypes = []any{
+	(*LookupIPRequest)(nil),      // 0: ip2asn.v1.LookupIPRequest
+	(*LookupASNRequest)(nil),     // 1: ip2asn.v1.LookupASNRequest
+	(*BatchLookupRequest)(nil),   // 2: ip2asn.v1.BatchLookupRequest
+	(*LookupReply)(nil),          // 3: ip2asn.v1.LookupReply
+	(*StreamChangesRequest)(nil), // 4: ip2asn.v1.StreamChangesRequest
+	(*Change)(nil),               // 5: ip2asn.v1.Change
+}
+var file_pkg_lookuppb_lookup_proto_depIdxs = []int32{
+	0, // 0: ip2asn.v1.Lookup.LookupIP:input_type -> ip2asn.v1.LookupIPRequest
+	1, // 1: ip2asn.v1.Lookup.LookupASN:input_type -> ip2asn.v1.LookupASNRequest
+	2, // 2: ip2asn.v1.Lookup.BatchLookup:input_type -> ip2asn.v1.BatchLookupRequest
+	4, // 3: ip2asn.v1.Lookup.StreamChanges:input_type -> ip2asn.v1.StreamChangesRequest
+	3, // 4: ip2asn.v1.Lookup.LookupIP:output_type -> ip2asn.v1.LookupReply
+	3, // 5: ip2asn.v1.Lookup.LookupASN:output_type -> ip2asn.v1.LookupReply
+	3, // 6: ip2asn.v1.Lookup.BatchLookup:output_type -> ip2asn.v1.LookupReply
+	5, // 7: ip2asn.v1.Lookup.StreamChanges:output_type -> ip2asn.v1.Change
+	4, // [4:8] is the sub-list for method output_type
+	0, // [0:4] is the sub-list for method input_type
+	0, // [0:0] is the sub-list for extension type_name
+	0, // [0:0] is the sub-list for extension extendee
+	0, // [0:0] is the sub-list for field type_name
+}
+
+func init() { file_pkg_lookuppb_lookup_proto_init() }
+func file_pkg_lookuppb_lookup_proto_init() {
+	if File_pkg_lookuppb_lookup_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_pkg_lookuppb_lookup_proto_rawDesc), len(file_pkg_lookuppb_lookup_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   6,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_pkg_lookuppb_lookup_proto_goTypes,
+		DependencyIndexes: file_pkg_lookuppb_lookup_proto_depIdxs,
+		MessageInfos:      file_pkg_lookuppb_lookup_proto_msgTypes,
+	}.Build()
+	File_pkg_lookuppb_lookup_proto = out.File
+	file_pkg_lookuppb_lookup_proto_goTypes = nil
+	file_pkg_lookuppb_lookup_proto_depIdxs = nil
+}
@@ -0,0 +1,267 @@
+// The gRPC lookup service of ip2asn, served on -grpc-listen next to the REST API. Like
+// the REST API it answers from the namespace of the API key in the "x-api-key" or
+// "authorization: Bearer" metadata, or from the process namespace.
+//
+// Regenerate the Go code after changes with
+//
+//	protoc --go_out=. --go_opt=paths=source_relative \
+//	    --go-grpc_out=. --go-grpc_opt=paths=source_relative pkg/lookuppb/lookup.proto
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.6.2
+// - protoc             (unknown)
+// source: pkg/lookuppb/lookup.proto
+
+package lookuppb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Lookup_LookupIP_FullMethodName      = "/ip2asn.v1.Lookup/LookupIP"
+	Lookup_LookupASN_FullMethodName     = "/ip2asn.v1.Lookup/LookupASN"
+	Lookup_BatchLookup_FullMethodName   = "/ip2asn.v1.Lookup/BatchLookup"
+	Lookup_StreamChanges_FullMethodName = "/ip2asn.v1.Lookup/StreamChanges"
+)
+
+// LookupClient is the client API for Lookup service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type LookupClient interface {
+	// LookupIP returns the delegation containing an address and the origin of its BGP
+	// route; NOT_FOUND when no delegation contains it.
+	LookupIP(ctx context.Context, in *LookupIPRequest, opts ...grpc.CallOption) (*LookupReply, error)
+	// LookupASN returns the delegation of an ASN; NOT_FOUND when there is none.
+	LookupASN(ctx context.Context, in *LookupASNRequest, opts ...grpc.CallOption) (*LookupReply, error)
+	// BatchLookup answers addresses and ASNs in the order given, one reply per query.
+	// Replies of queries without a delegation have found unset; invalid queries an error.
+	BatchLookup(ctx context.Context, in *BatchLookupRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[LookupReply], error)
+	// StreamChanges sends the changes recorded since a dataset, then the changes of
+	// every dataset imported by the serving process until the call ends. A client that
+	// falls behind gets RESOURCE_EXHAUSTED and resumes from the last dataset it received.
+	StreamChanges(ctx context.Context, in *StreamChangesRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Change], error)
+}
+
+type lookupClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewLookupClient(cc grpc.ClientConnInterface) LookupClient {
+	return &lookupClient{cc}
+}
+
+func (c *lookupClient) LookupIP(ctx context.Context, in *LookupIPRequest, opts ...grpc.CallOption) (*LookupReply, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(LookupReply)
+	err := c.cc.Invoke(ctx, Lookup_LookupIP_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *lookupClient) LookupASN(ctx context.Context, in *LookupASNRequest, opts ...grpc.CallOption) (*LookupReply, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(LookupReply)
+	err := c.cc.Invoke(ctx, Lookup_LookupASN_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *lookupClient) BatchLookup(ctx context.Context, in *BatchLookupRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[LookupReply], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Lookup_ServiceDesc.Streams[0], Lookup_BatchLookup_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[BatchLookupRequest, LookupReply]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Lookup_BatchLookupClient = grpc.ServerStreamingClient[LookupReply]
+
+func (c *lookupClient) StreamChanges(ctx context.Context, in *StreamChangesRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Change], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Lookup_ServiceDesc.Streams[1], Lookup_StreamChanges_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[StreamChangesRequest, Change]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Lookup_StreamChangesClient = grpc.ServerStreamingClient[Change]
+
+// LookupServer is the server API for Lookup service.
+// All implementations must embed UnimplementedLookupServer
+// for forward compatibility.
+type LookupServer interface {
+	// LookupIP returns the delegation containing an address and the origin of its BGP
+	// route; NOT_FOUND when no delegation contains it.
+	LookupIP(context.Context, *LookupIPRequest) (*LookupReply, error)
+	// LookupASN returns the delegation of an ASN; NOT_FOUND when there is none.
+	LookupASN(context.Context, *LookupASNRequest) (*LookupReply, error)
+	// BatchLookup answers addresses and ASNs in the order given, one reply per query.
+	// Replies of queries without a delegation have found unset; invalid queries an error.
+	BatchLookup(*BatchLookupRequest, grpc.ServerStreamingServer[LookupReply]) error
+	// StreamChanges sends the changes recorded since a dataset, then the changes of
+	// every dataset imported by the serving process until the call ends. A client that
+	// falls behind gets RESOURCE_EXHAUSTED and resumes from the last dataset it received.
+	StreamChanges(*StreamChangesRequest, grpc.ServerStreamingServer[Change]) error
+	mustEmbedUnimplementedLookupServer()
+}
+
+// UnimplementedLookupServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedLookupServer struct{}
+
+func (UnimplementedLookupServer) LookupIP(context.Context, *LookupIPRequest) (*LookupReply, error) {
+	return nil, status.Error(codes.Unimplemented, "method LookupIP not implemented")
+}
+func (UnimplementedLookupServer) LookupASN(context.Context, *LookupASNRequest) (*LookupReply, error) {
+	return nil, status.Error(codes.Unimplemented, "method LookupASN not implemented")
+}
+func (UnimplementedLookupServer) BatchLookup(*BatchLookupRequest, grpc.ServerStreamingServer[LookupReply]) error {
+	return status.Error(codes.Unimplemented, "method BatchLookup not implemented")
+}
+func (UnimplementedLookupServer) StreamChanges(*StreamChangesRequest, grpc.ServerStreamingServer[Change]) error {
+	return status.Error(codes.Unimplemented, "method StreamChanges not implemented")
+}
+func (UnimplementedLookupServer) mustEmbedUnimplementedLookupServer() {}
+func (UnimplementedLookupServer) testEmbeddedByValue()                {}
+
+// UnsafeLookupServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to LookupServer will
+// result in compilation errors.
+type UnsafeLookupServer interface {
+	mustEmbedUnimplementedLookupServer()
+}
+
+func RegisterLookupServer(s grpc.ServiceRegistrar, srv LookupServer) {
+	// If the following call panics, it indicates UnimplementedLookupServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Lookup_ServiceDesc, srv)
+}
+
+func _Lookup_LookupIP_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LookupIPRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LookupServer).LookupIP(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Lookup_LookupIP_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LookupServer).LookupIP(ctx, req.(*LookupIPRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Lookup_LookupASN_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LookupASNRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LookupServer).LookupASN(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Lookup_LookupASN_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LookupServer).LookupASN(ctx, req.(*LookupASNRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Lookup_BatchLookup_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(BatchLookupRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(LookupServer).BatchLookup(m, &grpc.GenericServerStream[BatchLookupRequest, LookupReply]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Lookup_BatchLookupServer = grpc.ServerStreamingServer[LookupReply]
+
+func _Lookup_StreamChanges_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(StreamChangesRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(LookupServer).StreamChanges(m, &grpc.GenericServerStream[StreamChangesRequest, Change]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Lookup_StreamChangesServer = grpc.ServerStreamingServer[Change]
+
+// Lookup_ServiceDesc is the grpc.ServiceDesc for Lookup service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Lookup_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "ip2asn.v1.Lookup",
+	HandlerType: (*LookupServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "LookupIP",
+			Handler:    _Lookup_LookupIP_Handler,
+		},
+		{
+			MethodName: "LookupASN",
+			Handler:    _Lookup_LookupASN_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "BatchLookup",
+			Handler:       _Lookup_BatchLookup_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "StreamChanges",
+			Handler:       _Lookup_StreamChanges_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "pkg/lookuppb/lookup.proto",
+}
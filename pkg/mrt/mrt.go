@@ -0,0 +1,296 @@
+// Package mrt reads the routing table dumps of BGP collectors such as RouteViews and
+// RIPE RIS, in the MRT format of RFC 6396: TABLE_DUMP_V2 RIB records (one prefix with
+// the route of each peer) and the older TABLE_DUMP records (one route per record).
+// Other records, e.g. BGP4MP updates, are skipped.
+package mrt
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MRT record types and subtypes.
+const (
+	typeTableDump   = 12
+	typeTableDumpV2 = 13
+
+	subtypeAFIIPv4 = 1 // TABLE_DUMP
+	subtypeAFIIPv6 = 2
+
+	subtypeRIBIPv4Unicast        = 2 // TABLE_DUMP_V2
+	subtypeRIBIPv6Unicast        = 4
+	subtypeRIBIPv4UnicastAddPath = 8 // RFC 8050
+	subtypeRIBIPv6UnicastAddPath = 10
+)
+
+// BGP path attributes and AS_PATH segment types.
+const (
+	attrASPath  = 2
+	attrAS4Path = 17
+
+	segmentASSet      = 1
+	segmentASSequence = 2
+)
+
+// ErrTruncated is returned by Next for a record shorter than its contents.
+var ErrTruncated = errors.New("mrt: truncated record")
+
+// Route is a prefix and the origin ASNs of its paths, the most common first. An origin
+// is an ASN ("64496") or the ASNs of an AS_SET at the end of the path ("64496,64497").
+type Route struct {
+	Prefix  netip.Prefix
+	Origins []string
+	Paths   int // number of peer paths the origins were taken from
+}
+
+// IsMRT reports whether header, the first 12 bytes of a file, starts a table dump.
+func IsMRT(header []byte) bool {
+	if len(header) < 12 {
+		return false
+	}
+	typ := binary.BigEndian.Uint16(header[4:6])
+	return (typ == typeTableDump || typ == typeTableDumpV2) && binary.BigEndian.Uint32(header[8:12]) < 1<<24
+}
+
+// Reader reads routes from a table dump.
+type Reader struct {
+	r       io.Reader
+	header  [12]byte
+	pending *routeCounts // TABLE_DUMP route waiting for the paths of the next records
+}
+
+// NewReader returns a Reader reading from r, which must be decompressed.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: r}
+}
+
+// routeCounts counts the paths per origin of a prefix.
+type routeCounts struct {
+	prefix  netip.Prefix
+	origins []string
+	counts  map[string]int
+	paths   int
+}
+
+func newRouteCounts(prefix netip.Prefix) *routeCounts {
+	return &routeCounts{prefix: prefix, counts: map[string]int{}}
+}
+
+func (c *routeCounts) add(origin string) {
+	c.paths++
+	if origin == "" {
+		return
+	}
+	if c.counts[origin] == 0 {
+		c.origins = append(c.origins, origin)
+	}
+	c.counts[origin]++
+}
+
+func (c *routeCounts) route() Route {
+	sort.SliceStable(c.origins, func(i, j int) bool { return c.counts[c.origins[i]] > c.counts[c.origins[j]] })
+	return Route{Prefix: c.prefix, Origins: c.origins, Paths: c.paths}
+}
+
+// Next returns the next route with at least one origin, or io.EOF at the end of the dump.
+func (r *Reader) Next() (Route, error) {
+	for {
+		if _, err := io.ReadFull(r.r, r.header[:]); err == io.EOF {
+			return r.flush()
+		} else if err == io.ErrUnexpectedEOF {
+			return Route{}, ErrTruncated
+		} else if err != nil {
+			return Route{}, err
+		}
+		typ := binary.BigEndian.Uint16(r.header[4:6])
+		subtype := binary.BigEndian.Uint16(r.header[6:8])
+		body := make([]byte, binary.BigEndian.Uint32(r.header[8:12]))
+		if _, err := io.ReadFull(r.r, body); err != nil {
+			return Route{}, ErrTruncated
+		}
+
+		switch {
+		case typ == typeTableDumpV2:
+			c, err := parseRIB(subtype, body)
+			if err != nil {
+				return Route{}, err
+			}
+			if c != nil && len(c.origins) > 0 {
+				return c.route(), nil
+			}
+		case typ == typeTableDump && (subtype == subtypeAFIIPv4 || subtype == subtypeAFIIPv6):
+			prefix, origin, err := parseTableDump(subtype, body)
+			if err != nil {
+				return Route{}, err
+			}
+			// Dumps list the routes of all peers for a prefix one after the other
+			if r.pending != nil && r.pending.prefix == prefix {
+				r.pending.add(origin)
+				continue
+			}
+			last := r.pending
+			r.pending = newRouteCounts(prefix)
+			r.pending.add(origin)
+			if last != nil && len(last.origins) > 0 {
+				return last.route(), nil
+			}
+		}
+	}
+}
+
+// flush returns the pending TABLE_DUMP route at the end of the dump.
+func (r *Reader) flush() (Route, error) {
+	last := r.pending
+	r.pending = nil
+	if last != nil && len(last.origins) > 0 {
+		return last.route(), nil
+	}
+	return Route{}, io.EOF
+}
+
+// parseRIB parses a TABLE_DUMP_V2 RIB record, or returns nil for other subtypes.
+func parseRIB(subtype uint16, b []byte) (*routeCounts, error) {
+	var bits int
+	addPath := false
+	switch subtype {
+	case subtypeRIBIPv4Unicast:
+		bits = 32
+	case subtypeRIBIPv6Unicast:
+		bits = 128
+	case subtypeRIBIPv4UnicastAddPath:
+		bits, addPath = 32, true
+	case subtypeRIBIPv6UnicastAddPath:
+		bits, addPath = 128, true
+	default:
+		return nil, nil // PEER_INDEX_TABLE, multicast and generic RIBs
+	}
+	if len(b) < 5 {
+		return nil, ErrTruncated
+	}
+	length := int(b[4])
+	n := (length + 7) / 8
+	if length > bits || len(b) < 5+n+2 {
+		return nil, ErrTruncated
+	}
+	addr := make([]byte, bits/8)
+	copy(addr, b[5:5+n])
+	ip, _ := netip.AddrFromSlice(addr)
+	c := newRouteCounts(netip.PrefixFrom(ip, length).Masked())
+
+	entries := int(binary.BigEndian.Uint16(b[5+n:]))
+	b = b[5+n+2:]
+	for i := 0; i < entries; i++ {
+		skip := 6 // peer index and originated time
+		if addPath {
+			skip += 4
+		}
+		if len(b) < skip+2 {
+			return nil, ErrTruncated
+		}
+		attrLen := int(binary.BigEndian.Uint16(b[skip:]))
+		if len(b) < skip+2+attrLen {
+			return nil, ErrTruncated
+		}
+		origin, err := originOf(b[skip+2:skip+2+attrLen], 4)
+		if err != nil {
+			return nil, err
+		}
+		c.add(origin)
+		b = b[skip+2+attrLen:]
+	}
+	return c, nil
+}
+
+// parseTableDump parses a TABLE_DUMP record, whose AS_PATH has 2-byte ASNs.
+func parseTableDump(subtype uint16, b []byte) (netip.Prefix, string, error) {
+	size := 4
+	if subtype == subtypeAFIIPv6 {
+		size = 16
+	}
+	// View, sequence, prefix, length, status, originated time, peer address and AS
+	fixed := 2 + 2 + size + 1 + 1 + 4 + size + 2
+	if len(b) < fixed+2 {
+		return netip.Prefix{}, "", ErrTruncated
+	}
+	ip, _ := netip.AddrFromSlice(b[4 : 4+size])
+	length := int(b[4+size])
+	if length > size*8 {
+		return netip.Prefix{}, "", ErrTruncated
+	}
+	attrLen := int(binary.BigEndian.Uint16(b[fixed:]))
+	if len(b) < fixed+2+attrLen {
+		return netip.Prefix{}, "", ErrTruncated
+	}
+	origin, err := originOf(b[fixed+2:fixed+2+attrLen], 2)
+	return netip.PrefixFrom(ip, length).Masked(), origin, err
+}
+
+// originOf returns the origin of the AS path in attrs, preferring AS4_PATH to an
+// AS_PATH of asSize-byte ASNs, or "" when the path is empty.
+func originOf(attrs []byte, asSize int) (string, error) {
+	var path, path4 []byte
+	for len(attrs) > 0 {
+		if len(attrs) < 3 {
+			return "", ErrTruncated
+		}
+		flags, typ := attrs[0], attrs[1]
+		hdr, length := 3, int(attrs[2])
+		if flags&0x10 != 0 { // Extended length
+			if len(attrs) < 4 {
+				return "", ErrTruncated
+			}
+			hdr, length = 4, int(binary.BigEndian.Uint16(attrs[2:4]))
+		}
+		if len(attrs) < hdr+length {
+			return "", ErrTruncated
+		}
+		switch typ {
+		case attrASPath:
+			path = attrs[hdr : hdr+length]
+		case attrAS4Path:
+			path4 = attrs[hdr : hdr+length]
+		}
+		attrs = attrs[hdr+length:]
+	}
+	if path4 != nil && asSize == 2 {
+		return lastSegment(path4, 4)
+	}
+	return lastSegment(path, asSize)
+}
+
+// lastSegment returns the last ASN of a path ending in an AS_SEQUENCE, or the ASNs of
+// its final AS_SET separated by commas. Confederation segments are ignored.
+func lastSegment(path []byte, asSize int) (string, error) {
+	origin := ""
+	for len(path) > 0 {
+		if len(path) < 2 {
+			return "", ErrTruncated
+		}
+		typ, count := path[0], int(path[1])
+		if len(path) < 2+count*asSize {
+			return "", ErrTruncated
+		}
+		asns := make([]string, count)
+		for i := range asns {
+			v := path[2+i*asSize:]
+			if asSize == 2 {
+				asns[i] = strconv.FormatUint(uint64(binary.BigEndian.Uint16(v)), 10)
+			} else {
+				asns[i] = strconv.FormatUint(uint64(binary.BigEndian.Uint32(v)), 10)
+			}
+		}
+		switch {
+		case typ == segmentASSequence && count > 0:
+			origin = asns[count-1]
+		case typ == segmentASSet && count > 0:
+			origin = strings.Join(asns, ",")
+		}
+		path = path[2+count*asSize:]
+	}
+	return origin, nil
+}
@@ -0,0 +1,276 @@
+// Package rirparse reads the statistics exchange files ("delegated" files) published by
+// the regional Internet registries: a version line, summary lines per record type and
+// one record per resource, e.g.
+//
+//	2|ripencc|1700000000|123456|19830705|20231113|+0100
+//	ripencc|*|ipv4|*|90000|summary
+//	ripencc|FR|ipv4|2.0.0.0|1048576|20100712|allocated|a1b2c3
+//
+// Records of the extended format carry an opaque ID after the status, shared by all
+// resources of the same holder, and may be followed by further extension fields.
+package rirparse
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// FileHeader is the version line of a file and its summary lines.
+type FileHeader struct {
+	Version   string            // format version number of this file, currently 2.3
+	Registry  string            // as for records and file name
+	Serial    uint64            // serial number of this file within the creating registry's series
+	Records   uint64            // number of records, excluding blank lines, summary lines, the version line and comments
+	StartDate string            // start date of the time period, yyyymmdd
+	EndDate   string            // end date of the period, yyyymmdd
+	UTCOffset int64             // offset from UTC (+/- hours) of the registry producing the file
+	Summaries map[string]uint64 // number of records per type (asn, ipv4, ipv6) from the summary lines
+}
+
+// Record is a resource line. Value is the number of addresses for ipv4, the prefix
+// length for ipv6 and the number of ASNs for asn records.
+type Record struct {
+	Registry   string
+	CC         string
+	Type       string // asn, ipv4 or ipv6
+	Start      string
+	Value      uint64
+	Date       string   // yyyymmdd; empty or 00000000 when unknown
+	Status     string   // allocated, assigned, available or reserved; other values are passed through lowercased
+	OpaqueID   string   // holder of the resource in extended files, empty otherwise
+	Extensions []string // fields after the opaque ID
+	Extra      string   // the rest of the line after the status, e.g. "|opaque-id" in extended files
+}
+
+// KnownStatus reports whether status is one of the values defined by the format.
+func KnownStatus(status string) bool {
+	switch status {
+	case "allocated", "assigned", "available", "reserved":
+		return true
+	}
+	return false
+}
+
+// Dataset is a whole file.
+type Dataset struct {
+	Header  FileHeader
+	Records []Record
+	Invalid int // lines that are not records
+}
+
+// ErrInvalidHeader is returned by ReadHeader when the file does not start with a version line.
+var ErrInvalidHeader = errors.New("rirparse: invalid or missing version line")
+
+// InvalidLineError is returned by Next for a line that is not a record.
+type InvalidLineError struct {
+	Line   string
+	Number int    // line number in the file, from 1
+	Reason string // see InvalidReason
+}
+
+func (e *InvalidLineError) Error() string {
+	return "rirparse: invalid record: " + e.Line
+}
+
+var (
+	versionRegexp = regexp.MustCompile(`^([0-9.]+)\|(afrinic|apnic|arin|lacnic|ripencc)\|([0-9]+)\|(\d+)\|(\d+)\|(\d+)\|(.*)`)
+	summaryRegexp = regexp.MustCompile(`^(afrinic|apnic|arin|lacnic|ripencc)\|\*\|(asn|ipv4|ipv6)\|\*\|([0-9]+)\|summary`)
+	recordRegexp  = regexp.MustCompile(`^(afrinic|apnic|arin|lacnic|ripencc)\|([A-Z].|)\|(asn|ipv4|ipv6)\|([0-9a-f:.]+)\|([0-9]+)\|([0-9]+|)\|([A-Za-z-]+)(\|.*|)$`)
+
+	// The fields of recordRegexp, for InvalidReason
+	fieldRegexps = []struct {
+		re     *regexp.Regexp
+		reason string
+	}{
+		{regexp.MustCompile(`^(afrinic|apnic|arin|lacnic|ripencc)$`), "bad_registry"},
+		{regexp.MustCompile(`^([A-Z].|)$`), "bad_cc"},
+		{regexp.MustCompile(`^(asn|ipv4|ipv6)$`), "bad_type"},
+		{regexp.MustCompile(`^[0-9a-f:.]+$`), "bad_start"},
+		{regexp.MustCompile(`^[0-9]+$`), "bad_value"},
+		{regexp.MustCompile(`^([0-9]+|)$`), "bad_date"},
+		{regexp.MustCompile(`^[A-Za-z-]+$`), "bad_status"},
+	}
+)
+
+// InvalidReason returns why a line is not a record: empty, comment, summary (a summary
+// line among the records), too_few_fields, the first field not matching (bad_registry,
+// bad_cc, bad_type, bad_start, bad_value, bad_date or bad_status), or malformed.
+func InvalidReason(line string) string {
+	line = strings.TrimRight(line, "\r")
+	if strings.TrimSpace(line) == "" {
+		return "empty"
+	}
+	if line[0] == '#' {
+		return "comment"
+	}
+	if summaryRegexp.MatchString(line) {
+		return "summary"
+	}
+	fields := strings.Split(line, "|")
+	if len(fields) < len(fieldRegexps) {
+		return "too_few_fields"
+	}
+	for i, f := range fieldRegexps {
+		if !f.re.MatchString(fields[i]) {
+			return f.reason
+		}
+	}
+	return "malformed"
+}
+
+// ParseVersionLine parses the version line of a file.
+func ParseVersionLine(line string) (FileHeader, bool) {
+	m := versionRegexp.FindStringSubmatch(line)
+	if m == nil {
+		return FileHeader{}, false
+	}
+	hdr := FileHeader{Version: m[1], Registry: m[2], StartDate: m[5], EndDate: m[6],
+		Summaries: map[string]uint64{"asn": 0, "ipv4": 0, "ipv6": 0}}
+	hdr.Serial, _ = strconv.ParseUint(m[3], 10, 32)
+	hdr.Records, _ = strconv.ParseUint(m[4], 10, 32)
+	hdr.UTCOffset, _ = strconv.ParseInt(m[7], 10, 32)
+	hdr.UTCOffset /= 100
+	if hdr.StartDate == "00000000" {
+		hdr.StartDate = "19700101"
+	}
+	return hdr, true
+}
+
+// ParseSummaryLine records the count of a summary line in hdr.
+func ParseSummaryLine(hdr *FileHeader, line string) bool {
+	m := summaryRegexp.FindStringSubmatch(line)
+	if m == nil {
+		return false
+	}
+	if hdr.Summaries == nil {
+		hdr.Summaries = map[string]uint64{}
+	}
+	hdr.Summaries[m[2]], _ = strconv.ParseUint(m[3], 10, 64)
+	return true
+}
+
+// ParseRecord parses a record line.
+func ParseRecord(line string) (Record, bool) {
+	m := recordRegexp.FindStringSubmatch(line)
+	if m == nil {
+		return Record{}, false
+	}
+	value, _ := strconv.ParseUint(m[5], 10, 64)
+	rec := Record{Registry: m[1], CC: m[2], Type: m[3], Start: m[4], Value: value, Date: m[6], Status: strings.ToLower(m[7]), Extra: m[8]}
+	if extra := strings.TrimRight(m[8], " \t\r"); extra != "" {
+		fields := strings.Split(extra[1:], "|")
+		rec.OpaqueID = strings.TrimSpace(fields[0])
+		for _, f := range fields[1:] {
+			if f = strings.TrimSpace(f); f != "" {
+				rec.Extensions = append(rec.Extensions, f)
+			}
+		}
+	}
+	return rec, true
+}
+
+// Reader reads a file line by line: first the header with ReadHeader, then the records
+// with Next.
+type Reader struct {
+	scanner *bufio.Scanner
+	line    int // number of the last line read
+}
+
+// NewReader returns a Reader of r.
+func NewReader(r io.Reader) *Reader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	return &Reader{scanner: scanner}
+}
+
+// ReadHeader skips leading comments and reads the version line and the three summary
+// lines after it. Without a valid version line it returns ErrInvalidHeader; that line
+// is consumed and the records can still be read.
+func (r *Reader) ReadHeader() (FileHeader, error) {
+	var line string
+	for {
+		if !r.scan() {
+			if err := r.scanner.Err(); err != nil {
+				return FileHeader{}, err
+			}
+			return FileHeader{}, ErrInvalidHeader
+		}
+		line = r.scanner.Text()
+		if line != "" && line[0] != '#' && line[0] != '\r' { // APNIC has comments before the header
+			break
+		}
+	}
+	hdr, ok := ParseVersionLine(line)
+	if !ok {
+		return FileHeader{}, ErrInvalidHeader
+	}
+	for i := 0; i < 3 && r.scan(); i++ {
+		ParseSummaryLine(&hdr, r.scanner.Text())
+	}
+	return hdr, r.scanner.Err()
+}
+
+// Next returns the next record, an *InvalidLineError for a line that is not a record,
+// or io.EOF at the end of the file.
+func (r *Reader) Next() (Record, error) {
+	if !r.scan() {
+		if err := r.scanner.Err(); err != nil {
+			return Record{}, err
+		}
+		return Record{}, io.EOF
+	}
+	line := r.scanner.Text()
+	rec, ok := ParseRecord(line)
+	if !ok {
+		return Record{}, &InvalidLineError{Line: line, Number: r.line, Reason: InvalidReason(line)}
+	}
+	return rec, nil
+}
+
+// CountsAsRecord reports whether an invalid line of a reason is included in the records
+// field of the version line, which leaves out blank lines, comments and summary lines.
+func CountsAsRecord(reason string) bool {
+	return reason != "empty" && reason != "comment" && reason != "summary"
+}
+
+// Line returns the number of the line last read, from 1.
+func (r *Reader) Line() int {
+	return r.line
+}
+
+func (r *Reader) scan() bool {
+	if !r.scanner.Scan() {
+		return false
+	}
+	r.line++
+	return true
+}
+
+// ParseDelegatedFile reads a whole file. Lines that are not records are counted in
+// Invalid; a missing version line is an error.
+func ParseDelegatedFile(r io.Reader) (*Dataset, error) {
+	rd := NewReader(r)
+	hdr, err := rd.ReadHeader()
+	if err != nil {
+		return nil, err
+	}
+	ds := &Dataset{Header: hdr}
+	for {
+		rec, err := rd.Next()
+		var invalid *InvalidLineError
+		switch {
+		case err == io.EOF:
+			return ds, nil
+		case errors.As(err, &invalid):
+			ds.Invalid++
+		case err != nil:
+			return nil, err
+		default:
+			ds.Records = append(ds.Records, rec)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package rirparse
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseRecord(t *testing.T) {
+	tests := []struct {
+		line string
+		want Record
+		ok   bool
+	}{
+		{"apnic|AU|ipv4|1.0.0.0|256|20110811|assigned",
+			Record{Registry: "apnic", CC: "AU", Type: "ipv4", Start: "1.0.0.0", Value: 256, Date: "20110811", Status: "assigned"}, true},
+		{"ripencc|NL|ipv6|2001:db8::|32|20000101|Allocated|",
+			Record{Registry: "ripencc", CC: "NL", Type: "ipv6", Start: "2001:db8::", Value: 32, Date: "20000101",
+				Status: "allocated", Extra: "|"}, true},
+		{"arin|US|asn|64496|16|19950101|assigned|7f3b2c1a-4d5e|e-stats|x \r",
+			Record{Registry: "arin", CC: "US", Type: "asn", Start: "64496", Value: 16, Date: "19950101", Status: "assigned",
+				OpaqueID: "7f3b2c1a-4d5e", Extensions: []string{"e-stats", "x"}, Extra: "|7f3b2c1a-4d5e|e-stats|x \r"}, true},
+		{"lacnic||ipv4|200.0.0.0|1024||reserved",
+			Record{Registry: "lacnic", Type: "ipv4", Start: "200.0.0.0", Value: 1024, Status: "reserved"}, true},
+		{"afrinic|ZA|ipv4|41.0.0.0|abc|20080101|allocated", Record{}, false},
+		{"ripencc|*|ipv4|*|100|summary", Record{}, false},
+		{"# comment", Record{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			got, ok := ParseRecord(tt.line)
+			if ok != tt.ok || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseRecord = %+v, %t; want %+v, %t", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestInvalidReason(t *testing.T) {
+	tests := []struct {
+		line, reason string
+	}{
+		{"", "empty"},
+		{" \r", "empty"},
+		{"# APNIC comment", "comment"},
+		{"apnic|*|ipv4|*|48000|summary\r", "summary"},
+		{"apnic|AU|ipv4|1.0.0.0", "too_few_fields"},
+		{"iana|AU|ipv4|1.0.0.0|256|20110811|assigned", "bad_registry"},
+		{"apnic|au|ipv4|1.0.0.0|256|20110811|assigned", "bad_cc"},
+		{"apnic|AU|ipv5|1.0.0.0|256|20110811|assigned", "bad_type"},
+		{"apnic|AU|ipv4|1.0.0.0/24|256|20110811|assigned", "bad_start"},
+		{"apnic|AU|ipv4|1.0.0.0|-1|20110811|assigned", "bad_value"},
+		{"apnic|AU|ipv4|1.0.0.0|256|2011-08-11|assigned", "bad_date"},
+		{"apnic|AU|ipv4|1.0.0.0|256|20110811|as signed", "bad_status"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			if got := InvalidReason(tt.line); got != tt.reason {
+				t.Errorf("InvalidReason = %q; want %q", got, tt.reason)
+			}
+		})
+	}
+}
+
+func TestReadHeader(t *testing.T) {
+	tests := []struct {
+		name, file string
+		want       FileHeader
+		err        error
+		first      string // start of the first record
+	}{
+		{"APNIC comments and CRLF",
+			"# Comments before the header\r\n#\r\n\r\n2.3|apnic|20240101|3|19830613|20231231|+1000\r\n" +
+				"apnic|*|asn|*|1|summary\r\napnic|*|ipv4|*|1|summary\r\napnic|*|ipv6|*|1|summary\r\n" +
+				"apnic|AU|ipv4|1.0.0.0|256|20110811|assigned\r\n",
+			FileHeader{Version: "2.3", Registry: "apnic", Serial: 20240101, Records: 3, StartDate: "19830613",
+				EndDate: "20231231", UTCOffset: 10, Summaries: map[string]uint64{"asn": 1, "ipv4": 1, "ipv6": 1}},
+			nil, "1.0.0.0"},
+		{"no start date",
+			"2|ripencc|1700000000|2|00000000|20231231|+0100\nripencc|*|ipv4|*|2|summary\n",
+			FileHeader{Version: "2", Registry: "ripencc", Serial: 1700000000, Records: 2, StartDate: "19700101",
+				EndDate: "20231231", UTCOffset: 1, Summaries: map[string]uint64{"asn": 0, "ipv4": 2, "ipv6": 0}},
+			nil, ""},
+		{"no version line", "apnic|AU|ipv4|1.0.0.0|256|20110811|assigned\n", FileHeader{}, ErrInvalidHeader, ""},
+		{"only comments", "# nothing else\n", FileHeader{}, ErrInvalidHeader, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewReader(strings.NewReader(tt.file))
+			hdr, err := r.ReadHeader()
+			if !errors.Is(err, tt.err) || !reflect.DeepEqual(hdr, tt.want) {
+				t.Fatalf("ReadHeader = %+v, %v; want %+v, %v", hdr, err, tt.want, tt.err)
+			}
+			if tt.first == "" {
+				return
+			}
+			rec, err := r.Next()
+			if err != nil || rec.Start != tt.first {
+				t.Errorf("first record %+v, %v; want start %s", rec, err, tt.first)
+			}
+			if _, err := r.Next(); err != io.EOF {
+				t.Errorf("after the last record: %v; want io.EOF", err)
+			}
+		})
+	}
+}
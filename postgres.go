@@ -0,0 +1,392 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/krassi/ip2asn/pkg/rirparse"
+	_ "github.com/lib/pq"
+)
+
+// postgresMaxBatchSize keeps a batch within PostgreSQL's 65535 parameters at up to 10
+// per record.
+const postgresMaxBatchSize = 6500
+
+// postgresDerived are the columns computed from each record on import, after
+// ID_LastDatasets, with the cast of their parameter.
+var postgresDerived = map[string][][2]string{
+	"ipv4": {{"LastIP", "::inet"}, {"Prefixes", ""}},
+	"ipv6": {{"LastIP", "::inet"}},
+}
+
+// postgresColumns were added to the record tables after databases were created from
+// db_schema_postgres.txt; upgradePostgresSchema adds them to older databases with
+// statements and fills them in with backfill.
+var postgresColumns = []struct {
+	table, column string
+	statements    []string
+	backfill      func(tx *sql.Tx) error
+}{
+	{"records_ipv4", "lastip", []string{"ALTER TABLE Records_ipv4 ADD COLUMN LastIP INET",
+		"CREATE INDEX ON Records_ipv4 (FirstIP, LastIP)",
+		"UPDATE Records_ipv4 SET LastIP = FirstIP + (HostCount - 1) WHERE HostCount > 0"}, nil},
+	{"records_ipv4", "prefixes", []string{"ALTER TABLE Records_ipv4 ADD COLUMN Prefixes TEXT"}, backfillPostgresIPv4Prefixes},
+	{"records_ipv6", "lastip", []string{"ALTER TABLE Records_ipv6 ADD COLUMN LastIP INET",
+		"CREATE INDEX ON Records_ipv6 (FirstIP, LastIP)",
+		"UPDATE Records_ipv6 SET LastIP = host(broadcast(set_masklen(FirstIP, PrefixLen)))::inet"}, nil},
+}
+
+// postgresStore imports into PostgreSQL (db_schema_postgres.txt). Addresses are stored
+// as inet, so the start address needs no conversion like MySQL's INET_ATON.
+type postgresStore struct {
+	db *sql.DB
+}
+
+// openPostgresStore connects to PGDATABASE (default ip2asn) using the standard PG*
+// environment variables, or the database section of the config file when they are
+// unset; a namespace selects the schema of that name.
+func openPostgresStore(ns string) (postgresStore, error) {
+	dsn := "dbname=" + pgQuote(dbSetting("PGDATABASE", "name", "ip2asn")) + " connect_timeout=15"
+	if user := dbSetting("PGUSER", "user", ""); user != "" {
+		dsn += " user=" + pgQuote(user)
+	}
+	if pass := dbSetting("PGPASSWORD", "password", ""); pass != "" {
+		dsn += " password=" + pgQuote(pass)
+	}
+	if ns != "" {
+		dsn += " search_path=" + ns
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return postgresStore{}, err
+	}
+	if err = db.Ping(); err != nil {
+		db.Close()
+		return postgresStore{}, err
+	}
+	if err = upgradePostgresSchema(db); err != nil {
+		db.Close()
+		return postgresStore{}, fmt.Errorf("upgrading the PostgreSQL tables: %w", err)
+	}
+	return postgresStore{db: db}, nil
+}
+
+// upgradePostgresSchema adds the missing postgresColumns to the record tables, each in
+// its own transaction.
+func upgradePostgresSchema(db *sql.DB) error {
+	for _, c := range postgresColumns {
+		var n int
+		err := db.QueryRow(`SELECT COUNT(*) FROM information_schema.columns
+			WHERE table_schema = current_schema() AND table_name = $1 AND column_name = $2;`, c.table, c.column).Scan(&n)
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			continue
+		}
+		logger.Info("Adding a column to the PostgreSQL tables", "table", c.table, "column", c.column)
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		for _, stmt := range c.statements {
+			if _, err := tx.Exec(stmt); err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+		if c.backfill != nil {
+			if err := c.backfill(tx); err != nil {
+				tx.Rollback()
+				return fmt.Errorf("filling in %s.%s: %w", c.table, c.column, err)
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// backfillPostgresIPv4Prefixes sets the Prefixes of the IPv4 records stored without them.
+func backfillPostgresIPv4Prefixes(tx *sql.Tx) error {
+	rows, err := tx.Query("SELECT ID, host(FirstIP), HostCount FROM Records_ipv4 WHERE Prefixes IS NULL;")
+	if err != nil {
+		return err
+	}
+	type update struct {
+		id       int64
+		prefixes interface{}
+	}
+	var updates []update
+	for rows.Next() {
+		var id int64
+		var start string
+		var count uint64
+		if err := rows.Scan(&id, &start, &count); err != nil {
+			rows.Close()
+			return err
+		}
+		updates = append(updates, update{id, ipv4PrefixList(start, count)})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare("UPDATE Records_ipv4 SET Prefixes = $1 WHERE ID = $2;")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, u := range updates {
+		if _, err := stmt.Exec(u.prefixes, u.id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pgQuote quotes a connection string value.
+func pgQuote(value string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value) + "'"
+}
+
+// savePostgresHeader stores the version and summary lines of a dataset in tx. With
+// force the dataset of the same registry and serial is updated and reused.
+func savePostgresHeader(tx *sql.Tx, hdr rirparse.FileHeader, force bool) (int64, error) {
+	var lastID int64
+	logger.Debug("Saving header data in database")
+	query := `INSERT INTO Datasets (ID_Registries, serial, version, records, startdate, enddate, UTCoffset)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`
+	if force {
+		query += ` ON CONFLICT (ID_Registries, serial) DO UPDATE SET version = EXCLUDED.version, records = EXCLUDED.records,
+			startdate = EXCLUDED.startdate, enddate = EXCLUDED.enddate, UTCoffset = EXCLUDED.UTCoffset`
+	}
+	err := tx.QueryRow(query+" RETURNING ID;", hdr.Registry, hdr.Serial, hdr.Version, hdr.Records, hdr.StartDate,
+		hdr.EndDate, hdr.UTCOffset).Scan(&lastID)
+	if err != nil {
+		return 0, fmt.Errorf("saving dataset header: %w", err)
+	}
+
+	for _, k := range []string{"ipv4", "asn", "ipv6"} {
+		_, err := tx.Exec(`INSERT INTO Summaries (ID_Datasets, RecordType, Count) VALUES ($1, $2, $3)
+			ON CONFLICT (ID_Datasets, RecordType) DO UPDATE SET Count = EXCLUDED.Count;`, lastID, k, hdr.Summaries[k])
+		if err != nil {
+			return 0, fmt.Errorf("saving the %s summary: %w", k, err) // A failed statement aborts the transaction
+		}
+	}
+	return lastID, nil
+}
+
+func (s postgresStore) HasDataset(registry string, serial uint64) (bool, error) {
+	var n int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM Datasets WHERE ID_Registries = $1 AND serial = $2;", registry, serial).Scan(&n)
+	return n > 0, err
+}
+
+func (s postgresStore) LatestSerial(registry string) (uint64, error) {
+	var serial uint64
+	err := s.db.QueryRow("SELECT COALESCE(MAX(serial), 0) FROM Datasets WHERE ID_Registries = $1;", registry).Scan(&serial)
+	return serial, err
+}
+
+func (s postgresStore) RegistryURL(registry string) (string, error) {
+	var URL string
+	err := s.db.QueryRow("SELECT LatestDataSetLocation FROM Registries WHERE ShortName = $1;", registry).Scan(&URL)
+	return URL, err
+}
+
+func (s postgresStore) MySQL() *sql.DB {
+	return nil
+}
+
+func (s postgresStore) Begin(hdr rirparse.FileHeader, force bool) (RecordTx, int64, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, 0, err
+	}
+	dataset, err := savePostgresHeader(tx, hdr, force)
+	if err != nil {
+		tx.Rollback()
+		return nil, 0, err
+	}
+	size := clampBatchSize(*f_batchSize)
+	if size > postgresMaxBatchSize {
+		size = postgresMaxBatchSize
+	}
+	t := &postgresRecordTx{tx: tx, batches: map[string]*postgresBatch{}}
+	for k, cols := range keyTypes {
+		cast := ""
+		if k != "asn" {
+			cast = "::inet"
+		}
+		t.batches[k] = &postgresBatch{tx: tx, recordType: k, cols: cols, cast: cast, derived: postgresDerived[k],
+			dataset: dataset, size: size}
+	}
+	return t, dataset, nil
+}
+
+type postgresRecordTx struct {
+	tx      *sql.Tx
+	batches map[string]*postgresBatch
+	counts  map[string]uint64
+}
+
+func (t *postgresRecordTx) SaveRecord(rec rirparse.Record) error {
+	args := []interface{}{rec.Registry, rec.CC, rec.Start, rec.Value, normalizeDate(rec.Date), rec.Status,
+		truncate(rec.OpaqueID, 255), truncate(strings.Join(rec.Extensions, "|"), 255)}
+	switch rec.Type {
+	case "ipv4":
+		args = append(args, ipv4LastIP(rec.Start, rec.Value), ipv4PrefixList(rec.Start, rec.Value))
+	case "ipv6":
+		args = append(args, ipv6LastIP(rec.Start, rec.Value))
+	}
+	return t.batches[rec.Type].add(args...)
+}
+
+func (t *postgresRecordTx) Flush() error {
+	for _, b := range t.batches {
+		if err := b.flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Commit writes the last batches and counts the rows returned by their upserts.
+func (t *postgresRecordTx) Commit() error {
+	if err := t.Flush(); err != nil {
+		return err
+	}
+	counts := map[string]uint64{}
+	for _, b := range t.batches {
+		counts["inserted"] += b.inserted
+		counts["updated"] += b.updated
+		counts["unchanged"] += b.written - b.inserted - b.updated
+	}
+	if err := t.tx.Commit(); err != nil {
+		return err
+	}
+	t.counts = counts
+	return nil
+}
+
+func (t *postgresRecordTx) Rollback() error {
+	return t.tx.Rollback()
+}
+
+func (t *postgresRecordTx) MySQL() *sql.Tx {
+	return nil
+}
+
+func (t *postgresRecordTx) RowCounts() map[string]uint64 {
+	return t.counts
+}
+
+// postgresBatch is the PostgreSQL counterpart of recordBatch, using numbered
+// placeholders and ON CONFLICT instead of ON DUPLICATE KEY UPDATE. The upsert returns
+// a row per inserted or updated record, telling which by xmax, and skips unchanged ones.
+type postgresBatch struct {
+	tx         *sql.Tx
+	recordType string
+	cols       [2]string   // start and value columns
+	cast       string      // of the start address
+	derived    [][2]string // postgresDerived of the record type
+	dataset    int64
+	size       int
+	args       []interface{}
+	n          int
+	queued     map[string]int // row of each conflict key in args
+	written    uint64         // records written
+	inserted   uint64
+	updated    uint64
+}
+
+// add queues a record: registry, cc, start, value, date, status, opaque ID and extensions,
+// then the derived columns. A record with the conflict key of one already queued replaces
+// it, as a single upsert cannot affect the same row twice.
+func (b *postgresBatch) add(args ...interface{}) error {
+	key := fmt.Sprintf("%v|%v|%v|%v|%v|%v", args[:6]...)
+	if i, ok := b.queued[key]; ok {
+		copy(b.args[i*len(args):], args)
+		b.written++ // Counted as unchanged
+		return nil
+	}
+	if b.queued == nil {
+		b.queued = make(map[string]int)
+	}
+	b.queued[key] = b.n
+	b.args = append(b.args, args...)
+	b.n++
+	if b.n >= b.size {
+		return b.flush()
+	}
+	return nil
+}
+
+func (b *postgresBatch) flush() error {
+	if b.n == 0 {
+		return nil
+	}
+	width := 8 + len(b.derived)
+	rows := make([]string, b.n)
+	for i := range rows {
+		p := i * width
+		rows[i] = fmt.Sprintf("(%d, $%d, $%d, $%d%s, $%d, $%d, $%d, $%d, $%d, %d", b.dataset,
+			p+1, p+2, p+3, b.cast, p+4, p+5, p+6, p+7, p+8, b.dataset)
+		for j, d := range b.derived {
+			rows[i] += fmt.Sprintf(", $%d%s", p+9+j, d[1])
+		}
+		rows[i] += ")"
+	}
+	var derived string
+	for _, d := range b.derived {
+		derived += ", " + d[0]
+	}
+	table := "Records_" + b.recordType
+	query := fmt.Sprintf(`INSERT INTO %[1]s (ID_Datasets, ID_Registries, CC, %[2]s, %[3]s, RecordDate, State, OpaqueID, Extensions,
+		ID_LastDatasets%[4]s) VALUES %[5]s ON CONFLICT (ID_Registries, CC, %[2]s, %[3]s, RecordDate, State) DO UPDATE SET
+		ID_LastDatasets = EXCLUDED.ID_LastDatasets, OpaqueID = EXCLUDED.OpaqueID, Extensions = EXCLUDED.Extensions
+		WHERE (%[1]s.ID_LastDatasets, %[1]s.OpaqueID, %[1]s.Extensions) IS DISTINCT FROM
+		(EXCLUDED.ID_LastDatasets, EXCLUDED.OpaqueID, EXCLUDED.Extensions) RETURNING (xmax = 0);`,
+		table, b.cols[0], b.cols[1], derived, strings.Join(rows, ", "))
+	res, err := b.tx.Query(query, b.args...)
+	if err != nil {
+		return fmt.Errorf("inserting %d records into %s: %w", b.n, table, err)
+	}
+	for res.Next() {
+		var inserted bool
+		if err := res.Scan(&inserted); err != nil {
+			res.Close()
+			return err
+		}
+		if inserted {
+			b.inserted++
+		} else {
+			b.updated++
+		}
+	}
+	res.Close()
+	if err := res.Err(); err != nil {
+		return fmt.Errorf("inserting %d records into %s: %w", b.n, table, err)
+	}
+	b.written += uint64(b.n)
+	b.args, b.n, b.queued = b.args[:0], 0, nil
+	return nil
+}
+
+// runPostgres imports the datasets selected by -source into PostgreSQL.
+func runPostgres() {
+	if flag.NArg() > 0 && !isCommand("import") {
+		log.Fatal("-db-driver postgres only supports imports; other commands need MySQL")
+	}
+	st, err := openPostgresStore(*f_namespace)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	defer st.db.Close()
+	runImports(st)
+}
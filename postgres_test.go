@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/krassi/ip2asn/pkg/rirparse"
+)
+
+// TestPostgresBatchDuplicates queues a file with a line repeated, as some registries'
+// files have, and expects one row per conflict key holding the last line's values.
+func TestPostgresBatchDuplicates(t *testing.T) {
+	b := &postgresBatch{recordType: "ipv4", cols: keyTypes["ipv4"], cast: "::inet", derived: postgresDerived["ipv4"],
+		dataset: 1, size: postgresMaxBatchSize}
+	tx := &postgresRecordTx{batches: map[string]*postgresBatch{"ipv4": b}}
+	record := func(start, holder string) rirparse.Record {
+		return rirparse.Record{Registry: "ripencc", CC: "NL", Type: "ipv4", Start: start, Value: 256, Date: "20200101",
+			Status: "allocated", OpaqueID: holder}
+	}
+	for _, rec := range []rirparse.Record{record("100.64.0.0", "org-a"), record("100.64.1.0", "org-a"),
+		record("100.64.0.0", "org-b")} {
+		if err := tx.SaveRecord(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if b.n != 2 || b.written != 1 {
+		t.Fatalf("%d rows queued, %d duplicates; want 2, 1", b.n, b.written)
+	}
+	width := len(b.args) / b.n
+	if holder := b.args[6]; holder != "org-b" {
+		t.Errorf("holder of the repeated line %v; want org-b", holder)
+	}
+	if start := b.args[width+2]; start != "100.64.1.0" {
+		t.Errorf("second row starts at %v; want 100.64.1.0", start)
+	}
+}
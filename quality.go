@@ -0,0 +1,127 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"math"
+	"net/netip"
+	"sort"
+)
+
+// datasetQuality scores how well-formed a dataset is. Score is 100 for a file without
+// invalid lines, count mismatches, date anomalies or overlapping blocks, and drops by
+// the share of records affected by each.
+type datasetQuality struct {
+	Records       uint64  `json:"records"`
+	Invalid       uint64  `json:"invalid"`
+	CountMismatch uint64  `json:"count_mismatch"` // summed difference to the summary lines
+	DateAnomalies uint64  `json:"date_anomalies"` // allocations without date or dated after the file
+	Overlaps      uint64  `json:"overlaps"`       // address blocks overlapping an earlier block
+	Score         float64 `json:"score"`
+}
+
+type addrRange struct{ first, last netip.Addr }
+
+// qualityChecker collects the data of a dataset needed for its quality score.
+type qualityChecker struct {
+	enddate       string
+	dateAnomalies uint64
+	ranges        []addrRange
+}
+
+func newQualityChecker(enddate string) *qualityChecker {
+	return &qualityChecker{enddate: datasetDate(enddate)}
+}
+
+// observe checks a valid record; date is YYYYMMDD or the 1970-01-01 placeholder for missing dates.
+func (q *qualityChecker) observe(kind, start string, value uint64, date, status string) {
+	if status == "allocated" || status == "assigned" {
+		if date == "1970-01-01" || normalizeDate(date) > q.enddate {
+			q.dateAnomalies++
+		}
+	}
+	if kind == "asn" {
+		return
+	}
+	prefixes, err := recordPrefixes(kind, start, value)
+	if err != nil || len(prefixes) == 0 {
+		return
+	}
+	q.ranges = append(q.ranges, addrRange{prefixes[0].Addr(), lastAddr(prefixes[len(prefixes)-1])})
+}
+
+// finish computes the score from the record counts of the import.
+func (q *qualityChecker) finish(counts, expected map[string]uint64) datasetQuality {
+	r := datasetQuality{Records: counts["all"], Invalid: counts["invalid"], DateAnomalies: q.dateAnomalies}
+	for _, k := range []string{"asn", "ipv4", "ipv6"} {
+		if e, ok := expected[k]; ok {
+			if e > counts[k] {
+				r.CountMismatch += e - counts[k]
+			} else {
+				r.CountMismatch += counts[k] - e
+			}
+		}
+	}
+
+	sort.Slice(q.ranges, func(i, j int) bool { return q.ranges[i].first.Less(q.ranges[j].first) })
+	var last netip.Addr
+	for i, rg := range q.ranges {
+		if i > 0 && rg.first.BitLen() == last.BitLen() && rg.first.Compare(last) <= 0 {
+			r.Overlaps++
+		}
+		if i == 0 || rg.first.BitLen() != last.BitLen() || rg.last.Compare(last) > 0 {
+			last = rg.last
+		}
+	}
+
+	r.Score = 100
+	if r.Records > 0 {
+		penalty := float64(r.Invalid+r.CountMismatch+r.DateAnomalies+r.Overlaps) / float64(r.Records)
+		r.Score = math.Round(100*math.Max(0, 1-penalty)*100) / 100
+	}
+	return r
+}
+
+func saveQuality(tx *sql.Tx, dataset int64, q datasetQuality) error {
+	_, err := tx.Exec("REPLACE INTO DatasetQuality VALUES (?, ?, ?, ?, ?, ?, ?);",
+		dataset, q.Records, q.Invalid, q.CountMismatch, q.DateAnomalies, q.Overlaps, q.Score)
+	if err != nil {
+		return fmt.Errorf("saving quality: %w", err)
+	}
+	return nil
+}
+
+// qualityStats lists the quality scores of recent datasets, newest first.
+func qualityStats(db *sql.DB, registry string, limit int, format string) {
+	rows, err := db.Query(`SELECT d.ID_Registries, d.serial, IFNULL(d.enddate, ''), q.Records, q.Invalid, q.CountMismatch,
+		q.DateAnomalies, q.Overlaps, q.Score FROM DatasetQuality q JOIN Datasets d ON d.ID = q.ID_Datasets
+		WHERE ? = '' OR d.ID_Registries = ? ORDER BY d.enddate DESC, d.ID_Registries LIMIT ?;`, registry, registry, limit)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rows.Close()
+
+	type datasetScore struct {
+		Registry string `json:"registry"`
+		Serial   uint64 `json:"serial"`
+		Date     string `json:"date"`
+		datasetQuality
+	}
+	list := []datasetScore{}
+	var table [][]string
+	for rows.Next() {
+		var s datasetScore
+		if err := rows.Scan(&s.Registry, &s.Serial, &s.Date, &s.Records, &s.Invalid, &s.CountMismatch,
+			&s.DateAnomalies, &s.Overlaps, &s.Score); err != nil {
+			log.Fatal(err)
+		}
+		list = append(list, s)
+		table = append(table, []string{s.Registry, fmt.Sprint(s.Serial), s.Date, fmt.Sprint(s.Records), fmt.Sprint(s.Invalid),
+			fmt.Sprint(s.CountMismatch), fmt.Sprint(s.DateAnomalies), fmt.Sprint(s.Overlaps), fmt.Sprintf("%.2f", s.Score)})
+	}
+	if err := rows.Err(); err != nil {
+		log.Fatal(err)
+	}
+	writeReport(format, []string{"registry", "serial", "date", "records", "invalid", "count_mismatch", "date_anomalies", "overlaps", "score"}, table, list)
+}
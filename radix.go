@@ -0,0 +1,150 @@
+package main
+
+import (
+	"math/bits"
+	"net/netip"
+)
+
+// prefixTrie is a path-compressed binary trie of IPv4 and IPv6 prefixes for
+// longest-prefix matches. Each node holds a prefix; a node exists for every stored
+// prefix and every branch point, so the trie has fewer than twice as many nodes as
+// prefixes. Values are indexes into a slice kept by the caller.
+type prefixTrie struct {
+	roots [2]*trieNode // IPv4, IPv6
+	size  int
+}
+
+type trieNode struct {
+	prefix   netip.Prefix // masked
+	value    int          // -1 for a branch point without a prefix of its own
+	children [2]*trieNode
+}
+
+func familyOf(addr netip.Addr) int {
+	if addr.Is4() {
+		return 0
+	}
+	return 1
+}
+
+// bitAt returns bit i of addr, counting from the most significant.
+func bitAt(addr netip.Addr, i int) int {
+	if addr.Is4() {
+		b := addr.As4()
+		return int(b[i/8]>>(7-i%8)) & 1
+	}
+	b := addr.As16()
+	return int(b[i/8]>>(7-i%8)) & 1
+}
+
+// commonBits returns the number of leading bits a and b of the same family share, at
+// most max.
+func commonBits(a, b netip.Addr, max int) int {
+	var x, y [16]byte
+	if a.Is4() {
+		a4, b4 := a.As4(), b.As4()
+		copy(x[:], a4[:])
+		copy(y[:], b4[:])
+	} else {
+		x, y = a.As16(), b.As16()
+	}
+	n := 0
+	for i := 0; i < len(x) && n < max; i++ {
+		if d := x[i] ^ y[i]; d != 0 {
+			n += bits.LeadingZeros8(d)
+			break
+		}
+		n += 8
+	}
+	if n > max {
+		n = max
+	}
+	return n
+}
+
+// insert stores value for a prefix, replacing the value of an equal prefix.
+func (t *prefixTrie) insert(p netip.Prefix, value int) {
+	p = p.Masked()
+	link := &t.roots[familyOf(p.Addr())]
+	for {
+		n := *link
+		if n == nil {
+			*link = &trieNode{prefix: p, value: value}
+			t.size++
+			return
+		}
+		shorter := n.prefix.Bits()
+		if p.Bits() < shorter {
+			shorter = p.Bits()
+		}
+		common := commonBits(n.prefix.Addr(), p.Addr(), shorter)
+		switch {
+		case common == n.prefix.Bits() && common == p.Bits():
+			if n.value < 0 {
+				t.size++
+			}
+			n.value = value
+			return
+		case common == n.prefix.Bits(): // p lies below n
+			link = &n.children[bitAt(p.Addr(), common)]
+			continue
+		}
+		// p covers n, or they diverge: insert a node where they part
+		parent := &trieNode{prefix: netip.PrefixFrom(p.Addr(), common).Masked(), value: -1}
+		parent.children[bitAt(n.prefix.Addr(), common)] = n
+		if common == p.Bits() {
+			parent.value = value
+		} else {
+			parent.children[bitAt(p.Addr(), common)] = &trieNode{prefix: p, value: value}
+		}
+		*link = parent
+		t.size++
+		return
+	}
+}
+
+// match returns the value and the longest stored prefix containing addr.
+func (t *prefixTrie) match(addr netip.Addr) (int, netip.Prefix, bool) {
+	best, found := -1, netip.Prefix{}
+	for n := t.roots[familyOf(addr)]; n != nil && n.prefix.Contains(addr); {
+		if n.value >= 0 {
+			best, found = n.value, n.prefix
+		}
+		if n.prefix.Bits() == addr.BitLen() {
+			break
+		}
+		n = n.children[bitAt(addr, n.prefix.Bits())]
+	}
+	return best, found, best >= 0
+}
+
+// eachCovering calls fn with the value of every stored prefix containing all of p, the
+// shortest first, until fn returns false.
+func (t *prefixTrie) eachCovering(p netip.Prefix, fn func(value int) bool) {
+	p = p.Masked()
+	for n := t.roots[familyOf(p.Addr())]; n != nil && n.prefix.Bits() <= p.Bits() && n.prefix.Contains(p.Addr()); {
+		if n.value >= 0 && !fn(n.value) {
+			return
+		}
+		if n.prefix.Bits() == p.Bits() {
+			break
+		}
+		n = n.children[bitAt(p.Addr(), n.prefix.Bits())]
+	}
+}
+
+// covering returns the value and the longest stored prefix containing all of p.
+func (t *prefixTrie) covering(p netip.Prefix) (int, netip.Prefix, bool) {
+	p = p.Masked()
+	best, found := -1, netip.Prefix{}
+	for n := t.roots[familyOf(p.Addr())]; n != nil && n.prefix.Bits() <= p.Bits() && n.prefix.Contains(p.Addr()); {
+		if n.value >= 0 {
+			best, found = n.value, n.prefix
+		}
+		if n.prefix.Bits() == p.Bits() {
+			break
+		}
+		n = n.children[bitAt(p.Addr(), n.prefix.Bits())]
+	}
+	return best, found, best >= 0
+}
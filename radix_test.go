@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+func TestPrefixTrie(t *testing.T) {
+	stored := []string{"10.0.0.0/8", "10.1.0.0/16", "10.1.2.0/24", "10.2.0.0/16", "0.0.0.0/0",
+		"2001:db8::/32", "2001:db8:1::/48", "10.1.0.0/16"} // The last replaces its value
+	var trie prefixTrie
+	for i, p := range stored {
+		trie.insert(netip.MustParsePrefix(p), i)
+	}
+	if trie.size != 7 {
+		t.Errorf("size %d; want 7", trie.size)
+	}
+
+	matches := []struct {
+		addr, prefix string // prefix "" for no match
+		value        int
+	}{
+		{"10.1.2.3", "10.1.2.0/24", 2},
+		{"10.1.3.1", "10.1.0.0/16", 7},
+		{"10.3.0.1", "10.0.0.0/8", 0},
+		{"192.0.2.1", "0.0.0.0/0", 4},
+		{"2001:db8:1::1", "2001:db8:1::/48", 6},
+		{"2001:db8:2::1", "2001:db8::/32", 5},
+		{"2001:db9::1", "", 0}, // No IPv6 default route
+	}
+	for _, tt := range matches {
+		value, prefix, ok := trie.match(netip.MustParseAddr(tt.addr))
+		if tt.prefix == "" {
+			if ok {
+				t.Errorf("match(%s) = %d, %s; want none", tt.addr, value, prefix)
+			}
+		} else if !ok || value != tt.value || prefix != netip.MustParsePrefix(tt.prefix) {
+			t.Errorf("match(%s) = %d, %s, %t; want %d, %s", tt.addr, value, prefix, ok, tt.value, tt.prefix)
+		}
+	}
+
+	coverings := []struct {
+		prefix, covering string // covering "" for none
+		all              []int  // values of eachCovering
+	}{
+		{"10.1.2.0/24", "10.1.2.0/24", []int{4, 0, 7, 2}},
+		{"10.1.2.0/23", "10.1.0.0/16", []int{4, 0, 7}},
+		{"10.0.0.0/7", "0.0.0.0/0", []int{4}},
+		{"2001:db8:1:2::/64", "2001:db8:1::/48", []int{5, 6}},
+		{"2001:db8::/31", "", nil},
+	}
+	for _, tt := range coverings {
+		p := netip.MustParsePrefix(tt.prefix)
+		_, covering, ok := trie.covering(p)
+		if tt.covering == "" {
+			if ok {
+				t.Errorf("covering(%s) = %s; want none", tt.prefix, covering)
+			}
+		} else if !ok || covering != netip.MustParsePrefix(tt.covering) {
+			t.Errorf("covering(%s) = %s, %t; want %s", tt.prefix, covering, ok, tt.covering)
+		}
+		var all []int
+		trie.eachCovering(p, func(value int) bool {
+			all = append(all, value)
+			return true
+		})
+		if !reflect.DeepEqual(all, tt.all) {
+			t.Errorf("eachCovering(%s) = %v; want %v", tt.prefix, all, tt.all)
+		}
+	}
+
+	var first []int
+	trie.eachCovering(netip.MustParsePrefix("10.1.2.0/24"), func(value int) bool {
+		first = append(first, value)
+		return false
+	})
+	if !reflect.DeepEqual(first, []int{4}) {
+		t.Errorf("eachCovering stopped after %v; want [4]", first)
+	}
+}
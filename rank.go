@@ -0,0 +1,128 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+)
+
+// holderRank sums the resources of one holder (opaque ID).
+type holderRank struct {
+	Rank          int    `json:"rank"`
+	Holder        string `json:"holder"`
+	Name          string `json:"name,omitempty"`
+	Registry      string `json:"registry"`
+	ASNs          uint64 `json:"asns"`
+	Prefixes      uint64 `json:"prefixes"`
+	IPv4Addresses uint64 `json:"ipv4_addresses"`
+	IPv6Slash48s  uint64 `json:"ipv6_48s"`
+	PrefixesThen  *int64 `json:"prefixes_then,omitempty"` // at the -trend date
+	AddressesThen *int64 `json:"ipv4_addresses_then,omitempty"`
+}
+
+// holderTotals returns the allocated and assigned resources per holder, either current
+// (empty date) or as of a date, derived from the first and last seen dates in Resources.
+func holderTotals(db *sql.DB, date string) (map[string]*holderRank, error) {
+	cond := "State IN ('allocated', 'assigned')"
+	var params []interface{}
+	if date != "" {
+		cond = "FirstSeen <= ? AND LastSeen >= ?"
+		params = append(params, date, date)
+	}
+	rows, err := db.Query(`SELECT r.Holder, IFNULL(MAX(o.Name), ''), MIN(r.ID_Registries),
+		SUM(IF(r.RecordType = 'asn', r.Value, 0)), SUM(r.RecordType <> 'asn'),
+		SUM(IF(r.RecordType = 'ipv4', r.Value, 0)), SUM(IF(r.RecordType = 'ipv6', POW(2, GREATEST(48 - CAST(r.Value AS SIGNED), 0)), 0))
+		FROM Resources r LEFT JOIN Orgs o ON o.ID_Registries = r.ID_Registries AND o.OpaqueID = r.Holder
+		WHERE r.Holder <> '' AND `+cond+` GROUP BY r.Holder;`, params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	totals := map[string]*holderRank{}
+	for rows.Next() {
+		var h holderRank
+		var v6 float64
+		if err := rows.Scan(&h.Holder, &h.Name, &h.Registry, &h.ASNs, &h.Prefixes, &h.IPv4Addresses, &v6); err != nil {
+			return nil, err
+		}
+		h.IPv6Slash48s = uint64(v6)
+		totals[h.Holder] = &h
+	}
+	return totals, rows.Err()
+}
+
+// rankCommand ranks holders by number of prefixes or IPv4 address space, optionally
+// with the totals at an earlier date for the trend.
+func rankCommand(db *sql.DB, args []string) {
+	fs := flag.NewFlagSet("rank", flag.ExitOnError)
+	by := fs.String("by", "prefixes", "Rank by: prefixes, addresses, ipv6 or asns")
+	limit := fs.Int("limit", 25, "Number of holders to list")
+	trend := fs.Duration("trend", 0, "Also show the totals this long ago, e.g. 8760h")
+	format := fs.String("format", "table", "Output format: table, csv or json")
+	fs.Parse(args)
+
+	totals, err := holderTotals(db, "")
+	if err != nil {
+		log.Fatal(err)
+	}
+	list := make([]holderRank, 0, len(totals))
+	for _, h := range totals {
+		list = append(list, *h)
+	}
+	key := map[string]func(h holderRank) uint64{
+		"prefixes":  func(h holderRank) uint64 { return h.Prefixes },
+		"addresses": func(h holderRank) uint64 { return h.IPv4Addresses },
+		"ipv6":      func(h holderRank) uint64 { return h.IPv6Slash48s },
+		"asns":      func(h holderRank) uint64 { return h.ASNs },
+	}[*by]
+	if key == nil {
+		log.Fatal("Unknown ranking: " + *by)
+	}
+	sortByKey(list, key)
+	if len(list) > *limit {
+		list = list[:*limit]
+	}
+
+	header := []string{"rank", "holder", "name", "registry", "asns", "prefixes", "ipv4_addresses", "ipv6_48s"}
+	if *trend > 0 {
+		then, err := holderTotals(db, time.Now().Add(-*trend).UTC().Format("2006-01-02"))
+		if err != nil {
+			log.Fatal(err)
+		}
+		for i := range list {
+			var prefixes, addresses int64
+			if h, ok := then[list[i].Holder]; ok {
+				prefixes, addresses = int64(h.Prefixes), int64(h.IPv4Addresses)
+			}
+			list[i].PrefixesThen, list[i].AddressesThen = &prefixes, &addresses
+		}
+		header = append(header, "prefixes_then", "ipv4_addresses_then")
+	}
+
+	rows := make([][]string, 0, len(list))
+	for i := range list {
+		h := &list[i]
+		h.Rank = i + 1
+		row := []string{fmt.Sprint(h.Rank), h.Holder, h.Name, h.Registry, fmt.Sprint(h.ASNs), fmt.Sprint(h.Prefixes),
+			fmt.Sprint(h.IPv4Addresses), fmt.Sprint(h.IPv6Slash48s)}
+		if h.PrefixesThen != nil {
+			row = append(row, fmt.Sprint(*h.PrefixesThen), fmt.Sprint(*h.AddressesThen))
+		}
+		rows = append(rows, row)
+	}
+	writeReport(*format, header, rows, list)
+}
+
+// sortByKey sorts holders by descending key, then by holder for a stable order.
+func sortByKey(list []holderRank, key func(h holderRank) uint64) {
+	sort.Slice(list, func(i, j int) bool {
+		if a, b := key(list[i]), key(list[j]); a != b {
+			return a > b
+		}
+		return list[i].Holder < list[j].Holder
+	})
+}
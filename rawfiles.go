@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"os"
+	"text/tabwriter"
+)
+
+// rawArchive compresses a file while it is imported, for archiveRawFile.
+type rawArchive struct {
+	sum  hash.Hash
+	size int
+	buf  bytes.Buffer
+	zw   *gzip.Writer
+}
+
+// newRawArchive returns nil unless -archive-raw is set.
+func newRawArchive() *rawArchive {
+	if !*f_archiveRaw {
+		return nil
+	}
+	a := &rawArchive{sum: sha256.New()}
+	a.zw = gzip.NewWriter(&a.buf)
+	return a
+}
+
+func (a *rawArchive) Write(p []byte) (int, error) {
+	a.sum.Write(p)
+	a.size += len(p)
+	return a.zw.Write(p)
+}
+
+// archiveRawFile stores the original file, gzip compressed, alongside its dataset
+// so the import can be reproduced or re-parsed later.
+func archiveRawFile(db *sql.DB, result ImportResult, raw *rawArchive) {
+	if raw == nil || result.Dataset == 0 {
+		return
+	}
+	raw.zw.Close()
+	_, err := db.Exec("INSERT IGNORE INTO RawFiles VALUES (DEFAULT, ?, ?, ?, ?, ?, ?);",
+		result.Dataset, result.Source, result.Started.UTC().Format("2006-01-02 15:04:05"),
+		hex.EncodeToString(raw.sum.Sum(nil)), raw.size, raw.buf.Bytes())
+	if err != nil {
+		logger.Warn("Cannot archive raw file", "err", err)
+		return
+	}
+	logger.Debug("Archived raw file", "bytes", raw.size, "compressed", raw.buf.Len())
+}
+
+// rawCommand implements "raw list" and "raw extract DATASET_ID [FILE]"; an extracted
+// file can be re-imported with -in FILE -force.
+func rawCommand(db *sql.DB, args []string) {
+	if len(args) == 0 {
+		log.Fatal("Usage: raw list | raw extract DATASET_ID [FILE]")
+	}
+	switch args[0] {
+	case "list":
+		rows, err := db.Query(`SELECT r.ID_Datasets, d.ID_Registries, d.serial, r.FetchedAt, r.Size, r.SHA256, r.Source
+			FROM RawFiles r JOIN Datasets d ON d.ID = r.ID_Datasets ORDER BY r.ID;`)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer rows.Close()
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "DATASET\tREGISTRY\tSERIAL\tFETCHED (UTC)\tSIZE\tSHA256\tSOURCE")
+		for rows.Next() {
+			var dataset, serial, size uint64
+			var registry, fetched, hash, source string
+			if err := rows.Scan(&dataset, &registry, &serial, &fetched, &size, &hash, &source); err != nil {
+				log.Fatal(err)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%d\t%s\t%d\t%s\t%s\n", dataset, registry, serial, fetched, size, hash, source)
+		}
+		w.Flush()
+	case "extract":
+		if len(args) < 2 {
+			log.Fatal("Usage: raw extract DATASET_ID [FILE]")
+		}
+		var data []byte
+		var hash string
+		err := db.QueryRow("SELECT Data, SHA256 FROM RawFiles WHERE ID_Datasets = ? ORDER BY ID DESC LIMIT 1;", args[1]).Scan(&data, &hash)
+		if err == sql.ErrNoRows {
+			log.Fatal("No raw file archived for dataset " + args[1])
+		} else if err != nil {
+			log.Fatal(err)
+		}
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			log.Fatal(err)
+		}
+		raw, err := io.ReadAll(zr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if sum := sha256.Sum256(raw); hex.EncodeToString(sum[:]) != hash {
+			log.Fatal("Archived file is corrupt: checksum mismatch")
+		}
+
+		out := os.Stdout
+		if len(args) > 2 {
+			if out, err = os.Create(args[2]); err != nil {
+				log.Fatal(err)
+			}
+			defer out.Close()
+		}
+		if _, err := out.Write(raw); err != nil {
+			log.Fatal(err)
+		}
+	default:
+		log.Fatal("Unknown raw command: " + args[0])
+	}
+}
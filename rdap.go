@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rdapBootstrapURL is the IANA registry of the RDAP services of address and ASN ranges;
+// %s is ipv4, ipv6 or asn.
+const rdapBootstrapURL = "https://data.iana.org/rdap/%s.json"
+
+// rdapAnswer is the live answer of a registry's RDAP service for an address or ASN.
+type rdapAnswer struct {
+	Registry string   `json:"registry,omitempty"`
+	Handle   string   `json:"handle,omitempty"`
+	Name     string   `json:"name,omitempty"`
+	Type     string   `json:"type"` // ipv4, ipv6 or asn
+	Start    string   `json:"start,omitempty"`
+	End      string   `json:"end,omitempty"`
+	CC       string   `json:"cc,omitempty"`
+	Status   []string `json:"status,omitempty"`
+	Holder   string   `json:"holder,omitempty"` // name of the registrant
+	Fetched  string   `json:"fetched"`
+}
+
+// rdapQuery returns the record type and RDAP path of an address or ASN query.
+func rdapQuery(q string) (kind, path string, ok bool) {
+	if asn, err := strconv.ParseUint(strings.TrimPrefix(strings.ToUpper(q), "AS"), 10, 32); err == nil {
+		return "asn", "autnum/" + strconv.FormatUint(asn, 10), true
+	}
+	addr, err := netip.ParseAddr(q)
+	if err != nil {
+		return "", "", false
+	}
+	addr = addr.Unmap()
+	if addr.Is4() {
+		return "ipv4", "ip/" + addr.String(), true
+	}
+	return "ipv6", "ip/" + addr.String(), true
+}
+
+// lookupRDAP answers a lookup query from the RDAP service of registry, or of the
+// registry IANA lists for it when registry is empty. Answers are cached in RdapCache for
+// -rdap-cache-ttl when db is not nil.
+func lookupRDAP(ctx context.Context, db *sql.DB, registry, q string) (rdapAnswer, error) {
+	kind, path, ok := rdapQuery(q)
+	if !ok {
+		return rdapAnswer{}, fmt.Errorf("not an address or ASN: %s", q)
+	}
+	cache := db != nil && *f_rdapCacheTTL > 0
+	if cache {
+		var data []byte
+		err := db.QueryRow("SELECT Response FROM RdapCache WHERE Query = ? AND Expires > UTC_TIMESTAMP();", path).Scan(&data)
+		if err == nil {
+			var a rdapAnswer
+			if err := json.Unmarshal(data, &a); err == nil {
+				return a, nil
+			}
+		} else if err != sql.ErrNoRows && !isMissingTable(err) {
+			logger.Debug("Cannot read the RDAP cache", "err", err)
+		}
+	}
+
+	base := rdapURLs[registry]
+	if base == "" {
+		var err error
+		if base, err = rdapService(ctx, kind, q); err != nil {
+			return rdapAnswer{}, err
+		}
+	}
+	a, err := fetchRDAP(ctx, base, path)
+	if err != nil {
+		return a, err
+	}
+	a.Type = kind
+	if a.Registry = registry; a.Registry == "" {
+		a.Registry = rdapRegistry(base)
+	}
+
+	if cache {
+		data, _ := json.Marshal(a)
+		_, err := db.Exec("REPLACE INTO RdapCache VALUES (?, ?, UTC_TIMESTAMP(), UTC_TIMESTAMP() + INTERVAL ? SECOND);",
+			path, data, int64(f_rdapCacheTTL.Seconds()))
+		if err != nil && !isMissingTable(err) {
+			logger.Debug("Cannot cache the RDAP answer", "query", q, "err", err)
+		}
+	}
+	return a, nil
+}
+
+// fetchRDAP queries an RDAP service for an ip/ or autnum/ path.
+func fetchRDAP(ctx context.Context, base, path string) (rdapAnswer, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, base+path, nil)
+	if err != nil {
+		return rdapAnswer{}, err
+	}
+	req.Header.Set("Accept", "application/rdap+json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return rdapAnswer{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return rdapAnswer{}, fmt.Errorf("RDAP %s: %s", base+path, resp.Status)
+	}
+	var obj struct {
+		Handle       string       `json:"handle"`
+		Name         string       `json:"name"`
+		Country      string       `json:"country"`
+		Status       []string     `json:"status"`
+		StartAddress string       `json:"startAddress"`
+		EndAddress   string       `json:"endAddress"`
+		StartAutnum  uint64       `json:"startAutnum"`
+		EndAutnum    uint64       `json:"endAutnum"`
+		Entities     []rdapEntity `json:"entities"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&obj); err != nil {
+		return rdapAnswer{}, fmt.Errorf("RDAP %s: %w", base+path, err)
+	}
+	a := rdapAnswer{Handle: obj.Handle, Name: obj.Name, CC: strings.ToUpper(obj.Country), Status: obj.Status,
+		Start: obj.StartAddress, End: obj.EndAddress, Fetched: time.Now().UTC().Format("2006-01-02 15:04:05")}
+	if strings.HasPrefix(path, "autnum/") {
+		a.Start, a.End = strconv.FormatUint(obj.StartAutnum, 10), strconv.FormatUint(obj.EndAutnum, 10)
+	}
+	a.Holder = findRegistrant(obj.Entities)
+	return a, nil
+}
+
+// findRegistrant returns the formatted name of the first registrant entity.
+func findRegistrant(entities []rdapEntity) string {
+	for _, e := range entities {
+		for _, role := range e.Roles {
+			if role == "registrant" {
+				if name := vcardProperty(e.VCardArray, "fn"); name != "" {
+					return name
+				}
+			}
+		}
+		if name := findRegistrant(e.Entities); name != "" {
+			return name
+		}
+	}
+	return ""
+}
+
+// rdapBootstrap caches the IANA bootstrap files by kind.
+var rdapBootstrap = struct {
+	sync.Mutex
+	services map[string][][2][]string // kind: [ranges, URLs]
+}{services: map[string][][2][]string{}}
+
+// rdapService returns the base URL of the RDAP service IANA lists for an address or ASN.
+func rdapService(ctx context.Context, kind, q string) (string, error) {
+	rdapBootstrap.Lock()
+	defer rdapBootstrap.Unlock()
+	services, ok := rdapBootstrap.services[kind]
+	if !ok {
+		u := fmt.Sprintf(rdapBootstrapURL, kind)
+		data, err := downloadFile(ctx, &u)
+		if err != nil {
+			return "", fmt.Errorf("reading the RDAP bootstrap file %s: %w", u, err)
+		}
+		var file struct {
+			Services [][2][]string `json:"services"`
+		}
+		if err := json.Unmarshal(data, &file); err != nil {
+			return "", fmt.Errorf("parsing the RDAP bootstrap file %s: %w", u, err)
+		}
+		services = file.Services
+		rdapBootstrap.services[kind] = services
+	}
+
+	addr, _ := netip.ParseAddr(q)
+	asn, _ := strconv.ParseUint(strings.TrimPrefix(strings.ToUpper(q), "AS"), 10, 32)
+	for _, s := range services {
+		for _, r := range s[0] {
+			if !bootstrapContains(kind, r, addr.Unmap(), asn) {
+				continue
+			}
+			// Prefer HTTPS; the URLs end with a slash
+			for _, base := range s[1] {
+				if strings.HasPrefix(base, "https://") {
+					return base, nil
+				}
+			}
+			if len(s[1]) > 0 {
+				return s[1][0], nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no RDAP service listed for %s", q)
+}
+
+// bootstrapContains reports whether an entry of a bootstrap file, a prefix or an ASN
+// range "64496-64511", contains the query.
+func bootstrapContains(kind, entry string, addr netip.Addr, asn uint64) bool {
+	if kind == "asn" {
+		first, last, found := strings.Cut(entry, "-")
+		if !found {
+			last = first
+		}
+		lo, err1 := strconv.ParseUint(first, 10, 32)
+		hi, err2 := strconv.ParseUint(last, 10, 32)
+		return err1 == nil && err2 == nil && lo <= asn && asn <= hi
+	}
+	p, err := netip.ParsePrefix(entry)
+	return err == nil && p.Contains(addr)
+}
+
+// rdapRegistry returns the registry whose RDAP service has the host of base.
+func rdapRegistry(base string) string {
+	u, err := url.Parse(base)
+	if err != nil {
+		return ""
+	}
+	for registry, service := range rdapURLs {
+		if s, err := url.Parse(service); err == nil && s.Host == u.Host {
+			return registry
+		}
+	}
+	return ""
+}
+
+// mergeRDAP adds a live answer to a lookup answer, and fills in the registry, country
+// and range when the database has no delegation for the query.
+func mergeRDAP(a *lookupAnswer, r rdapAnswer) {
+	a.RDAP = &r
+	if a.Registry != "" {
+		return
+	}
+	a.Registry, a.CC, a.Type, a.Start = r.Registry, r.CC, r.Type, r.Start
+	first, err1 := netip.ParseAddr(r.Start)
+	last, err2 := netip.ParseAddr(r.End)
+	switch {
+	case r.Type == "asn":
+		lo, _ := strconv.ParseUint(r.Start, 10, 32)
+		hi, _ := strconv.ParseUint(r.End, 10, 32)
+		if hi >= lo {
+			a.Value = hi - lo + 1
+		}
+	case err1 != nil || err2 != nil || first.Is4() != last.Is4():
+	case first.Is4():
+		if s := spanOf(first, last); s.hi >= s.lo {
+			a.Value = s.hi - s.lo + 1
+		}
+	default: // Prefix length, as in the delegated files
+		for bits := 0; bits <= 128; bits++ {
+			if p := netip.PrefixFrom(first, bits); p.Masked().Addr() == first && lastAddr(p) == last {
+				a.Value = uint64(bits)
+				break
+			}
+		}
+	}
+	if len(r.Status) > 0 {
+		a.Status = r.Status[0]
+	}
+}
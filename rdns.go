@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rdnsSample is the dominant reverse DNS suffix of an allocation's sampled addresses.
+type rdnsSample struct {
+	Registry string `json:"registry"`
+	Type     string `json:"type"`
+	Start    string `json:"start"`
+	Value    uint64 `json:"value"`
+	Suffix   string `json:"suffix"`
+	Samples  int    `json:"samples"`
+	Resolved int    `json:"resolved"`
+	Matching int    `json:"matching"`
+	Sampled  string `json:"sampled"`
+}
+
+// rdnsCommand implements "rdns sample [-samples N] [-limit N] [-max-age D]", which resolves
+// PTR records of addresses spread over each allocation and stores the most common
+// domain suffix, and "rdns show ADDRESS".
+func rdnsCommand(db *sql.DB, args []string) {
+	usage := "Usage: rdns sample [-samples N] [-limit N] [-max-age DURATION] | rdns show ADDRESS"
+	if len(args) == 0 {
+		log.Fatal(usage)
+	}
+	switch args[0] {
+	case "sample":
+		fs := flag.NewFlagSet("rdns sample", flag.ExitOnError)
+		samples := fs.Int("samples", 8, "Addresses to resolve per allocation")
+		limit := fs.Int("limit", 1000, "Maximum number of allocations to sample")
+		maxAge := fs.Duration("max-age", 30*24*time.Hour, "Resample allocations sampled longer ago than this")
+		fs.Parse(args[1:])
+		if err := sampleRdns(context.Background(), db, *samples, *limit, *maxAge); err != nil {
+			log.Fatal(err)
+		}
+	case "show":
+		if len(args) != 2 {
+			log.Fatal(usage)
+		}
+		addr, err := netip.ParseAddr(args[1])
+		if err != nil {
+			log.Fatal("Invalid address: " + args[1])
+		}
+		s, err := rdnsSuffixAt(db, addr)
+		if err == sql.ErrNoRows {
+			log.Fatal("No reverse DNS sample covers " + args[1])
+		} else if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("%s %d/%d of %d sampled %s (%s %s/%d)\n", s.Suffix, s.Matching, s.Resolved, s.Samples, s.Sampled,
+			s.Registry, s.Start, s.Value)
+	default:
+		log.Fatal(usage)
+	}
+}
+
+// sampleRdns samples up to limit delegated allocations not sampled within maxAge.
+func sampleRdns(ctx context.Context, db *sql.DB, samples, limit int, maxAge time.Duration) error {
+	rows, err := db.Query(`SELECT l.ID_Registries, l.RecordType, l.Start, l.Value FROM LatestAllocations l
+		LEFT JOIN RdnsSuffixes s ON s.ID_Registries = l.ID_Registries AND s.RecordType = l.RecordType
+			AND s.Start = l.Start AND s.Value = l.Value
+		WHERE l.RecordType <> 'asn' AND l.State IN ('allocated', 'assigned') AND (s.Sampled IS NULL OR s.Sampled < ?)
+		ORDER BY s.Sampled LIMIT ?;`, time.Now().UTC().Add(-maxAge).Format("2006-01-02 15:04:05"), limit)
+	if err != nil {
+		return err
+	}
+	var list []rdnsSample
+	for rows.Next() {
+		var s rdnsSample
+		if err := rows.Scan(&s.Registry, &s.Type, &s.Start, &s.Value); err != nil {
+			rows.Close()
+			return err
+		}
+		list = append(list, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	var n int
+	for _, s := range list {
+		if ctx.Err() != nil {
+			break
+		}
+		prefixes, err := recordPrefixes(s.Type, s.Start, s.Value)
+		if err != nil || len(prefixes) == 0 {
+			continue
+		}
+		first, last := prefixes[0].Addr(), lastAddr(prefixes[len(prefixes)-1])
+		s.Suffix, s.Samples, s.Resolved, s.Matching = dominantSuffix(ctx, sampleAddrs(first, last, samples))
+		family := 4
+		if first.Is6() {
+			family = 6
+		}
+		_, err = db.Exec("REPLACE INTO RdnsSuffixes VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, UTC_TIMESTAMP());",
+			s.Registry, s.Type, s.Start, s.Value, family, first.AsSlice(), last.AsSlice(), truncate(s.Suffix, 255),
+			s.Samples, s.Resolved, s.Matching)
+		if err != nil {
+			return fmt.Errorf("saving reverse DNS sample of %s: %w", s.Start, err)
+		}
+		logger.Log(ctx, levelTrace, "Reverse DNS suffix", "prefix", fmt.Sprintf("%s/%d", s.Start, s.Value),
+			"suffix", s.Suffix, "matching", s.Matching, "samples", s.Samples)
+		n++
+	}
+	logger.Info("Sampled reverse DNS", "allocations", n)
+	return nil
+}
+
+// sampleAddrs returns n addresses spread evenly between first and last, skipping the
+// network address of each step as it rarely has a PTR record.
+func sampleAddrs(first, last netip.Addr, n int) []netip.Addr {
+	lo := new(big.Int).SetBytes(first.AsSlice())
+	hi := new(big.Int).SetBytes(last.AsSlice())
+	step := new(big.Int).Sub(hi, lo)
+	step.Div(step, big.NewInt(int64(n)))
+	var addrs []netip.Addr
+	for i := 0; i < n; i++ {
+		x := new(big.Int).Mul(step, big.NewInt(int64(i)))
+		x.Add(x, lo)
+		if x.Cmp(hi) < 0 {
+			x.Add(x, big.NewInt(1))
+		}
+		buf := make([]byte, first.BitLen()/8)
+		a, _ := netip.AddrFromSlice(x.FillBytes(buf))
+		if len(addrs) == 0 || addrs[len(addrs)-1] != a {
+			addrs = append(addrs, a)
+		}
+	}
+	return addrs
+}
+
+// dominantSuffix resolves the PTR records of the addresses and returns the most common
+// suffix with the number of addresses sampled, resolved and matching it.
+func dominantSuffix(ctx context.Context, addrs []netip.Addr) (suffix string, samples, resolved, matching int) {
+	counts := map[string]int{}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, a := range addrs {
+		wg.Add(1)
+		go func(a netip.Addr) {
+			defer wg.Done()
+			lctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+			defer cancel()
+			names, err := net.DefaultResolver.LookupAddr(lctx, a.String())
+			if err != nil || len(names) == 0 {
+				return
+			}
+			mu.Lock()
+			counts[domainSuffix(names[0])]++
+			mu.Unlock()
+		}(a)
+	}
+	wg.Wait()
+	for s, c := range counts {
+		resolved += c
+		if c > matching || (c == matching && s < suffix) {
+			suffix, matching = s, c
+		}
+	}
+	return suffix, len(addrs), resolved, matching
+}
+
+// domainSuffix reduces a host name to its registered domain: the last two labels, or
+// three under country code second level domains such as co.uk or com.br.
+func domainSuffix(name string) string {
+	labels := strings.Split(strings.ToLower(strings.TrimSuffix(name, ".")), ".")
+	n := 2
+	if len(labels) > 2 && len(labels[len(labels)-1]) == 2 && len(labels[len(labels)-2]) <= 3 {
+		n = 3
+	}
+	if len(labels) < n {
+		n = len(labels)
+	}
+	return strings.Join(labels[len(labels)-n:], ".")
+}
+
+// rdnsSuffixAt returns the reverse DNS sample of the most specific sampled allocation containing an address.
+func rdnsSuffixAt(db *sql.DB, addr netip.Addr) (rdnsSample, error) {
+	family := 4
+	if addr.Is6() {
+		family = 6
+	}
+	var s rdnsSample
+	err := db.QueryRow(`SELECT ID_Registries, RecordType, Start, Value, Suffix, Samples, Resolved, Matching, Sampled
+		FROM RdnsSuffixes WHERE Family = ? AND StartIP <= ? AND EndIP >= ? ORDER BY StartIP DESC LIMIT 1;`,
+		family, addr.AsSlice(), addr.AsSlice()).Scan(&s.Registry, &s.Type, &s.Start, &s.Value, &s.Suffix, &s.Samples,
+		&s.Resolved, &s.Matching, &s.Sampled)
+	return s, err
+}
+
+// watchRdnsSamples samples reverse DNS in the background every hour with -rdns-sample set.
+func watchRdnsSamples(db *sql.DB) {
+	if *f_rdnsSample <= 0 {
+		return
+	}
+	for range time.Tick(time.Hour) {
+		if err := sampleRdns(context.Background(), db, 8, 1000, *f_rdnsSample); err != nil {
+			logger.Warn("Cannot sample reverse DNS", "err", err)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// countryReport is the delegated space of a country in a registry's latest dataset and
+// its change since an earlier dataset of the same registry.
+type countryReport struct {
+	CC            string `json:"cc"`
+	Registry      string `json:"registry"`
+	FromSerial    uint64 `json:"from_serial,omitempty"`
+	ToSerial      uint64 `json:"to_serial"`
+	IPv4Addresses uint64 `json:"ipv4_addresses"`
+	IPv4Change    int64  `json:"ipv4_change"`
+	IPv6Slash48s  uint64 `json:"ipv6_48s"` // prefixes longer than /48 count as one
+	IPv6Change    int64  `json:"ipv6_48s_change"`
+	ASNs          uint64 `json:"asns"`
+	ASNChange     int64  `json:"asns_change"`
+}
+
+// reportDataset is a dataset with growth series rows.
+type reportDataset struct {
+	id     int64
+	serial uint64
+	date   string
+}
+
+// reportCommand implements "report country [-registry R] [-cc CC] [-since DATE | -from SERIAL]
+// [-format table|csv|json]": the allocated and assigned IPv4 addresses, IPv6 /48s and ASNs
+// per country and registry, with the change since the previous dataset, the last dataset
+// on or before -since, or serial -from of the -registry.
+func reportCommand(db *sql.DB, args []string) {
+	usage := "Usage: report country [-registry R] [-cc CC] [-since DATE | -from SERIAL] [-format table|csv|json]"
+	if len(args) == 0 || args[0] != "country" {
+		log.Fatal(usage)
+	}
+	fs := flag.NewFlagSet("report country", flag.ExitOnError)
+	registry := fs.String("registry", "", "Only this registry")
+	cc := fs.String("cc", "", "Only this country code")
+	since := fs.String("since", "", "Compare with the last dataset on or before this date (YYYYMMDD or YYYY-MM-DD)")
+	from := fs.Uint64("from", 0, "Compare with this serial of the -registry")
+	format := fs.String("format", "table", "Output format: table, csv or json")
+	fs.Parse(args[1:])
+	if fs.NArg() > 0 || *since != "" && *from != 0 {
+		log.Fatal(usage)
+	}
+	if *from != 0 && *registry == "" {
+		log.Fatal("-from needs -registry: serials are numbered per registry")
+	}
+	if *since != "" {
+		date, err := parseAsOf(*since)
+		if err != nil {
+			log.Fatal(err)
+		}
+		*since = date
+	}
+
+	list, err := countryReports(db, *registry, strings.ToUpper(*cc), *since, *from)
+	if err != nil {
+		log.Fatal(err)
+	}
+	rows := make([][]string, 0, len(list))
+	for _, r := range list {
+		from := ""
+		if r.FromSerial != 0 {
+			from = strconv.FormatUint(r.FromSerial, 10)
+		}
+		rows = append(rows, []string{r.CC, r.Registry, from, strconv.FormatUint(r.ToSerial, 10),
+			fmt.Sprint(r.IPv4Addresses), fmt.Sprintf("%+d", r.IPv4Change), fmt.Sprint(r.IPv6Slash48s), fmt.Sprintf("%+d", r.IPv6Change),
+			fmt.Sprint(r.ASNs), fmt.Sprintf("%+d", r.ASNChange)})
+	}
+	writeReport(*format, []string{"cc", "registry", "from_serial", "to_serial", "ipv4_addresses", "ipv4_change",
+		"ipv6_48s", "ipv6_48s_change", "asns", "asns_change"}, rows, list)
+}
+
+// countryReports compares the GrowthSeries totals of the latest dataset of each registry
+// with those of an earlier one: serial from, the last one on or before since, or else
+// the previous one. Without an earlier dataset the changes are the totals.
+func countryReports(db *sql.DB, registry, cc, since string, from uint64) ([]countryReport, error) {
+	rows, err := db.Query(`SELECT DISTINCT g.ID_Registries, g.ID_Datasets, d.serial, g.SeriesDate FROM GrowthSeries g
+		JOIN Datasets d ON d.ID = g.ID_Datasets WHERE ? = '' OR g.ID_Registries = ?
+		ORDER BY g.ID_Registries, d.serial;`, registry, registry)
+	if err != nil {
+		return nil, err
+	}
+	datasets := map[string][]reportDataset{}
+	for rows.Next() {
+		var reg string
+		var d reportDataset
+		if err := rows.Scan(&reg, &d.id, &d.serial, &d.date); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		datasets[reg] = append(datasets[reg], d)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(datasets) == 0 {
+		return nil, fmt.Errorf("no growth series found; run \"summaries totals\" to compute them for imported datasets")
+	}
+
+	reports := map[[2]string]*countryReport{}
+	for reg, list := range datasets {
+		latest := list[len(list)-1]
+		var earlier *reportDataset
+		for i := len(list) - 2; i >= 0; i-- {
+			d := list[i]
+			if from != 0 && d.serial == from || since != "" && d.date <= since || from == 0 && since == "" {
+				earlier = &list[i]
+				break
+			}
+		}
+		if from != 0 && earlier == nil {
+			return nil, fmt.Errorf("no growth series for serial %d of %s", from, reg)
+		}
+
+		ids := []interface{}{latest.id}
+		if earlier != nil {
+			ids = append(ids, earlier.id)
+		}
+		rows, err := db.Query(`SELECT ID_Datasets, CC, RecordType, SUM(Size) FROM GrowthSeries
+			WHERE ID_Datasets IN (?`+strings.Repeat(", ?", len(ids)-1)+`) AND State IN ('allocated', 'assigned') AND CC <> ''
+			GROUP BY ID_Datasets, CC, RecordType;`, ids...)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var id int64
+			var country, kind string
+			var size uint64
+			if err := rows.Scan(&id, &country, &kind, &size); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			if cc != "" && country != cc {
+				continue
+			}
+			key := [2]string{country, reg}
+			r := reports[key]
+			if r == nil {
+				r = &countryReport{CC: country, Registry: reg, ToSerial: latest.serial}
+				if earlier != nil {
+					r.FromSerial = earlier.serial
+				}
+				reports[key] = r
+			}
+			total, change := &r.ASNs, &r.ASNChange
+			switch kind {
+			case "ipv4":
+				total, change = &r.IPv4Addresses, &r.IPv4Change
+			case "ipv6":
+				total, change = &r.IPv6Slash48s, &r.IPv6Change
+			}
+			if id == latest.id {
+				*total = size
+				*change += int64(size)
+			} else {
+				*change -= int64(size)
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	list := make([]countryReport, 0, len(reports))
+	for _, r := range reports {
+		list = append(list, *r)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].CC != list[j].CC {
+			return list[i].CC < list[j].CC
+		}
+		return list[i].Registry < list[j].Registry
+	})
+	return list, nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+// resourceTracker maintains the Resources table: one row per allocation of a
+// resource to a holder, with the dates it was first and last seen.
+type resourceTracker struct {
+	registry string
+	dataset  int64
+	date     string
+	upsert   *sql.Stmt
+}
+
+// newResourceTracker prepares the upsert for records of a dataset with the given date (YYYY-MM-DD)
+// in the transaction of its records.
+// Older datasets imported out of order widen FirstSeen but never overwrite newer attributes.
+func newResourceTracker(tx *sql.Tx, registry string, dataset int64, date string) (*resourceTracker, error) {
+	stmt, err := tx.Prepare(`INSERT INTO Resources (ID_Registries, RecordType, Start, Value, Holder, CC, State, RecordDate,
+		FirstSeen, LastSeen, ID_FirstDatasets, ID_LastDatasets) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE
+		CC = IF(VALUES(LastSeen) >= LastSeen, VALUES(CC), CC),
+		State = IF(VALUES(LastSeen) >= LastSeen, VALUES(State), State),
+		RecordDate = IF(VALUES(LastSeen) >= LastSeen, VALUES(RecordDate), RecordDate),
+		ID_FirstDatasets = IF(VALUES(FirstSeen) < FirstSeen, VALUES(ID_FirstDatasets), ID_FirstDatasets),
+		FirstSeen = LEAST(FirstSeen, VALUES(FirstSeen)),
+		ID_LastDatasets = IF(VALUES(LastSeen) >= LastSeen, VALUES(ID_LastDatasets), ID_LastDatasets),
+		LastSeen = GREATEST(LastSeen, VALUES(LastSeen));`)
+	if err != nil {
+		return nil, fmt.Errorf("prepare resource update: %w", err)
+	}
+	return &resourceTracker{registry: registry, dataset: dataset, date: date, upsert: stmt}, nil
+}
+
+// track records that a resource was present in the dataset. rest is the
+// remainder of the record line after the status field.
+func (r *resourceTracker) track(recordType, start string, value uint64, cc, recordDate, status, rest string) error {
+	_, err := r.upsert.Exec(r.registry, recordType, normalizeAddr(start), value, holder(rest), cc, status, normalizeDate(recordDate),
+		r.date, r.date, r.dataset, r.dataset)
+	return err
+}
+
+// finish marks the resources of the registry that are missing from the dataset as removed.
+func (r *resourceTracker) finish(tx *sql.Tx) error {
+	res, err := tx.Exec("UPDATE Resources SET State = 'removed' WHERE ID_Registries = ? AND LastSeen < ? AND State <> 'removed';",
+		r.registry, r.date)
+	if err != nil {
+		return fmt.Errorf("marking removed resources: %w", err)
+	}
+	n, _ := res.RowsAffected()
+	logger.Debug("Resources no longer delegated", "count", n)
+	return nil
+}
+
+// holder returns the opaque ID of an extended format record, which identifies the holder.
+func holder(rest string) string {
+	fields := strings.Split(strings.TrimPrefix(rest, "|"), "|")
+	return fields[0]
+}
+
+// resourcesCommand shows the allocation history of a prefix start address or ASN.
+func resourcesCommand(db *sql.DB, args []string) {
+	fs := flag.NewFlagSet("resources", flag.ExitOnError)
+	registry := fs.String("registry", "", "Only resources of this registry")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		log.Fatal("Usage: resources [-registry NAME] START_ADDRESS|ASN")
+	}
+	start := normalizeAddr(fs.Arg(0))
+
+	query := `SELECT r.ID_Registries, r.RecordType, r.Start, r.Value, r.Holder, IFNULL(o.Name, ''), r.CC, r.State,
+		r.FirstSeen, r.LastSeen, DATEDIFF(r.LastSeen, r.FirstSeen)
+		FROM Resources r LEFT JOIN Orgs o ON o.ID_Registries = r.ID_Registries AND o.OpaqueID = r.Holder WHERE r.Start = ?`
+	params := []interface{}{start}
+	if *registry != "" {
+		query += " AND r.ID_Registries = ?"
+		params = append(params, *registry)
+	}
+	rows, err := db.Query(query+" ORDER BY r.FirstSeen;", params...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rows.Close()
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "REGISTRY\tTYPE\tSTART\tVALUE\tHOLDER\tNAME\tCC\tSTATE\tFIRST SEEN\tLAST SEEN\tDAYS")
+	for rows.Next() {
+		var reg, t, s, holderID, name, cc, state, first, last string
+		var value uint64
+		var days int
+		if err := rows.Scan(&reg, &t, &s, &value, &holderID, &name, &cc, &state, &first, &last, &days); err != nil {
+			log.Fatal(err)
+		}
+		if holderID == "" {
+			holderID = "-"
+		}
+		if name == "" {
+			name = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\t%s\t%s\t%s\t%s\t%d\n", reg, t, s, value, holderID, name, cc, state, first, last, days)
+	}
+	if err := rows.Err(); err != nil {
+		log.Fatal(err)
+	}
+	w.Flush()
+}
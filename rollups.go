@@ -0,0 +1,205 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+)
+
+// The summary tables hold the current state of every registry so dashboards need not
+// scan the Records history: LatestAllocations has one row per resource of the latest
+// dataset, CountryRollup and HolderRollup the totals per country and per holder.
+// They are updated after each import from the dataset diff.
+
+type rollupDelta struct {
+	blocks int64
+	size   int64
+}
+
+// updateSummaries applies the changes of an imported dataset to the summary tables in
+// the transaction of the import. The tables of a registry are rebuilt from the dataset
+// when they are still empty, e.g. for its first dataset.
+func updateSummaries(tx *sql.Tx, registry string, dataset int64, diff *datasetDiff) error {
+	var exists bool
+	if err := tx.QueryRow("SELECT EXISTS(SELECT 1 FROM LatestAllocations WHERE ID_Registries = ?);", registry).Scan(&exists); err != nil {
+		return fmt.Errorf("checking summaries: %w", err)
+	}
+	if exists {
+		if diff == nil { // Re-import of a known dataset
+			return nil
+		}
+		return applySummaryChanges(tx, registry, dataset, diff.changes, false)
+	}
+	logger.Debug("Building summaries", "registry", registry, "dataset", dataset)
+	changes, err := datasetAsChanges(tx, registry, dataset)
+	if err != nil {
+		return err
+	}
+	return applySummaryChanges(tx, registry, dataset, changes, true)
+}
+
+// datasetAsChanges returns the records of a dataset as if they were all added.
+func datasetAsChanges(tx *sql.Tx, registry string, dataset int64) ([]change, error) {
+	var changes []change
+	err := datasetRecords(tx, registry, dataset, func(t, start string, value uint64, a allocation) {
+		a.Date = normalizeDate(a.Date)
+		changes = append(changes, change{Type: t, Change: "added", Start: start, Value: value, New: &a})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("loading dataset %d: %w", dataset, err)
+	}
+	return changes, nil
+}
+
+// applySummaryChanges updates LatestAllocations row by row and the rollups by the
+// summed differences, in tx. With rebuild, the registry's rows are replaced.
+func applySummaryChanges(tx *sql.Tx, registry string, dataset int64, changes []change, rebuild bool) error {
+	if rebuild {
+		for _, table := range []string{"LatestAllocations", "CountryRollup", "HolderRollup"} {
+			if _, err := tx.Exec("DELETE FROM "+table+" WHERE ID_Registries = ?;", registry); err != nil {
+				return err
+			}
+		}
+	}
+
+	upsert, err := tx.Prepare(`REPLACE INTO LatestAllocations (ID_Registries, RecordType, Start, Value, CC, RecordDate, State,
+		Holder, ID_Datasets) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?);`)
+	if err != nil {
+		return err
+	}
+	defer upsert.Close()
+	remove, err := tx.Prepare("DELETE FROM LatestAllocations WHERE ID_Registries = ? AND RecordType = ? AND Start = ? AND Value = ?;")
+	if err != nil {
+		return err
+	}
+	defer remove.Close()
+
+	countries := map[[3]string]*rollupDelta{} // cc, type, status
+	holders := map[string]*[4]int64{}         // ASNs, prefixes, IPv4 addresses, IPv6 /48s
+	account := func(kind string, value uint64, a *allocation, sign int64) {
+		if a == nil {
+			return
+		}
+		size := int64(value)
+		if kind == "ipv6" {
+			size = int64(slash48s(value))
+		}
+		key := [3]string{a.CC, kind, a.Status}
+		if countries[key] == nil {
+			countries[key] = &rollupDelta{}
+		}
+		countries[key].blocks += sign
+		countries[key].size += sign * size
+
+		h := holder(a.OpaqueID)
+		if h == "" || (a.Status != "allocated" && a.Status != "assigned") {
+			return
+		}
+		if holders[h] == nil {
+			holders[h] = &[4]int64{}
+		}
+		switch kind {
+		case "asn":
+			holders[h][0] += sign * size
+		case "ipv4":
+			holders[h][1] += sign
+			holders[h][2] += sign * size
+		case "ipv6":
+			holders[h][1] += sign
+			holders[h][3] += sign * size
+		}
+	}
+
+	for _, c := range changes {
+		start := normalizeAddr(c.Start)
+		if c.New == nil {
+			_, err = remove.Exec(registry, c.Type, start, c.Value)
+		} else {
+			_, err = upsert.Exec(registry, c.Type, start, c.Value, c.New.CC, sql.NullString{String: c.New.Date, Valid: c.New.Date != ""},
+				c.New.Status, holder(c.New.OpaqueID), dataset)
+		}
+		if err != nil {
+			return fmt.Errorf("updating latest allocations: %w", err)
+		}
+		account(c.Type, c.Value, c.Old, -1)
+		account(c.Type, c.Value, c.New, 1)
+	}
+
+	for key, d := range countries {
+		if d.blocks == 0 && d.size == 0 {
+			continue
+		}
+		_, err := tx.Exec(`INSERT INTO CountryRollup VALUES (?, ?, ?, ?, ?, ?)
+			ON DUPLICATE KEY UPDATE Blocks = Blocks + VALUES(Blocks), Size = Size + VALUES(Size);`,
+			registry, key[0], key[1], key[2], d.blocks, d.size)
+		if err != nil {
+			return fmt.Errorf("updating country rollup: %w", err)
+		}
+	}
+	for h, d := range holders {
+		_, err := tx.Exec(`INSERT INTO HolderRollup VALUES (?, ?, ?, ?, ?, ?)
+			ON DUPLICATE KEY UPDATE ASNs = ASNs + VALUES(ASNs), Prefixes = Prefixes + VALUES(Prefixes),
+			IPv4Addresses = IPv4Addresses + VALUES(IPv4Addresses), IPv6Slash48s = IPv6Slash48s + VALUES(IPv6Slash48s);`,
+			registry, h, d[0], d[1], d[2], d[3])
+		if err != nil {
+			return fmt.Errorf("updating holder rollup: %w", err)
+		}
+	}
+	if _, err := tx.Exec("DELETE FROM CountryRollup WHERE ID_Registries = ? AND Blocks = 0;", registry); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM HolderRollup WHERE ID_Registries = ? AND ASNs = 0 AND Prefixes = 0;", registry); err != nil {
+		return err
+	}
+	logger.Debug("Applied changes to the summary tables", "registry", registry, "changes", len(changes))
+	return nil
+}
+
+// summariesCommand implements "summaries rebuild", rebuilding the summary tables of all
+// registries from their latest datasets, and "summaries totals", computing the per
+// dataset totals of datasets imported before they were recorded from archived raw files.
+func summariesCommand(db *sql.DB, args []string) {
+	if len(args) == 1 && args[0] == "totals" {
+		if err := backfillTotals(db); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if len(args) != 1 || args[0] != "rebuild" {
+		log.Fatal("Usage: summaries rebuild | summaries totals")
+	}
+	rows, err := db.Query(`SELECT d.ID_Registries, d.ID FROM Datasets d
+		JOIN (SELECT ID_Registries, MAX(serial) AS serial FROM Datasets GROUP BY ID_Registries) l USING (ID_Registries, serial);`)
+	if err != nil {
+		log.Fatal(err)
+	}
+	latest := map[string]int64{}
+	for rows.Next() {
+		var registry string
+		var id int64
+		if err := rows.Scan(&registry, &id); err != nil {
+			log.Fatal(err)
+		}
+		latest[registry] = id
+	}
+	rows.Close()
+
+	for registry, dataset := range latest {
+		tx, err := db.Begin()
+		if err != nil {
+			log.Fatal(err)
+		}
+		changes, err := datasetAsChanges(tx, registry, dataset)
+		if err == nil {
+			err = applySummaryChanges(tx, registry, dataset, changes, true)
+		}
+		if err == nil {
+			err = tx.Commit()
+		}
+		if err != nil {
+			tx.Rollback()
+			log.Fatal(err)
+		}
+	}
+	auditLog(db, "summaries", "rebuild", 0)
+}
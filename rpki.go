@@ -0,0 +1,438 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// vrp is a validated ROA payload: origin ASN, prefix and maximum length.
+type vrp struct {
+	ASN       uint64
+	Prefix    netip.Prefix
+	MaxLength int
+	TA        string
+}
+
+// vrpsURL is the default VRP source, the JSON export of the rpki-client console.
+const vrpsURL = "https://console.rpki-client.org/vrps.json"
+
+// rpkiCommand implements "rpki import [FILE|URL]", loading VRPs from rpki-client or
+// Routinator JSON ({"roas": [{"asn", "prefix", "maxLength", "ta"}]}) or CSV exports
+// (ASN,IP Prefix,Max Length,Trust Anchor, or the roas.csv of the RIPE NCC repository
+// archive), gzip compressed or not; "rpki validate PREFIX ASN"; and "rpki coverage",
+// reporting delegated space without ROAs per registry and country.
+func rpkiCommand(db *sql.DB, args []string) {
+	usage := "Usage: rpki import [FILE|URL] | rpki validate PREFIX ASN | rpki coverage [-registry NAME] [-cc CC] [-format F]"
+	if len(args) == 0 {
+		log.Fatal(usage)
+	}
+	switch args[0] {
+	case "import":
+		source := vrpsURL
+		if len(args) > 2 {
+			log.Fatal(usage)
+		} else if len(args) == 2 {
+			source = args[1]
+		}
+		if err := importVRPs(db, source); err != nil {
+			log.Fatal(err)
+		}
+	case "validate":
+		if len(args) != 3 {
+			log.Fatal(usage)
+		}
+		p, err := netip.ParsePrefix(args[1])
+		if err != nil {
+			log.Fatal("Invalid prefix: " + args[1])
+		}
+		asn, err := strconv.ParseUint(strings.TrimPrefix(strings.ToUpper(args[2]), "AS"), 10, 32)
+		if err != nil {
+			log.Fatal("Invalid ASN: " + args[2])
+		}
+		status, err := rpkiStatus(db, p, asn)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(status)
+	case "coverage":
+		fs := flag.NewFlagSet("rpki coverage", flag.ExitOnError)
+		registry := fs.String("registry", "", "Only this registry")
+		cc := fs.String("cc", "", "Only this country code")
+		format := fs.String("format", "table", "Output format: table, csv or json")
+		fs.Parse(args[1:])
+		list, err := roaCoverage(db, *registry, strings.ToUpper(*cc))
+		if err != nil {
+			log.Fatal(err)
+		}
+		rows := make([][]string, 0, len(list))
+		for _, s := range list {
+			rows = append(rows, []string{s.Registry, s.CC, s.Type, fmt.Sprint(s.Delegated), fmt.Sprint(s.Covered),
+				fmt.Sprint(s.Uncovered), fmt.Sprintf("%.2f", s.Percent)})
+		}
+		writeReport(*format, []string{"registry", "cc", "type", "delegated", "covered", "uncovered", "covered_percent"}, rows, list)
+	default:
+		log.Fatal(usage)
+	}
+}
+
+// importVRPs replaces all VRPs with the contents of a file or URL.
+func importVRPs(db *sql.DB, source string) error {
+	var data []byte
+	var err error
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		data, err = downloadFile(context.Background(), &source)
+	} else {
+		data, err = ioutil.ReadFile(source)
+	}
+	if err != nil {
+		return fmt.Errorf("reading VRPs %s: %w", source, err)
+	}
+	r, _, err := decompressReader(bytes.NewReader(data))
+	if err == nil {
+		data, err = ioutil.ReadAll(r)
+	}
+	if err != nil {
+		return fmt.Errorf("reading VRPs %s: %w", source, err)
+	}
+
+	var vrps []vrp
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		vrps, err = parseVRPJSON(data)
+	} else {
+		vrps, err = parseVRPCSV(data)
+	}
+	if err != nil {
+		return fmt.Errorf("parsing VRPs %s: %w", source, err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM Vrps;"); err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare("REPLACE INTO Vrps VALUES (?, ?, ?, ?, ?, ?, ?, ?);")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, v := range vrps {
+		family := 4
+		if v.Prefix.Addr().Is6() {
+			family = 6
+		}
+		if _, err := stmt.Exec(family, v.Prefix.Addr().AsSlice(), lastAddr(v.Prefix).AsSlice(), v.Prefix.String(),
+			v.Prefix.Bits(), v.MaxLength, v.ASN, v.TA); err != nil {
+			return fmt.Errorf("saving VRP %s AS%d: %w", v.Prefix, v.ASN, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	auditLog(db, "rpki", source, 0)
+	logger.Info("Imported VRPs", "count", len(vrps), "source", source)
+	return nil
+}
+
+func parseVRPJSON(data []byte) ([]vrp, error) {
+	var file struct {
+		ROAs []struct {
+			ASN       json.RawMessage `json:"asn"` // 64496 or "AS64496"
+			Prefix    string          `json:"prefix"`
+			MaxLength int             `json:"maxLength"`
+			TA        string          `json:"ta"`
+		} `json:"roas"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+	vrps := make([]vrp, 0, len(file.ROAs))
+	for _, r := range file.ROAs {
+		v, ok := newVRP(strings.Trim(string(r.ASN), `"`), r.Prefix, strconv.Itoa(r.MaxLength), r.TA)
+		if ok {
+			vrps = append(vrps, v)
+		}
+	}
+	return vrps, nil
+}
+
+func parseVRPCSV(data []byte) ([]vrp, error) {
+	r := csv.NewReader(bufio.NewReader(bytes.NewReader(data)))
+	r.FieldsPerRecord = -1
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	// Columns are found by name in the header, else ASN, prefix, max length and trust anchor
+	cols := map[string]int{"ASN": 0, "IP Prefix": 1, "Max Length": 2, "Trust Anchor": 3}
+	if len(records) > 0 {
+		header := map[string]int{}
+		for i, name := range records[0] {
+			header[strings.TrimSpace(name)] = i
+		}
+		if _, ok := header["IP Prefix"]; ok {
+			cols = header
+		}
+	}
+	field := func(rec []string, name string) string {
+		if i, ok := cols[name]; ok && i < len(rec) {
+			return rec[i]
+		}
+		return ""
+	}
+	var vrps []vrp
+	for _, rec := range records {
+		if v, ok := newVRP(field(rec, "ASN"), field(rec, "IP Prefix"), field(rec, "Max Length"), field(rec, "Trust Anchor")); ok {
+			vrps = append(vrps, v) // The header line fails to parse
+		}
+	}
+	return vrps, nil
+}
+
+func newVRP(asn, prefix, maxLength, ta string) (vrp, bool) {
+	a, err := strconv.ParseUint(strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(asn)), "AS"), 10, 32)
+	if err != nil {
+		return vrp{}, false
+	}
+	p, err := netip.ParsePrefix(strings.TrimSpace(prefix))
+	if err != nil {
+		return vrp{}, false
+	}
+	m, err := strconv.Atoi(strings.TrimSpace(maxLength))
+	if err != nil || m < p.Bits() {
+		m = p.Bits()
+	}
+	return vrp{ASN: a, Prefix: p.Masked(), MaxLength: m, TA: truncate(ta, 32)}, true
+}
+
+// rpkiStatus validates a route as in RFC 6811: "valid" when a covering VRP matches the
+// origin and length, "invalid" when covering VRPs exist but none matches, else "not-found".
+func rpkiStatus(db *sql.DB, p netip.Prefix, origin uint64) (string, error) {
+	p = p.Masked()
+	family := 4
+	if p.Addr().Is6() {
+		family = 6
+	}
+	rows, err := db.Query(`SELECT ASN, MaxLength FROM Vrps WHERE Family = ? AND StartIP <= ? AND EndIP >= ? AND PrefixLen <= ?;`,
+		family, p.Addr().AsSlice(), lastAddr(p).AsSlice(), p.Bits())
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	status := "not-found"
+	for rows.Next() {
+		var asn uint64
+		var maxLength int
+		if err := rows.Scan(&asn, &maxLength); err != nil {
+			return "", err
+		}
+		if asn == origin && asn != 0 && p.Bits() <= maxLength {
+			return "valid", nil
+		}
+		status = "invalid"
+	}
+	return status, rows.Err()
+}
+
+// vrpTable validates routes from memory, for exports and the lookup table.
+type vrpTable struct {
+	prefixes prefixTrie // index into entries
+	entries  [][]vrpEntry
+}
+
+type vrpEntry struct {
+	asn       uint64
+	maxLength int
+}
+
+// loadVRPTable reads the VRPs, or returns nil when none have been imported: routes are
+// then left unvalidated rather than all not-found.
+func loadVRPTable(db *sql.DB) (*vrpTable, error) {
+	rows, err := db.Query("SELECT Prefix, MaxLength, ASN FROM Vrps;")
+	if isMissingTable(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	t := &vrpTable{}
+	index := map[netip.Prefix]int{}
+	for rows.Next() {
+		var prefix string
+		var e vrpEntry
+		if err := rows.Scan(&prefix, &e.maxLength, &e.asn); err != nil {
+			return nil, err
+		}
+		p, err := netip.ParsePrefix(prefix)
+		if err != nil {
+			continue
+		}
+		i, ok := index[p]
+		if !ok {
+			i = len(t.entries)
+			index[p] = i
+			t.entries = append(t.entries, nil)
+			t.prefixes.insert(p, i)
+		}
+		t.entries[i] = append(t.entries[i], e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(t.entries) == 0 {
+		return nil, nil
+	}
+	return t, nil
+}
+
+// status validates a route like rpkiStatus, with the origin as in BgpPrefixes: MOAS
+// routes are validated with their first origin. Without VRPs, or for an AS set, it
+// returns "".
+func (t *vrpTable) status(route netip.Prefix, origin string) string {
+	if t == nil {
+		return ""
+	}
+	asn, err := strconv.ParseUint(strings.SplitN(origin, "_", 2)[0], 10, 32)
+	if err != nil {
+		return ""
+	}
+	status := "not-found"
+	t.prefixes.eachCovering(route, func(i int) bool {
+		for _, e := range t.entries[i] {
+			if e.asn == asn && asn != 0 && route.Bits() <= e.maxLength {
+				status = "valid"
+				return false
+			}
+			status = "invalid"
+		}
+		return true
+	})
+	return status
+}
+
+// roaStats compares the delegated (allocated and assigned) space of a registry and
+// country with the space covered by ROAs. Sizes are IPv4 addresses or IPv6 /48s.
+type roaStats struct {
+	Registry  string  `json:"registry"`
+	CC        string  `json:"cc"`
+	Type      string  `json:"type"`
+	Delegated uint64  `json:"delegated"`
+	Covered   uint64  `json:"covered"`
+	Uncovered uint64  `json:"uncovered"`
+	Percent   float64 `json:"covered_percent"`
+}
+
+func roaCoverage(db *sql.DB, registry, cc string) ([]roaStats, error) {
+	covered := map[string][]span{}
+	rows, err := db.Query("SELECT Family, StartIP, EndIP FROM Vrps;")
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var family int
+		var start, end []byte
+		if err := rows.Scan(&family, &start, &end); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		first, _ := netip.AddrFromSlice(start)
+		last, _ := netip.AddrFromSlice(end)
+		kind := "ipv" + strconv.Itoa(family)
+		covered[kind] = append(covered[kind], spanOf(first, last))
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for kind := range covered {
+		covered[kind] = mergeSpans(covered[kind])
+	}
+
+	rows, err = db.Query(`SELECT ID_Registries, CC, RecordType, Start, Value FROM LatestAllocations
+		WHERE RecordType <> 'asn' AND State IN ('allocated', 'assigned')
+		AND (? = '' OR ID_Registries = ?) AND (? = '' OR CC = ?);`, registry, registry, cc, cc)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	stats := map[[3]string]*roaStats{}
+	for rows.Next() {
+		var reg, country, kind, start string
+		var value uint64
+		if err := rows.Scan(&reg, &country, &kind, &start, &value); err != nil {
+			return nil, err
+		}
+		blocks, err := recordPrefixes(kind, start, value)
+		if err != nil || len(blocks) == 0 {
+			continue
+		}
+		s := spanOf(blocks[0].Addr(), lastAddr(blocks[len(blocks)-1]))
+		key := [3]string{reg, country, kind}
+		if stats[key] == nil {
+			stats[key] = &roaStats{Registry: reg, CC: country, Type: kind}
+		}
+		stats[key].Delegated += s.hi - s.lo + 1
+		stats[key].Covered += coveredSize(covered[kind], s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	list := make([]roaStats, 0, len(stats))
+	for _, s := range stats {
+		s.Uncovered = s.Delegated - s.Covered
+		if s.Delegated > 0 {
+			s.Percent = float64(s.Covered*10000/s.Delegated) / 100
+		}
+		list = append(list, *s)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		a, b := list[i], list[j]
+		return a.Registry+"|"+a.CC+"|"+a.Type < b.Registry+"|"+b.CC+"|"+b.Type
+	})
+	return list, nil
+}
+
+// routeValidity annotates lookups: the covering BGP route, its origin, and its RPKI and
+// IRR status when VRPs and route objects have been imported.
+func routeValidity(db *sql.DB, target string) (map[string]string, error) {
+	route, origin, err := routeOf(db, target)
+	if err != nil {
+		return nil, err
+	}
+	out := map[string]string{"route": route.String(), "origin_as": origin}
+	asn, err := strconv.ParseUint(strings.SplitN(origin, "_", 2)[0], 10, 32)
+	if err != nil {
+		return out, nil // AS sets and MOAS origins are not validated
+	}
+	for _, check := range []struct {
+		key, table string
+		status     func(db *sql.DB, p netip.Prefix, origin uint64) (string, error)
+	}{{"rpki", "Vrps", rpkiStatus}, {"irr", "IrrRoutes", irrStatus}} {
+		// Without data every route would be not-found or missing
+		var loaded bool
+		if err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM " + check.table + ");").Scan(&loaded); err != nil || !loaded {
+			continue
+		}
+		status, err := check.status(db, route, asn)
+		if err != nil {
+			return nil, err
+		}
+		out[check.key] = status
+	}
+	return out, nil
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSchedule is a five field cron specification in local time: minute, hour, day of
+// month, month and day of week (0 or 7 is Sunday). A field is *, a value, a range a-b,
+// either with a step /n, or a comma-separated list of those.
+type cronSchedule struct {
+	fields         [5]uint64 // Bit sets of the matching values
+	anyDOM, anyDOW bool
+}
+
+var cronRanges = [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+
+// parseSchedule parses a cron specification such as "0 4 * * *".
+func parseSchedule(spec string) (*cronSchedule, error) {
+	parts := strings.Fields(spec)
+	if len(parts) != 5 {
+		return nil, fmt.Errorf("invalid schedule %q: want 5 fields", spec)
+	}
+	s := &cronSchedule{anyDOM: parts[2] == "*", anyDOW: parts[4] == "*"}
+	for i, part := range parts {
+		lo, hi := cronRanges[i][0], cronRanges[i][1]
+		for _, item := range strings.Split(part, ",") {
+			step := 1
+			if j := strings.Index(item, "/"); j >= 0 {
+				n, err := strconv.Atoi(item[j+1:])
+				if err != nil || n < 1 {
+					return nil, fmt.Errorf("invalid schedule %q: bad step in %q", spec, item)
+				}
+				item, step = item[:j], n
+			}
+			first, last := lo, hi
+			if item != "*" {
+				bounds := strings.SplitN(item, "-", 2)
+				var err error
+				if first, err = strconv.Atoi(bounds[0]); err != nil {
+					return nil, fmt.Errorf("invalid schedule %q: bad value %q", spec, item)
+				}
+				last = first
+				if len(bounds) == 2 {
+					if last, err = strconv.Atoi(bounds[1]); err != nil {
+						return nil, fmt.Errorf("invalid schedule %q: bad value %q", spec, item)
+					}
+				} else if step > 1 {
+					last = hi // a/n runs from a to the end of the range
+				}
+			}
+			if first < lo || last > hi || first > last {
+				return nil, fmt.Errorf("invalid schedule %q: %q out of range %d-%d", spec, item, lo, hi)
+			}
+			for v := first; v <= last; v += step {
+				s.fields[i] |= 1 << uint(v)
+			}
+		}
+	}
+	if s.fields[4]&(1<<7) != 0 { // Sunday
+		s.fields[4] |= 1
+	}
+	return s, nil
+}
+
+func (s *cronSchedule) has(field, v int) bool {
+	return s.fields[field]&(1<<uint(v)) != 0
+}
+
+// dayMatches applies the cron rule that a day matches either restricted day field when
+// both are restricted.
+func (s *cronSchedule) dayMatches(t time.Time) bool {
+	dom, dow := s.has(2, t.Day()), s.has(4, int(t.Weekday()))
+	switch {
+	case s.anyDOM && s.anyDOW:
+		return true
+	case s.anyDOM:
+		return dow
+	case s.anyDOW:
+		return dom
+	}
+	return dom || dow
+}
+
+// next returns the first matching minute after t, or the zero time if none comes within
+// five years (e.g. "0 0 30 2 *").
+func (s *cronSchedule) next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		y, m, d := t.Date()
+		switch {
+		case !s.has(3, int(m)):
+			t = time.Date(y, m+1, 1, 0, 0, 0, 0, t.Location())
+		case !s.dayMatches(t):
+			t = time.Date(y, m, d+1, 0, 0, 0, 0, t.Location())
+		case !s.has(1, t.Hour()):
+			t = time.Date(y, m, d, t.Hour()+1, 0, 0, 0, t.Location()) // Truncate would use UTC hours
+		case !s.has(0, t.Minute()):
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// runDaemon imports -source on the -schedule until the process is stopped. Datasets whose
+// serial was imported before are skipped, so a run only imports what the registries
+// published since the last one. After a reload the next import is planned by the
+// schedule then in effect.
+func runDaemon(ctx context.Context, db *sql.DB) {
+	for {
+		s := currentSettings()
+		next := s.schedule.next(time.Now())
+		if next.IsZero() {
+			logger.Error("Schedule never matches; no imports until it is reloaded", "schedule", s.scheduleSpec)
+			select {
+			case <-ctx.Done():
+				return
+			case <-settingsReloaded:
+				continue
+			}
+		}
+		logger.Info("Next scheduled import", "at", next.Format(time.RFC3339))
+		sdNotify("STATUS=Next import at " + next.Format(time.RFC3339))
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-settingsReloaded:
+			timer.Stop() // Plan again by the reloaded schedule
+			continue
+		case <-timer.C:
+		}
+		if !tryLeadership(db) {
+			logger.Info("Another instance holds the leader lock; skipping scheduled import", "lock", *f_leaderLock)
+			continue
+		}
+		runScheduledImport(ctx, db)
+	}
+}
+
+// runScheduledImport imports once, runs the steps that follow an import and logs a summary.
+func runScheduledImport(ctx context.Context, db *sql.DB) {
+	started := time.Now()
+	results, err := importSource(ctx, mysqlStore{db})
+	if ctx.Err() != nil {
+		return
+	}
+	counts := map[string]int{}
+	for _, r := range results {
+		counts[r.Status]++
+	}
+	if counts["success"] > 0 {
+		afterImports(db)
+	}
+	duration := time.Since(started).Round(time.Second)
+	logger.Info("Scheduled import finished", "duration_seconds", duration.Seconds(), "imported", counts["success"],
+		"unchanged", counts["unchanged"], "failed", counts["failure"])
+	if err != nil {
+		logger.Warn("Scheduled import failed", "err", err)
+	}
+}
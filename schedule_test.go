@@ -0,0 +1,73 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	tests := []struct {
+		spec string
+		ok   bool
+	}{
+		{"0 4 * * *", true},
+		{"*/15 * * * *", true},
+		{"0 0-23/6 1,15 * 1-5", true},
+		{"5/20 * * * 7", true},
+		{"0 4 * *", false},
+		{"60 * * * *", false},
+		{"0 24 * * *", false},
+		{"0 0 0 * *", false},
+		{"0 0 * 13 *", false},
+		{"0 0 * * 8", false},
+		{"5-1 * * * *", false},
+		{"*/0 * * * *", false},
+		{"a * * * *", false},
+		{"1-x * * * *", false},
+	}
+	for _, tt := range tests {
+		if _, err := parseSchedule(tt.spec); (err == nil) != tt.ok {
+			t.Errorf("parseSchedule(%q): %v; want ok %t", tt.spec, err, tt.ok)
+		}
+	}
+}
+
+func TestScheduleNext(t *testing.T) {
+	at := func(s string) time.Time {
+		v, err := time.ParseInLocation("2006-01-02 15:04", s, time.UTC)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	tests := []struct {
+		spec, from, want string // want "" for never
+	}{
+		{"0 4 * * *", "2024-01-01 03:59", "2024-01-01 04:00"},
+		{"0 4 * * *", "2024-01-01 04:00", "2024-01-02 04:00"},
+		{"*/15 * * * *", "2024-01-01 10:07", "2024-01-01 10:15"},
+		{"5/20 * * * *", "2024-01-01 10:46", "2024-01-01 11:05"},
+		{"30 2 * * 0", "2024-01-01 00:00", "2024-01-07 02:30"}, // 2024-01-01 is a Monday
+		{"30 2 * * 7", "2024-01-01 00:00", "2024-01-07 02:30"},
+		{"0 0 1 * *", "2024-01-31 12:00", "2024-02-01 00:00"},
+		{"0 0 29 2 *", "2024-03-01 00:00", "2028-02-29 00:00"},
+		{"0 12 13 * 5", "2024-09-01 00:00", "2024-09-06 12:00"}, // Friday or the 13th
+		{"0 0 1,15 * 1-5", "2024-06-02 00:00", "2024-06-03 00:00"},
+		{"0 0 30 2 *", "2024-01-01 00:00", ""},
+		{"59 23 31 12 *", "2024-12-31 23:58", "2024-12-31 23:59"},
+	}
+	for _, tt := range tests {
+		s, err := parseSchedule(tt.spec)
+		if err != nil {
+			t.Fatalf("parseSchedule(%q): %v", tt.spec, err)
+		}
+		got := s.next(at(tt.from))
+		if tt.want == "" {
+			if !got.IsZero() {
+				t.Errorf("%q after %s: %s; want never", tt.spec, tt.from, got)
+			}
+		} else if !got.Equal(at(tt.want)) {
+			t.Errorf("%q after %s: %s; want %s", tt.spec, tt.from, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,498 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	_ "embed"
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// schemaSQL is the full schema of a new database.
+//
+//go:embed db_schema.txt
+var schemaSQL string
+
+// defaultRegistries are the registries and delegation file locations of a new database.
+var defaultRegistries = []struct {
+	ShortName, LongName, Latest, BaseDir string
+}{
+	{"afrinic", "African Network Information Center (AFRINIC)", "http://ftp.afrinic.net/pub/stats/afrinic/delegated-afrinic-latest", "http://ftp.afrinic.net/pub/stats/afrinic/"},
+	{"apnic", "Asia-Pacific Network Information Centre (APNIC)", "http://ftp.apnic.net/stats/apnic/delegated-apnic-latest", "http://ftp.apnic.net/stats/apnic/"},
+	{"arin", "American Registry for Internet Numbers (ARIN)", "http://ftp.arin.net/pub/stats/arin/delegated-arin-extended-latest", "http://ftp.arin.net/pub/stats/arin/"},
+	{"lacnic", "Latin America and Caribbean Network Information Centre (LACNIC)", "http://ftp.lacnic.net/pub/stats/lacnic/delegated-lacnic-latest", "http://ftp.lacnic.net/pub/stats/lacnic/"},
+	{"ripencc", "Réseaux IP Européens Network Coordination Centre (RIPE NCC)", "http://ftp.arin.net/pub/stats/ripencc/delegated-ripencc-latest", "http://ftp.arin.net/pub/stats/ripencc/"},
+}
+
+// schemaMigrations upgrade a database one version at a time. Version 1 is the schema
+// from before versioning; new databases get db_schema.txt, which already includes every
+// migration, and start at the latest version. Append new migrations here and update
+// db_schema.txt to match.
+var schemaMigrations = []struct {
+	version     int
+	description string
+	statements  []string
+}{
+	{2, "track the schema version", []string{`CREATE TABLE SchemaVersion(Version SMALLINT UNSIGNED NOT NULL,
+		Description VARCHAR(255) NOT NULL, Applied DATETIME NOT NULL, PRIMARY KEY (Version))`}},
+	{3, "split the opaque ID of extended records from their extension fields", opaqueIDMigration()},
+	{4, "record imports skipped because the serial was imported before", []string{
+		`ALTER TABLE ImportJobs MODIFY Status ENUM('running', 'success', 'failure', 'unchanged') NOT NULL`}},
+	{5, "keep the ETag and Last-Modified of imported downloads", []string{`CREATE TABLE DownloadValidators(
+		URL VARCHAR(255) NOT NULL, ETag VARCHAR(255) NOT NULL, LastModified VARCHAR(64) NOT NULL, Updated DATETIME NOT NULL,
+		PRIMARY KEY (URL))`}},
+	{6, "add mirrors of the registry download locations", []string{`CREATE TABLE RegistryMirrors(
+		ShortName CHAR(10) NOT NULL, Priority TINYINT UNSIGNED NOT NULL, URL CHAR(250) NOT NULL, PRIMARY KEY (ShortName, Priority))`}},
+	{7, "store the last address and CIDR prefixes of IPv4 records", []string{
+		`ALTER TABLE Records_ipv4 ADD LastIP INT UNSIGNED, ADD Prefixes TEXT, ADD INDEX(FirstIP, LastIP)`,
+		`UPDATE Records_ipv4 SET LastIP = FirstIP + HostCount - 1 WHERE HostCount > 0`}},
+	{8, "store the organization of AS names", []string{
+		`ALTER TABLE AsNames ADD Org VARCHAR(255) NOT NULL DEFAULT '' AFTER Description`}},
+	{9, "cache the RDAP answers of lookups", []string{`CREATE TABLE RdapCache(
+		Query VARCHAR(64) NOT NULL, Response TEXT NOT NULL, Fetched DATETIME NOT NULL, Expires DATETIME NOT NULL,
+		PRIMARY KEY (Query), INDEX(Expires))`}},
+	{10, "store the last address of IPv6 records", []string{
+		`ALTER TABLE Records_ipv6 ADD LastIP BINARY(16), ADD INDEX(FirstIP, LastIP)`}},
+}
+
+// migrationPreparations run before the statements of a migration. Like backfills, they
+// must be safe to run again.
+var migrationPreparations = map[int]func(db *sql.DB) error{
+	4: createUnversionedTables,
+}
+
+// migrationBackfills fill in what the statements of a migration cannot compute, after
+// them and before the version is recorded. They must be safe to run again.
+var migrationBackfills = map[int]func(db *sql.DB) error{
+	7:  backfillIPv4Prefixes,
+	10: backfillIPv6LastIP,
+}
+
+// backfillIPv4Prefixes sets the Prefixes of the IPv4 records stored without them.
+func backfillIPv4Prefixes(db *sql.DB) error {
+	return backfillRecords(db, "Records_ipv4", "INET_NTOA(FirstIP), HostCount", "Prefixes", "?", ipv4PrefixList)
+}
+
+// backfillIPv6LastIP sets the LastIP of the IPv6 records stored without it.
+func backfillIPv6LastIP(db *sql.DB) error {
+	return backfillRecords(db, "Records_ipv6", "INET6_NTOA(FirstIP), PrefixLen", "LastIP", "INET6_ATON(?)", ipv6LastIP)
+}
+
+// backfillRecords sets a column of the records of table stored without it, in batches.
+// columns select the start address and value of a record, value computes the column
+// from them and set is the SQL expression storing it.
+func backfillRecords(db *sql.DB, table, columns, column, set string, value func(start string, n uint64) interface{}) error {
+	var lastID, total int64
+	for {
+		rows, err := db.Query(fmt.Sprintf(`SELECT ID, %s FROM %s
+			WHERE %s IS NULL AND ID > ? ORDER BY ID LIMIT 10000;`, columns, table, column), lastID)
+		if err != nil {
+			return err
+		}
+		type update struct {
+			id    int64
+			value interface{}
+		}
+		var updates []update
+		for rows.Next() {
+			var start string
+			var n uint64
+			if err := rows.Scan(&lastID, &start, &n); err != nil {
+				rows.Close()
+				return err
+			}
+			updates = append(updates, update{lastID, value(start, n)})
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if len(updates) == 0 {
+			return nil
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		stmt, err := tx.Prepare(fmt.Sprintf("UPDATE %s SET %s = %s WHERE ID = ?;", table, column, set))
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		for _, u := range updates {
+			if _, err := stmt.Exec(u.value, u.id); err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		total += int64(len(updates))
+		logger.Debug("Backfilled records", "table", table, "column", column, "records", total)
+	}
+}
+
+// unversionedTables are the tables db_schema.txt gained before the schema was versioned,
+// as they were then; later migrations alter some of them.
+var unversionedTables = []string{
+	`CREATE TABLE IF NOT EXISTS ImportJobs(ID INT UNSIGNED AUTO_INCREMENT NOT NULL, Source VARCHAR(255) NOT NULL,
+		ID_Registries ENUM('afrinic', 'apnic', 'arin', 'lacnic', 'ripencc'), serial BIGINT UNSIGNED, ID_Datasets SMALLINT,
+		StartTime DATETIME NOT NULL, EndTime DATETIME, Status ENUM('running', 'success', 'failure') NOT NULL, Error TEXT,
+		CountASN INT UNSIGNED, CountIPv4 INT UNSIGNED, CountIPv6 INT UNSIGNED, CountInvalid INT UNSIGNED, PRIMARY KEY (ID),
+		INDEX(StartTime))`,
+	`CREATE TABLE IF NOT EXISTS AuditLog(ID INT UNSIGNED AUTO_INCREMENT NOT NULL, Time DATETIME NOT NULL,
+		Action VARCHAR(32) NOT NULL, Target VARCHAR(255) NOT NULL, UserName VARCHAR(64) NOT NULL, Host VARCHAR(255) NOT NULL,
+		PID INT UNSIGNED NOT NULL, Arguments TEXT NOT NULL, ID_ImportJobs INT UNSIGNED, PRIMARY KEY (ID), INDEX(Time))`,
+	`CREATE TABLE IF NOT EXISTS ApiKeys(ID INT UNSIGNED AUTO_INCREMENT NOT NULL, KeyHash CHAR(64) NOT NULL,
+		Namespace VARCHAR(32) NOT NULL, Comment VARCHAR(255) NOT NULL, Created DATETIME NOT NULL, PRIMARY KEY (ID),
+		UNIQUE(KeyHash))`,
+	`CREATE TABLE IF NOT EXISTS RawFiles(ID INT UNSIGNED AUTO_INCREMENT NOT NULL, ID_Datasets SMALLINT NOT NULL,
+		Source VARCHAR(255) NOT NULL, FetchedAt DATETIME NOT NULL, SHA256 CHAR(64) NOT NULL, Size BIGINT UNSIGNED NOT NULL,
+		Data LONGBLOB NOT NULL, PRIMARY KEY (ID), UNIQUE(ID_Datasets, SHA256))`,
+	`CREATE TABLE IF NOT EXISTS Changes(ID INT UNSIGNED AUTO_INCREMENT NOT NULL, ID_Datasets SMALLINT UNSIGNED NOT NULL,
+		ID_PrevDatasets SMALLINT UNSIGNED NOT NULL,
+		ID_Registries ENUM('afrinic', 'apnic', 'arin', 'lacnic', 'ripencc') NOT NULL, ChangeDate DATE NOT NULL,
+		RecordType ENUM('ipv4','asn','ipv6') NOT NULL, ChangeType ENUM('added', 'removed', 'changed') NOT NULL,
+		Start VARCHAR(39) NOT NULL, Value INT UNSIGNED NOT NULL, OldCC CHAR(2), NewCC CHAR(2),
+		OldState ENUM('available', 'allocated', 'assigned', 'reserved'),
+		NewState ENUM('available', 'allocated', 'assigned', 'reserved'), OldRecordDate DATE, NewRecordDate DATE,
+		OldOpaqueID VARCHAR(255), NewOpaqueID VARCHAR(255), PRIMARY KEY (ID), INDEX(ChangeDate),
+		INDEX(ID_Registries, ChangeDate), INDEX(ID_Datasets), INDEX(OldCC), INDEX(NewCC), INDEX(RecordType, Start))`,
+	`CREATE TABLE IF NOT EXISTS Resources(ID INT UNSIGNED AUTO_INCREMENT NOT NULL,
+		ID_Registries ENUM('afrinic', 'apnic', 'arin', 'lacnic', 'ripencc') NOT NULL,
+		RecordType ENUM('ipv4','asn','ipv6') NOT NULL, Start VARCHAR(39) NOT NULL, Value INT UNSIGNED NOT NULL,
+		Holder VARCHAR(64) NOT NULL, CC CHAR(2) NOT NULL,
+		State ENUM('available', 'allocated', 'assigned', 'reserved', 'removed') NOT NULL, RecordDate DATE,
+		FirstSeen DATE NOT NULL, LastSeen DATE NOT NULL, ID_FirstDatasets SMALLINT UNSIGNED NOT NULL,
+		ID_LastDatasets SMALLINT UNSIGNED NOT NULL, PRIMARY KEY (ID),
+		UNIQUE(ID_Registries, RecordType, Start, Value, Holder), INDEX(Start), INDEX(Holder),
+		INDEX(ID_Registries, LastSeen))`,
+	`CREATE TABLE IF NOT EXISTS Transfers(ID INT UNSIGNED AUTO_INCREMENT NOT NULL,
+		ID_Registries ENUM('afrinic', 'apnic', 'arin', 'lacnic', 'ripencc') NOT NULL, TransferDate DATETIME NOT NULL,
+		Type VARCHAR(32) NOT NULL, SourceRIR VARCHAR(10) NOT NULL, RecipientRIR VARCHAR(10) NOT NULL,
+		SourceOrg VARCHAR(255) NOT NULL, SourceCC CHAR(2) NOT NULL, RecipientOrg VARCHAR(255) NOT NULL,
+		RecipientCC CHAR(2) NOT NULL, RecordType ENUM('ipv4','asn','ipv6') NOT NULL, Start VARCHAR(39) NOT NULL,
+		End VARCHAR(39) NOT NULL, ID_Resources INT UNSIGNED, PRIMARY KEY (ID),
+		UNIQUE(ID_Registries, TransferDate, RecordType, Start, End), INDEX(Start), INDEX(TransferDate))`,
+	`CREATE TABLE IF NOT EXISTS DatasetTotals(ID_Datasets SMALLINT NOT NULL, RecordType ENUM('ipv4','asn','ipv6') NOT NULL,
+		State ENUM('available', 'allocated', 'assigned', 'reserved') NOT NULL, Blocks INT UNSIGNED NOT NULL,
+		Size BIGINT UNSIGNED NOT NULL, PRIMARY KEY (ID_Datasets, RecordType, State))`,
+	`CREATE TABLE IF NOT EXISTS Orgs(ID_Registries ENUM('afrinic', 'apnic', 'arin', 'lacnic', 'ripencc') NOT NULL,
+		OpaqueID VARCHAR(64) NOT NULL, OrgHandle VARCHAR(64) NOT NULL, Name VARCHAR(255) NOT NULL, CC CHAR(2) NOT NULL,
+		Updated DATETIME NOT NULL, PRIMARY KEY (ID_Registries, OpaqueID), INDEX(Name))`,
+	`CREATE TABLE IF NOT EXISTS Watches(ID INT UNSIGNED AUTO_INCREMENT NOT NULL, Kind ENUM('prefix', 'asn', 'cc') NOT NULL,
+		Value VARCHAR(43) NOT NULL, Comment VARCHAR(255) NOT NULL, Created DATETIME NOT NULL, PRIMARY KEY (ID),
+		UNIQUE(Kind, Value))`,
+	`CREATE TABLE IF NOT EXISTS GeoCities(Family TINYINT UNSIGNED NOT NULL, StartIP VARBINARY(16) NOT NULL,
+		EndIP VARBINARY(16) NOT NULL, CC CHAR(2) NOT NULL, Region VARCHAR(128) NOT NULL, City VARCHAR(128) NOT NULL,
+		Source VARCHAR(64) NOT NULL, INDEX(Family, StartIP), INDEX(Source))`,
+	`CREATE TABLE IF NOT EXISTS LatestAllocations(ID_Registries ENUM('afrinic', 'apnic', 'arin', 'lacnic', 'ripencc') NOT NULL,
+		RecordType ENUM('ipv4','asn','ipv6') NOT NULL, Start VARCHAR(39) NOT NULL, Value INT UNSIGNED NOT NULL,
+		CC CHAR(2) NOT NULL, RecordDate DATE, State ENUM('available', 'allocated', 'assigned', 'reserved') NOT NULL,
+		Holder VARCHAR(64) NOT NULL, ID_Datasets SMALLINT NOT NULL, PRIMARY KEY (ID_Registries, RecordType, Start, Value),
+		INDEX(CC), INDEX(Holder))`,
+	`CREATE TABLE IF NOT EXISTS CountryRollup(ID_Registries ENUM('afrinic', 'apnic', 'arin', 'lacnic', 'ripencc') NOT NULL,
+		CC CHAR(2) NOT NULL, RecordType ENUM('ipv4','asn','ipv6') NOT NULL,
+		State ENUM('available', 'allocated', 'assigned', 'reserved') NOT NULL, Blocks BIGINT NOT NULL, Size BIGINT NOT NULL,
+		PRIMARY KEY (ID_Registries, CC, RecordType, State))`,
+	`CREATE TABLE IF NOT EXISTS HolderRollup(ID_Registries ENUM('afrinic', 'apnic', 'arin', 'lacnic', 'ripencc') NOT NULL,
+		Holder VARCHAR(64) NOT NULL, ASNs BIGINT NOT NULL, Prefixes BIGINT NOT NULL, IPv4Addresses BIGINT NOT NULL,
+		IPv6Slash48s BIGINT NOT NULL, PRIMARY KEY (ID_Registries, Holder))`,
+	`CREATE TABLE IF NOT EXISTS DatasetQuality(ID_Datasets SMALLINT NOT NULL, Records INT UNSIGNED NOT NULL,
+		Invalid INT UNSIGNED NOT NULL, CountMismatch INT UNSIGNED NOT NULL, DateAnomalies INT UNSIGNED NOT NULL,
+		Overlaps INT UNSIGNED NOT NULL, Score DECIMAL(5,2) NOT NULL, PRIMARY KEY (ID_Datasets))`,
+	`CREATE TABLE IF NOT EXISTS Overlaps(Family TINYINT UNSIGNED NOT NULL, StartIP VARBINARY(16) NOT NULL,
+		EndIP VARBINARY(16) NOT NULL, ID_Registries ENUM('afrinic', 'apnic', 'arin', 'lacnic', 'ripencc') NOT NULL,
+		CC CHAR(2) NOT NULL, State ENUM('available', 'allocated', 'assigned', 'reserved') NOT NULL,
+		OtherRegistry ENUM('afrinic', 'apnic', 'arin', 'lacnic', 'ripencc') NOT NULL, OtherCC CHAR(2) NOT NULL,
+		OtherState ENUM('available', 'allocated', 'assigned', 'reserved') NOT NULL, FirstSeen DATETIME NOT NULL,
+		LastSeen DATETIME NOT NULL, PRIMARY KEY (Family, StartIP, EndIP, ID_Registries, OtherRegistry))`,
+	`CREATE TABLE IF NOT EXISTS AsNames(ASN INT UNSIGNED NOT NULL, Name VARCHAR(128) NOT NULL,
+		Description VARCHAR(255) NOT NULL, CC CHAR(2) NOT NULL, Source VARCHAR(64) NOT NULL, Updated DATETIME NOT NULL,
+		PRIMARY KEY (ASN), INDEX(Source))`,
+	`CREATE TABLE IF NOT EXISTS GrowthSeries(ID_Datasets SMALLINT NOT NULL,
+		ID_Registries ENUM('afrinic', 'apnic', 'arin', 'lacnic', 'ripencc') NOT NULL, SeriesDate DATE NOT NULL,
+		CC CHAR(2) NOT NULL, RecordType ENUM('ipv4','asn','ipv6') NOT NULL,
+		State ENUM('available', 'allocated', 'assigned', 'reserved') NOT NULL, Blocks INT UNSIGNED NOT NULL,
+		Size BIGINT UNSIGNED NOT NULL, PRIMARY KEY (ID_Datasets, CC, RecordType, State), INDEX(ID_Registries, SeriesDate),
+		INDEX(CC, SeriesDate))`,
+	`CREATE TABLE IF NOT EXISTS BgpPrefixes(Family TINYINT UNSIGNED NOT NULL, StartIP VARBINARY(16) NOT NULL,
+		EndIP VARBINARY(16) NOT NULL, Prefix VARCHAR(43) NOT NULL, OriginAS VARCHAR(64) NOT NULL,
+		Source VARCHAR(64) NOT NULL, PRIMARY KEY (Source, Prefix), INDEX(Family, StartIP))`,
+	`CREATE TABLE IF NOT EXISTS AsRelationships(ProviderAS INT UNSIGNED NOT NULL, CustomerAS INT UNSIGNED NOT NULL,
+		Relationship ENUM('p2c', 'p2p') NOT NULL, Source VARCHAR(64) NOT NULL, PRIMARY KEY (ProviderAS, CustomerAS),
+		INDEX(CustomerAS), INDEX(Source))`,
+	`CREATE TABLE IF NOT EXISTS Vrps(Family TINYINT UNSIGNED NOT NULL, StartIP VARBINARY(16) NOT NULL,
+		EndIP VARBINARY(16) NOT NULL, Prefix VARCHAR(43) NOT NULL, PrefixLen TINYINT UNSIGNED NOT NULL,
+		MaxLength TINYINT UNSIGNED NOT NULL, ASN INT UNSIGNED NOT NULL, TrustAnchor VARCHAR(32) NOT NULL,
+		PRIMARY KEY (Prefix, MaxLength, ASN), INDEX(Family, StartIP))`,
+	`CREATE TABLE IF NOT EXISTS IrrRoutes(Family TINYINT UNSIGNED NOT NULL, StartIP VARBINARY(16) NOT NULL,
+		EndIP VARBINARY(16) NOT NULL, Prefix VARCHAR(43) NOT NULL, PrefixLen TINYINT UNSIGNED NOT NULL,
+		Origin INT UNSIGNED NOT NULL, Source VARCHAR(32) NOT NULL, PRIMARY KEY (Source, Prefix, Origin),
+		INDEX(Family, StartIP))`,
+	`CREATE TABLE IF NOT EXISTS AbuseContacts(ID_Registries ENUM('afrinic', 'apnic', 'arin', 'lacnic', 'ripencc') NOT NULL,
+		Holder VARCHAR(64) NOT NULL, Handle VARCHAR(64) NOT NULL, Email VARCHAR(255) NOT NULL, Fetched DATETIME NOT NULL,
+		PRIMARY KEY (ID_Registries, Holder))`,
+	`CREATE TABLE IF NOT EXISTS RdnsSuffixes(ID_Registries ENUM('afrinic', 'apnic', 'arin', 'lacnic', 'ripencc') NOT NULL,
+		RecordType ENUM('ipv4','asn','ipv6') NOT NULL, Start VARCHAR(39) NOT NULL, Value INT UNSIGNED NOT NULL,
+		Family TINYINT UNSIGNED NOT NULL, StartIP VARBINARY(16) NOT NULL, EndIP VARBINARY(16) NOT NULL,
+		Suffix VARCHAR(255) NOT NULL, Samples SMALLINT UNSIGNED NOT NULL, Resolved SMALLINT UNSIGNED NOT NULL,
+		Matching SMALLINT UNSIGNED NOT NULL, Sampled DATETIME NOT NULL,
+		PRIMARY KEY (ID_Registries, RecordType, Start, Value), INDEX(Family, StartIP))`,
+	`CREATE TABLE IF NOT EXISTS GeofeedEntries(URL VARCHAR(255) NOT NULL, Family TINYINT UNSIGNED NOT NULL,
+		StartIP VARBINARY(16) NOT NULL, EndIP VARBINARY(16) NOT NULL, Prefix VARCHAR(43) NOT NULL, CC CHAR(2) NOT NULL,
+		Region VARCHAR(64) NOT NULL, City VARCHAR(64) NOT NULL, Fetched DATETIME NOT NULL, PRIMARY KEY (URL, Prefix),
+		INDEX(Family, StartIP))`,
+	`CREATE TABLE IF NOT EXISTS SpecialPurpose(Family TINYINT UNSIGNED NOT NULL, StartIP VARBINARY(16) NOT NULL,
+		EndIP VARBINARY(16) NOT NULL, Prefix VARCHAR(43) NOT NULL, Name VARCHAR(255) NOT NULL, Rfc VARCHAR(255) NOT NULL,
+		GloballyReachable BOOL NOT NULL, PRIMARY KEY (Prefix), INDEX(Family, StartIP))`,
+	`CREATE TABLE IF NOT EXISTS FirewallPolicies(Name VARCHAR(64) NOT NULL, Target VARCHAR(16) NOT NULL,
+		Spec TEXT NOT NULL, Updated DATETIME NOT NULL, PRIMARY KEY (Name))`,
+	`CREATE TABLE IF NOT EXISTS DnsblZones(Zone VARCHAR(255) NOT NULL, Spec TEXT NOT NULL, Updated DATETIME NOT NULL,
+		PRIMARY KEY (Zone))`,
+	`CREATE TABLE IF NOT EXISTS Tags(Source VARCHAR(64) NOT NULL, Kind ENUM('asn', 'prefix') NOT NULL,
+		Value VARCHAR(43) NOT NULL, Family TINYINT UNSIGNED NOT NULL, StartIP VARBINARY(16), EndIP VARBINARY(16),
+		Tag VARCHAR(64) NOT NULL, Imported DATETIME NOT NULL, PRIMARY KEY (Source, Kind, Value, Tag), INDEX(Kind, Value),
+		INDEX(Family, StartIP))`,
+}
+
+// createUnversionedTables adds what db_schema.txt gained before the schema was versioned
+// to a database created before then: the unversionedTables, the AuditLog triggers and
+// the ID_LastDatasets of the Records tables. It runs ahead of migration 4, the first one
+// to alter such a table.
+func createUnversionedTables(db *sql.DB) error {
+	for _, s := range unversionedTables {
+		if _, err := db.Exec(s); err != nil {
+			return err
+		}
+	}
+	for _, event := range []string{"UPDATE", "DELETE"} {
+		name := "AuditLog_no_" + strings.ToLower(event)
+		var n int
+		err := db.QueryRow(`SELECT COUNT(*) FROM information_schema.TRIGGERS
+			WHERE TRIGGER_SCHEMA = DATABASE() AND TRIGGER_NAME = ?;`, name).Scan(&n)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			_, err = db.Exec("CREATE TRIGGER " + name + " BEFORE " + event + ` ON AuditLog FOR EACH ROW
+				SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'AuditLog is append-only'`)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	for _, t := range []string{"Records_ipv4", "Records_ipv6", "Records_asn"} {
+		var n int
+		err := db.QueryRow(`SELECT COUNT(*) FROM information_schema.COLUMNS
+			WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND COLUMN_NAME = 'ID_LastDatasets';`, t).Scan(&n)
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			continue
+		}
+		// Records were not tracked across datasets before; each was last seen where inserted
+		if _, err := db.Exec("ALTER TABLE " + t + " ADD ID_LastDatasets SMALLINT UNSIGNED, ADD INDEX(ID_Registries, ID_LastDatasets)"); err != nil {
+			return err
+		}
+		if _, err := db.Exec("UPDATE " + t + " SET ID_LastDatasets = ID_Datasets"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func opaqueIDMigration() []string {
+	var statements []string
+	for _, t := range []string{"Records_ipv4", "Records_ipv6", "Records_asn"} {
+		statements = append(statements,
+			`UPDATE `+t+` SET Extensions = NULLIF(SUBSTRING(OpaqueID, LENGTH(SUBSTRING_INDEX(OpaqueID, '|', 2)) + 2), ''),
+				OpaqueID = SUBSTRING_INDEX(SUBSTRING(OpaqueID, 2), '|', 1) WHERE OpaqueID LIKE '|%'`,
+			`ALTER TABLE `+t+` ADD INDEX(ID_Registries, OpaqueID)`)
+	}
+	return statements
+}
+
+// latestSchemaVersion is the schema version this program works with.
+func latestSchemaVersion() int {
+	return schemaMigrations[len(schemaMigrations)-1].version
+}
+
+// schemaVersion returns the version of the database schema: 0 for an empty database
+// and 1 for one created before versioning.
+func schemaVersion(db *sql.DB) (int, error) {
+	var version int
+	err := db.QueryRow("SELECT IFNULL(MAX(Version), 0) FROM SchemaVersion;").Scan(&version)
+	if isMissingTable(err) {
+		var n int
+		err = db.QueryRow("SELECT COUNT(*) FROM Datasets;").Scan(&n)
+		if isMissingTable(err) {
+			return 0, nil
+		}
+		return 1, err
+	}
+	return version, err
+}
+
+// requireSchemaVersion stops an import against a database that needs init or migrate,
+// or that was upgraded by a newer version of the program.
+func requireSchemaVersion(db *sql.DB) {
+	version, err := schemaVersion(db)
+	if err != nil {
+		log.Fatal("Cannot determine the schema version: " + err.Error())
+	}
+	switch latest := latestSchemaVersion(); {
+	case version == 0:
+		log.Fatal("The database is empty; create the tables with the init command")
+	case version < latest:
+		log.Fatal(fmt.Sprintf("The database schema is at version %d but %d is required; upgrade it with the migrate command", version, latest))
+	case version > latest:
+		log.Fatal(fmt.Sprintf("The database schema is at version %d, newer than the supported %d; upgrade ip2asn", version, latest))
+	}
+}
+
+// schemaStatements returns the tables and triggers of db_schema.txt. The database, users
+// and grants are left to the administrator, as their names differ per installation.
+func schemaStatements() []string {
+	return parseSchemaStatements(schemaSQL)
+}
+
+// parseSchemaStatements returns the CREATE TABLE and CREATE TRIGGER statements of a
+// schema file.
+func parseSchemaStatements(schema string) []string {
+	var statements []string
+	var stmt strings.Builder
+	for _, line := range strings.Split(schema, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		stmt.WriteString(line + "\n")
+		if strings.HasSuffix(trimmed, ";") {
+			s := strings.TrimSpace(stmt.String())
+			if strings.HasPrefix(s, "CREATE TABLE") || strings.HasPrefix(s, "CREATE TRIGGER") {
+				statements = append(statements, strings.TrimSuffix(s, ";"))
+			}
+			stmt.Reset()
+		}
+	}
+	return statements
+}
+
+// seedRegistries adds the default registries that are missing.
+func seedRegistries(db *sql.DB) error {
+	for _, r := range defaultRegistries {
+		_, err := db.Exec("INSERT IGNORE INTO Registries (ShortName, LongName, LatestDataSetLocation, BaseDirDataSetLocation) VALUES (?, ?, ?, ?);",
+			r.ShortName, r.LongName, r.Latest, r.BaseDir)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// initCommand implements "init", creating the tables of an empty database and seeding
+// the registries.
+func initCommand(db *sql.DB, args []string) {
+	fs := flag.NewFlagSet("init", flag.ExitOnError)
+	fs.Parse(args)
+	if version, err := schemaVersion(db); err != nil {
+		log.Fatal(err)
+	} else if version != 0 {
+		log.Fatal(fmt.Sprintf("The database already has tables (schema version %d); use the migrate command", version))
+	}
+
+	statements := schemaStatements()
+	for _, s := range statements {
+		logger.Log(context.Background(), levelTrace, "Executing statement", "sql", s)
+		if _, err := db.Exec(s); err != nil {
+			log.Fatal(fmt.Sprintf("Creating the schema: %s", err.Error()))
+		}
+	}
+	if err := seedRegistries(db); err != nil {
+		log.Fatal(fmt.Sprintf("Seeding registries: %s", err.Error()))
+	}
+	latest := latestSchemaVersion()
+	if _, err := db.Exec("INSERT INTO SchemaVersion VALUES (?, 'initial schema', NOW());", latest); err != nil {
+		log.Fatal(err)
+	}
+	auditLog(db, "init", fmt.Sprintf("schema version %d", latest), 0)
+	logger.Info("Created tables and triggers", "statements", len(statements), "version", latest)
+}
+
+// migrateCommand implements "migrate [-status]", applying the migrations newer than the
+// schema version of the database in order.
+func migrateCommand(db *sql.DB, args []string) {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	status := fs.Bool("status", false, "Only show the schema version and pending migrations")
+	fs.Parse(args)
+
+	version, err := schemaVersion(db)
+	if err != nil {
+		log.Fatal(err)
+	} else if version == 0 {
+		log.Fatal("The database is empty; create the tables with the init command")
+	}
+	logger.Info("Schema version", "version", version, "latest", latestSchemaVersion())
+	if *status {
+		for _, m := range schemaMigrations {
+			if m.version > version {
+				logger.Info("Pending migration", "version", m.version, "description", m.description)
+			}
+		}
+		return
+	}
+	version, applied, err := migrateSchema(db, version)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := seedRegistries(db); err != nil {
+		log.Fatal(fmt.Sprintf("Seeding registries: %s", err.Error()))
+	}
+	if applied > 0 {
+		auditLog(db, "migrate", fmt.Sprintf("schema version %d", version), 0)
+	}
+	logger.Info("Applied migrations", "count", applied, "version", version)
+}
+
+// migrateSchema applies the migrations newer than version in order and returns the
+// version reached and the number of migrations applied.
+func migrateSchema(db *sql.DB, version int) (int, int, error) {
+	applied := 0
+	for _, m := range schemaMigrations {
+		if m.version <= version {
+			continue
+		}
+		logger.Info("Applying migration", "version", m.version, "description", m.description)
+		failed := func(err error) (int, int, error) {
+			return version, applied, fmt.Errorf("migration %d failed; the schema stays at version %d: %w", m.version, version, err)
+		}
+		if prepare := migrationPreparations[m.version]; prepare != nil {
+			if err := prepare(db); err != nil {
+				return failed(err)
+			}
+		}
+		for _, s := range m.statements {
+			logger.Log(context.Background(), levelTrace, "Executing statement", "sql", s)
+			if _, err := db.Exec(s); err != nil {
+				return failed(err)
+			}
+		}
+		if backfill := migrationBackfills[m.version]; backfill != nil {
+			if err := backfill(db); err != nil {
+				return failed(err)
+			}
+		}
+		if _, err := db.Exec("INSERT INTO SchemaVersion VALUES (?, ?, NOW());", m.version, m.description); err != nil {
+			return version, applied, err
+		}
+		version = m.version
+		applied++
+	}
+	return version, applied, nil
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// TestMigrateBaselineSchema migrates a database created with the db_schema.txt of before
+// the schema was versioned, and compares it with one created with the current
+// db_schema.txt. It needs a MySQL server: set IP2ASN_TEST_MYSQL=1 and the MYSQL_*
+// variables. The schemas ip2asn_test_migrated and ip2asn_test_init are recreated.
+func TestMigrateBaselineSchema(t *testing.T) {
+	if os.Getenv("IP2ASN_TEST_MYSQL") == "" {
+		t.Skip("set IP2ASN_TEST_MYSQL=1 and the MYSQL_* variables to test against a MySQL server")
+	}
+	baseline, err := os.ReadFile("testdata/db_schema_baseline.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	migrated := testSchema(t, "ip2asn_test_migrated", parseSchemaStatements(string(baseline)))
+	created := testSchema(t, "ip2asn_test_init", schemaStatements())
+
+	version, err := schemaVersion(migrated)
+	if err != nil || version != 1 {
+		t.Fatalf("schema version of the baseline: %d, %v; want 1", version, err)
+	}
+	version, _, err = migrateSchema(migrated, version)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != latestSchemaVersion() {
+		t.Fatalf("migrated to version %d; want %d", version, latestSchemaVersion())
+	}
+	// Migrating again must not fail, e.g. after a migration was interrupted
+	if err := createUnversionedTables(migrated); err != nil {
+		t.Errorf("creating the unversioned tables again: %v", err)
+	}
+
+	got, want := describeSchema(t, migrated), describeSchema(t, created)
+	for table, w := range want {
+		if g := got[table]; strings.Join(g, "\n") != strings.Join(w, "\n") {
+			t.Errorf("%s after migration:\n  %s\nwant:\n  %s", table, strings.Join(g, "\n  "), strings.Join(w, "\n  "))
+		}
+	}
+	for table := range got {
+		if want[table] == nil {
+			t.Errorf("%s is not in db_schema.txt", table)
+		}
+	}
+}
+
+// testSchema recreates a schema with the given statements.
+func testSchema(t *testing.T, name string, statements []string) *sql.DB {
+	server, err := openDB("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	for _, s := range []string{"DROP DATABASE IF EXISTS " + name, "CREATE DATABASE " + name} {
+		if _, err := server.Exec(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db, err := openDB(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	for _, s := range statements {
+		if _, err := db.Exec(s); err != nil {
+			t.Fatalf("%s: %v", s, err)
+		}
+	}
+	return db
+}
+
+// describeSchema returns the columns, indexes and triggers of each table. Indexes are
+// described by their columns, as their generated names depend on the order they were
+// added in.
+func describeSchema(t *testing.T, db *sql.DB) map[string][]string {
+	tables := map[string][]string{}
+	query := func(q string, scan func(rows *sql.Rows) error) {
+		rows, err := db.Query(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			if err := scan(rows); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := rows.Err(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	query(`SELECT TABLE_NAME, COLUMN_NAME, COLUMN_TYPE, IS_NULLABLE, IFNULL(COLUMN_DEFAULT, 'NULL'), EXTRA
+		FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = DATABASE() ORDER BY TABLE_NAME, ORDINAL_POSITION`,
+		func(rows *sql.Rows) error {
+			var table, column, kind, nullable, def, extra string
+			if err := rows.Scan(&table, &column, &kind, &nullable, &def, &extra); err != nil {
+				return err
+			}
+			tables[table] = append(tables[table], fmt.Sprintf("%s %s nullable=%s default=%s %s", column, kind, nullable, def, extra))
+			return nil
+		})
+
+	indexes := map[string]map[string]string{} // table: name: description
+	query(`SELECT TABLE_NAME, INDEX_NAME, NON_UNIQUE, COLUMN_NAME FROM information_schema.STATISTICS
+		WHERE TABLE_SCHEMA = DATABASE() ORDER BY TABLE_NAME, INDEX_NAME, SEQ_IN_INDEX`,
+		func(rows *sql.Rows) error {
+			var table, name, column string
+			var nonUnique int
+			if err := rows.Scan(&table, &name, &nonUnique, &column); err != nil {
+				return err
+			}
+			if indexes[table] == nil {
+				indexes[table] = map[string]string{}
+			}
+			desc := indexes[table][name]
+			switch {
+			case desc != "":
+				desc = strings.TrimSuffix(desc, ")") + ", " + column + ")"
+			case name == "PRIMARY":
+				desc = "PRIMARY KEY (" + column + ")"
+			case nonUnique == 0:
+				desc = "UNIQUE(" + column + ")"
+			default:
+				desc = "INDEX(" + column + ")"
+			}
+			indexes[table][name] = desc
+			return nil
+		})
+	for table, byName := range indexes {
+		var list []string
+		for _, desc := range byName {
+			list = append(list, desc)
+		}
+		sort.Strings(list)
+		tables[table] = append(tables[table], list...)
+	}
+
+	query(`SELECT EVENT_OBJECT_TABLE, TRIGGER_NAME, ACTION_TIMING, EVENT_MANIPULATION FROM information_schema.TRIGGERS
+		WHERE TRIGGER_SCHEMA = DATABASE() ORDER BY TRIGGER_NAME`,
+		func(rows *sql.Rows) error {
+			var table, name, timing, event string
+			if err := rows.Scan(&table, &name, &timing, &event); err != nil {
+				return err
+			}
+			tables[table] = append(tables[table], fmt.Sprintf("TRIGGER %s %s %s", name, timing, event))
+			return nil
+		})
+	return tables
+}
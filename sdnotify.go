@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+var lastProgress atomic.Int64 // unix nanoseconds of the last sign of life from the import
+var busy atomic.Int64         // downloads and imports in progress; an idle process is never considered hung
+
+// sdNotify sends a state string (READY=1, STATUS=..., WATCHDOG=1) to systemd.
+// It does nothing when the process is not supervised by systemd.
+func sdNotify(state string) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return
+	}
+	if socket[0] == '@' { // Abstract namespace socket
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		logger.Log(context.Background(), levelTrace, "sd_notify failed", "err", err)
+		return
+	}
+	defer conn.Close()
+	if _, err = conn.Write([]byte(state)); err != nil {
+		logger.Log(context.Background(), levelTrace, "sd_notify failed", "err", err)
+	}
+}
+
+// markProgress records that the process is still making progress; the watchdog
+// only keeps systemd happy while this keeps getting called.
+func markProgress() {
+	lastProgress.Store(time.Now().UnixNano())
+}
+
+// startWatchdog pings systemd at half the configured WatchdogSec interval while the
+// process is idle or progress has been made within the interval, so a hung import
+// gets restarted.
+func startWatchdog() {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return
+	}
+	interval := time.Duration(usec) * time.Microsecond
+	logger.Debug("systemd watchdog enabled", "interval", interval)
+
+	markProgress()
+	go func() {
+		for range time.Tick(interval / 2) {
+			if busy.Load() == 0 || time.Since(time.Unix(0, lastProgress.Load())) < interval {
+				sdNotify("WATCHDOG=1")
+			}
+		}
+	}()
+}
+
+// progressReader marks progress on every read, e.g. while a download is in flight.
+type progressReader struct {
+	r io.Reader
+}
+
+func (p progressReader) Read(buf []byte) (int, error) {
+	markProgress()
+	return p.r.Read(buf)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var httpMux = http.NewServeMux()
+
+// openAPISpec describes the REST endpoints; keep it and the client package in step.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// startHTTPServer exposes the status endpoints on -listen. The server runs in the
+// background for the lifetime of the process.
+func startHTTPServer(db *sql.DB) *http.Server {
+	httpMux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		handleHealthz(db, w, r)
+	})
+	httpMux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		handleReadyz(db, w, r)
+	})
+	httpMux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		handleMetrics(db, w, r)
+	})
+	httpMux.HandleFunc("/v1/openapi.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(openAPISpec)
+	})
+	httpMux.HandleFunc("/v1/datasets", withNamespace(handleDatasets))
+	httpMux.HandleFunc("/v1/freepool", withNamespace(handleFreePool))
+	httpMux.HandleFunc("/v1/holders/", withNamespace(handleHolder))
+	httpMux.HandleFunc("/v1/stats/space", withNamespace(handleSpace))
+	httpMux.HandleFunc("/v1/stats/ipv6", withNamespace(handleIPv6))
+	httpMux.HandleFunc("/v1/growth", withNamespace(handleGrowth))
+	httpMux.HandleFunc("/v1/deallocated", withNamespace(handleDeallocated))
+	httpMux.HandleFunc("/v1/bogons", withNamespace(handleBogons))
+	httpMux.HandleFunc("/v1/ip/", withNamespace(handleIP))
+	httpMux.HandleFunc("/v1/asn/", withNamespace(handleASN))
+	httpMux.HandleFunc("/v1/prefixes", withNamespace(handlePrefixes))
+	httpMux.HandleFunc("/taxii2/", withNamespace(handleTAXII))
+	if *f_exportDir != "" {
+		httpMux.HandleFunc("/exports/", withNamespace(handleExport))
+	}
+
+	srv := &http.Server{Addr: *f_listen, Handler: httpMux}
+	go func() {
+		logger.Info("HTTP server listening", "addr", *f_listen)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+	return srv
+}
+
+// handleHealthz reports whether the process is alive and the database reachable.
+func handleHealthz(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		http.Error(w, "database unreachable: "+err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+// handleReadyz reports ready once every registry with data has a dataset within its staleness threshold.
+func handleReadyz(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	list, err := freshness(db)
+	if err != nil {
+		http.Error(w, "cannot query datasets: "+err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if len(list) == 0 {
+		http.Error(w, "no dataset loaded", http.StatusServiceUnavailable)
+		return
+	}
+
+	var stale []string
+	for _, f := range list {
+		if f.Stale {
+			stale = append(stale, fmt.Sprintf("%s (%s)", f.Registry, f.Date.Format("2006-01-02")))
+		}
+	}
+	if len(stale) > 0 {
+		http.Error(w, "stale datasets: "+strings.Join(stale, ", "), http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ready")
+}
+
+// handleDatasets lists the latest dataset per registry of the caller's namespace.
+func handleDatasets(db *sql.DB, ns string, w http.ResponseWriter, r *http.Request) {
+	rows, err := db.Query(`SELECT d.ID, d.ID_Registries, d.serial, d.enddate FROM Datasets d
+		JOIN (SELECT ID_Registries, MAX(serial) AS serial FROM Datasets GROUP BY ID_Registries) l USING (ID_Registries, serial)
+		ORDER BY d.ID_Registries;`)
+	if err != nil {
+		http.Error(w, "cannot query datasets", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	type dataset struct {
+		ID       int64  `json:"id"`
+		Registry string `json:"registry"`
+		Serial   uint64 `json:"serial"`
+		Date     string `json:"date"`
+	}
+	datasets := []dataset{}
+	for rows.Next() {
+		var d dataset
+		var date sql.NullString
+		if err := rows.Scan(&d.ID, &d.Registry, &d.Serial, &date); err != nil {
+			http.Error(w, "cannot query datasets", http.StatusInternalServerError)
+			return
+		}
+		d.Date = date.String
+		datasets = append(datasets, d)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"namespace": ns, "datasets": datasets})
+}
+
+// latestDatasetDates returns the end date of the most recent dataset per registry.
+func latestDatasetDates(db *sql.DB) (map[string]time.Time, error) {
+	rows, err := db.Query("SELECT ID_Registries, MAX(enddate) FROM Datasets GROUP BY ID_Registries;")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	latest := make(map[string]time.Time)
+	for rows.Next() {
+		var registry string
+		var enddate sql.NullString
+		if err := rows.Scan(&registry, &enddate); err != nil {
+			return nil, err
+		}
+		if !enddate.Valid {
+			continue
+		}
+		date, err := time.Parse("2006-01-02", enddate.String)
+		if err != nil {
+			return nil, err
+		}
+		latest[registry] = date
+	}
+	return latest, rows.Err()
+}
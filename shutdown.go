@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var shutdown struct {
+	once sync.Once
+	ctx  context.Context
+}
+
+// shutdownContext returns the context, shared by the imports and servers of the process,
+// that is cancelled on SIGTERM or SIGINT. Running imports stop at the next record,
+// servers drain, and buffers are flushed by the deferred cleanups in main. If that takes
+// longer than -shutdown-timeout, or a second signal arrives, the process exits immediately.
+func shutdownContext() context.Context {
+	shutdown.once.Do(func() { shutdown.ctx = watchShutdownSignals() })
+	return shutdown.ctx
+}
+
+// watchShutdownSignals returns a context cancelled by the first signal and exits on the
+// second or at the timeout.
+func watchShutdownSignals() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, syscall.SIGTERM, syscall.SIGINT)
+
+	go func() {
+		sig := <-sigs
+		logger.Info("Shutting down", "signal", sig.String())
+		sdNotify("STOPPING=1")
+		cancel()
+
+		select {
+		case <-sigs:
+		case <-time.After(*f_shutdownTimeout + 5*time.Second): // Leave room for flushing after the HTTP drain
+		}
+		fmt.Fprintln(os.Stderr, "Shutdown did not complete in time; exiting.")
+		os.Exit(1)
+	}()
+	return ctx
+}
+
+// serverRequests counts the whois and DNSBL requests in flight and the gRPC server while
+// it drains. Their listeners are closed by the shutdown context.
+var serverRequests sync.WaitGroup
+
+// drainServers stops the HTTP server, if any, and waits for its requests in flight and
+// those of the other servers, all within -shutdown-timeout.
+func drainServers(srv *http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), *f_shutdownTimeout)
+	defer cancel()
+	if srv != nil {
+		logger.Debug("Draining HTTP requests")
+		if err := srv.Shutdown(ctx); err != nil {
+			logger.Warn("HTTP shutdown failed", "err", err)
+		}
+	}
+	drained := make(chan struct{})
+	go func() {
+		serverRequests.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		logger.Warn("Requests still running at the shutdown timeout")
+	}
+}
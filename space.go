@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/krassi/ip2asn/pkg/rirparse"
+)
+
+// spacePoint is the available or reserved space of one record type in a dataset.
+type spacePoint struct {
+	Registry string `json:"registry"`
+	Date     string `json:"date"`
+	Serial   uint64 `json:"serial"`
+	Type     string `json:"type"`
+	Status   string `json:"status"`
+	Blocks   uint64 `json:"blocks"`
+	Size     uint64 `json:"size"` // IPv4 addresses, IPv6 /48s or ASNs
+}
+
+// spaceSeries returns the available and reserved space per dataset, oldest first. Empty
+// arguments select all registries, types and dates.
+func spaceSeries(db *sql.DB, registry, kind, since string) ([]spacePoint, error) {
+	rows, err := db.Query(`SELECT d.ID_Registries, IFNULL(d.enddate, ''), d.serial, t.RecordType, t.State, t.Blocks, t.Size
+		FROM Datasets d JOIN DatasetTotals t ON t.ID_Datasets = d.ID
+		WHERE t.State IN ('available', 'reserved') AND (? = '' OR d.ID_Registries = ?) AND (? = '' OR t.RecordType = ?)
+		AND (? = '' OR d.enddate >= ?) ORDER BY d.ID_Registries, t.RecordType, t.State, d.serial;`,
+		registry, registry, kind, kind, since, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []spacePoint{}
+	for rows.Next() {
+		var p spacePoint
+		if err := rows.Scan(&p.Registry, &p.Date, &p.Serial, &p.Type, &p.Status, &p.Blocks, &p.Size); err != nil {
+			return nil, err
+		}
+		list = append(list, p)
+	}
+	return list, rows.Err()
+}
+
+func spaceStats(db *sql.DB, registry, kind, since, format string) error {
+	list, err := spaceSeries(db, registry, kind, since)
+	if err != nil {
+		return err
+	}
+	rows := make([][]string, 0, len(list))
+	for _, p := range list {
+		rows = append(rows, []string{p.Registry, p.Date, strconv.FormatUint(p.Serial, 10), p.Type, p.Status,
+			strconv.FormatUint(p.Blocks, 10), strconv.FormatUint(p.Size, 10)})
+	}
+	writeReport(format, []string{"registry", "date", "serial", "type", "status", "blocks", "size"}, rows, list)
+	return nil
+}
+
+// handleSpace serves /v1/stats/space; accepts registry, type and since parameters.
+func handleSpace(db *sql.DB, ns string, w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if since := q.Get("since"); since != "" {
+		if _, err := time.Parse("2006-01-02", since); err != nil {
+			http.Error(w, "invalid since date", http.StatusBadRequest)
+			return
+		}
+	}
+	list, err := spaceSeries(db, q.Get("registry"), q.Get("type"), q.Get("since"))
+	if err != nil {
+		http.Error(w, "cannot query space", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"namespace": ns, "space": list})
+}
+
+// backfillTotals computes DatasetTotals and GrowthSeries for older datasets from their
+// archived raw files.
+func backfillTotals(db *sql.DB) error {
+	rows, err := db.Query(`SELECT r.ID_Datasets, d.ID_Registries, IFNULL(d.enddate, ''), r.Data FROM RawFiles r
+		JOIN Datasets d ON d.ID = r.ID_Datasets
+		WHERE (NOT EXISTS (SELECT 1 FROM DatasetTotals t WHERE t.ID_Datasets = r.ID_Datasets)
+		OR NOT EXISTS (SELECT 1 FROM GrowthSeries g WHERE g.ID_Datasets = r.ID_Datasets))
+		AND r.ID = (SELECT MAX(ID) FROM RawFiles WHERE ID_Datasets = r.ID_Datasets);`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var n int
+	for rows.Next() {
+		var dataset int64
+		var registry, date string
+		var data []byte
+		if err := rows.Scan(&dataset, &registry, &date, &data); err != nil {
+			return err
+		}
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return fmt.Errorf("dataset %d: %w", dataset, err)
+		}
+		totals := spaceTotals{}
+		growth := growthTotals{}
+		scanner := bufio.NewScanner(zr)
+		for scanner.Scan() {
+			if rec, ok := rirparse.ParseRecord(scanner.Text()); ok {
+				totals.add(rec.Type, rec.Status, rec.Value)
+				growth.add(rec.CC, rec.Type, rec.Status, rec.Value)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return fmt.Errorf("dataset %d: %w", dataset, err)
+		}
+		if date == "" {
+			date = "1970-01-01" // Datasets without an end date cannot be placed in the series
+		}
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if err := saveDatasetTotals(tx, dataset, totals); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := saveGrowth(tx, registry, dataset, date, growth); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		n++
+	}
+	logger.Info("Computed totals from archived raw files", "datasets", n)
+	return rows.Err()
+}
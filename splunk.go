@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// splunkSink sends records, changes and dataset events to a Splunk HTTP Event Collector
+// with the sourcetypes ip2asn:record, ip2asn:change and ip2asn:dataset. Events are sent
+// in batches of -splunk-batch-size; failed batches are retried with backoff.
+type splunkSink struct {
+	url     string
+	client  *http.Client
+	mu      sync.Mutex
+	buf     bytes.Buffer
+	pending int
+}
+
+func newSplunkSink() *splunkSink {
+	url := strings.TrimSuffix(*f_splunkURL, "/")
+	if !strings.HasSuffix(url, "/services/collector/event") {
+		url += "/services/collector/event"
+	}
+	logger.Debug("Sending events to Splunk HEC", "url", url)
+	return &splunkSink{url: url, client: &http.Client{Timeout: 30 * time.Second}}
+}
+
+func (s *splunkSink) add(sourcetype string, t time.Time, ev interface{}) {
+	payload := map[string]interface{}{
+		"time":       float64(t.UnixNano()/int64(time.Millisecond)) / 1000,
+		"source":     "ip2asn",
+		"sourcetype": sourcetype,
+		"event":      ev,
+	}
+	if *f_splunkIndex != "" { // Otherwise the token's default index
+		payload["index"] = *f_splunkIndex
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.buf.Write(data)
+	s.buf.WriteByte('\n')
+	s.pending++
+	if s.pending >= *f_splunkBatchSize {
+		s.flush()
+	}
+}
+
+// flush sends the pending events, retrying on network errors, throttling and server
+// errors; the caller holds s.mu.
+func (s *splunkSink) flush() {
+	if s.pending == 0 {
+		return
+	}
+	n := s.pending
+	body := append([]byte(nil), s.buf.Bytes()...)
+	s.buf.Reset()
+	s.pending = 0
+
+	var lastErr string
+	for attempt := 0; attempt <= *f_splunkRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(1<<uint(attempt-1)) * time.Second)
+		}
+		req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(body))
+		if err != nil {
+			lastErr = err.Error()
+			break
+		}
+		req.Header.Set("Authorization", "Splunk "+*f_splunkToken)
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := s.client.Do(req)
+		if err != nil {
+			lastErr = err.Error()
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			return
+		}
+		lastErr = resp.Status
+		if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode < 500 {
+			break // Bad token or malformed events; retrying will not help
+		}
+	}
+	logger.Warn("Splunk events not delivered", "count", n, "err", lastErr)
+}
+
+func (s *splunkSink) publishDataset(ev DatasetEvent) {
+	s.add("ip2asn:dataset", ev.Time, ev)
+	if ev.Event == "import.finished" {
+		s.mu.Lock()
+		s.flush()
+		s.mu.Unlock()
+	}
+}
+
+func (s *splunkSink) publishRecord(ev RecordEvent) {
+	s.add("ip2asn:record", time.Now(), ev)
+}
+
+func (s *splunkSink) publishChange(ev ChangeEvent) {
+	s.add("ip2asn:change", time.Now(), ev)
+}
+
+func (s *splunkSink) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.flush()
+}
@@ -0,0 +1,428 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"log"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"github.com/krassi/ip2asn/pkg/rirparse"
+	_ "modernc.org/sqlite"
+)
+
+// sqliteMaxBatchSize keeps a batch within SQLite's 32766 variables at up to 10 per record.
+const sqliteMaxBatchSize = 3200
+
+// sqliteSchema is created in a new -sqlite-file. IPv4 addresses are stored as integers
+// and IPv6 addresses as 16-byte blobs, which SQLite compares bytewise.
+var sqliteSchema = []string{
+	`CREATE TABLE IF NOT EXISTS Registries (ID INTEGER PRIMARY KEY, ShortName TEXT NOT NULL UNIQUE, LongName TEXT NOT NULL,
+		LatestDataSetLocation TEXT NOT NULL, BaseDirDataSetLocation TEXT NOT NULL)`,
+	`CREATE TABLE IF NOT EXISTS Datasets (ID INTEGER PRIMARY KEY, ID_Registries TEXT NOT NULL, serial INTEGER NOT NULL,
+		version TEXT NOT NULL, records INTEGER NOT NULL, startdate TEXT, enddate TEXT, UTCoffset INTEGER NOT NULL,
+		UNIQUE(ID_Registries, serial))`,
+	`CREATE TABLE IF NOT EXISTS Summaries (ID INTEGER PRIMARY KEY, ID_Datasets INTEGER NOT NULL, RecordType TEXT NOT NULL,
+		Count INTEGER NOT NULL, UNIQUE(ID_Datasets, RecordType))`,
+	`CREATE TABLE IF NOT EXISTS Records_ipv4 (ID INTEGER PRIMARY KEY, ID_Datasets INTEGER NOT NULL, ID_Registries TEXT NOT NULL,
+		CC TEXT NOT NULL, FirstIP INTEGER NOT NULL, HostCount INTEGER NOT NULL, RecordDate TEXT, State TEXT NOT NULL,
+		OpaqueID TEXT, Extensions TEXT, ID_LastDatasets INTEGER, LastIP INTEGER, Prefixes TEXT,
+		UNIQUE(ID_Registries, CC, FirstIP, HostCount, RecordDate, State))`,
+	`CREATE TABLE IF NOT EXISTS Records_ipv6 (ID INTEGER PRIMARY KEY, ID_Datasets INTEGER NOT NULL, ID_Registries TEXT NOT NULL,
+		CC TEXT NOT NULL, FirstIP BLOB NOT NULL, PrefixLen INTEGER NOT NULL, RecordDate TEXT, State TEXT NOT NULL,
+		OpaqueID TEXT, Extensions TEXT, ID_LastDatasets INTEGER, LastIP BLOB,
+		UNIQUE(ID_Registries, CC, FirstIP, PrefixLen, RecordDate, State))`,
+	`CREATE TABLE IF NOT EXISTS Records_asn (ID INTEGER PRIMARY KEY, ID_Datasets INTEGER NOT NULL, ID_Registries TEXT NOT NULL,
+		CC TEXT NOT NULL, ASN INTEGER NOT NULL, ASNCount INTEGER NOT NULL, RecordDate TEXT, State TEXT NOT NULL,
+		OpaqueID TEXT, Extensions TEXT, ID_LastDatasets INTEGER, UNIQUE(ID_Registries, CC, ASN, ASNCount, RecordDate, State))`,
+	`CREATE INDEX IF NOT EXISTS Records_ipv4_FirstIP ON Records_ipv4 (FirstIP)`,
+	`CREATE INDEX IF NOT EXISTS Records_ipv6_FirstIP ON Records_ipv6 (FirstIP)`,
+	`CREATE INDEX IF NOT EXISTS Records_asn_ASN ON Records_asn (ASN)`,
+}
+
+// sqliteDerived are the columns computed from each record on import, after
+// ID_LastDatasets. Files created before a column existed get it from upgradeSQLiteSchema.
+var sqliteDerived = []struct {
+	recordType, column, definition string
+	value                          func(start string, value uint64) interface{}
+}{
+	{"ipv4", "LastIP", "INTEGER", sqliteLastIP("ipv4")},
+	{"ipv4", "Prefixes", "TEXT", ipv4PrefixList},
+	{"ipv6", "LastIP", "BLOB", sqliteLastIP("ipv6")},
+}
+
+// sqliteLastIP returns a function computing the stored form of the last address of an
+// IPv4 or IPv6 record.
+func sqliteLastIP(recordType string) func(start string, value uint64) interface{} {
+	last := map[string]func(string, uint64) interface{}{"ipv4": ipv4LastIP, "ipv6": ipv6LastIP}[recordType]
+	return func(start string, value uint64) interface{} {
+		addr, ok := last(start, value).(string)
+		if !ok {
+			return nil
+		}
+		stored, _ := sqliteStart(recordType, addr)
+		return stored
+	}
+}
+
+// upgradeSQLiteSchema adds the missing sqliteDerived columns to the tables of an
+// existing file and fills them in, each in its own transaction.
+func upgradeSQLiteSchema(db *sql.DB) error {
+	for _, c := range sqliteDerived {
+		table := "Records_" + c.recordType
+		var n int
+		if err := db.QueryRow("SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?;", table, c.column).Scan(&n); err != nil {
+			return err
+		}
+		if n > 0 {
+			continue
+		}
+		logger.Info("Adding a column to the SQLite file", "table", table, "column", c.column)
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s;", table, c.column, c.definition)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := backfillSQLiteColumn(tx, c.recordType, c.column, c.value); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("filling in %s.%s: %w", table, c.column, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// backfillSQLiteColumn sets a derived column of the records stored without it.
+func backfillSQLiteColumn(tx *sql.Tx, recordType, column string, value func(string, uint64) interface{}) error {
+	table := "Records_" + recordType
+	rows, err := tx.Query(fmt.Sprintf("SELECT ID, FirstIP, %s FROM %s WHERE %s IS NULL;", keyTypes[recordType][1], table, column))
+	if err != nil {
+		return err
+	}
+	type update struct {
+		id    int64
+		value interface{}
+	}
+	var updates []update
+	for rows.Next() {
+		var id, n int64
+		var first interface{}
+		if err := rows.Scan(&id, &first, &n); err != nil {
+			rows.Close()
+			return err
+		}
+		if start, ok := sqliteAddr(first); ok {
+			updates = append(updates, update{id, value(start.String(), uint64(n))})
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare(fmt.Sprintf("UPDATE %s SET %s = ? WHERE ID = ?;", table, column))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, u := range updates {
+		if _, err := stmt.Exec(u.value, u.id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sqliteStore imports into a single SQLite file, for local lookups without a database
+// server.
+type sqliteStore struct {
+	db *sql.DB
+}
+
+// openSQLiteStore opens or creates a SQLite file and its tables.
+func openSQLiteStore(path string) (sqliteStore, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return sqliteStore{}, err
+	}
+	db.SetMaxOpenConns(1) // SQLite has a single writer; share one connection
+	for _, stmt := range append([]string{"PRAGMA busy_timeout = 5000"}, sqliteSchema...) {
+		if _, err := db.Exec(stmt); err != nil {
+			db.Close()
+			return sqliteStore{}, fmt.Errorf("creating tables in %s: %w", path, err)
+		}
+	}
+	if err := upgradeSQLiteSchema(db); err != nil {
+		db.Close()
+		return sqliteStore{}, fmt.Errorf("upgrading tables in %s: %w", path, err)
+	}
+	for _, r := range defaultRegistries {
+		if _, err := db.Exec("INSERT OR IGNORE INTO Registries (ShortName, LongName, LatestDataSetLocation, BaseDirDataSetLocation) VALUES (?, ?, ?, ?);",
+			r.ShortName, r.LongName, r.Latest, r.BaseDir); err != nil {
+			db.Close()
+			return sqliteStore{}, err
+		}
+	}
+	return sqliteStore{db: db}, nil
+}
+
+// saveSQLiteHeader stores the version and summary lines of a dataset in tx. With force
+// the dataset of the same registry and serial is updated and reused.
+func saveSQLiteHeader(tx *sql.Tx, hdr rirparse.FileHeader, force bool) (int64, error) {
+	var lastID int64
+	logger.Debug("Saving header data in database")
+	query := "INSERT INTO Datasets (ID_Registries, serial, version, records, startdate, enddate, UTCoffset) VALUES (?, ?, ?, ?, ?, ?, ?)"
+	if force {
+		query += ` ON CONFLICT (ID_Registries, serial) DO UPDATE SET version = excluded.version, records = excluded.records,
+			startdate = excluded.startdate, enddate = excluded.enddate, UTCoffset = excluded.UTCoffset`
+	}
+	err := tx.QueryRow(query+" RETURNING ID;", hdr.Registry, int64(hdr.Serial), hdr.Version, hdr.Records,
+		normalizeDate(hdr.StartDate), normalizeDate(hdr.EndDate), hdr.UTCOffset).Scan(&lastID)
+	if err != nil {
+		return 0, fmt.Errorf("saving dataset header: %w", err)
+	}
+
+	for _, k := range []string{"ipv4", "asn", "ipv6"} {
+		_, err := tx.Exec(`INSERT INTO Summaries (ID_Datasets, RecordType, Count) VALUES (?, ?, ?)
+			ON CONFLICT (ID_Datasets, RecordType) DO UPDATE SET Count = excluded.Count;`, lastID, k, hdr.Summaries[k])
+		if err != nil {
+			return 0, fmt.Errorf("saving the %s summary: %w", k, err)
+		}
+	}
+	return lastID, nil
+}
+
+func (s sqliteStore) HasDataset(registry string, serial uint64) (bool, error) {
+	var n int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM Datasets WHERE ID_Registries = ? AND serial = ?;", registry, int64(serial)).Scan(&n)
+	return n > 0, err
+}
+
+func (s sqliteStore) LatestSerial(registry string) (uint64, error) {
+	var serial int64
+	err := s.db.QueryRow("SELECT IFNULL(MAX(serial), 0) FROM Datasets WHERE ID_Registries = ?;", registry).Scan(&serial)
+	return uint64(serial), err
+}
+
+func (s sqliteStore) RegistryURL(registry string) (string, error) {
+	var URL string
+	err := s.db.QueryRow("SELECT LatestDataSetLocation FROM Registries WHERE ShortName = ?;", registry).Scan(&URL)
+	return URL, err
+}
+
+func (s sqliteStore) MySQL() *sql.DB {
+	return nil
+}
+
+func (s sqliteStore) Begin(hdr rirparse.FileHeader, force bool) (RecordTx, int64, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, 0, err
+	}
+	dataset, err := saveSQLiteHeader(tx, hdr, force)
+	if err != nil {
+		tx.Rollback()
+		return nil, 0, err
+	}
+	size := clampBatchSize(*f_batchSize)
+	if size > sqliteMaxBatchSize {
+		size = sqliteMaxBatchSize
+	}
+	var maxID int64
+	t := &sqliteRecordTx{tx: tx, registry: hdr.Registry, batches: map[string]*recordBatch{}, maxIDs: map[string]int64{}}
+	for k, cols := range keyTypes {
+		table := "Records_" + k
+		// Records inserted by this import get IDs above the highest before it
+		if err := tx.QueryRow(fmt.Sprintf("SELECT IFNULL(MAX(ID), 0) FROM %s;", table)).Scan(&maxID); err != nil {
+			tx.Rollback()
+			return nil, 0, err
+		}
+		t.maxIDs[k] = maxID
+		derived, placeholders := "", ""
+		for _, c := range sqliteDerived {
+			if c.recordType == k {
+				derived, placeholders = derived+", "+c.column, placeholders+", ?"
+			}
+		}
+		t.batches[k] = &recordBatch{tx: tx, table: table, size: size,
+			insert: fmt.Sprintf("INSERT INTO %s (ID_Datasets, ID_Registries, CC, %s, %s, RecordDate, State, OpaqueID, Extensions, ID_LastDatasets%s) VALUES ",
+				table, cols[0], cols[1], derived),
+			row: fmt.Sprintf("(%d, ?, ?, ?, ?, ?, ?, ?, ?, %d%s)", dataset, dataset, placeholders),
+			suffix: fmt.Sprintf(` ON CONFLICT (ID_Registries, CC, %s, %s, RecordDate, State) DO UPDATE SET ID_LastDatasets = excluded.ID_LastDatasets,
+				OpaqueID = excluded.OpaqueID, Extensions = excluded.Extensions
+				WHERE (ID_LastDatasets, OpaqueID, Extensions) IS NOT (excluded.ID_LastDatasets, excluded.OpaqueID, excluded.Extensions);`,
+				cols[0], cols[1])}
+	}
+	return t, dataset, nil
+}
+
+type sqliteRecordTx struct {
+	tx       *sql.Tx
+	registry string
+	batches  map[string]*recordBatch
+	maxIDs   map[string]int64
+	counts   map[string]uint64
+}
+
+func (t *sqliteRecordTx) SaveRecord(rec rirparse.Record) error {
+	start, err := sqliteStart(rec.Type, rec.Start)
+	if err != nil {
+		return fmt.Errorf("invalid start of %s record: %s", rec.Type, rec.Start)
+	}
+	args := []interface{}{rec.Registry, rec.CC, start, int64(rec.Value), normalizeDate(rec.Date), rec.Status,
+		rec.OpaqueID, strings.Join(rec.Extensions, "|")}
+	for _, c := range sqliteDerived {
+		if c.recordType == rec.Type {
+			args = append(args, c.value(rec.Start, rec.Value))
+		}
+	}
+	return t.batches[rec.Type].add(args...)
+}
+
+func (t *sqliteRecordTx) Flush() error {
+	for _, b := range t.batches {
+		if err := b.flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Commit writes the last batches and counts the rows: SQLite reports 1 changed row per
+// inserted or updated record; unchanged ones are skipped by the upsert.
+func (t *sqliteRecordTx) Commit() error {
+	if err := t.Flush(); err != nil {
+		return err
+	}
+	counts := map[string]uint64{}
+	for k, b := range t.batches {
+		var inserted uint64
+		err := t.tx.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM Records_%s WHERE ID > ? AND ID_Registries = ?;", k),
+			t.maxIDs[k], t.registry).Scan(&inserted)
+		if err != nil {
+			return fmt.Errorf("counting inserted records: %w", err)
+		}
+		counts["inserted"] += inserted
+		counts["updated"] += b.affected - inserted
+		counts["unchanged"] += b.written - b.affected
+	}
+	if err := t.tx.Commit(); err != nil {
+		return err
+	}
+	t.counts = counts
+	return nil
+}
+
+func (t *sqliteRecordTx) Rollback() error {
+	return t.tx.Rollback()
+}
+
+func (t *sqliteRecordTx) MySQL() *sql.Tx {
+	return nil
+}
+
+func (t *sqliteRecordTx) RowCounts() map[string]uint64 {
+	return t.counts
+}
+
+// sqliteStart converts a start address or ASN to its stored form.
+func sqliteStart(recordType, start string) (interface{}, error) {
+	if recordType == "asn" {
+		asn, err := strconv.ParseUint(start, 10, 32)
+		return int64(asn), err
+	}
+	addr, err := netip.ParseAddr(start)
+	if err != nil {
+		return nil, err
+	}
+	if recordType == "ipv4" {
+		if !addr.Is4() {
+			return nil, fmt.Errorf("not an IPv4 address: %s", start)
+		}
+		b := addr.As4()
+		return int64(binary.BigEndian.Uint32(b[:])), nil
+	}
+	b := addr.As16()
+	return b[:], nil
+}
+
+// sqliteAddr converts a stored FirstIP or LastIP back to an address.
+func sqliteAddr(stored interface{}) (netip.Addr, bool) {
+	switch v := stored.(type) {
+	case int64:
+		var b [4]byte
+		binary.BigEndian.PutUint32(b[:], uint32(v))
+		return netip.AddrFrom4(b), true
+	case []byte:
+		return netip.AddrFromSlice(v)
+	}
+	return netip.Addr{}, false
+}
+
+// sqliteLookup answers a lookup query from the newest record containing an address or
+// ASN. Routes, AS names and tags are not kept in SQLite.
+func sqliteLookup(db *sql.DB, q string) (lookupAnswer, error) {
+	a := lookupAnswer{Query: q}
+	var start interface{}
+	var date, opaque sql.NullString
+	if asn, err := strconv.ParseUint(strings.TrimPrefix(strings.ToUpper(q), "AS"), 10, 32); err == nil {
+		a.ASN = strconv.FormatUint(asn, 10)
+		err := db.QueryRow(`SELECT ID_Registries, CC, ASN, ASNCount, RecordDate, State, OpaqueID FROM Records_asn
+			WHERE ASN <= ? AND ASN + ASNCount > ? ORDER BY ID_LastDatasets DESC LIMIT 1;`, int64(asn), int64(asn)).Scan(
+			&a.Registry, &a.CC, &start, &a.Value, &date, &a.Status, &opaque)
+		if err == nil {
+			a.Type, a.Start, a.Date, a.Holder = "asn", fmt.Sprint(start), date.String, opaque.String
+		}
+		return a, err
+	}
+
+	addr, err := netip.ParseAddr(q)
+	if err != nil {
+		return a, fmt.Errorf("not an address or ASN: %s", q)
+	}
+	addr = addr.Unmap()
+	kind, value := "ipv6", "PrefixLen"
+	if addr.Is4() {
+		kind, value = "ipv4", "HostCount"
+	}
+	key, _ := sqliteStart(kind, addr.String())
+	var first interface{}
+	err = db.QueryRow(fmt.Sprintf(`SELECT ID_Registries, CC, FirstIP, %s, RecordDate, State, OpaqueID FROM Records_%s
+		WHERE FirstIP <= ? AND LastIP >= ? ORDER BY ID_LastDatasets DESC, FirstIP DESC, LastIP LIMIT 1;`, value, kind), key, key).Scan(
+		&a.Registry, &a.CC, &first, &a.Value, &date, &a.Status, &opaque)
+	if err == nil {
+		start, _ := sqliteAddr(first)
+		a.Type, a.Start, a.Date, a.Holder = kind, start.String(), date.String, opaque.String
+	}
+	return a, err
+}
+
+// runSQLite imports the datasets selected by -source into -sqlite-file, or runs the
+// lookup command against it.
+func runSQLite() {
+	st, err := openSQLiteStore(*f_sqliteFile)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	defer st.db.Close()
+	if flag.NArg() > 0 && !isCommand("import") {
+		if flag.Arg(0) != "lookup" {
+			log.Fatal("-db-driver sqlite only supports imports and the lookup command")
+		}
+		runLookups(flag.Args()[1:], nil, func(_ int, asOf string) func(q string) (lookupAnswer, error) {
+			if asOf != "" {
+				log.Fatal("-db-driver sqlite only keeps the latest records; -as-of is not supported")
+			}
+			return func(q string) (lookupAnswer, error) { return sqliteLookup(st.db, q) }
+		})
+		return
+	}
+	runImports(st)
+}
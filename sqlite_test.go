@@ -0,0 +1,154 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/krassi/ip2asn/pkg/rirparse"
+)
+
+// TestSQLiteLookup finds an IPv6 allocation behind more more-specific prefixes than a
+// fixed number of candidate rows would reach, and the end of an IPv4 range, in a new
+// file and in one created before the records had LastIP and Prefixes.
+func TestSQLiteLookup(t *testing.T) {
+	records := []rirparse.Record{{Registry: "ripencc", CC: "NL", Type: "ipv6", Start: "2001:db8::", Value: 32,
+		Date: "20200101", Status: "allocated", OpaqueID: "org-a"}, {Registry: "ripencc", CC: "NL", Type: "ipv4",
+		Start: "100.64.0.0", Value: 768, Date: "20200101", Status: "allocated", OpaqueID: "org-a"}}
+	for i := 0; i < 100; i++ {
+		records = append(records, rirparse.Record{Registry: "ripencc", CC: "DE", Type: "ipv6",
+			Start: fmt.Sprintf("2001:db8:%x::", i), Value: 48, Date: "20200101", Status: "assigned", OpaqueID: "org-b"})
+	}
+	tests := []struct {
+		query, start string
+	}{
+		{"2001:db8:ffff::1", "2001:db8::"},
+		{"2001:db8:63:1::1", "2001:db8:63::"},
+		{"2001:db9::1", ""},
+		{"100.64.2.255", "100.64.0.0"},
+		{"100.64.3.0", ""},
+	}
+
+	for _, old := range []bool{false, true} {
+		t.Run(fmt.Sprintf("upgraded=%t", old), func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "ip2asn.sqlite")
+			if old {
+				createPreLastIPFile(t, path, records)
+			} else {
+				st, err := openSQLiteStore(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				tx, _, err := st.Begin(rirparse.FileHeader{Version: "2", Registry: "ripencc", Serial: 1,
+					Records: uint64(len(records)), Summaries: map[string]uint64{"ipv4": 1, "ipv6": uint64(len(records) - 1)}}, false)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, rec := range records {
+					if err := tx.SaveRecord(rec); err != nil {
+						t.Fatal(err)
+					}
+				}
+				if err := tx.Commit(); err != nil {
+					t.Fatal(err)
+				}
+				st.db.Close()
+			}
+
+			st, err := openSQLiteStore(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer st.db.Close()
+			for _, tt := range tests {
+				a, err := sqliteLookup(st.db, tt.query)
+				if tt.start == "" {
+					if err != sql.ErrNoRows {
+						t.Errorf("%s: %+v, %v; want no rows", tt.query, a, err)
+					}
+				} else if err != nil || a.Start != tt.start {
+					t.Errorf("%s: delegation %q, %v; want %q", tt.query, a.Start, err, tt.start)
+				}
+			}
+			var prefixes string
+			if err := st.db.QueryRow("SELECT Prefixes FROM Records_ipv4;").Scan(&prefixes); err != nil {
+				t.Fatal(err)
+			} else if prefixes != "100.64.0.0/23,100.64.2.0/24" {
+				t.Errorf("prefixes of 100.64.0.0 + 768: %q", prefixes)
+			}
+		})
+	}
+}
+
+// createPreLastIPFile writes records to a SQLite file with the record tables as they
+// were before LastIP and Prefixes.
+func createPreLastIPFile(t *testing.T, path string, records []rirparse.Record) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for _, k := range []string{"ipv4", "ipv6"} {
+		if _, err := db.Exec(fmt.Sprintf(`CREATE TABLE Records_%s (ID INTEGER PRIMARY KEY, ID_Datasets INTEGER NOT NULL,
+			ID_Registries TEXT NOT NULL, CC TEXT NOT NULL, FirstIP NOT NULL, %s INTEGER NOT NULL, RecordDate TEXT,
+			State TEXT NOT NULL, OpaqueID TEXT, Extensions TEXT, ID_LastDatasets INTEGER,
+			UNIQUE(ID_Registries, CC, FirstIP, %[2]s, RecordDate, State))`, k, keyTypes[k][1])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, rec := range records {
+		start, _ := sqliteStart(rec.Type, rec.Start)
+		if _, err := db.Exec(fmt.Sprintf(`INSERT INTO Records_%s (ID_Datasets, ID_Registries, CC, FirstIP, %s, RecordDate,
+			State, OpaqueID, ID_LastDatasets) VALUES (1, ?, ?, ?, ?, ?, ?, ?, 1);`, rec.Type, keyTypes[rec.Type][1]),
+			rec.Registry, rec.CC, start, int64(rec.Value), normalizeDate(rec.Date), rec.Status, rec.OpaqueID); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// TestSQLiteRowCounts imports a dataset, imports it again with force with a changed
+// holder and a new record, then imports the next one.
+func TestSQLiteRowCounts(t *testing.T) {
+	st, err := openSQLiteStore(filepath.Join(t.TempDir(), "ip2asn.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.db.Close()
+	record := func(start, holder string) rirparse.Record {
+		return rirparse.Record{Registry: "ripencc", CC: "NL", Type: "ipv4", Start: start, Value: 256, Date: "20200101",
+			Status: "allocated", OpaqueID: holder}
+	}
+	tests := []struct {
+		serial  uint64
+		force   bool
+		records []rirparse.Record
+		want    map[string]uint64
+	}{
+		{1, false, []rirparse.Record{record("100.64.0.0", "org-a"), record("100.64.1.0", "org-a")},
+			map[string]uint64{"inserted": 2, "updated": 0, "unchanged": 0}},
+		{1, true, []rirparse.Record{record("100.64.0.0", "org-a"), record("100.64.1.0", "org-b"), record("100.64.2.0", "org-a")},
+			map[string]uint64{"inserted": 1, "updated": 1, "unchanged": 1}},
+		{2, false, []rirparse.Record{record("100.64.0.0", "org-a"), record("100.64.1.0", "org-b"), record("100.64.2.0", "org-a")},
+			map[string]uint64{"inserted": 0, "updated": 3, "unchanged": 0}},
+	}
+	for _, tt := range tests {
+		tx, _, err := st.Begin(rirparse.FileHeader{Version: "2", Registry: "ripencc", Serial: tt.serial,
+			Records: uint64(len(tt.records)), Summaries: map[string]uint64{"ipv4": uint64(len(tt.records))}}, tt.force)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, rec := range tt.records {
+			if err := tx.SaveRecord(rec); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		if got := tx.RowCounts(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("serial %d, force %t: %v; want %v", tt.serial, tt.force, got, tt.want)
+		}
+	}
+}
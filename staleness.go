@@ -0,0 +1,151 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// datasetFreshness describes how current the latest dataset of a registry is.
+type datasetFreshness struct {
+	Registry  string        `json:"registry"`
+	Date      time.Time     `json:"date"`
+	Age       time.Duration `json:"-"`
+	Threshold time.Duration `json:"-"`
+	Stale     bool          `json:"stale"`
+}
+
+// staleThreshold returns the freshness threshold of a registry: its entry in
+// -stale-after-registry, or -stale-after.
+func staleThreshold(registry string) time.Duration {
+	s := currentSettings()
+	if d, ok := s.staleAfterRegistry[registry]; ok {
+		return d
+	}
+	return s.staleAfter
+}
+
+// parseStaleThresholds parses -stale-after-registry, e.g. "afrinic=72h,arin=36h".
+func parseStaleThresholds(spec string) (map[string]time.Duration, error) {
+	if spec == "" {
+		return nil, nil
+	}
+	thresholds := map[string]time.Duration{}
+	for _, entry := range strings.Split(spec, ",") {
+		parts := strings.SplitN(strings.TrimSpace(entry), "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid -stale-after-registry entry: %s", entry)
+		}
+		d, err := time.ParseDuration(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid -stale-after-registry entry: %s", entry)
+		}
+		thresholds[parts[0]] = d
+	}
+	return thresholds, nil
+}
+
+// freshness returns the freshness of every registry with at least one dataset, sorted by registry.
+func freshness(db *sql.DB) ([]datasetFreshness, error) {
+	latest, err := latestDatasetDates(db)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]datasetFreshness, 0, len(latest))
+	for reg, date := range latest {
+		f := datasetFreshness{Registry: reg, Date: date, Age: time.Since(date), Threshold: staleThreshold(reg)}
+		f.Stale = f.Age > f.Threshold
+		list = append(list, f)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Registry < list[j].Registry })
+	return list, nil
+}
+
+// checkCommand implements "check": prints the freshness of each registry and exits
+// with a monitoring plugin status: 0 OK, 2 stale datasets, 3 unknown.
+func checkCommand(db *sql.DB) {
+	list, err := freshness(db)
+	if err != nil {
+		fmt.Printf("UNKNOWN: cannot query datasets: %s\n", err.Error())
+		os.Exit(3)
+	}
+	if len(list) == 0 {
+		fmt.Println("UNKNOWN: no dataset loaded")
+		os.Exit(3)
+	}
+
+	var stale []string
+	for _, f := range list {
+		if f.Stale {
+			stale = append(stale, f.Registry)
+		}
+	}
+	if len(stale) > 0 {
+		fmt.Printf("CRITICAL: stale datasets: %s\n", strings.Join(stale, ", "))
+	} else {
+		fmt.Println("OK: all datasets are current")
+	}
+	for _, f := range list {
+		state := "ok"
+		if f.Stale {
+			state = "STALE"
+		}
+		fmt.Printf("%-8s %s  age %-10s threshold %-10s %s\n", f.Registry, f.Date.Format("2006-01-02"),
+			f.Age.Truncate(time.Minute), f.Threshold, state)
+	}
+	if len(stale) > 0 {
+		os.Exit(2)
+	}
+}
+
+// registryStatus is a line of the status command.
+type registryStatus struct {
+	datasetFreshness
+	Serial uint64 `json:"serial"`
+	Age    string `json:"age"`
+}
+
+// statusCommand implements "status [-format table|json]": the schema version and the
+// latest dataset of each registry with its serial, age and staleness.
+func statusCommand(db *sql.DB, args []string) {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	format := fs.String("format", "table", "Output format: table or json")
+	fs.Parse(args)
+
+	version, err := schemaVersion(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+	list, err := freshness(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+	st := mysqlStore{db}
+	var regs []registryStatus
+	rows := make([][]string, 0, len(list))
+	for _, f := range list {
+		serial, err := st.LatestSerial(f.Registry)
+		if err != nil {
+			log.Fatal(err)
+		}
+		r := registryStatus{datasetFreshness: f, Serial: serial, Age: f.Age.Truncate(time.Minute).String()}
+		regs = append(regs, r)
+		state := "ok"
+		if f.Stale {
+			state = "stale"
+		}
+		rows = append(rows, []string{f.Registry, strconv.FormatUint(serial, 10), f.Date.Format("2006-01-02"), r.Age,
+			f.Threshold.String(), state})
+	}
+	if *format != "json" {
+		fmt.Printf("Schema version %d; this program uses %d.\n", version, latestSchemaVersion())
+	}
+	writeReport(*format, []string{"registry", "serial", "date", "age", "threshold", "state"}, rows, map[string]interface{}{
+		"schema_version": version, "program_schema_version": latestSchemaVersion(), "registries": regs})
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// countryStats are the delegated resources of one country in the latest datasets.
+type countryStats struct {
+	CC            string `json:"cc"`
+	IPv4Addresses uint64 `json:"ipv4_addresses"`
+	IPv6Slash48s  uint64 `json:"ipv6_48s"` // prefixes longer than /48 count as one
+	ASNs          uint64 `json:"asns"`
+}
+
+// registryStats counts the records of one type and status in the latest dataset
+// of a registry. Delta is the change since the previous dataset, from the Changes table.
+type registryStats struct {
+	Registry string `json:"registry"`
+	Serial   uint64 `json:"serial"`
+	Type     string `json:"type"`
+	Status   string `json:"status"`
+	Count    int64  `json:"count"`
+	Delta    int64  `json:"delta"`
+}
+
+// statsCommand prints summary statistics of the latest datasets.
+func statsCommand(db *sql.DB, args []string) {
+	fs := flag.NewFlagSet("stats", flag.ExitOnError)
+	by := fs.String("by", "country", "Group statistics by: country, registry, quality, space, age or ipv6")
+	format := fs.String("format", "table", "Output format: table, csv or json")
+	registry := fs.String("registry", "", "Only datasets of this registry (quality, space, age)")
+	limit := fs.Int("limit", 30, "Number of datasets to list (quality)")
+	kind := fs.String("type", "", "Only this record type: asn, ipv4 or ipv6 (space, age)")
+	since := fs.String("since", "", "Only datasets on or after this date, YYYY-MM-DD (space, ipv6)")
+	cc := fs.String("cc", "", "Only this country code (age, ipv6)")
+	daily := fs.Bool("daily", false, "Every dataset date instead of the last one of each month (ipv6)")
+	perCountry := fs.Bool("per-country", false, "Break the age distribution down by country (age)")
+	fs.Parse(args)
+
+	switch *format {
+	case "table", "csv", "json":
+	default:
+		log.Fatal("Unknown stats format: " + *format)
+	}
+
+	switch *by {
+	case "country":
+		list, err := statsByCountry(db)
+		if err != nil {
+			log.Fatal(err)
+		}
+		rows := make([][]string, 0, len(list))
+		for _, s := range list {
+			rows = append(rows, []string{s.CC, fmt.Sprint(s.IPv4Addresses), fmt.Sprint(s.IPv6Slash48s), fmt.Sprint(s.ASNs)})
+		}
+		writeReport(*format, []string{"cc", "ipv4_addresses", "ipv6_48s", "asns"}, rows, list)
+	case "registry":
+		list, err := statsByRegistry(db)
+		if err != nil {
+			log.Fatal(err)
+		}
+		rows := make([][]string, 0, len(list))
+		for _, s := range list {
+			rows = append(rows, []string{s.Registry, fmt.Sprint(s.Serial), s.Type, s.Status, fmt.Sprint(s.Count), fmt.Sprintf("%+d", s.Delta)})
+		}
+		writeReport(*format, []string{"registry", "serial", "type", "status", "count", "delta"}, rows, list)
+	case "quality":
+		qualityStats(db, *registry, *limit, *format)
+	case "age":
+		if err := ageStats(db, *registry, strings.ToUpper(*cc), *kind, *perCountry || *cc != "", *format); err != nil {
+			log.Fatal(err)
+		}
+	case "ipv6":
+		if err := ipv6Stats(db, strings.ToUpper(*cc), *since, *daily, *format); err != nil {
+			log.Fatal(err)
+		}
+	case "space":
+		if err := spaceStats(db, *registry, *kind, *since, *format); err != nil {
+			log.Fatal(err)
+		}
+	default:
+		log.Fatal("Unknown stats grouping: " + *by)
+	}
+}
+
+// statsByCountry totals allocated and assigned resources per country code from CountryRollup.
+func statsByCountry(db *sql.DB) ([]countryStats, error) {
+	rows, err := db.Query(`SELECT CC, RecordType, SUM(Size) FROM CountryRollup
+		WHERE State IN ('allocated', 'assigned') AND CC <> '' GROUP BY CC, RecordType ORDER BY CC;`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []countryStats{}
+	for rows.Next() {
+		var cc, kind string
+		var size uint64
+		if err := rows.Scan(&cc, &kind, &size); err != nil {
+			return nil, err
+		}
+		if len(list) == 0 || list[len(list)-1].CC != cc {
+			list = append(list, countryStats{CC: cc})
+		}
+		s := &list[len(list)-1]
+		switch kind {
+		case "ipv4":
+			s.IPv4Addresses = size
+		case "ipv6":
+			s.IPv6Slash48s = size
+		case "asn":
+			s.ASNs = size
+		}
+	}
+	return list, rows.Err()
+}
+
+// statsByRegistry counts the records of the latest dataset of every registry by type and status.
+func statsByRegistry(db *sql.DB) ([]registryStats, error) {
+	type dataset struct {
+		registry string
+		id       int64
+		serial   uint64
+	}
+	var latest []dataset
+	rows, err := db.Query(`SELECT d.ID_Registries, d.ID, d.serial FROM Datasets d
+		JOIN (SELECT ID_Registries, MAX(serial) AS serial FROM Datasets GROUP BY ID_Registries) l USING (ID_Registries, serial)
+		ORDER BY d.ID_Registries;`)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var d dataset
+		if err := rows.Scan(&d.registry, &d.id, &d.serial); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		latest = append(latest, d)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var list []registryStats
+	for _, d := range latest {
+		counts := map[string]*registryStats{}
+		get := func(kind, status string) *registryStats {
+			s := counts[kind+"|"+status]
+			if s == nil {
+				s = &registryStats{Registry: d.registry, Serial: d.serial, Type: kind, Status: status}
+				counts[kind+"|"+status] = s
+			}
+			return s
+		}
+
+		for _, kind := range []string{"asn", "ipv4", "ipv6"} {
+			rows, err := db.Query(fmt.Sprintf("SELECT State, COUNT(*) FROM Records_%s WHERE ID_Registries = ? AND ID_LastDatasets = ? GROUP BY State;", kind),
+				d.registry, d.id)
+			if err != nil {
+				return nil, err
+			}
+			for rows.Next() {
+				var status string
+				var n int64
+				if err := rows.Scan(&status, &n); err != nil {
+					rows.Close()
+					return nil, err
+				}
+				get(kind, status).Count = n
+			}
+			rows.Close()
+			if err := rows.Err(); err != nil {
+				return nil, err
+			}
+		}
+
+		rows, err := db.Query(`SELECT RecordType, IFNULL(OldState, ''), IFNULL(NewState, ''), COUNT(*) FROM Changes
+			WHERE ID_Datasets = ? GROUP BY 1, 2, 3;`, d.id)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var kind, oldState, newState string
+			var n int64
+			if err := rows.Scan(&kind, &oldState, &newState, &n); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			if oldState != "" {
+				get(kind, oldState).Delta -= n
+			}
+			if newState != "" {
+				get(kind, newState).Delta += n
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+
+		start := len(list)
+		for _, s := range counts {
+			list = append(list, *s)
+		}
+		part := list[start:]
+		sort.Slice(part, func(i, j int) bool {
+			if part[i].Type != part[j].Type {
+				return part[i].Type < part[j].Type
+			}
+			return part[i].Status < part[j].Status
+		})
+	}
+	return list, nil
+}
+
+// slash48s returns the number of /48 networks in an IPv6 prefix of the given length.
+func slash48s(prefixLen uint64) uint64 {
+	if prefixLen >= 48 {
+		return 1
+	}
+	return 1 << (48 - prefixLen)
+}
+
+// writeReport prints tabular data as an aligned table or CSV, or v as JSON.
+func writeReport(format string, header []string, rows [][]string, v interface{}) {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(v); err != nil {
+			log.Fatal(err)
+		}
+	case "csv":
+		w := csv.NewWriter(os.Stdout)
+		w.Write(header)
+		w.WriteAll(rows)
+		if err := w.Error(); err != nil {
+			log.Fatal(err)
+		}
+	default:
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', tabwriter.AlignRight)
+		fmt.Fprintln(w, strings.ToUpper(strings.Join(header, "\t"))+"\t")
+		for _, row := range rows {
+			fmt.Fprintln(w, strings.Join(row, "\t")+"\t")
+		}
+		w.Flush()
+	}
+}
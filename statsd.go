@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+)
+
+// statsdClient emits metrics over UDP in the StatsD line format with DogStatsD tags.
+// A nil client discards everything, so callers need not check whether -statsd is set.
+type statsdClient struct {
+	conn   net.Conn
+	prefix string
+	tags   []string
+}
+
+var stats *statsdClient
+
+func setupStatsd() {
+	if *f_statsd == "" {
+		return
+	}
+	conn, err := net.Dial("udp", *f_statsd)
+	if err != nil {
+		log.Fatal("Cannot set up StatsD client: " + err.Error())
+	}
+	stats = &statsdClient{conn: conn, prefix: *f_statsdPrefix}
+	for _, tag := range strings.Split(*f_statsdTags, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			stats.tags = append(stats.tags, tag)
+		}
+	}
+	logger.Debug("Sending metrics to StatsD", "addr", *f_statsd)
+}
+
+func (s *statsdClient) send(name, value, kind string, tags []string) {
+	if s == nil {
+		return
+	}
+	line := s.prefix + name + ":" + value + "|" + kind
+	if all := append(append([]string{}, s.tags...), tags...); len(all) > 0 {
+		line += "|#" + strings.Join(all, ",")
+	}
+	if _, err := s.conn.Write([]byte(line)); err != nil {
+		logger.Log(context.Background(), levelTrace, "StatsD send failed", "err", err)
+	}
+}
+
+func (s *statsdClient) count(name string, value uint64, tags ...string) {
+	s.send(name, fmt.Sprint(value), "c", tags)
+}
+
+func (s *statsdClient) gauge(name string, value float64, tags ...string) {
+	s.send(name, fmt.Sprint(value), "g", tags)
+}
+
+func (s *statsdClient) timing(name string, d time.Duration, tags ...string) {
+	s.send(name, fmt.Sprintf("%.3f", float64(d)/float64(time.Millisecond)), "ms", tags)
+}
+
+// importMetrics emits timing and record counts for a finished import attempt.
+func (s *statsdClient) importMetrics(result ImportResult) {
+	if s == nil {
+		return
+	}
+	registry := "registry:" + result.Registry
+	s.timing("import.duration", time.Duration(result.Duration*float64(time.Second)), registry, "status:"+result.Status)
+	s.count("import.attempts", 1, registry, "status:"+result.Status)
+	for _, k := range []string{"asn", "ipv4", "ipv6"} {
+		s.count("import.records", result.Counts[k], registry, "type:"+k)
+	}
+	s.count("import.invalid", result.Counts["invalid"], registry)
+	if result.Status == "success" {
+		s.gauge("import.last_success", float64(time.Now().Unix()), registry)
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/krassi/ip2asn/pkg/rirparse"
+)
+
+// Store keeps the datasets and records of imports. MySQL also holds the tables derived
+// from them (changes, resources, statistics) that commands and the servers read; other
+// stores only keep datasets, summaries and records.
+type Store interface {
+	// HasDataset reports whether the dataset of a registry with a serial was imported.
+	HasDataset(registry string, serial uint64) (bool, error)
+	// LatestSerial returns the highest serial imported of a registry, or 0.
+	LatestSerial(registry string) (uint64, error)
+	// Begin starts the transaction a dataset is saved in, stores its version and summary
+	// lines in it and returns the dataset ID. With force an existing dataset of the same
+	// registry and serial is reused.
+	Begin(hdr rirparse.FileHeader, force bool) (RecordTx, int64, error)
+	// RegistryURL returns the location of a registry's latest dataset from the
+	// Registries table, or sql.ErrNoRows.
+	RegistryURL(registry string) (string, error)
+	// MySQL returns the connection holding the derived tables, or nil.
+	MySQL() *sql.DB
+}
+
+// RecordTx saves the records of a dataset; nothing of the dataset, including its
+// Datasets and Summaries rows, is visible before Commit, and Rollback removes it all.
+type RecordTx interface {
+	SaveRecord(rec rirparse.Record) error
+	// Flush writes the records queued in batches, so that statements in the transaction
+	// see them.
+	Flush() error
+	Commit() error
+	Rollback() error
+	// MySQL returns the transaction of a MySQL store, which the tables derived from the
+	// dataset are written in too, or nil.
+	MySQL() *sql.Tx
+	// RowCounts returns, after Commit, how many records were inserted, updated (seen again
+	// in this dataset or with a changed holder or extensions) and unchanged (stored by this
+	// dataset before, when it is imported again with -force), or nil if the store cannot tell.
+	RowCounts() map[string]uint64
+}
+
+type mysqlStore struct {
+	db *sql.DB
+}
+
+func (s mysqlStore) HasDataset(registry string, serial uint64) (bool, error) {
+	var n int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM Datasets WHERE ID_Registries = ? AND serial = ?;", registry, serial).Scan(&n)
+	return n > 0, err
+}
+
+func (s mysqlStore) LatestSerial(registry string) (uint64, error) {
+	var serial uint64
+	err := s.db.QueryRow("SELECT IFNULL(MAX(serial), 0) FROM Datasets WHERE ID_Registries = ?;", registry).Scan(&serial)
+	return serial, err
+}
+
+func (s mysqlStore) RegistryURL(registry string) (string, error) {
+	var URL string
+	err := s.db.QueryRow("SELECT LatestDataSetLocation FROM Registries WHERE ShortName = ?;", registry).Scan(&URL)
+	return URL, err
+}
+
+func (s mysqlStore) MySQL() *sql.DB {
+	return s.db
+}
+
+func (s mysqlStore) Begin(hdr rirparse.FileHeader, force bool) (RecordTx, int64, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, 0, err
+	}
+	dataset, err := saveHeaderData(tx, hdr, force)
+	if err != nil {
+		tx.Rollback()
+		return nil, 0, err
+	}
+	t := &mysqlRecordTx{tx: tx, registry: hdr.Registry, batches: map[string]*recordBatch{}, maxIDs: map[string]uint64{}}
+	for k := range keyTypes {
+		t.batches[k] = newRecordBatch(tx, k, dataset, *f_batchSize)
+		// Records inserted by this import get IDs above the highest before it
+		var maxID uint64
+		if err := tx.QueryRow(fmt.Sprintf("SELECT IFNULL(MAX(ID), 0) FROM Records_%s;", k)).Scan(&maxID); err != nil {
+			tx.Rollback()
+			return nil, 0, err
+		}
+		t.maxIDs[k] = maxID
+	}
+	return t, dataset, nil
+}
+
+type mysqlRecordTx struct {
+	tx       *sql.Tx
+	registry string
+	batches  map[string]*recordBatch
+	maxIDs   map[string]uint64
+	counts   map[string]uint64
+}
+
+func (t *mysqlRecordTx) SaveRecord(rec rirparse.Record) error {
+	args := []interface{}{rec.Registry, rec.CC, rec.Start, rec.Value, rec.Date, rec.Status,
+		truncate(rec.OpaqueID, 255), truncate(strings.Join(rec.Extensions, "|"), 255)}
+	switch rec.Type {
+	case "ipv4":
+		args = append(args, ipv4PrefixList(rec.Start, rec.Value))
+	case "ipv6":
+		args = append(args, ipv6LastIP(rec.Start, rec.Value))
+	}
+	return t.batches[rec.Type].add(args...)
+}
+
+func (t *mysqlRecordTx) Flush() error {
+	for _, b := range t.batches {
+		if err := b.flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Commit writes the last batches and counts the rows: MySQL reports 1 affected row per
+// inserted record, 2 per updated and 0 per unchanged one.
+func (t *mysqlRecordTx) Commit() error {
+	if err := t.Flush(); err != nil {
+		return err
+	}
+	counts := map[string]uint64{}
+	for k, b := range t.batches {
+		var inserted uint64
+		err := t.tx.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM Records_%s WHERE ID > ? AND ID_Registries = ?;", k),
+			t.maxIDs[k], t.registry).Scan(&inserted)
+		if err != nil {
+			return fmt.Errorf("counting inserted records: %w", err)
+		}
+		updated := (b.affected - inserted) / 2
+		counts["inserted"] += inserted
+		counts["updated"] += updated
+		counts["unchanged"] += b.written - inserted - updated
+	}
+	if err := t.tx.Commit(); err != nil {
+		return err
+	}
+	t.counts = counts
+	return nil
+}
+
+func (t *mysqlRecordTx) RowCounts() map[string]uint64 {
+	return t.counts
+}
+
+func (t *mysqlRecordTx) Rollback() error {
+	return t.tx.Rollback()
+}
+
+func (t *mysqlRecordTx) MySQL() *sql.Tx {
+	return t.tx
+}
+
+// runImports imports the datasets selected by -source into a store without the derived
+// tables. Commands, servers and -worker read those and need MySQL.
+func runImports(st Store) {
+	if *f_listen != "" || *f_grpcListen != "" || *f_whoisListen != "" || *f_dnsblListen != "" || *f_worker != "" || *f_daemon {
+		log.Fatal("-db-driver " + *f_dbDriver + " only supports imports with -source; servers, -worker and -daemon need MySQL")
+	}
+	ctx := shutdownContext()
+	setupEventSinks()
+	defer closeEventSinks()
+	if _, err := importSource(ctx, st); err != nil && ctx.Err() == nil {
+		log.Fatal(err)
+	}
+}
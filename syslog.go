@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RFC 5424 severities used by the logger
+const (
+	sevCrit   = 2
+	sevErr    = 3
+	sevWarn   = 4
+	sevNotice = 5
+	sevInfo   = 6
+	sevDebug  = 7
+)
+
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "lpr": 6, "news": 7,
+	"uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19, "local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// syslogSink sends RFC 5424 formatted messages to a local or remote syslog daemon.
+type syslogSink struct {
+	mu       sync.Mutex
+	network  string
+	addr     string
+	conn     net.Conn
+	facility int
+	hostname string
+	appName  string
+}
+
+var sysLogger *syslogSink
+
+// setupSyslog connects to the target given in -syslog: "local" for /dev/log or
+// udp://host:port, tcp://host:port for a remote collector.
+func setupSyslog() {
+	if *f_syslog == "" {
+		return
+	}
+	facility, ok := syslogFacilities[*f_syslogFacility]
+	if !ok {
+		log.Fatal("Unknown syslog facility: " + *f_syslogFacility)
+	}
+
+	network, addr := "unixgram", "/dev/log"
+	if *f_syslog != "local" {
+		parts := strings.SplitN(*f_syslog, "://", 2)
+		if len(parts) != 2 || (parts[0] != "udp" && parts[0] != "tcp") {
+			log.Fatal("Invalid -syslog target; use local, udp://host:port or tcp://host:port")
+		}
+		network, addr = parts[0], parts[1]
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "-"
+	}
+	sysLogger = &syslogSink{network: network, addr: addr, facility: facility, hostname: hostname, appName: "ip2asn"}
+	if err := sysLogger.connect(); err != nil {
+		log.Fatal("Cannot connect to syslog: " + err.Error())
+	}
+	log.SetOutput(logWriter{})
+}
+
+func (s *syslogSink) connect() error {
+	conn, err := net.DialTimeout(s.network, s.addr, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	s.conn = conn
+	return nil
+}
+
+// send formats and writes one message, reconnecting once if the connection dropped.
+func (s *syslogSink) send(severity int, level uint, msg string) {
+	msg = strings.TrimRight(msg, "\n")
+	if msg == "" {
+		return
+	}
+	line := fmt.Sprintf("<%d>1 %s %s %s %d - [ip2asn@32473 level=\"%d\"] %s",
+		s.facility*8+severity, time.Now().Format(time.RFC3339Nano), s.hostname, s.appName, os.Getpid(), level, msg)
+	if s.network == "tcp" { // Octet counting framing (RFC 6587)
+		line = fmt.Sprintf("%d %s", len(line), line)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil || s.write(line) != nil {
+		if s.conn != nil {
+			s.conn.Close()
+		}
+		if err := s.connect(); err != nil {
+			fmt.Fprintf(os.Stderr, "syslog: %s\n", err.Error())
+			return
+		}
+		if err := s.write(line); err != nil {
+			fmt.Fprintf(os.Stderr, "syslog: %s\n", err.Error())
+		}
+	}
+}
+
+func (s *syslogSink) write(line string) error {
+	_, err := s.conn.Write([]byte(line))
+	return err
+}
+
+// syslogSeverity maps a log level to a syslog severity.
+func syslogSeverity(level slog.Level) int {
+	switch {
+	case level >= slog.LevelError:
+		return sevErr
+	case level >= slog.LevelWarn:
+		return sevWarn
+	case level >= slog.LevelInfo:
+		return sevNotice
+	case level >= slog.LevelDebug:
+		return sevInfo
+	}
+	return sevDebug
+}
+
+// logWriter duplicates the standard logger (log.Fatal and friends) to syslog and sends
+// it to the log output.
+type logWriter struct{}
+
+func (logWriter) Write(p []byte) (int, error) {
+	if sysLogger != nil {
+		sysLogger.send(sevCrit, 0, string(p))
+	}
+	return writeStdLog(p)
+}
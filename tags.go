@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// tagsCommand implements "tags import [-source NAME] FILE", replacing the tags of a
+// source, "tags list [-source NAME]", "tags remove SOURCE" and "tags show ASN|ADDRESS".
+// Tag files have one ASN or prefix per line followed by its tags, separated by commas,
+// tabs or spaces, e.g. "AS64500,hosting" or "192.0.2.0/24 vpn risk=80"; "#" starts a comment.
+func tagsCommand(db *sql.DB, args []string) {
+	usage := "Usage: tags import [-source NAME] FILE | tags list [-source NAME] | tags remove SOURCE | tags show ASN|ADDRESS"
+	if len(args) == 0 {
+		log.Fatal(usage)
+	}
+	switch args[0] {
+	case "import":
+		fs := flag.NewFlagSet("tags import", flag.ExitOnError)
+		source := fs.String("source", "", "Name of the tag list; default the file name without extension")
+		fs.Parse(args[1:])
+		if fs.NArg() != 1 {
+			log.Fatal(usage)
+		}
+		if *source == "" {
+			*source = strings.TrimSuffix(filepath.Base(fs.Arg(0)), filepath.Ext(fs.Arg(0)))
+		}
+		if err := importTags(db, *source, fs.Arg(0)); err != nil {
+			log.Fatal(err)
+		}
+	case "list":
+		fs := flag.NewFlagSet("tags list", flag.ExitOnError)
+		source := fs.String("source", "", "Only list the tags of this source")
+		fs.Parse(args[1:])
+		rows, err := db.Query("SELECT Source, Kind, Value, Tag FROM Tags WHERE ? = '' OR Source = ? ORDER BY Source, Kind, Value, Tag;",
+			*source, *source)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var src, kind, value, tag string
+			if err := rows.Scan(&src, &kind, &value, &tag); err != nil {
+				log.Fatal(err)
+			}
+			fmt.Printf("%s\t%s\t%s\t%s\n", src, kind, value, tag)
+		}
+		if err := rows.Err(); err != nil {
+			log.Fatal(err)
+		}
+	case "remove":
+		if len(args) != 2 {
+			log.Fatal(usage)
+		}
+		res, err := db.Exec("DELETE FROM Tags WHERE Source = ?;", args[1])
+		if err != nil {
+			log.Fatal(err)
+		}
+		n, _ := res.RowsAffected()
+		auditLog(db, "tags remove", args[1], 0)
+		logger.Info("Removed tags", "count", n, "resource", args[1])
+	case "show":
+		if len(args) != 2 {
+			log.Fatal(usage)
+		}
+		var tags []string
+		var err error
+		if asn, e := strconv.ParseUint(strings.TrimPrefix(strings.ToUpper(args[1]), "AS"), 10, 32); e == nil {
+			tags, err = tagsAt(db, netip.Addr{}, strconv.FormatUint(asn, 10))
+		} else if addr, e := netip.ParseAddr(args[1]); e == nil {
+			origin := ""
+			if route, e := routeValidity(db, addr.String()); e == nil {
+				origin = route["origin_as"]
+			}
+			tags, err = tagsAt(db, addr, origin)
+		} else {
+			log.Fatal(usage)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(strings.Join(tags, ","))
+	default:
+		log.Fatal(usage)
+	}
+}
+
+// importTags replaces the tags of a source with the contents of a tag file.
+func importTags(db *sql.DB, source, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM Tags WHERE Source = ?;", source); err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare("REPLACE INTO Tags VALUES (?, ?, ?, ?, ?, ?, ?, NOW());")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	var n, skipped int
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if i := strings.IndexByte(text, '#'); i >= 0 {
+			text = text[:i]
+		}
+		fields := strings.FieldsFunc(text, func(c rune) bool { return c == ',' || c == '\t' || c == ' ' || c == ';' })
+		if len(fields) < 2 {
+			if len(fields) == 1 {
+				skipped++
+			}
+			continue
+		}
+		kind, value, family := "asn", "", 0
+		var start, end []byte
+		if asn, err := strconv.ParseUint(strings.TrimPrefix(strings.ToUpper(fields[0]), "AS"), 10, 32); err == nil {
+			value = strconv.FormatUint(asn, 10)
+		} else if p, err := netip.ParsePrefix(fields[0]); err == nil {
+			p = p.Masked()
+			kind, value, family = "prefix", p.String(), 4
+			if p.Addr().Is6() {
+				family = 6
+			}
+			start, end = p.Addr().AsSlice(), lastAddr(p).AsSlice()
+		} else if a, err := netip.ParseAddr(fields[0]); err == nil {
+			p := netip.PrefixFrom(a, a.BitLen())
+			kind, value, family = "prefix", p.String(), 4
+			if a.Is6() {
+				family = 6
+			}
+			start, end = a.AsSlice(), a.AsSlice()
+		} else {
+			logger.Debug("Not an ASN or prefix", "file", file, "line", line, "value", fields[0])
+			skipped++
+			continue
+		}
+		for _, tag := range fields[1:] {
+			if _, err := stmt.Exec(source, kind, value, family, start, end, truncate(strings.ToLower(tag), 64)); err != nil {
+				return fmt.Errorf("saving %s: %w", value, err)
+			}
+			n++
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	auditLog(db, "tags import", source, 0)
+	logger.Info("Imported tags", "count", n, "source", source, "skipped", skipped)
+	return nil
+}
+
+// tagsAt returns the sorted tags of the prefixes containing addr (if valid) and of the
+// origin ASNs, which may be a multi-origin set such as "64500_64501".
+func tagsAt(db *sql.DB, addr netip.Addr, origin string) ([]string, error) {
+	family := 4
+	if addr.Is6() {
+		family = 6
+	}
+	var key []byte
+	if addr.IsValid() {
+		key = addr.Unmap().AsSlice()
+	}
+	rows, err := db.Query(`SELECT DISTINCT Tag FROM Tags
+		WHERE (Kind = 'prefix' AND Family = ? AND StartIP <= ? AND EndIP >= ?)
+		OR (Kind = 'asn' AND FIND_IN_SET(Value, REPLACE(?, '_', ','))) ORDER BY Tag;`,
+		family, key, key, origin)
+	if isMissingTable(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var tags []string
+	for rows.Next() {
+		var tag string
+		if err := rows.Scan(&tag); err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	return tags, rows.Err()
+}
+
+// tagOverlay holds all tags in memory for bulk lookups and exports.
+type tagOverlay struct {
+	asns     map[string][]string
+	prefixes map[int]map[netip.Prefix][]string // By prefix length, IPv6 lengths offset by 128
+	lengths  []int
+	sorted   []netip.Prefix // Tagged prefixes by address, to find those inside a block
+}
+
+// loadTagOverlay reads the Tags table; it returns nil when there are no tags.
+func loadTagOverlay(db *sql.DB) (*tagOverlay, error) {
+	rows, err := db.Query("SELECT Kind, Value, Tag FROM Tags;")
+	if isMissingTable(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	o := &tagOverlay{asns: map[string][]string{}, prefixes: map[int]map[netip.Prefix][]string{}}
+	n := 0
+	for rows.Next() {
+		var kind, value, tag string
+		if err := rows.Scan(&kind, &value, &tag); err != nil {
+			return nil, err
+		}
+		n++
+		if kind == "asn" {
+			o.asns[value] = append(o.asns[value], tag)
+			continue
+		}
+		p, err := netip.ParsePrefix(value)
+		if err != nil {
+			continue
+		}
+		bits := p.Bits()
+		if p.Addr().Is6() {
+			bits += 128
+		}
+		if o.prefixes[bits] == nil {
+			o.prefixes[bits] = map[netip.Prefix][]string{}
+			o.lengths = append(o.lengths, bits)
+		}
+		if o.prefixes[bits][p] == nil {
+			o.sorted = append(o.sorted, p)
+		}
+		o.prefixes[bits][p] = append(o.prefixes[bits][p], tag)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, nil
+	}
+	sort.Ints(o.lengths)
+	sort.Slice(o.sorted, func(i, j int) bool { return o.sorted[i].Addr().Less(o.sorted[j].Addr()) })
+	return o, nil
+}
+
+// match returns the sorted tags of the origin ASNs and of the tagged prefixes that
+// overlap the range first-last: those containing it and those inside it. A nil overlay
+// matches nothing.
+func (o *tagOverlay) match(first, last netip.Addr, origin string) []string {
+	if o == nil {
+		return nil
+	}
+	seen := map[string]bool{}
+	for _, asn := range strings.Split(origin, "_") {
+		for _, tag := range o.asns[asn] {
+			seen[tag] = true
+		}
+	}
+	if first.IsValid() {
+		first, last = first.Unmap(), last.Unmap()
+		for _, bits := range o.lengths {
+			plen := bits
+			if first.Is6() {
+				if bits < 128 {
+					continue
+				}
+				plen -= 128
+			} else if bits >= 128 {
+				break
+			}
+			if p, err := first.Prefix(plen); err == nil {
+				for _, tag := range o.prefixes[bits][p] {
+					seen[tag] = true
+				}
+			}
+		}
+		i := sort.Search(len(o.sorted), func(i int) bool { return !o.sorted[i].Addr().Less(first) })
+		for ; i < len(o.sorted) && !last.Less(o.sorted[i].Addr()); i++ {
+			p := o.sorted[i]
+			bits := p.Bits()
+			if p.Addr().Is6() {
+				bits += 128
+			}
+			for _, tag := range o.prefixes[bits][p] {
+				seen[tag] = true
+			}
+		}
+	}
+	tags := make([]string, 0, len(seen))
+	for tag := range seen {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	return tags
+}
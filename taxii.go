@@ -0,0 +1,195 @@
+package main
+
+import (
+	"crypto/sha1"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const taxiiContentType = "application/taxii+json;version=2.1"
+
+// taxiiCollection is a TAXII 2.1 collection of STIX indicators built from the database.
+type taxiiCollection struct {
+	ID          string   `json:"id"`
+	Title       string   `json:"title"`
+	Description string   `json:"description"`
+	CanRead     bool     `json:"can_read"`
+	CanWrite    bool     `json:"can_write"`
+	MediaTypes  []string `json:"media_types"`
+	prefixes    func(db *sql.DB) ([]netip.Prefix, error)
+}
+
+// stixID derives a stable UUID (version 5 layout) from a name, so collections and
+// indicators keep their IDs between runs.
+func stixID(name string) string {
+	h := sha1.Sum([]byte("ip2asn:" + name))
+	h[6] = h[6]&0x0f | 0x50
+	h[8] = h[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", h[0:4], h[4:6], h[6:8], h[8:10], h[10:16])
+}
+
+// taxiiCollections are the full bogons, the watched prefixes, ASNs and countries, and the
+// delegations of every country in -taxii-countries.
+func taxiiCollections() []taxiiCollection {
+	media := []string{"application/stix+json;version=2.1"}
+	list := []taxiiCollection{
+		{ID: stixID("bogons"), Title: "Full bogons", Description: "Special-purpose and undelegated address space",
+			CanRead: true, MediaTypes: media, prefixes: func(db *sql.DB) ([]netip.Prefix, error) { return bogonPrefixes(db, true, 0) }},
+		{ID: stixID("watched"), Title: "Watched prefixes", Description: "Watched prefixes, the BGP routes of watched ASNs and the delegations of watched countries",
+			CanRead: true, MediaTypes: media, prefixes: watchedPrefixes},
+	}
+	for _, cc := range strings.Split(*f_taxiiCountries, ",") {
+		cc = strings.ToUpper(strings.TrimSpace(cc))
+		if len(cc) != 2 {
+			continue
+		}
+		list = append(list, taxiiCollection{ID: stixID("country:" + cc), Title: "Country " + cc,
+			Description: "Address space delegated to " + cc, CanRead: true, MediaTypes: media,
+			prefixes: func(db *sql.DB) ([]netip.Prefix, error) { return policyPrefixes(db, policyRule{Kind: "cc", Value: cc}) }})
+	}
+	return list
+}
+
+// watchedPrefixes returns the watched prefixes, the routes originated by watched ASNs
+// and the delegations of watched countries.
+func watchedPrefixes(db *sql.DB) ([]netip.Prefix, error) {
+	rows, err := db.Query("SELECT Kind, Value FROM Watches;")
+	if err != nil {
+		return nil, err
+	}
+	var rules []policyRule
+	for rows.Next() {
+		var r policyRule
+		if err := rows.Scan(&r.Kind, &r.Value); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	var list []netip.Prefix
+	for _, r := range rules {
+		prefixes, err := policyPrefixes(db, r)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, prefixes...)
+	}
+	return aggregatePrefixes(list), nil
+}
+
+// handleTAXII serves the TAXII 2.1 discovery (/taxii2/), API root (/taxii2/api/),
+// collections and objects endpoints. Objects are STIX indicators, one per prefix, paged
+// with limit and next and filtered by added_after against the last successful import.
+func handleTAXII(db *sql.DB, ns string, w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/taxii2"), "/")
+	parts := strings.Split(path, "/")
+	reply := func(v interface{}) {
+		w.Header().Set("Content-Type", taxiiContentType)
+		json.NewEncoder(w).Encode(v)
+	}
+	taxiiError := func(status int, title string) {
+		w.Header().Set("Content-Type", taxiiContentType)
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]string{"title": title})
+	}
+
+	switch {
+	case path == "":
+		reply(map[string]interface{}{"title": "ip2asn", "default": "/taxii2/api/", "api_roots": []string{"/taxii2/api/"}})
+		return
+	case parts[0] != "api":
+		taxiiError(http.StatusNotFound, "unknown API root")
+		return
+	case len(parts) == 1:
+		reply(map[string]interface{}{"title": "ip2asn " + ns, "versions": []string{taxiiContentType}, "max_content_length": 0})
+		return
+	case parts[1] != "collections":
+		taxiiError(http.StatusNotFound, "unknown endpoint")
+		return
+	}
+
+	collections := taxiiCollections()
+	if len(parts) == 2 {
+		reply(map[string]interface{}{"collections": collections})
+		return
+	}
+	var c *taxiiCollection
+	for i := range collections {
+		if collections[i].ID == parts[2] {
+			c = &collections[i]
+		}
+	}
+	if c == nil {
+		taxiiError(http.StatusNotFound, "unknown collection")
+		return
+	}
+	if len(parts) == 3 {
+		reply(c)
+		return
+	}
+	if len(parts) != 4 || parts[3] != "objects" {
+		taxiiError(http.StatusNotFound, "unknown endpoint")
+		return
+	}
+
+	var updated sql.NullString
+	db.QueryRow("SELECT DATE_FORMAT(MAX(EndTime), '%Y-%m-%dT%H:%i:%s.000Z') FROM ImportJobs WHERE Status = 'success';").Scan(&updated)
+	modified := updated.String
+	if modified == "" {
+		modified = "1970-01-01T00:00:00.000Z"
+	}
+	if after, err := time.Parse(time.RFC3339, r.URL.Query().Get("added_after")); err == nil {
+		if m, err := time.Parse(time.RFC3339, modified); err == nil && !m.After(after) {
+			reply(map[string]interface{}{"more": false, "objects": []interface{}{}})
+			return
+		}
+	}
+	prefixes, err := c.prefixes(db)
+	if err != nil {
+		taxiiError(http.StatusInternalServerError, "cannot build collection")
+		return
+	}
+
+	offset, _ := strconv.Atoi(r.URL.Query().Get("next"))
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 || limit > 10000 {
+		limit = 10000
+	}
+	if offset < 0 || offset > len(prefixes) {
+		offset = len(prefixes)
+	}
+	end := offset + limit
+	if end > len(prefixes) {
+		end = len(prefixes)
+	}
+	objects := make([]map[string]interface{}, 0, end-offset)
+	for _, p := range prefixes[offset:end] {
+		kind := "ipv4-addr"
+		if p.Addr().Is6() {
+			kind = "ipv6-addr"
+		}
+		objects = append(objects, map[string]interface{}{
+			"type": "indicator", "spec_version": "2.1", "id": "indicator--" + stixID(c.ID+":"+p.String()),
+			"created": modified, "modified": modified, "valid_from": modified, "name": c.Title + ": " + p.String(),
+			"indicator_types": []string{"unknown"}, "pattern_type": "stix",
+			"pattern": fmt.Sprintf("[%s:value = '%s']", kind, p),
+		})
+	}
+	envelope := map[string]interface{}{"more": end < len(prefixes), "objects": objects}
+	if end < len(prefixes) {
+		envelope["next"] = strconv.Itoa(end)
+	}
+	w.Header().Set("X-TAXII-Date-Added-First", modified)
+	w.Header().Set("X-TAXII-Date-Added-Last", modified)
+	reply(envelope)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer is a no-op until setupTracing installs an exporting provider.
+var tracer = otel.Tracer("github.com/krassi/ip2asn")
+
+// setupTracing exports spans to the OTLP/HTTP endpoint given in -otlp-endpoint.
+// The returned function flushes pending spans and must be called before exiting.
+func setupTracing() func() {
+	if *f_otlpEndpoint == "" {
+		return func() {}
+	}
+	exporter, err := otlptracehttp.New(context.Background(), otlptracehttp.WithEndpointURL(*f_otlpEndpoint))
+	if err != nil {
+		log.Fatal("Cannot set up OTLP exporter: " + err.Error())
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", "ip2asn"))),
+	)
+	otel.SetTracerProvider(provider)
+	tracer = provider.Tracer("github.com/krassi/ip2asn")
+	logger.Debug("Exporting traces", "endpoint", *f_otlpEndpoint)
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := provider.Shutdown(ctx); err != nil {
+			logger.Warn("Cannot flush traces", "err", err)
+		}
+	}
+}
+
+// endSpan records err on the span, if any, and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/netip"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// transferURLs are the transfer statistics files in the common NRO JSON format.
+var transferURLs = map[string]string{
+	"afrinic": "https://ftp.afrinic.net/stats/afrinic/transfers/transfers_latest.json",
+	"apnic":   "https://ftp.apnic.net/stats/apnic/transfers/transfers_latest.json",
+	"arin":    "https://ftp.arin.net/pub/stats/arin/transfers/transfers_latest.json",
+	"lacnic":  "https://ftp.lacnic.net/pub/stats/lacnic/transfers/transfers_latest.json",
+	"ripencc": "https://ftp.ripe.net/pub/stats/ripencc/transfers/transfers_latest.json",
+}
+
+type transferOrg struct {
+	Name        string `json:"name"`
+	CountryCode string `json:"country_code"`
+}
+
+type transferIPSet struct {
+	OriginalSet []struct {
+		Start string `json:"start_address"`
+		End   string `json:"end_address"`
+	} `json:"original_set"`
+	TransferSet []struct {
+		Start string `json:"start_address"`
+		End   string `json:"end_address"`
+	} `json:"transfer_set"`
+}
+
+type transferASNSet struct {
+	OriginalSet []struct {
+		Start uint64 `json:"start"`
+		End   uint64 `json:"end"`
+	} `json:"original_set"`
+	TransferSet []struct {
+		Start uint64 `json:"start"`
+		End   uint64 `json:"end"`
+	} `json:"transfer_set"`
+}
+
+type transferFile struct {
+	Transfers []struct {
+		Type         string          `json:"type"`
+		Date         string          `json:"transfer_date"`
+		SourceRIR    string          `json:"source_rir"`
+		RecipientRIR string          `json:"recipient_rir"`
+		SourceOrg    transferOrg     `json:"source_organization"`
+		RecipientOrg transferOrg     `json:"recipient_organization"`
+		IPv4         *transferIPSet  `json:"ip4nets"`
+		IPv6         *transferIPSet  `json:"ip6nets"`
+		ASNs         *transferASNSet `json:"asns"`
+	} `json:"transfers"`
+}
+
+// transfersCommand imports or lists inter-RIR and intra-RIR transfers.
+func transfersCommand(db *sql.DB, args []string) {
+	if len(args) == 0 {
+		log.Fatal("Usage: transfers import [REGISTRY|URL|FILE ...] | transfers list [-registry NAME] [-start ADDRESS|ASN] [-limit N]")
+	}
+	switch args[0] {
+	case "import":
+		sources := args[1:]
+		if len(sources) == 0 {
+			sources = []string{"afrinic", "apnic", "arin", "lacnic", "ripencc"}
+		}
+		for _, source := range sources {
+			if err := importTransfers(context.Background(), db, source); err != nil {
+				log.Fatal(err)
+			}
+		}
+	case "list":
+		listTransfers(db, args[1:])
+	default:
+		log.Fatal("Unknown transfers command: " + args[0])
+	}
+}
+
+// importTransfers loads a transfer file given as registry name, URL or local path.
+func importTransfers(ctx context.Context, db *sql.DB, source string) error {
+	registry := ""
+	if url, ok := transferURLs[source]; ok {
+		registry, source = source, url
+	}
+	var data []byte
+	var err error
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		data, err = downloadFile(ctx, &source)
+	} else {
+		data, err = ioutil.ReadFile(source)
+	}
+	if err != nil {
+		return fmt.Errorf("reading transfers %s: %w", source, err)
+	}
+	auditLog(db, "transfers", source, 0)
+
+	var file transferFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("parsing transfers %s: %w", source, err)
+	}
+
+	stmt, err := db.Prepare(`INSERT IGNORE INTO Transfers (ID_Registries, TransferDate, Type, SourceRIR, RecipientRIR,
+		SourceOrg, SourceCC, RecipientOrg, RecipientCC, RecordType, Start, End) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	var inserted, total int64
+	for _, t := range file.Transfers {
+		date, err := time.Parse(time.RFC3339, t.Date)
+		if err != nil {
+			logger.Debug("Invalid transfer date", "date", t.Date)
+			continue
+		}
+		publisher := registry
+		if publisher == "" { // Local files are attributed to the recipient
+			publisher = rirShortName(t.RecipientRIR)
+		}
+		insert := func(kind, start, end string) {
+			total++
+			res, err := stmt.Exec(publisher, date.UTC().Format("2006-01-02 15:04:05"), t.Type, rirShortName(t.SourceRIR),
+				rirShortName(t.RecipientRIR), t.SourceOrg.Name, t.SourceOrg.CountryCode, t.RecipientOrg.Name,
+				t.RecipientOrg.CountryCode, kind, start, end)
+			if err != nil {
+				logger.Debug("Invalid transfer", "type", kind, "start", start, "end", end, "err", err)
+				return
+			}
+			n, _ := res.RowsAffected()
+			inserted += n
+		}
+		for kind, set := range map[string]*transferIPSet{"ipv4": t.IPv4, "ipv6": t.IPv6} {
+			if set == nil {
+				continue
+			}
+			for _, r := range set.TransferSet {
+				insert(kind, normalizeAddr(r.Start), normalizeAddr(r.End))
+			}
+		}
+		if t.ASNs != nil {
+			for _, r := range t.ASNs.TransferSet {
+				insert("asn", fmt.Sprint(r.Start), fmt.Sprint(r.End))
+			}
+		}
+	}
+
+	// Link transfers to the allocation starting at the same address or ASN
+	_, err = db.Exec(`UPDATE Transfers t JOIN Resources r ON r.RecordType = t.RecordType AND r.Start = t.Start
+		AND r.ID_Registries = t.ID_Registries SET t.ID_Resources = r.ID WHERE t.ID_Resources IS NULL;`)
+	if err != nil {
+		return fmt.Errorf("linking transfers: %w", err)
+	}
+	logger.Info("Imported transferred resources", "new", inserted, "total", total, "source", source)
+	return nil
+}
+
+// rirShortName maps names used in transfer files, e.g. "RIPE NCC", to registry short names.
+func rirShortName(name string) string {
+	n := strings.ToLower(strings.NewReplacer(" ", "", "-", "").Replace(name))
+	if n == "ripe" {
+		return "ripencc"
+	}
+	return n
+}
+
+func normalizeAddr(s string) string {
+	if addr, err := netip.ParseAddr(s); err == nil {
+		return addr.String()
+	}
+	return s
+}
+
+func listTransfers(db *sql.DB, args []string) {
+	fs := flag.NewFlagSet("transfers list", flag.ExitOnError)
+	registry := fs.String("registry", "", "Only transfers published by this registry")
+	start := fs.String("start", "", "Only transfers of the range starting at this address or ASN")
+	limit := fs.Int("limit", 100, "Maximum number of transfers to list")
+	fs.Parse(args)
+
+	query := `SELECT TransferDate, ID_Registries, Type, RecordType, Start, End, SourceRIR, RecipientRIR,
+		SourceOrg, RecipientOrg, IFNULL(ID_Resources, 0) FROM Transfers`
+	var where []string
+	var params []interface{}
+	if *registry != "" {
+		where = append(where, "ID_Registries = ?")
+		params = append(params, *registry)
+	}
+	if *start != "" {
+		where = append(where, "Start = ?")
+		params = append(params, normalizeAddr(*start))
+	}
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	rows, err := db.Query(query+" ORDER BY TransferDate DESC LIMIT ?;", append(params, *limit)...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rows.Close()
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "DATE\tREGISTRY\tTYPE\tRESOURCE\tRANGE\tFROM\tTO\tRESOURCE ID")
+	for rows.Next() {
+		var date, reg, kind, rtype, s, e, srcRIR, dstRIR, srcOrg, dstOrg string
+		var resource int64
+		if err := rows.Scan(&date, &reg, &kind, &rtype, &s, &e, &srcRIR, &dstRIR, &srcOrg, &dstOrg, &resource); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s-%s\t%s (%s)\t%s (%s)\t%d\n", date, reg, kind, rtype, s, e, srcOrg, srcRIR, dstOrg, dstRIR, resource)
+	}
+	if err := rows.Err(); err != nil {
+		log.Fatal(err)
+	}
+	w.Flush()
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+)
+
+// reportingViews are (re)created by the "views" command for dashboards such as Grafana.
+// Records are only stored once per unique allocation, so the "latest" views pick the
+// newest dataset each prefix or ASN was recorded in.
+var reportingViews = []struct {
+	name  string
+	query string
+}{
+	{"v_latest_datasets", `SELECT d.* FROM Datasets d
+		JOIN (SELECT ID_Registries, MAX(serial) AS serial FROM Datasets GROUP BY ID_Registries) l USING (ID_Registries, serial)`},
+	{"v_latest_ipv4", `SELECT r.ID_Registries, r.CC, r.FirstIP, INET_NTOA(r.FirstIP) AS FirstIPText, r.HostCount,
+		r.RecordDate, r.State, r.OpaqueID, r.ID_Datasets FROM Records_ipv4 r
+		JOIN (SELECT ID_Registries, FirstIP, MAX(ID_Datasets) AS ID_Datasets FROM Records_ipv4 GROUP BY ID_Registries, FirstIP) l
+		USING (ID_Registries, FirstIP, ID_Datasets)`},
+	{"v_latest_ipv6", `SELECT r.ID_Registries, r.CC, r.FirstIP, INET6_NTOA(r.FirstIP) AS FirstIPText, r.PrefixLen,
+		r.RecordDate, r.State, r.OpaqueID, r.ID_Datasets FROM Records_ipv6 r
+		JOIN (SELECT ID_Registries, FirstIP, MAX(ID_Datasets) AS ID_Datasets FROM Records_ipv6 GROUP BY ID_Registries, FirstIP) l
+		USING (ID_Registries, FirstIP, ID_Datasets)`},
+	{"v_latest_asn", `SELECT r.ID_Registries, r.CC, r.ASN, r.ASNCount, r.RecordDate, r.State, r.OpaqueID, r.ID_Datasets
+		FROM Records_asn r
+		JOIN (SELECT ID_Registries, ASN, MAX(ID_Datasets) AS ID_Datasets FROM Records_asn GROUP BY ID_Registries, ASN) l
+		USING (ID_Registries, ASN, ID_Datasets)`},
+	{"v_holder_totals", `SELECT ID_Registries, OpaqueID, SUM(IPv4Addresses) AS IPv4Addresses, SUM(IPv6Prefixes) AS IPv6Prefixes,
+		SUM(ASNs) AS ASNs FROM (
+		SELECT ID_Registries, OpaqueID, SUM(HostCount) AS IPv4Addresses, 0 AS IPv6Prefixes, 0 AS ASNs FROM v_latest_ipv4
+			WHERE State IN ('allocated', 'assigned') GROUP BY ID_Registries, OpaqueID
+		UNION ALL SELECT ID_Registries, OpaqueID, 0, COUNT(*), 0 FROM v_latest_ipv6
+			WHERE State IN ('allocated', 'assigned') GROUP BY ID_Registries, OpaqueID
+		UNION ALL SELECT ID_Registries, OpaqueID, 0, 0, SUM(ASNCount) FROM v_latest_asn
+			WHERE State IN ('allocated', 'assigned') GROUP BY ID_Registries, OpaqueID
+		) t WHERE OpaqueID <> '' GROUP BY ID_Registries, OpaqueID`},
+	{"v_registry_counts", `SELECT d.ID_Registries, d.serial, d.enddate AS Date, s.RecordType, s.Count
+		FROM Datasets d JOIN Summaries s ON s.ID_Datasets = d.ID`},
+	{"v_country_totals", `SELECT CC, SUM(IPv4Addresses) AS IPv4Addresses, SUM(IPv6Prefixes) AS IPv6Prefixes, SUM(ASNs) AS ASNs FROM (
+		SELECT CC, SUM(HostCount) AS IPv4Addresses, 0 AS IPv6Prefixes, 0 AS ASNs FROM v_latest_ipv4
+			WHERE State IN ('allocated', 'assigned') GROUP BY CC
+		UNION ALL SELECT CC, 0, COUNT(*), 0 FROM v_latest_ipv6 WHERE State IN ('allocated', 'assigned') GROUP BY CC
+		UNION ALL SELECT CC, 0, 0, SUM(ASNCount) FROM v_latest_asn WHERE State IN ('allocated', 'assigned') GROUP BY CC
+		) t WHERE CC <> '' GROUP BY CC`},
+}
+
+// viewsCommand creates or refreshes the reporting views; needs CREATE VIEW privileges.
+func viewsCommand(db *sql.DB) {
+	for _, v := range reportingViews {
+		logger.Debug("Creating view", "view", v.name)
+		if _, err := db.Exec("CREATE OR REPLACE VIEW " + v.name + " AS " + v.query); err != nil {
+			log.Fatal(fmt.Sprintf("Cannot create view %s: %s", v.name, err.Error()))
+		}
+	}
+	auditLog(db, "views", "reporting views", 0)
+	logger.Info("Created or refreshed reporting views", "count", len(reportingViews))
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// watchesCommand implements "watch add prefix|asn|cc VALUE [COMMENT]", "watch list"
+// and "watch remove ID".
+func watchesCommand(db *sql.DB, args []string) {
+	usage := "Usage: watch add prefix|asn|cc VALUE [COMMENT] | watch list | watch remove ID"
+	if len(args) == 0 {
+		log.Fatal(usage)
+	}
+	switch args[0] {
+	case "add":
+		if len(args) < 3 {
+			log.Fatal(usage)
+		}
+		kind, value := args[1], args[2]
+		switch kind {
+		case "prefix":
+			p, err := netip.ParsePrefix(value)
+			if err != nil {
+				log.Fatal("Invalid prefix: " + value)
+			}
+			value = p.Masked().String()
+		case "asn":
+			asn, err := strconv.ParseUint(strings.TrimPrefix(strings.ToUpper(value), "AS"), 10, 32)
+			if err != nil {
+				log.Fatal("Invalid ASN: " + value)
+			}
+			value = strconv.FormatUint(asn, 10)
+		case "cc":
+			if len(value) != 2 {
+				log.Fatal("Invalid country code: " + value)
+			}
+			value = strings.ToUpper(value)
+		default:
+			log.Fatal(usage)
+		}
+		res, err := db.Exec("INSERT INTO Watches VALUES (DEFAULT, ?, ?, ?, NOW());", kind, value, strings.Join(args[3:], " "))
+		if err != nil {
+			log.Fatal(err)
+		}
+		id, _ := res.LastInsertId()
+		auditLog(db, "watch.add", kind+" "+value, 0)
+		fmt.Printf("Watch %d added for %s %s.\n", id, kind, value)
+	case "list":
+		rows, err := db.Query("SELECT ID, Kind, Value, Comment, Created FROM Watches ORDER BY ID;")
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer rows.Close()
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tKIND\tVALUE\tCREATED (UTC)\tCOMMENT")
+		for rows.Next() {
+			var id uint64
+			var kind, value, comment, created string
+			if err := rows.Scan(&id, &kind, &value, &comment, &created); err != nil {
+				log.Fatal(err)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", id, kind, value, created, comment)
+		}
+		w.Flush()
+	case "remove":
+		if len(args) < 2 {
+			log.Fatal(usage)
+		}
+		res, err := db.Exec("DELETE FROM Watches WHERE ID = ?;", args[1])
+		if err != nil {
+			log.Fatal(err)
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			log.Fatal("No such watch: " + args[1])
+		}
+		auditLog(db, "watch.remove", args[1], 0)
+	default:
+		log.Fatal(usage)
+	}
+}
+
+type watch struct {
+	ID     uint64
+	Kind   string
+	Value  string
+	prefix netip.Prefix
+	asn    uint64
+}
+
+// matches reports whether a change concerns the watched prefix, ASN or country.
+func (w watch) matches(kind, start string, value uint64, oldCC, newCC string) bool {
+	switch w.Kind {
+	case "cc":
+		return oldCC == w.Value || newCC == w.Value
+	case "asn":
+		first, err := strconv.ParseUint(start, 10, 64)
+		return kind == "asn" && err == nil && w.asn >= first && w.asn < first+value
+	case "prefix":
+		if kind == "asn" {
+			return false
+		}
+		prefixes, _ := recordPrefixes(kind, start, value)
+		for _, p := range prefixes {
+			if p.Overlaps(w.prefix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// alertOnWatches sends one alert listing the changes of an import that concern watched resources.
+func alertOnWatches(db *sql.DB, result ImportResult) {
+	if result.Status != "success" || result.Changes == nil {
+		return
+	}
+	var watches []watch
+	rows, err := db.Query("SELECT ID, Kind, Value FROM Watches;")
+	if err != nil {
+		logger.Warn("Cannot read watches", "err", err)
+		return
+	}
+	for rows.Next() {
+		var w watch
+		if err := rows.Scan(&w.ID, &w.Kind, &w.Value); err != nil {
+			rows.Close()
+			logger.Warn("Cannot read watches", "err", err)
+			return
+		}
+		w.prefix, _ = netip.ParsePrefix(w.Value)
+		w.asn, _ = strconv.ParseUint(w.Value, 10, 64)
+		watches = append(watches, w)
+	}
+	rows.Close()
+	if len(watches) == 0 {
+		return
+	}
+
+	rows, err = db.Query(`SELECT RecordType, ChangeType, Start, Value, IFNULL(OldCC, ''), IFNULL(NewCC, ''),
+		IFNULL(OldState, ''), IFNULL(NewState, ''), IFNULL(OldOpaqueID, ''), IFNULL(NewOpaqueID, '')
+		FROM Changes WHERE ID_Datasets = ?;`, result.Dataset)
+	if err != nil {
+		logger.Warn("Cannot read changes", "err", err)
+		return
+	}
+	defer rows.Close()
+
+	var lines []string
+	var matched []map[string]interface{}
+	for rows.Next() {
+		var kind, change, start, oldCC, newCC, oldState, newState, oldHolder, newHolder string
+		var value uint64
+		if err := rows.Scan(&kind, &change, &start, &value, &oldCC, &newCC, &oldState, &newState, &oldHolder, &newHolder); err != nil {
+			logger.Warn("Cannot read changes", "err", err)
+			return
+		}
+		for _, w := range watches {
+			if !w.matches(kind, start, value, oldCC, newCC) {
+				continue
+			}
+			var details []string
+			for _, d := range [][3]string{{"country", oldCC, newCC}, {"status", oldState, newState}, {"holder", holder(oldHolder), holder(newHolder)}} {
+				if d[1] != d[2] {
+					details = append(details, fmt.Sprintf("%s %s", d[0], transition(d[1], d[2])))
+				}
+			}
+			lines = append(lines, fmt.Sprintf("%s %s %s/%d in %s (watch %d %s %s): %s",
+				kind, change, start, value, result.Registry, w.ID, w.Kind, w.Value, strings.Join(details, ", ")))
+			matched = append(matched, map[string]interface{}{"watch": w.ID, "type": kind, "change": change, "start": start,
+				"value": value, "old_cc": oldCC, "new_cc": newCC, "old_status": oldState, "new_status": newState})
+			break
+		}
+	}
+	if len(lines) == 0 {
+		return
+	}
+	sendAlert(fmt.Sprintf("%d watched resources changed in %s", len(lines), result.Registry), strings.Join(lines, "\n"))
+	postWebhooks(map[string]interface{}{"event": "watch.changed", "registry": result.Registry, "serial": result.Serial, "changes": matched})
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var webhookClient = &http.Client{Timeout: 15 * time.Second}
+
+// notifyWebhooks POSTs the import summary to every URL given in -webhook.
+// Failures are reported but never abort the import.
+func notifyWebhooks(result ImportResult) {
+	postWebhooks(result)
+}
+
+// postWebhooks sends payload as JSON to every URL given in -webhook.
+func postWebhooks(payload interface{}) {
+	webhooks := currentSettings().webhooks
+	if webhooks == "" {
+		return
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		logger.Warn("Cannot encode webhook payload", "err", err)
+		return
+	}
+
+	for _, url := range strings.Split(webhooks, ",") {
+		url = strings.TrimSpace(url)
+		if url == "" {
+			continue
+		}
+		logger.Log(context.Background(), levelTrace, "Posting webhook", "url", url)
+		resp, err := webhookClient.Post(url, "application/json", bytes.NewReader(body))
+		if err != nil {
+			logger.Warn("Webhook failed", "url", url, "err", err)
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			logger.Warn("Webhook failed", "url", url, "status", resp.Status)
+		}
+	}
+}
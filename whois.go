@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// whoisServers are the authoritative whois servers of the registries.
+var whoisServers = map[string]string{
+	"afrinic": "whois.afrinic.net:43",
+	"apnic":   "whois.apnic.net:43",
+	"arin":    "whois.arin.net:43",
+	"lacnic":  "whois.lacnic.net:43",
+	"ripencc": "whois.ripe.net:43",
+	"iana":    "whois.iana.org:43",
+}
+
+// whoisCacheTTL is how long proxied responses are reused.
+const whoisCacheTTL = time.Hour
+
+type whoisCacheEntry struct {
+	data    []byte
+	expires time.Time
+}
+
+// whoisCache holds recent responses of the registries' whois servers by server and query.
+var whoisCache = struct {
+	sync.Mutex
+	m map[string]whoisCacheEntry
+}{m: make(map[string]whoisCacheEntry)}
+
+// startWhoisServer answers whois queries on -whois-listen. Addresses, prefixes and ASNs
+// are answered from the local database; queries with options (e.g. "-B 192.0.2.1"),
+// anything else and local misses are passed to the authoritative registry's whois
+// server, with the local data prepended as comments when there is some. The listener is
+// closed when ctx is cancelled.
+func startWhoisServer(ctx context.Context, db *sql.DB) {
+	ln, err := net.Listen("tcp", *f_whoisListen)
+	if err != nil {
+		logger.Warn("Cannot listen for whois", "addr", *f_whoisListen, "err", err)
+		return
+	}
+	logger.Info("Serving whois", "addr", *f_whoisListen)
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+	serverRequests.Add(1)
+	go func() {
+		defer serverRequests.Done()
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				if ctx.Err() == nil {
+					logger.Warn("whois server failed", "err", err)
+				}
+				return
+			}
+			serverRequests.Add(1)
+			go func() {
+				defer serverRequests.Done()
+				handleWhois(db, conn)
+			}()
+		}
+	}()
+}
+
+func handleWhois(db *sql.DB, conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Minute))
+	line, err := bufio.NewReader(io.LimitReader(conn, 1024)).ReadString('\n')
+	if err != nil && line == "" {
+		return
+	}
+	query := strings.TrimSpace(line)
+	logger.Log(context.Background(), levelTrace, "whois query", "query", query, "remote", conn.RemoteAddr().String())
+	conn.Write(whoisResponse(db, query))
+}
+
+// whoisResponse answers a query, merging local data with the registry's response as needed.
+func whoisResponse(db *sql.DB, query string) []byte {
+	fields := strings.Fields(query)
+	if len(fields) == 0 {
+		return []byte("% Empty query\n")
+	}
+	detailed := strings.HasPrefix(fields[0], "-")
+	key := fields[len(fields)-1]
+
+	registry, local := whoisLocal(db, key)
+	if local != "" && !detailed {
+		return []byte(local + "\n% Query with an option, e.g. \"-B " + key + "\", for the full registry record.\n")
+	}
+	server := whoisServers[registry]
+	if server == "" {
+		server = whoisServers["iana"]
+	}
+	remote, err := whoisProxy(server, query)
+	if err != nil {
+		if local != "" {
+			return []byte(local + "\n% The registry whois server is unavailable: " + err.Error() + "\n")
+		}
+		return []byte("% Error: " + err.Error() + "\n")
+	}
+	if local == "" {
+		return remote
+	}
+	return append([]byte(strings.ReplaceAll(local, "\n", "\n% ")+"\n%\n% Registry response from "+server+":\n\n"), remote...)
+}
+
+// whoisLocal formats what the database knows about an address, prefix or ASN.
+func whoisLocal(db *sql.DB, key string) (registry, text string) {
+	var b strings.Builder
+	line := func(name, value string) {
+		if value != "" {
+			fmt.Fprintf(&b, "%-16s%s\n", name+":", value)
+		}
+	}
+
+	if asn, err := strconv.ParseUint(strings.TrimPrefix(strings.ToUpper(key), "AS"), 10, 32); err == nil {
+		var cc, status, holderID string
+		err := db.QueryRow(`SELECT ID_Registries, CC, State, Holder FROM LatestAllocations
+			WHERE RecordType = 'asn' AND CAST(Start AS UNSIGNED) <= ? AND CAST(Start AS UNSIGNED) + Value > ? LIMIT 1;`,
+			asn, asn).Scan(&registry, &cc, &status, &holderID)
+		if err != nil {
+			return "", ""
+		}
+		name, _ := orgName(db, registry, holderID)
+		line("aut-num", "AS"+strconv.FormatUint(asn, 10))
+		line("as-name", asNameOf(db, strconv.FormatUint(asn, 10)))
+		line("registry", registry)
+		line("country", cc)
+		line("status", status)
+		line("holder", holderID)
+		line("org-name", name)
+		if c, err := holderAbuseContact(db, registry, holderID); err == nil {
+			line("abuse-mailbox", c.Email)
+		}
+		if tags, err := tagsAt(db, netip.Addr{}, strconv.FormatUint(asn, 10)); err == nil {
+			line("tags", strings.Join(tags, ","))
+		}
+		return registry, "% Local data of ip2asn\n\n" + b.String()
+	}
+
+	addr, err := netip.ParseAddr(key)
+	if err != nil {
+		p, err := netip.ParsePrefix(key)
+		if err != nil {
+			return "", ""
+		}
+		addr = p.Addr()
+	}
+	cc, registry, holderID, err := rirRecord(db, addr)
+	if err != nil {
+		return "", ""
+	}
+	name, _ := orgName(db, registry, holderID)
+	line("query", key)
+	line("registry", registry)
+	line("country", cc)
+	line("holder", holderID)
+	line("org-name", name)
+	origin := ""
+	if route, err := routeValidity(db, addr.String()); err == nil {
+		origin = route["origin_as"]
+		line("route", route["route"])
+		line("origin", "AS"+route["origin_as"])
+		line("as-name", asNameOf(db, strings.SplitN(route["origin_as"], "_", 2)[0]))
+		line("rpki", route["rpki"])
+	}
+	if c, err := holderAbuseContact(db, registry, holderID); err == nil {
+		line("abuse-mailbox", c.Email)
+	}
+	if tags, err := tagsAt(db, addr, origin); err == nil {
+		line("tags", strings.Join(tags, ","))
+	}
+	return registry, "% Local data of ip2asn\n\n" + b.String()
+}
+
+// whoisProxy sends a query to a whois server, answering from the cache when possible.
+// IANA referrals are followed once.
+func whoisProxy(server, query string) ([]byte, error) {
+	key := server + " " + query
+	now := time.Now()
+	whoisCache.Lock()
+	if e, ok := whoisCache.m[key]; ok && now.Before(e.expires) {
+		whoisCache.Unlock()
+		return e.data, nil
+	}
+	whoisCache.Unlock()
+
+	data, err := whoisQuery(server, query)
+	if err != nil {
+		return nil, err
+	}
+	if server == whoisServers["iana"] {
+		for _, l := range strings.Split(string(data), "\n") {
+			if strings.HasPrefix(l, "refer:") {
+				referred := strings.TrimSpace(strings.TrimPrefix(l, "refer:")) + ":43"
+				if more, err := whoisQuery(referred, query); err == nil {
+					data = more
+				}
+				break
+			}
+		}
+	}
+
+	whoisCache.Lock()
+	defer whoisCache.Unlock()
+	if len(whoisCache.m) >= 10000 {
+		for k, e := range whoisCache.m {
+			if now.After(e.expires) {
+				delete(whoisCache.m, k)
+			}
+		}
+		if len(whoisCache.m) >= 10000 {
+			whoisCache.m = make(map[string]whoisCacheEntry) // Start over rather than grow without bound
+		}
+	}
+	whoisCache.m[key] = whoisCacheEntry{data: data, expires: now.Add(whoisCacheTTL)}
+	return data, nil
+}
+
+func whoisQuery(server, query string) ([]byte, error) {
+	conn, err := net.DialTimeout("tcp", server, 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(30 * time.Second))
+	if _, err := conn.Write([]byte(query + "\r\n")); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, io.LimitReader(conn, 4<<20)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// importTask is a queued request to import one dataset, e.g.
+// {"id": "42", "registry": "arin"} or {"url": "https://.../delegated-apnic-latest", "force": true}.
+type importTask struct {
+	ID       string `json:"id,omitempty"`
+	Registry string `json:"registry,omitempty"`
+	URL      string `json:"url,omitempty"`
+	Force    bool   `json:"force,omitempty"`
+}
+
+// taskResult is reported back for every task taken from the queue.
+type taskResult struct {
+	ID string `json:"id,omitempty"`
+	ImportResult
+}
+
+// runTask performs one queued import, forced by -force or the task, and runs the steps
+// that follow a successful one.
+func runTask(ctx context.Context, db *sql.DB, data []byte) taskResult {
+	var task importTask
+	if err := json.Unmarshal(data, &task); err != nil {
+		return taskResult{ImportResult: ImportResult{Status: "failure", Error: "invalid task: " + err.Error()}}
+	}
+	res := taskResult{ID: task.ID}
+
+	urls := []string{task.URL}
+	if task.URL == "" {
+		var err error
+		if urls, err = lookupRegistryURLs(mysqlStore{db}, task.Registry); err != nil {
+			res.Status, res.Error = "failure", err.Error()
+			return res
+		}
+	}
+	source := urls[0]
+	logger.Info("Importing task", "task", task.ID, "source", source)
+
+	force := *f_force || task.Force
+	st := mysqlStore{db}
+	res.ImportResult, _ = importData(ctx, st, source, force, func(ctx context.Context) (io.ReadCloser, error) {
+		return downloadIfChanged(ctx, st, urls, force)
+	})
+	if res.Status == "success" && ctx.Err() == nil {
+		afterImports(db)
+	}
+	return res
+}
+
+// runWorker takes tasks from the queue in -worker until shutdown.
+func runWorker(ctx context.Context, db *sql.DB) {
+	u, err := url.Parse(*f_worker)
+	if err != nil {
+		log.Fatal("Invalid -worker URL: " + err.Error())
+	}
+	sdNotify("STATUS=Waiting for import tasks")
+	switch u.Scheme {
+	case "nats":
+		err = runNATSWorker(ctx, db, *f_worker)
+	case "redis":
+		err = runRedisWorker(ctx, db, u)
+	default:
+		log.Fatal("Unsupported -worker queue: " + u.Scheme)
+	}
+	if err != nil && ctx.Err() == nil {
+		log.Fatal(err)
+	}
+}
+
+// runNATSWorker joins a queue group so each task goes to one worker. Results are
+// sent as the reply for request/reply callers and published to -worker-results.
+func runNATSWorker(ctx context.Context, db *sql.DB, server string) error {
+	conn, err := nats.Connect(server, nats.Name("ip2asn-worker"), nats.MaxReconnects(-1))
+	if err != nil {
+		return err
+	}
+	defer conn.Drain()
+	sub, err := conn.QueueSubscribeSync(*f_workerQueue, "ip2asn-workers")
+	if err != nil {
+		return err
+	}
+	logger.Info("Waiting for import tasks on NATS", "subject", *f_workerQueue)
+
+	for {
+		msg, err := sub.NextMsgWithContext(ctx)
+		if err != nil {
+			return err
+		}
+		out, _ := json.Marshal(runTask(ctx, db, msg.Data))
+		if msg.Reply != "" {
+			msg.Respond(out)
+		}
+		if err := conn.Publish(*f_workerResults, out); err != nil {
+			logger.Warn("Cannot publish task result", "err", err)
+		}
+	}
+}
+
+// runRedisWorker pops tasks from a Redis list with BLPOP and pushes results onto
+// the -worker-results list.
+func runRedisWorker(ctx context.Context, db *sql.DB, u *url.URL) error {
+	client, err := dialRedis(u)
+	if err != nil {
+		return err
+	}
+	defer client.conn.Close()
+	logger.Info("Waiting for import tasks on Redis", "list", *f_workerQueue)
+
+	for ctx.Err() == nil {
+		reply, err := client.do("BLPOP", *f_workerQueue, "5")
+		if err != nil {
+			return err
+		}
+		item, ok := reply.([]interface{})
+		if !ok || len(item) != 2 { // Timed out; check for shutdown and wait again
+			continue
+		}
+		task, _ := item[1].(string)
+		out, _ := json.Marshal(runTask(ctx, db, []byte(task)))
+		if _, err := client.do("RPUSH", *f_workerResults, string(out)); err != nil {
+			return err
+		}
+	}
+	return ctx.Err()
+}
+
+// redisClient speaks just enough RESP for the worker.
+type redisClient struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func dialRedis(u *url.URL) (*redisClient, error) {
+	conn, err := net.DialTimeout("tcp", u.Host, 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	client := &redisClient{conn: conn, r: bufio.NewReader(conn)}
+	if pass, ok := u.User.Password(); ok {
+		if _, err := client.do("AUTH", pass); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		if _, err := client.do("SELECT", db); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return client, nil
+}
+
+func (c *redisClient) do(args ...string) (interface{}, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := c.conn.Write([]byte(b.String())); err != nil {
+		return nil, err
+	}
+	return c.readReply()
+}
+
+func (c *redisClient) readReply() (interface{}, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("redis: empty reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, errors.New("redis: " + line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			if items[i], err = c.readReply(); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: unexpected reply %q", line)
+}